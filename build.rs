@@ -0,0 +1,36 @@
+use std::env;
+use std::process::Command;
+use std::time::{SystemTime, UNIX_EPOCH};
+
+fn main() {
+    let commit = match Command::new("git")
+        .args(["rev-parse", "--short", "HEAD"])
+        .output()
+    {
+        Ok(output) if output.status.success() => {
+            String::from_utf8_lossy(&output.stdout).trim().to_string()
+        }
+        _ => String::from("unknown"),
+    };
+
+    let rustc = env::var("RUSTC").unwrap_or(String::from("rustc"));
+    let rustc_version = match Command::new(rustc).arg("--version").output() {
+        Ok(output) if output.status.success() => {
+            String::from_utf8_lossy(&output.stdout).trim().to_string()
+        }
+        _ => String::from("unknown"),
+    };
+
+    let build_time = SystemTime::now()
+        .duration_since(UNIX_EPOCH)
+        .map(|duration| duration.as_secs())
+        .unwrap_or(0);
+
+    let target = env::var("TARGET").unwrap_or(String::from("unknown"));
+
+    println!("cargo:rustc-env=ROXIDE_BUILD_COMMIT={commit}");
+    println!("cargo:rustc-env=ROXIDE_BUILD_RUSTC={rustc_version}");
+    println!("cargo:rustc-env=ROXIDE_BUILD_TIME={build_time}");
+    println!("cargo:rustc-env=ROXIDE_BUILD_TARGET={target}");
+    println!("cargo:rerun-if-changed=.git/HEAD");
+}
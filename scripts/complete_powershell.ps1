@@ -0,0 +1,16 @@
+$_roxide_completer = {
+	param($wordToComplete, $commandAst, $cursorPosition)
+	$cmpArgs = @($commandAst.CommandElements | Select-Object -Skip 1 | ForEach-Object { $_.ToString() })
+	if ($wordToComplete -eq "") {
+		# Complete the new word.
+		$cmpArgs += ""
+	}
+	$items = @(roxide complete @cmpArgs 2>$null)
+
+	# The first line is the no space flag, PowerShell cannot control it.
+	$items | Select-Object -Skip 1 | Where-Object { $_ -like "$wordToComplete*" } | ForEach-Object {
+		[System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_)
+	}
+}
+
+Register-ArgumentCompleter -Native -CommandName roxide -ScriptBlock $_roxide_completer
@@ -0,0 +1,62 @@
+function _roxide_home {
+	$ret = @(roxide @args)
+	if ($LASTEXITCODE -ne 0) {
+		return
+	}
+	# The path to enter is the last line, the lines before it are the normal
+	# output, such as the report of `sync --resolve --cd`.
+	$ret_path = $ret | Select-Object -Last 1
+	if ($ret_path -and (Test-Path -PathType Container $ret_path)) {
+		$ret | Select-Object -SkipLast 1 | Write-Output
+		Set-Location $ret_path
+		return
+	}
+	$ret | Write-Output
+}
+
+# Return true if the args contain `--open`, or `-o` in a single or combined
+# short flag, such as `-io`. The args after `--` are not flags.
+function _roxide_has_open {
+	foreach ($arg in $args) {
+		if ($arg -eq "--") {
+			return $false
+		}
+		if ($arg -ceq "--open" -or $arg -cmatch "^-[^-]*o") {
+			return $true
+		}
+	}
+	return $false
+}
+
+# Return true if the args contain `--cd`. The args after `--` are not flags.
+function _roxide_has_cd {
+	foreach ($arg in $args) {
+		if ($arg -eq "--") {
+			return $false
+		}
+		if ($arg -ceq "--cd") {
+			return $true
+		}
+	}
+	return $false
+}
+
+function _roxide_base {
+	$action = $args[0]
+	$open = _roxide_has_open @args
+	$cd = _roxide_has_cd @args
+	switch ($action) {
+		{ $_ -in "home", "move", "mv" } {
+			_roxide_home @args
+		}
+		{ ($_ -in "grep", "which-repo") -and $open } {
+			_roxide_home @args
+		}
+		{ ($_ -in "sync", "dirty") -and $cd } {
+			_roxide_home @args
+		}
+		default {
+			roxide @args
+		}
+	}
+}
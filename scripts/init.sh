@@ -1,24 +1,82 @@
 _roxide_home() {
-	if ret_path=$(roxide $@); then
-		if [ -d $ret_path ]; then
-			cd $ret_path
+	if ret=$(roxide "$@"); then
+		# The path to enter is the last line, the lines before it are the
+		# normal output, such as the report of `sync --resolve --cd`.
+		ret_path=${ret##*$'\n'}
+		if [ -d "$ret_path" ]; then
+			if [ "$ret" != "$ret_path" ]; then
+				echo "${ret%$'\n'*}"
+			fi
+			cd "$ret_path"
 			return
 		fi
-		if [ ! -z $ret_path ]; then
-			echo $ret_path
+		if [ ! -z "$ret" ]; then
+			echo "$ret"
 		fi
 		return
 	fi
 	return 1
 }
 
+# Return 0 if the args contain `--open`, or `-o` in a single or combined
+# short flag, such as `-io`. The args after `--` are not flags.
+_roxide_has_open() {
+	for arg in "$@"; do
+		case "${arg}" in
+			--)
+				return 1
+				;;
+			--open)
+				return 0
+				;;
+			--*)
+				;;
+			-*o*)
+				return 0
+				;;
+		esac
+	done
+	return 1
+}
+
+# Return 0 if the args contain `--cd`. The args after `--` are not flags.
+_roxide_has_cd() {
+	for arg in "$@"; do
+		case "${arg}" in
+			--)
+				return 1
+				;;
+			--cd)
+				return 0
+				;;
+		esac
+	done
+	return 1
+}
+
 _roxide_base() {
 	action=$1
 	case "${action}" in
-		home)
+		home|move|mv)
 			_roxide_home "$@"
 			;;
 
+		grep|which-repo)
+			if _roxide_has_open "$@"; then
+				_roxide_home "$@"
+			else
+				roxide "$@"
+			fi
+			;;
+
+		sync|dirty)
+			if _roxide_has_cd "$@"; then
+				_roxide_home "$@"
+			else
+				roxide "$@"
+			fi
+			;;
+
 		*)
 			roxide "$@"
 			;;
@@ -0,0 +1,394 @@
+use std::fs;
+use std::fs::OpenOptions;
+use std::io::{ErrorKind, Write};
+#[cfg(unix)]
+use std::os::unix::fs::OpenOptionsExt;
+use std::path::PathBuf;
+use std::process::{Command, Stdio};
+use std::thread;
+use std::time::{Duration, Instant};
+
+use anyhow::{bail, Context, Result};
+use serde::{Deserialize, Serialize};
+
+use crate::config;
+use crate::config::types::Provider as ProviderType;
+use crate::config::types::Remote;
+use crate::utils;
+
+/// The device code returned by remote, the user should open the verification
+/// uri and enter the user code to authorize.
+#[derive(Debug, Deserialize)]
+pub struct DeviceCode {
+    pub user_code: String,
+    pub verification_uri: String,
+
+    device_code: String,
+    expires_in: u64,
+    #[serde(default = "default_interval")]
+    interval: u64,
+}
+
+fn default_interval() -> u64 {
+    5
+}
+
+#[derive(Debug, Deserialize)]
+struct TokenResponse {
+    access_token: Option<String>,
+    error: Option<String>,
+    error_description: Option<String>,
+}
+
+/// Where the login token is stored.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "lowercase")]
+pub enum TokenStore {
+    /// The system keychain, `security` for macOS and `secret-tool` (libsecret)
+    /// for Linux.
+    Keychain,
+    /// A file under `{metadir}/auth` with mode 0600, used when no keychain is
+    /// available. The token is NOT encrypted, it is only protected by the file
+    /// permissions: without a keychain there is no safe place to keep the
+    /// key, encrypting with a key stored next to the file would not protect
+    /// it any better.
+    File,
+}
+
+impl TokenStore {
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            TokenStore::Keychain => "keychain",
+            TokenStore::File => "file",
+        }
+    }
+}
+
+/// The login record of a remote, the token is only saved here if the store is
+/// [`TokenStore::File`].
+#[derive(Debug, Serialize, Deserialize)]
+pub struct Login {
+    pub store: TokenStore,
+    pub login_at: u64,
+
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    token: Option<String>,
+}
+
+/// The keychain service name of roxide tokens.
+const KEYCHAIN_SERVICE: &str = "roxide";
+
+const GRANT_TYPE: &str = "urn:ietf:params:oauth:grant-type:device_code";
+
+/// Start the device authorization flow, see:
+/// https://docs.github.com/en/apps/oauth-apps/building-oauth-apps/authorizing-oauth-apps#device-flow
+/// https://docs.gitlab.com/ee/api/oauth2.html#device-authorization-grant-flow
+pub fn request_device_code(remote: &Remote) -> Result<DeviceCode> {
+    let (client_id, domain, provider) = get_oauth(remote)?;
+    let (path, scope) = match provider {
+        ProviderType::Github => ("login/device/code", "repo read:org"),
+        ProviderType::Gitlab => ("oauth/authorize_device", "api"),
+    };
+    let url = format!("https://{domain}/{path}");
+    let client = super::build_common_client(remote)?;
+    let resp = client
+        .post(&url)
+        .header("Accept", "application/json")
+        .form(&[("client_id", client_id), ("scope", scope)])
+        .send()
+        .with_context(|| format!("Request device code from {url}"))?;
+    let ok = resp.status().is_success();
+    let data = resp.bytes().context("Read device code response body")?;
+    if !ok {
+        bail!(
+            "Request device code failed: {}",
+            String::from_utf8_lossy(&data)
+        );
+    }
+    serde_json::from_slice(&data).context("Decode device code response")
+}
+
+/// Poll the token until the user authorizes, denies or the device code
+/// expires.
+pub fn poll_token(remote: &Remote, code: &DeviceCode) -> Result<String> {
+    let (client_id, domain, provider) = get_oauth(remote)?;
+    let path = match provider {
+        ProviderType::Github => "login/oauth/access_token",
+        ProviderType::Gitlab => "oauth/token",
+    };
+    let url = format!("https://{domain}/{path}");
+    let client = super::build_common_client(remote)?;
+
+    let start = Instant::now();
+    let mut interval = code.interval;
+    loop {
+        if start.elapsed().as_secs() >= code.expires_in {
+            bail!("The device code is expired, please login again");
+        }
+        thread::sleep(Duration::from_secs(interval));
+
+        // Github returns the pending error with 200, while Gitlab with 400,
+        // so the body is always decoded.
+        let resp = client
+            .post(&url)
+            .header("Accept", "application/json")
+            .form(&[
+                ("client_id", client_id),
+                ("device_code", code.device_code.as_str()),
+                ("grant_type", GRANT_TYPE),
+            ])
+            .send()
+            .with_context(|| format!("Request token from {url}"))?;
+        let data = resp.bytes().context("Read token response body")?;
+        let resp: TokenResponse = serde_json::from_slice(&data).context("Decode token response")?;
+        if let Some(token) = resp.access_token {
+            return Ok(token);
+        }
+        match resp.error.as_deref() {
+            Some("authorization_pending") => continue,
+            Some("slow_down") => interval += 5,
+            Some("expired_token") => bail!("The device code is expired, please login again"),
+            Some("access_denied") => bail!("The authorization is denied"),
+            Some(err) => match resp.error_description {
+                Some(desc) => bail!("Request token failed: {err}: {desc}"),
+                None => bail!("Request token failed: {err}"),
+            },
+            None => bail!("Remote returned neither token nor error"),
+        }
+    }
+}
+
+fn get_oauth(remote: &Remote) -> Result<(&str, &str, &ProviderType)> {
+    let provider = match &remote.provider {
+        Some(provider) => provider,
+        None => bail!("Missing provider config for remote {}", remote.name),
+    };
+    let client_id = match &remote.oauth_client_id {
+        Some(client_id) => client_id.as_str(),
+        None => bail!(
+            "Missing oauth_client_id config for remote {}, please create an OAuth app with device flow enabled",
+            remote.name
+        ),
+    };
+    let domain = match provider {
+        ProviderType::Github => match &remote.clone {
+            Some(domain) => domain.as_str(),
+            None => "github.com",
+        },
+        ProviderType::Gitlab => match &remote.api_domain {
+            Some(domain) => domain.as_str(),
+            None => "gitlab.com",
+        },
+    };
+    Ok((client_id, domain, provider))
+}
+
+fn login_path(remote: &str) -> PathBuf {
+    PathBuf::from(&config::base().metadir)
+        .join("auth")
+        .join(format!("{remote}.json"))
+}
+
+/// Save the login token of remote, the keychain is preferred. Return where
+/// the token is stored.
+pub fn save(remote: &str, token: &str) -> Result<TokenStore> {
+    let (store, token) = if keychain_save(remote, token)? {
+        (TokenStore::Keychain, None)
+    } else {
+        (TokenStore::File, Some(token.to_string()))
+    };
+    let login = Login {
+        store,
+        login_at: config::now_secs(),
+        token,
+    };
+    let data = serde_json::to_vec(&login).context("Encode login json")?;
+
+    // The file might contain token, restrict its permissions before writing.
+    let path = login_path(remote);
+    utils::ensure_dir(&path)?;
+    let mut opts = OpenOptions::new();
+    opts.create(true).truncate(true).write(true);
+    #[cfg(unix)]
+    opts.mode(0o600);
+    let mut file = opts
+        .open(&path)
+        .with_context(|| format!("Open file {}", path.display()))?;
+    // The existing file keeps its mode when opening, restrict it too.
+    utils::set_mode(&path, 0o600)?;
+    file.write_all(&data)
+        .with_context(|| format!("Write file {}", path.display()))?;
+    Ok(store)
+}
+
+/// Read the login record of remote, return None if not logged in.
+pub fn get_login(remote: &str) -> Result<Option<Login>> {
+    let path = login_path(remote);
+    let data = match fs::read(&path) {
+        Ok(data) => data,
+        Err(err) if err.kind() == ErrorKind::NotFound => return Ok(None),
+        Err(err) => return Err(err).with_context(|| format!("Read file {}", path.display())),
+    };
+    let login = serde_json::from_slice(&data)
+        .with_context(|| format!("Decode login file {}", path.display()))?;
+    Ok(Some(login))
+}
+
+/// Load the login token of remote, return None if not logged in.
+pub fn load(remote: &str) -> Result<Option<String>> {
+    let login = match get_login(remote)? {
+        Some(login) => login,
+        None => return Ok(None),
+    };
+    match login.store {
+        TokenStore::File => Ok(login.token),
+        TokenStore::Keychain => keychain_load(remote),
+    }
+}
+
+/// Delete the login token of remote, return false if not logged in.
+pub fn delete(remote: &str) -> Result<bool> {
+    let login = match get_login(remote)? {
+        Some(login) => login,
+        None => return Ok(false),
+    };
+    if let TokenStore::Keychain = login.store {
+        keychain_delete(remote)?;
+    }
+    let path = login_path(remote);
+    fs::remove_file(&path).with_context(|| format!("Remove file {}", path.display()))?;
+    Ok(true)
+}
+
+/// Build the keychain command, `security` for macOS and `secret-tool` for
+/// others.
+fn keychain_command(args: &[&str]) -> Command {
+    let program = if cfg!(target_os = "macos") {
+        "security"
+    } else {
+        "secret-tool"
+    };
+    let mut cmd = Command::new(program);
+    cmd.args(args).stdout(Stdio::piped()).stderr(Stdio::null());
+    cmd
+}
+
+/// Save token to keychain, return false if no keychain is available.
+fn keychain_save(remote: &str, token: &str) -> Result<bool> {
+    let label = format!("{KEYCHAIN_SERVICE} {remote}");
+    let args: Vec<&str> = if cfg!(target_os = "macos") {
+        // The `-w <token>` in argv can be read by other users with `ps`, so
+        // run `security` interactively and pass the command by stdin.
+        vec!["-i"]
+    } else {
+        // The secret-tool reads the token from stdin.
+        vec![
+            "store",
+            "--label",
+            label.as_str(),
+            "service",
+            KEYCHAIN_SERVICE,
+            "remote",
+            remote,
+        ]
+    };
+    let mut cmd = keychain_command(&args);
+    cmd.stdin(Stdio::piped());
+    let mut child = match cmd.spawn() {
+        Ok(child) => child,
+        Err(err) if err.kind() == ErrorKind::NotFound => return Ok(false),
+        Err(err) => return Err(err).context("Launch keychain command"),
+    };
+    let input = if cfg!(target_os = "macos") {
+        // The `-U` updates the existing item.
+        format!(
+            "add-generic-password -U -s {KEYCHAIN_SERVICE} -a {} -w {}\n",
+            quote_keychain_arg(remote),
+            quote_keychain_arg(token)
+        )
+    } else {
+        token.to_string()
+    };
+    let mut stdin = child.stdin.take().unwrap();
+    stdin
+        .write_all(input.as_bytes())
+        .context("Write token to keychain command")?;
+    drop(stdin);
+    let status = child.wait().context("Wait keychain command done")?;
+    // The keychain might be unavailable, such as no secret service running in
+    // ssh session, fallback to file.
+    if !status.success() {
+        return Ok(false);
+    }
+    if cfg!(target_os = "macos") {
+        // The `security -i` exits successfully even if the command in it
+        // failed, read the token back to make sure it is saved.
+        let saved = keychain_load(remote).ok().flatten();
+        return Ok(saved.as_deref() == Some(token));
+    }
+    Ok(true)
+}
+
+/// Quote the arg for the command line of `security -i`, which splits args by
+/// spaces and supports double quotes with backslash escapes.
+fn quote_keychain_arg(arg: &str) -> String {
+    let escaped = arg.replace('\\', "\\\\").replace('"', "\\\"");
+    format!("\"{escaped}\"")
+}
+
+fn keychain_load(remote: &str) -> Result<Option<String>> {
+    let args: Vec<&str> = if cfg!(target_os = "macos") {
+        vec![
+            "find-generic-password",
+            "-s",
+            KEYCHAIN_SERVICE,
+            "-a",
+            remote,
+            "-w",
+        ]
+    } else {
+        vec!["lookup", "service", KEYCHAIN_SERVICE, "remote", remote]
+    };
+    let output = keychain_command(&args)
+        .output()
+        .context("Execute keychain command")?;
+    if !output.status.success() {
+        bail!("Could not find token of {remote} in keychain, please login again");
+    }
+    let token = String::from_utf8(output.stdout).context("Decode token from keychain")?;
+    let token = token.trim();
+    if token.is_empty() {
+        return Ok(None);
+    }
+    Ok(Some(token.to_string()))
+}
+
+fn keychain_delete(remote: &str) -> Result<()> {
+    let args: Vec<&str> = if cfg!(target_os = "macos") {
+        vec![
+            "delete-generic-password",
+            "-s",
+            KEYCHAIN_SERVICE,
+            "-a",
+            remote,
+        ]
+    } else {
+        vec!["clear", "service", KEYCHAIN_SERVICE, "remote", remote]
+    };
+    // The item might have been removed manually, ignore the result.
+    let _ = keychain_command(&args).output();
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_quote_keychain_arg() {
+        assert_eq!(quote_keychain_arg("ghp_abc123"), "\"ghp_abc123\"");
+        assert_eq!(quote_keychain_arg("a b"), "\"a b\"");
+        assert_eq!(quote_keychain_arg("a\"b"), "\"a\\\"b\"");
+        assert_eq!(quote_keychain_arg("a\\b"), "\"a\\\\b\"");
+    }
+}
@@ -1,3 +1,4 @@
+use std::cell::RefCell;
 use std::fs;
 use std::io::ErrorKind;
 use std::path::PathBuf;
@@ -6,9 +7,13 @@ use std::time::Duration;
 use anyhow::{bail, Context, Result};
 use bincode::Options;
 use serde::de::DeserializeOwned;
-use serde::Serialize;
+use serde::{Deserialize, Serialize};
 
-use crate::api::types::{ApiRepo, MergeOptions, Provider};
+use crate::api::types::{
+    ApiAction, ApiCompare, ApiInfo, ApiJob, ApiMergeStatus, ApiNotification, ApiRepo,
+    ApiRepoSettings, ApiSnippet, ApiUpstream, Conditional, MergeMethod, MergeOptions, Provider,
+    RepoUpdate, Validator,
+};
 use crate::utils::{self, Lock};
 
 pub struct Cache {
@@ -19,28 +24,129 @@ pub struct Cache {
 
     upstream: Box<dyn Provider>,
 
+    stats_path: PathBuf,
+    stats: RefCell<CacheStats>,
+
     _lock: Lock,
 }
 
+/// The hit counters of the cache, they are accumulated in memory and saved
+/// when the cache is dropped, see `roxide remote {name}`.
+#[derive(Debug, Default, Deserialize, Serialize)]
+pub struct CacheStats {
+    /// The value is returned from cache without calling api.
+    pub hits: u64,
+    /// The value is missing or expired, and fetched from api.
+    pub misses: u64,
+    /// The value is expired, but renewed by a conditional request (304).
+    pub renewed: u64,
+}
+
+impl CacheStats {
+    pub fn read(path: &PathBuf) -> Result<CacheStats> {
+        match fs::read(path) {
+            Ok(data) => serde_json::from_slice(&data)
+                .with_context(|| format!("Decode cache stats {}", path.display())),
+            Err(err) if err.kind() == ErrorKind::NotFound => Ok(CacheStats::default()),
+            Err(err) => Err(err).with_context(|| format!("Read file {}", path.display())),
+        }
+    }
+
+    fn is_empty(&self) -> bool {
+        self.hits + self.misses + self.renewed == 0
+    }
+
+    fn save(&self, path: &PathBuf) -> Result<()> {
+        let mut stats = Self::read(path)?;
+        stats.hits += self.hits;
+        stats.misses += self.misses;
+        stats.renewed += self.renewed;
+        let data = serde_json::to_vec(&stats).context("Encode cache stats")?;
+        utils::write_file(path, &data)
+    }
+}
+
+impl Drop for Cache {
+    fn drop(&mut self) {
+        let stats = self.stats.borrow();
+        if stats.is_empty() {
+            return;
+        }
+        // The stats are only for diagnostics, should not fail the command.
+        if let Err(err) = stats.save(&self.stats_path) {
+            utils::show_warn(format!("Save cache stats: {err:#}"));
+        }
+    }
+}
+
+struct CacheItem<T> {
+    value: T,
+    validator: Option<Validator>,
+    expired: bool,
+}
+
 impl Provider for Cache {
     fn get_repo(&self, owner: &str, name: &str) -> Result<ApiRepo> {
         let path = self.get_repo_path(owner, name);
-        if let Some(repo) = self.read(&path)? {
-            return Ok(repo);
+        self.get_or_refresh(&path, |validator| {
+            self.upstream.get_repo_conditional(owner, name, validator)
+        })
+    }
+
+    fn get_repo_settings(&self, owner: &str, name: &str) -> Result<ApiRepoSettings> {
+        self.upstream.get_repo_settings(owner, name)
+    }
+
+    fn get_repos(&self, repos: &[(String, String)]) -> Result<Vec<Option<ApiRepo>>> {
+        let mut result = Vec::with_capacity(repos.len());
+        let mut missing = Vec::new();
+        let mut missing_idx = Vec::new();
+        for (idx, (owner, name)) in repos.iter().enumerate() {
+            let path = self.get_repo_path(owner, name);
+            match self.read::<ApiRepo>(&path)? {
+                Some(item) if !item.expired => {
+                    self.stats.borrow_mut().hits += 1;
+                    result.push(Some(item.value));
+                }
+                _ => {
+                    result.push(None);
+                    missing.push((owner.clone(), name.clone()));
+                    missing_idx.push(idx);
+                }
+            }
+        }
+        if missing.is_empty() {
+            return Ok(result);
+        }
+
+        // The batch api does not support conditional request, fetch all the
+        // missing and expired repos at once.
+        let fetched = self.upstream.get_repos(&missing)?;
+        self.stats.borrow_mut().misses += missing.len() as u64;
+        for ((idx, (owner, name)), repo) in missing_idx.into_iter().zip(missing).zip(fetched) {
+            if let Some(repo) = repo.as_ref() {
+                let path = self.get_repo_path(&owner, &name);
+                self.write(repo, None, &path)?;
+            }
+            result[idx] = repo;
         }
-        let repo = self.upstream.get_repo(owner, name)?;
-        self.write(&repo, &path)?;
-        Ok(repo)
+        Ok(result)
     }
 
     fn list_repos(&self, owner: &str) -> Result<Vec<String>> {
         let path = self.list_repos_path(owner);
-        if let Some(repos) = self.read(&path)? {
-            return Ok(repos);
-        }
-        let repos = self.upstream.list_repos(owner)?;
-        self.write(&repos, &path)?;
-        Ok(repos)
+        self.get_or_refresh(&path, |validator| {
+            self.upstream.list_repos_conditional(owner, validator)
+        })
+    }
+
+    fn update_repo(&self, owner: &str, name: &str, update: RepoUpdate) -> Result<()> {
+        self.upstream.update_repo(owner, name, update)?;
+        // The cached repo holds the old description and topics, remove it and
+        // the owner listing, so that the next read fetches them again. The
+        // repo settings are never cached.
+        self.remove(&self.get_repo_path(owner, name))?;
+        self.remove(&self.list_repos_path(owner))
     }
 
     fn get_merge(&self, merge: MergeOptions) -> Result<Option<String>> {
@@ -50,10 +156,94 @@ impl Provider for Cache {
     fn create_merge(&self, merge: MergeOptions, title: String, body: String) -> Result<String> {
         self.upstream.create_merge(merge, title, body)
     }
+
+    fn ready_merge(&self, merge: MergeOptions) -> Result<Option<String>> {
+        self.upstream.ready_merge(merge)
+    }
+
+    fn accept_merge(
+        &self,
+        merge: MergeOptions,
+        method: MergeMethod,
+        delete_source: bool,
+    ) -> Result<Option<String>> {
+        self.upstream.accept_merge(merge, method, delete_source)
+    }
+
+    fn get_merge_status(&self, merge: MergeOptions) -> Result<Option<ApiMergeStatus>> {
+        self.upstream.get_merge_status(merge)
+    }
+
+    fn compare_upstream(
+        &self,
+        owner: &str,
+        name: &str,
+        branch: &str,
+        upstream: &ApiUpstream,
+    ) -> Result<ApiCompare> {
+        // The branches change frequently, don't cache the result.
+        self.upstream
+            .compare_upstream(owner, name, branch, upstream)
+    }
+
+    fn list_notifications(&self) -> Result<Vec<ApiNotification>> {
+        self.upstream.list_notifications()
+    }
+
+    fn mark_notification_read(&self, id: &str) -> Result<()> {
+        self.upstream.mark_notification_read(id)
+    }
+
+    fn list_snippets(&self) -> Result<Vec<ApiSnippet>> {
+        self.upstream.list_snippets()
+    }
+
+    fn create_snippet(
+        &self,
+        title: &str,
+        file_name: &str,
+        content: &str,
+        public: bool,
+    ) -> Result<ApiSnippet> {
+        self.upstream
+            .create_snippet(title, file_name, content, public)
+    }
+
+    fn get_info(&self) -> Result<ApiInfo> {
+        self.upstream.get_info()
+    }
+
+    fn list_actions(
+        &self,
+        owner: &str,
+        name: &str,
+        branch: Option<&str>,
+        limit: u32,
+    ) -> Result<Vec<ApiAction>> {
+        // The runs change frequently, don't cache them.
+        self.upstream.list_actions(owner, name, branch, limit)
+    }
+
+    fn list_jobs(&self, owner: &str, name: &str, commit: &str) -> Result<Vec<ApiJob>> {
+        self.upstream.list_jobs(owner, name, commit)
+    }
+
+    fn get_job_log(&self, owner: &str, name: &str, id: u64) -> Result<String> {
+        self.upstream.get_job_log(owner, name, id)
+    }
 }
 
 impl Cache {
-    pub fn new(dir: PathBuf, hours: u64, p: Box<dyn Provider>) -> Result<Box<dyn Provider>> {
+    /// The version of cache file format, files written with other versions
+    /// are treated as missing.
+    const VERSION: u32 = 2;
+
+    pub fn new(
+        dir: PathBuf,
+        stats_path: PathBuf,
+        hours: u64,
+        p: Box<dyn Provider>,
+    ) -> Result<Box<dyn Provider>> {
         let lock = Lock::acquire("cache")?;
         let now = utils::current_time()?;
         let expire = Duration::from_secs(hours * 3600);
@@ -63,24 +253,63 @@ impl Cache {
             expire,
             now,
             upstream: p,
+            stats_path,
+            stats: RefCell::new(CacheStats::default()),
             _lock: lock,
         }))
     }
 
     fn list_repos_path(&self, owner: &str) -> PathBuf {
-        let owner = owner.replace("/", ".");
-        self.dir.join(format!("list.{owner}"))
+        self.dir.join(format!("list.{}", encode_name(owner)))
     }
 
     fn get_repo_path(&self, owner: &str, name: &str) -> PathBuf {
-        let owner = owner.replace("/", ".");
-        let name = name.replace("/", ".");
-        self.dir.join(format!("repo.{owner}.{name}"))
+        self.dir
+            .join(format!("repo.{}.{}", encode_name(owner), encode_name(name)))
+    }
+
+    /// Return the cached value if it is not expired. Otherwise, issue a
+    /// conditional request with the stored validator, if the remote reports
+    /// 304, the cached value is renewed without downloading it again.
+    fn get_or_refresh<T, F>(&self, path: &PathBuf, fetch: F) -> Result<T>
+    where
+        T: Serialize + DeserializeOwned,
+        F: FnOnce(Option<&Validator>) -> Result<Conditional<T>>,
+    {
+        let item = match self.read::<T>(path)? {
+            Some(item) if !item.expired => {
+                self.stats.borrow_mut().hits += 1;
+                return Ok(item.value);
+            }
+            item => item,
+        };
+
+        let validator = match item.as_ref() {
+            Some(item) => item.validator.as_ref(),
+            None => None,
+        };
+        match (fetch(validator)?, item) {
+            (Conditional::NotModified, Some(item)) => {
+                self.stats.borrow_mut().renewed += 1;
+                self.write(&item.value, item.validator.as_ref(), path)?;
+                Ok(item.value)
+            }
+            // The remote should never return 304 for a request without
+            // validator.
+            (Conditional::NotModified, None) => {
+                bail!("Remote returned not modified for a missing cache item")
+            }
+            (Conditional::Modified(value, validator), _) => {
+                self.stats.borrow_mut().misses += 1;
+                self.write(&value, validator.as_ref(), path)?;
+                Ok(value)
+            }
+        }
     }
 
-    fn read<T>(&self, path: &PathBuf) -> Result<Option<T>>
+    fn read<T>(&self, path: &PathBuf) -> Result<Option<CacheItem<T>>>
     where
-        T: DeserializeOwned + ?Sized,
+        T: DeserializeOwned,
     {
         let data = match fs::read(path) {
             Ok(data) => data,
@@ -90,42 +319,70 @@ impl Cache {
             }
         };
 
+        // The cache data might be written by an older version with a different
+        // format, treat it as missing.
         let decoder = &mut bincode::options().with_fixint_encoding();
-        let mut update_time: u64 = 0;
-        let update_time_size = decoder.serialized_size(&update_time).unwrap() as usize;
-        if data.len() < update_time_size {
-            bail!("Corrupted cache data in {}", path.display());
-        }
-
-        let (update_time_data, cache_data) = data.split_at(update_time_size);
-        update_time = decoder
-            .deserialize(update_time_data)
-            .context("Decode cache last_updated data")?;
-        let expire_duration = Duration::from_secs(update_time) + self.expire;
-        if self.now >= expire_duration {
+        let (version, update_time, validator, value) =
+            match decoder.deserialize::<(u32, u64, Option<Validator>, T)>(&data) {
+                Ok(cache) => cache,
+                Err(_) => {
+                    fs::remove_file(path)
+                        .with_context(|| format!("Remove cache file {}", path.display()))?;
+                    return Ok(None);
+                }
+            };
+        if version != Self::VERSION {
             fs::remove_file(path)
                 .with_context(|| format!("Remove cache file {}", path.display()))?;
             return Ok(None);
         }
 
-        let cache = decoder
-            .deserialize::<T>(cache_data)
-            .context("Decode cache data")?;
-        Ok(Some(cache))
+        let expire_duration = Duration::from_secs(update_time) + self.expire;
+        Ok(Some(CacheItem {
+            value,
+            validator,
+            expired: self.now >= expire_duration,
+        }))
+    }
+
+    fn remove(&self, path: &PathBuf) -> Result<()> {
+        match fs::remove_file(path) {
+            Ok(()) => Ok(()),
+            Err(err) if err.kind() == ErrorKind::NotFound => Ok(()),
+            Err(err) => Err(err).with_context(|| format!("Remove cache file {}", path.display())),
+        }
     }
 
-    fn write<T>(&self, value: &T, path: &PathBuf) -> Result<()>
+    fn write<T>(&self, value: &T, validator: Option<&Validator>, path: &PathBuf) -> Result<()>
     where
-        T: Serialize + ?Sized,
+        T: Serialize,
     {
         let now = self.now.as_secs();
-        let buffer_size =
-            bincode::serialized_size(&now).unwrap() + bincode::serialized_size(value).unwrap();
-        let mut buffer = Vec::with_capacity(buffer_size as usize);
+        let buffer = bincode::serialize(&(Self::VERSION, now, validator, value))
+            .context("Encode cache data")?;
+        utils::write_file(path, &buffer)
+    }
+}
 
-        bincode::serialize_into(&mut buffer, &now).context("Encode now")?;
-        bincode::serialize_into(&mut buffer, value).context("Encode cache data")?;
+/// Encode the owner or name in cache file names, the `/` (not allowed in file
+/// names) and `.` (the separator) are escaped like urls, so that the names
+/// can be split by `.` without ambiguity, such as `repo.group%2Fsub.name`.
+pub fn encode_name(name: &str) -> String {
+    name.replace("%", "%25")
+        .replace("/", "%2F")
+        .replace(".", "%2E")
+}
 
-        utils::write_file(path, &buffer)
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_encode_name() {
+        assert_eq!(encode_name("fioncat"), "fioncat");
+        assert_eq!(encode_name("group/sub"), "group%2Fsub");
+        assert_eq!(encode_name("group.sub"), "group%2Esub");
+        assert_eq!(encode_name("fioncat.github.io"), "fioncat%2Egithub%2Eio");
+        assert_eq!(encode_name("100%/x"), "100%25%2Fx");
     }
 }
@@ -1,20 +1,47 @@
+use std::collections::HashMap;
+
 use anyhow::{bail, Context, Result};
-use reqwest::blocking::{Client, Request};
-use reqwest::{Method, Url};
+use chrono::{NaiveDateTime, TimeZone, Utc};
+use reqwest::blocking::{Client, Request, Response};
+use reqwest::{Method, StatusCode, Url};
 use serde::de::DeserializeOwned;
 use serde::{Deserialize, Serialize};
 
-use crate::api::types::{ApiRepo, ApiUpstream, MergeOptions, Provider};
+use crate::api::types::{
+    ApiAction, ApiCompare, ApiInfo, ApiJob, ApiMergeStatus, ApiNotification, ApiRateLimit, ApiRepo,
+    ApiRepoSettings, ApiSnippet, ApiUpstream, Conditional, MergeMethod, MergeOptions, Provider,
+    RepoUpdate, Validator,
+};
 use crate::config::types::Remote;
+use crate::utils;
 
 #[derive(Debug, Deserialize)]
 struct Repo {
     pub name: String,
+    pub owner: Owner,
     pub html_url: String,
 
     pub source: Option<Source>,
 
     pub default_branch: String,
+
+    pub description: Option<String>,
+    #[serde(default)]
+    pub topics: Vec<String>,
+}
+
+/// The merge settings are only returned when the token has admin or push
+/// permission.
+#[derive(Debug, Deserialize)]
+struct RepoSettings {
+    visibility: String,
+    archived: bool,
+    default_branch: String,
+
+    allow_merge_commit: Option<bool>,
+    allow_squash_merge: Option<bool>,
+    allow_rebase_merge: Option<bool>,
+    delete_branch_on_merge: Option<bool>,
 }
 
 #[derive(Debug, Deserialize)]
@@ -29,6 +56,90 @@ struct Owner {
     pub login: String,
 }
 
+#[derive(Debug, Serialize)]
+struct GraphqlRequest {
+    query: String,
+    variables: HashMap<String, String>,
+}
+
+#[derive(Debug, Deserialize)]
+struct GraphqlResponse {
+    data: Option<HashMap<String, Option<GraphqlRepo>>>,
+    errors: Option<Vec<Error>>,
+}
+
+#[derive(Debug, Deserialize)]
+#[serde(rename_all = "camelCase")]
+struct GraphqlRepo {
+    name: String,
+    owner: Owner,
+    url: String,
+    default_branch_ref: Option<GraphqlRef>,
+    parent: Option<GraphqlParent>,
+    description: Option<String>,
+    repository_topics: GraphqlTopics,
+}
+
+#[derive(Debug, Deserialize)]
+struct GraphqlTopics {
+    nodes: Vec<GraphqlTopicNode>,
+}
+
+#[derive(Debug, Deserialize)]
+struct GraphqlTopicNode {
+    topic: GraphqlTopic,
+}
+
+#[derive(Debug, Deserialize)]
+struct GraphqlTopic {
+    name: String,
+}
+
+#[derive(Debug, Deserialize)]
+#[serde(rename_all = "camelCase")]
+struct GraphqlParent {
+    name: String,
+    owner: Owner,
+    default_branch_ref: Option<GraphqlRef>,
+}
+
+#[derive(Debug, Deserialize)]
+struct GraphqlRef {
+    name: String,
+}
+
+impl GraphqlRepo {
+    fn to_api(self) -> ApiRepo {
+        // The default branch ref is null for empty repo.
+        let get_branch = |branch: Option<GraphqlRef>| match branch {
+            Some(branch) => branch.name,
+            None => String::new(),
+        };
+        let upstream = match self.parent {
+            Some(parent) => Some(ApiUpstream {
+                owner: parent.owner.login,
+                name: parent.name,
+                default_branch: get_branch(parent.default_branch_ref),
+            }),
+            None => None,
+        };
+        ApiRepo {
+            owner: self.owner.login,
+            name: self.name,
+            default_branch: get_branch(self.default_branch_ref),
+            upstream,
+            web_url: self.url,
+            description: self.description,
+            topics: self
+                .repository_topics
+                .nodes
+                .into_iter()
+                .map(|node| node.topic.name)
+                .collect(),
+        }
+    }
+}
+
 #[derive(Debug, Deserialize)]
 struct Error {
     pub message: String,
@@ -38,9 +149,12 @@ impl Repo {
     fn to_api(self) -> ApiRepo {
         let Repo {
             name,
+            owner,
             html_url,
             source,
             default_branch,
+            description,
+            topics,
         } = self;
         let upstream = match source {
             Some(source) => Some(ApiUpstream {
@@ -51,10 +165,13 @@ impl Repo {
             None => None,
         };
         ApiRepo {
+            owner: owner.login,
             name,
             default_branch,
             upstream,
             web_url: html_url,
+            description,
+            topics,
         }
     }
 }
@@ -65,6 +182,12 @@ struct PullRequestOptions {
 
     head: String,
     base: String,
+
+    draft: bool,
+
+    reviewers: Vec<String>,
+    assignees: Vec<String>,
+    labels: Vec<String>,
 }
 
 #[derive(Debug, Serialize)]
@@ -74,6 +197,8 @@ struct PullRequestBody {
 
     title: String,
     body: String,
+
+    draft: bool,
 }
 
 impl From<MergeOptions> for PullRequestOptions {
@@ -84,6 +209,10 @@ impl From<MergeOptions> for PullRequestOptions {
             upstream,
             source,
             target,
+            draft,
+            reviewers,
+            assignees,
+            labels,
         } = merge;
 
         let head = match upstream {
@@ -98,6 +227,10 @@ impl From<MergeOptions> for PullRequestOptions {
             name,
             head,
             base: target,
+            draft,
+            reviewers,
+            assignees,
+            labels,
         }
     }
 }
@@ -105,9 +238,299 @@ impl From<MergeOptions> for PullRequestOptions {
 #[derive(Debug, Deserialize)]
 struct PullRequest {
     html_url: String,
+    #[serde(default)]
+    number: u64,
+    #[serde(default)]
+    node_id: String,
+    #[serde(default)]
+    draft: bool,
+}
+
+#[derive(Debug, Deserialize)]
+struct PullRequestDetail {
+    html_url: String,
+    title: String,
+    state: String,
+    draft: bool,
+    mergeable: Option<bool>,
+    mergeable_state: String,
+    head: PullRequestRef,
+    base: PullRequestRef,
+}
+
+#[derive(Debug, Deserialize)]
+struct PullRequestRef {
+    #[serde(rename = "ref")]
+    branch: String,
+    sha: String,
+}
+
+#[derive(Debug, Deserialize)]
+struct Review {
+    user: Option<Owner>,
+    state: String,
+}
+
+#[derive(Debug, Deserialize)]
+struct CheckRuns {
+    check_runs: Vec<CheckRun>,
+}
+
+#[derive(Debug, Deserialize)]
+struct CheckRun {
+    status: String,
+    conclusion: Option<String>,
+}
+
+#[derive(Debug, Serialize)]
+struct MergePullRequestBody {
+    merge_method: &'static str,
+}
+
+#[derive(Debug, Deserialize)]
+struct MergePullRequestResult {
+    merged: bool,
+    message: String,
+}
+
+#[derive(Debug, Serialize)]
+struct RequestReviewersBody {
+    reviewers: Vec<String>,
+}
+
+#[derive(Debug, Serialize)]
+struct AssigneesBody {
+    assignees: Vec<String>,
+}
+
+#[derive(Debug, Serialize)]
+struct LabelsBody {
+    labels: Vec<String>,
+}
+
+#[derive(Debug, Deserialize)]
+struct GraphqlMutationResponse {
+    errors: Option<Vec<Error>>,
+}
+
+#[derive(Debug, Deserialize)]
+struct WorkflowRuns {
+    workflow_runs: Vec<WorkflowRun>,
+}
+
+#[derive(Debug, Deserialize)]
+struct WorkflowRun {
+    id: u64,
+    name: Option<String>,
+    head_branch: Option<String>,
+    status: Option<String>,
+    conclusion: Option<String>,
+    event: String,
+    created_at: String,
+    run_started_at: Option<String>,
+    updated_at: String,
+    html_url: String,
+}
+
+impl WorkflowRun {
+    fn to_api(self) -> Result<ApiAction> {
+        let created_at = super::parse_time(&self.created_at)?;
+        // The conclusion is only available after the run is completed.
+        let (status, duration) = match self.conclusion {
+            Some(conclusion) => {
+                let started_at = match &self.run_started_at {
+                    Some(started_at) => super::parse_time(started_at)?,
+                    None => created_at,
+                };
+                let updated_at = super::parse_time(&self.updated_at)?;
+                (conclusion, Some(updated_at.saturating_sub(started_at)))
+            }
+            None => (self.status.unwrap_or(String::from("unknown")), None),
+        };
+        Ok(ApiAction {
+            name: self.name.unwrap_or_default(),
+            branch: self.head_branch.unwrap_or_default(),
+            status,
+            trigger: self.event,
+            created_at,
+            duration,
+            web_url: self.html_url,
+        })
+    }
+}
+
+#[derive(Debug, Deserialize)]
+struct WorkflowJobs {
+    jobs: Vec<WorkflowJob>,
+}
+
+#[derive(Debug, Deserialize)]
+struct WorkflowJob {
+    id: u64,
+    name: String,
+    status: String,
+    conclusion: Option<String>,
+    html_url: String,
+}
+
+impl WorkflowJob {
+    /// The conclusions of the jobs not finished successfully, their logs
+    /// might contain the errors.
+    const FAILED_CONCLUSIONS: [&'static str; 4] =
+        ["failure", "cancelled", "timed_out", "startup_failure"];
+
+    fn to_api(self) -> ApiJob {
+        let status = self.conclusion.unwrap_or(self.status);
+        ApiJob {
+            id: self.id,
+            name: self.name,
+            failed: Self::FAILED_CONCLUSIONS.contains(&status.as_str()),
+            status,
+            web_url: self.html_url,
+        }
+    }
+}
+
+#[derive(Debug, Deserialize)]
+struct Notification {
+    id: String,
+    reason: String,
+    updated_at: String,
+    subject: NotificationSubject,
+    repository: NotificationRepo,
+}
+
+#[derive(Debug, Deserialize)]
+struct NotificationSubject {
+    title: String,
+    /// The api url of the subject, None for some types, such as `CheckSuite`.
+    url: Option<String>,
+    #[serde(rename = "type")]
+    kind: String,
+}
+
+#[derive(Debug, Deserialize)]
+struct NotificationRepo {
+    name: String,
+    owner: Owner,
+    html_url: String,
+}
+
+impl Notification {
+    /// Return None if the reason is not interested.
+    fn to_api(self) -> Result<Option<ApiNotification>> {
+        let reason = match self.reason.as_str() {
+            "mention" | "team_mention" => "mention",
+            "review_requested" => "review",
+            "ci_activity" => "ci",
+            _ => return Ok(None),
+        };
+        let Notification {
+            id,
+            updated_at,
+            subject,
+            repository,
+            ..
+        } = self;
+
+        // The subject url is an api url, such as
+        // `https://api.github.com/repos/{owner}/{name}/pulls/1`, convert it to
+        // the web url.
+        let repo_path = format!("/repos/{}/{}", repository.owner.login, repository.name);
+        let web_url = match subject
+            .url
+            .as_ref()
+            .and_then(|url| url.split_once(repo_path.as_str()))
+        {
+            Some((_, path)) => format!(
+                "{}{}",
+                repository.html_url,
+                path.replacen("/pulls/", "/pull/", 1)
+            ),
+            None if reason == "ci" => format!("{}/actions", repository.html_url),
+            None => repository.html_url,
+        };
+
+        Ok(Some(ApiNotification {
+            id,
+            owner: repository.owner.login,
+            name: repository.name,
+            reason: String::from(reason),
+            kind: subject.kind,
+            title: subject.title,
+            updated_at: super::parse_time(&updated_at)?,
+            web_url,
+        }))
+    }
+}
+
+#[derive(Debug, Deserialize)]
+struct Gist {
+    id: String,
+    description: Option<String>,
+    public: bool,
+    files: HashMap<String, serde_json::Value>,
+    updated_at: String,
+    html_url: String,
+}
+
+impl Gist {
+    fn to_api(self) -> Result<ApiSnippet> {
+        let mut files: Vec<String> = self.files.into_keys().collect();
+        files.sort();
+        Ok(ApiSnippet {
+            id: self.id,
+            title: self.description.unwrap_or_default(),
+            files,
+            public: self.public,
+            updated_at: super::parse_time(&self.updated_at)?,
+            web_url: self.html_url,
+        })
+    }
+}
+
+#[derive(Debug, Serialize)]
+struct GistBody {
+    description: String,
+    public: bool,
+    files: HashMap<String, GistFileBody>,
+}
+
+#[derive(Debug, Serialize)]
+struct GistFileBody {
+    content: String,
+}
+
+#[derive(Debug, Serialize)]
+struct RepoDescriptionBody {
+    description: String,
+}
+
+#[derive(Debug, Serialize)]
+struct RepoTopicsBody {
+    names: Vec<String>,
+}
+
+#[derive(Debug, Deserialize)]
+struct Compare {
+    ahead_by: u64,
+    behind_by: u64,
+}
+
+#[derive(Debug, Deserialize)]
+struct RateLimitResponse {
+    rate: RateLimit,
+}
+
+#[derive(Debug, Deserialize)]
+struct RateLimit {
+    limit: u64,
+    remaining: u64,
+    reset: u64,
 }
 
 pub struct Github {
+    remote: String,
     token: Option<String>,
 
     client: Client,
@@ -117,15 +540,65 @@ pub struct Github {
 
 impl Provider for Github {
     fn list_repos(&self, owner: &str) -> Result<Vec<String>> {
-        let path = format!("users/{owner}/repos?per_page={}", self.per_page);
-        let github_repos = self.execute_get::<Vec<Repo>>(&path)?;
-        let repos: Vec<String> = github_repos.into_iter().map(|repo| repo.name).collect();
-        Ok(repos)
+        self.list_repos_conditional(owner, None)?.into_modified()
     }
 
     fn get_repo(&self, owner: &str, name: &str) -> Result<ApiRepo> {
-        let path = format!("repos/{}/{}", owner, name);
-        Ok(self.execute_get::<Repo>(&path)?.to_api())
+        self.get_repo_conditional(owner, name, None)?
+            .into_modified()
+    }
+
+    fn get_repo_settings(&self, owner: &str, name: &str) -> Result<ApiRepoSettings> {
+        let path = format!("repos/{owner}/{name}");
+        let settings = self.execute_get::<RepoSettings>(&path)?;
+        let mut merge_methods = Vec::with_capacity(3);
+        let methods = [
+            ("merge", settings.allow_merge_commit),
+            ("squash", settings.allow_squash_merge),
+            ("rebase", settings.allow_rebase_merge),
+        ];
+        for (method, allow) in methods {
+            if let Some(true) = allow {
+                merge_methods.push(String::from(method));
+            }
+        }
+        Ok(ApiRepoSettings {
+            visibility: settings.visibility,
+            archived: settings.archived,
+            default_branch: settings.default_branch,
+            merge_methods,
+            delete_branch_on_merge: settings.delete_branch_on_merge,
+        })
+    }
+
+    fn get_repos(&self, repos: &[(String, String)]) -> Result<Vec<Option<ApiRepo>>> {
+        // Github GraphQL api requires authentication.
+        if let None = self.token {
+            return repos
+                .iter()
+                .map(|(owner, name)| Ok(Some(self.get_repo(owner, name)?)))
+                .collect();
+        }
+        let mut result = Vec::with_capacity(repos.len());
+        for chunk in repos.chunks(Self::GRAPHQL_BATCH) {
+            result.extend(self.graphql_repos(chunk)?);
+        }
+        Ok(result)
+    }
+
+    fn update_repo(&self, owner: &str, name: &str, update: RepoUpdate) -> Result<()> {
+        let path = format!("repos/{owner}/{name}");
+        if let Some(description) = update.description {
+            let body = RepoDescriptionBody { description };
+            self.execute_patch::<_, serde_json::Value>(&path, body)?;
+        }
+        // The topics are replaced by a separate api.
+        if let Some(names) = update.topics {
+            let path = format!("{path}/topics");
+            let body = RepoTopicsBody { names };
+            self.execute_put::<_, serde_json::Value>(&path, body)?;
+        }
+        Ok(())
     }
 
     fn get_merge(&self, merge: MergeOptions) -> Result<Option<String>> {
@@ -149,22 +622,393 @@ impl Provider for Github {
             base: opts.base,
             title,
             body,
+            draft: opts.draft,
         };
         let pr = self.execute_post::<PullRequestBody, PullRequest>(&path, body)?;
+
+        // Github does not support these fields when creating PR, set them
+        // after creation. The PR already exists, so failing to set them (such
+        // as an unknown reviewer) is only a warning.
+        if !opts.reviewers.is_empty() {
+            let path = format!(
+                "repos/{}/{}/pulls/{}/requested_reviewers",
+                opts.owner, opts.name, pr.number
+            );
+            let body = RequestReviewersBody {
+                reviewers: opts.reviewers,
+            };
+            let result = self.execute_post::<RequestReviewersBody, serde_json::Value>(&path, body);
+            Self::warn_pr_field(&pr, "reviewers", result);
+        }
+        if !opts.assignees.is_empty() {
+            let path = format!(
+                "repos/{}/{}/issues/{}/assignees",
+                opts.owner, opts.name, pr.number
+            );
+            let body = AssigneesBody {
+                assignees: opts.assignees,
+            };
+            let result = self.execute_post::<AssigneesBody, serde_json::Value>(&path, body);
+            Self::warn_pr_field(&pr, "assignees", result);
+        }
+        if !opts.labels.is_empty() {
+            let path = format!(
+                "repos/{}/{}/issues/{}/labels",
+                opts.owner, opts.name, pr.number
+            );
+            let body = LabelsBody {
+                labels: opts.labels,
+            };
+            let result = self.execute_post::<LabelsBody, serde_json::Value>(&path, body);
+            Self::warn_pr_field(&pr, "labels", result);
+        }
         Ok(pr.html_url)
     }
+
+    fn ready_merge(&self, merge: MergeOptions) -> Result<Option<String>> {
+        let opts: PullRequestOptions = merge.into();
+        let path = format!(
+            "repos/{}/{}/pulls?state=open&head={}&base={}",
+            opts.owner, opts.name, opts.head, opts.base
+        );
+        let mut prs = self.execute_get::<Vec<PullRequest>>(&path)?;
+        if prs.is_empty() {
+            return Ok(None);
+        }
+        let pr = prs.remove(0);
+        if !pr.draft {
+            return Ok(Some(pr.html_url));
+        }
+
+        // The REST api does not support marking PR ready, use GraphQL instead,
+        // which requires authentication.
+        if let None = self.token {
+            bail!("Marking PR ready requires token, please config it");
+        }
+        let query = String::from(
+            "mutation($id: ID!) { markPullRequestReadyForReview(input: { pullRequestId: $id }) \
+             { pullRequest { id } } }",
+        );
+        let variables = HashMap::from([(String::from("id"), pr.node_id)]);
+        let body = GraphqlRequest { query, variables };
+        let resp = self.execute_post::<GraphqlRequest, GraphqlMutationResponse>("graphql", body)?;
+        if let Some(errors) = resp.errors {
+            if !errors.is_empty() {
+                bail!("Github graphql error: {}", errors[0].message);
+            }
+        }
+        Ok(Some(pr.html_url))
+    }
+
+    fn list_repos_conditional(
+        &self,
+        owner: &str,
+        validator: Option<&Validator>,
+    ) -> Result<Conditional<Vec<String>>> {
+        let path = format!("users/{owner}/repos?per_page={}", self.per_page);
+        Ok(
+            match self.execute_get_conditional::<Vec<Repo>>(&path, validator)? {
+                Conditional::NotModified => Conditional::NotModified,
+                Conditional::Modified(repos, validator) => Conditional::Modified(
+                    repos.into_iter().map(|repo| repo.name).collect(),
+                    validator,
+                ),
+            },
+        )
+    }
+
+    fn get_repo_conditional(
+        &self,
+        owner: &str,
+        name: &str,
+        validator: Option<&Validator>,
+    ) -> Result<Conditional<ApiRepo>> {
+        let path = format!("repos/{}/{}", owner, name);
+        Ok(
+            match self.execute_get_conditional::<Repo>(&path, validator)? {
+                Conditional::NotModified => Conditional::NotModified,
+                Conditional::Modified(repo, validator) => {
+                    Conditional::Modified(repo.to_api(), validator)
+                }
+            },
+        )
+    }
+
+    fn compare_upstream(
+        &self,
+        owner: &str,
+        _name: &str,
+        branch: &str,
+        upstream: &ApiUpstream,
+    ) -> Result<ApiCompare> {
+        // Compare in upstream repo, the head is the fork's branch (in the same
+        // network, so the owner is enough), so that `behind_by` is the commits
+        // the fork is missing.
+        let path = format!(
+            "repos/{}/{}/compare/{}...{owner}:{}",
+            upstream.owner,
+            upstream.name,
+            urlencoding::encode(&upstream.default_branch),
+            urlencoding::encode(branch)
+        );
+        let compare = self.execute_get::<Compare>(&path)?;
+        Ok(ApiCompare {
+            ahead: compare.ahead_by,
+            behind: compare.behind_by,
+        })
+    }
+
+    fn list_notifications(&self) -> Result<Vec<ApiNotification>> {
+        let path = format!("notifications?per_page={}", self.per_page);
+        let notifications = self.execute_get::<Vec<Notification>>(&path)?;
+        let mut result = Vec::with_capacity(notifications.len());
+        for notification in notifications {
+            if let Some(notification) = notification.to_api()? {
+                result.push(notification);
+            }
+        }
+        Ok(result)
+    }
+
+    fn mark_notification_read(&self, id: &str) -> Result<()> {
+        let path = format!("notifications/threads/{id}");
+        self.execute_empty(&path, Method::PATCH)
+    }
+
+    fn list_snippets(&self) -> Result<Vec<ApiSnippet>> {
+        // The gists are sorted by updated time desc by Github.
+        let path = format!("gists?per_page={}", self.per_page);
+        let gists = self.execute_get::<Vec<Gist>>(&path)?;
+        gists.into_iter().map(|gist| gist.to_api()).collect()
+    }
+
+    fn create_snippet(
+        &self,
+        title: &str,
+        file_name: &str,
+        content: &str,
+        public: bool,
+    ) -> Result<ApiSnippet> {
+        let mut files = HashMap::with_capacity(1);
+        files.insert(
+            file_name.to_string(),
+            GistFileBody {
+                content: content.to_string(),
+            },
+        );
+        let body = GistBody {
+            description: title.to_string(),
+            public,
+            files,
+        };
+        self.execute_post::<_, Gist>("gists", body)?.to_api()
+    }
+
+    fn get_info(&self) -> Result<ApiInfo> {
+        let req = self.build_request("rate_limit", Method::GET, None)?;
+        let resp = self.send(req)?;
+        // The token scopes are returned by response header, see:
+        // https://docs.github.com/en/apps/oauth-apps/building-oauth-apps/scopes-for-oauth-apps
+        let scopes = match resp.headers().get("X-OAuth-Scopes") {
+            Some(value) => {
+                let value = value.to_str().context("Decode Github scopes header")?;
+                let scopes: Vec<String> = value
+                    .split(",")
+                    .map(|scope| scope.trim().to_string())
+                    .filter(|scope| !scope.is_empty())
+                    .collect();
+                Some(scopes)
+            }
+            None => None,
+        };
+        let token_expires_at = Self::get_token_expire(&resp);
+        let rate = Self::parse_response::<RateLimitResponse>(resp)?.rate;
+        Ok(ApiInfo {
+            scopes,
+            token_expires_at,
+            rate_limit: Some(ApiRateLimit {
+                limit: rate.limit,
+                remaining: rate.remaining,
+                reset: rate.reset,
+            }),
+        })
+    }
+
+    fn accept_merge(
+        &self,
+        merge: MergeOptions,
+        method: MergeMethod,
+        delete_source: bool,
+    ) -> Result<Option<String>> {
+        // The source branch belongs to the head repo, which is the fork in
+        // upstream mode.
+        let source_path = format!(
+            "repos/{}/{}/git/refs/heads/{}",
+            merge.owner, merge.name, merge.source
+        );
+        let opts: PullRequestOptions = merge.into();
+        let path = format!(
+            "repos/{}/{}/pulls?state=open&head={}&base={}",
+            opts.owner, opts.name, opts.head, opts.base
+        );
+        let mut prs = self.execute_get::<Vec<PullRequest>>(&path)?;
+        if prs.is_empty() {
+            return Ok(None);
+        }
+        let pr = prs.remove(0);
+
+        let path = format!(
+            "repos/{}/{}/pulls/{}/merge",
+            opts.owner, opts.name, pr.number
+        );
+        let body = MergePullRequestBody {
+            merge_method: method.as_str(),
+        };
+        let result =
+            self.execute_put::<MergePullRequestBody, MergePullRequestResult>(&path, body)?;
+        if !result.merged {
+            bail!("Github refused to merge PR: {}", result.message);
+        }
+
+        if delete_source {
+            self.execute_delete(&source_path)?;
+        }
+        Ok(Some(pr.html_url))
+    }
+
+    fn get_merge_status(&self, merge: MergeOptions) -> Result<Option<ApiMergeStatus>> {
+        // The PR from fork is opened in upstream, its head is still the fork's
+        // branch.
+        let head = format!("{}:{}", merge.owner, merge.source);
+        let (owner, name) = match merge.upstream {
+            Some(upstream) => (upstream.owner, upstream.name),
+            None => (merge.owner, merge.name),
+        };
+        let path = format!("repos/{owner}/{name}/pulls?state=open&head={head}");
+        let mut prs = self.execute_get::<Vec<PullRequest>>(&path)?;
+        if prs.is_empty() {
+            return Ok(None);
+        }
+        let number = prs.remove(0).number;
+        let path = format!("repos/{owner}/{name}/pulls/{number}");
+        let pr = self.execute_get::<PullRequestDetail>(&path)?;
+
+        // Only the latest review of each user counts.
+        let path = format!("repos/{owner}/{name}/pulls/{number}/reviews?per_page=100");
+        let reviews = self.execute_get::<Vec<Review>>(&path)?;
+        let mut latest: HashMap<String, String> = HashMap::new();
+        for review in reviews {
+            if let Some(user) = review.user {
+                latest.insert(user.login, review.state);
+            }
+        }
+        let approvals = latest
+            .values()
+            .filter(|state| state.as_str() == "APPROVED")
+            .count() as u64;
+
+        let path = format!(
+            "repos/{owner}/{name}/commits/{}/check-runs?per_page=100",
+            pr.head.sha
+        );
+        let runs = self.execute_get::<CheckRuns>(&path)?.check_runs;
+        let ci_status = if runs.is_empty() {
+            None
+        } else if runs.iter().any(|run| run.status != "completed") {
+            Some(String::from("pending"))
+        } else if runs.iter().all(|run| {
+            matches!(
+                run.conclusion.as_deref(),
+                Some("success") | Some("skipped") | Some("neutral")
+            )
+        }) {
+            Some(String::from("success"))
+        } else {
+            Some(String::from("failure"))
+        };
+
+        Ok(Some(ApiMergeStatus {
+            title: pr.title,
+            state: pr.state,
+            draft: pr.draft,
+            source: pr.head.branch,
+            target: pr.base.branch,
+            approvals,
+            ci_status,
+            mergeable: pr.mergeable,
+            conflicts: pr.mergeable_state == "dirty",
+            web_url: pr.html_url,
+        }))
+    }
+
+    fn list_actions(
+        &self,
+        owner: &str,
+        name: &str,
+        branch: Option<&str>,
+        limit: u32,
+    ) -> Result<Vec<ApiAction>> {
+        let mut path = format!("repos/{owner}/{name}/actions/runs?per_page={limit}");
+        if let Some(branch) = branch {
+            path.push_str(&format!("&branch={}", urlencoding::encode(branch)));
+        }
+        let runs = self.execute_get::<WorkflowRuns>(&path)?;
+        runs.workflow_runs
+            .into_iter()
+            .map(|run| run.to_api())
+            .collect()
+    }
+
+    fn list_jobs(&self, owner: &str, name: &str, commit: &str) -> Result<Vec<ApiJob>> {
+        let path = format!(
+            "repos/{owner}/{name}/actions/runs?head_sha={commit}&per_page={}",
+            self.per_page
+        );
+        let runs = self.execute_get::<WorkflowRuns>(&path)?;
+        let mut jobs = Vec::new();
+        for run in runs.workflow_runs {
+            let path = format!(
+                "repos/{owner}/{name}/actions/runs/{}/jobs?filter=latest&per_page={}",
+                run.id, self.per_page
+            );
+            let run_jobs = self.execute_get::<WorkflowJobs>(&path)?;
+            jobs.extend(run_jobs.jobs.into_iter().map(|job| job.to_api()));
+        }
+        Ok(jobs)
+    }
+
+    fn get_job_log(&self, owner: &str, name: &str, id: u64) -> Result<String> {
+        // Github redirects to a temporary url to download the log.
+        let path = format!("repos/{owner}/{name}/actions/jobs/{id}/logs");
+        self.execute_get_text(&path)
+    }
 }
 
 impl Github {
     const API_VERSION: &str = "2022-11-28";
 
-    pub fn new(remote: &Remote) -> Box<dyn Provider> {
-        let client = super::build_common_client(remote);
-        Box::new(Github {
-            token: remote.token.clone(),
+    /// The max number of repos to query in one GraphQL request.
+    const GRAPHQL_BATCH: usize = 100;
+
+    const GRAPHQL_REPO_FIELDS: &str = "fragment RepoFields on Repository { \
+        name owner { login } url defaultBranchRef { name } description \
+        repositoryTopics(first: 20) { nodes { topic { name } } } \
+        parent { name owner { login } defaultBranchRef { name } } }";
+
+    pub fn new(remote: &Remote, token: Option<String>) -> Result<Box<dyn Provider>> {
+        let client = super::build_common_client(remote)?;
+        Ok(Box::new(Github {
+            remote: remote.name.clone(),
+            token,
             per_page: remote.list_limit,
             client,
-        })
+        }))
+    }
+
+    fn warn_pr_field<T>(pr: &PullRequest, field: &str, result: Result<T>) {
+        if let Err(err) = result {
+            utils::show_warn(format!("Set {field} of PR {} failed: {err:#}", pr.html_url));
+        }
     }
 
     fn execute_get<T>(&self, path: &str) -> Result<T>
@@ -175,6 +1019,49 @@ impl Github {
         self.execute(req)
     }
 
+    /// Query the repos in one GraphQL request, each repo is queried with an
+    /// alias `r{idx}`, so that the result can be mapped back.
+    fn graphql_repos(&self, repos: &[(String, String)]) -> Result<Vec<Option<ApiRepo>>> {
+        let mut params = Vec::with_capacity(repos.len() * 2);
+        let mut fields = Vec::with_capacity(repos.len());
+        let mut variables = HashMap::with_capacity(repos.len() * 2);
+        for (idx, (owner, name)) in repos.iter().enumerate() {
+            params.push(format!("$o{idx}: String!"));
+            params.push(format!("$n{idx}: String!"));
+            fields.push(format!(
+                "r{idx}: repository(owner: $o{idx}, name: $n{idx}) {{ ...RepoFields }}"
+            ));
+            variables.insert(format!("o{idx}"), owner.clone());
+            variables.insert(format!("n{idx}"), name.clone());
+        }
+        let query = format!(
+            "query({}) {{ {} }} {}",
+            params.join(", "),
+            fields.join(" "),
+            Self::GRAPHQL_REPO_FIELDS
+        );
+
+        let body = GraphqlRequest { query, variables };
+        let resp = self.execute_post::<GraphqlRequest, GraphqlResponse>("graphql", body)?;
+        // Repos that could not be found are reported in `errors` with null
+        // data, only fail if the whole query failed.
+        let mut data = match resp.data {
+            Some(data) => data,
+            None => match resp.errors {
+                Some(errors) if !errors.is_empty() => {
+                    bail!("Github graphql error: {}", errors[0].message)
+                }
+                _ => bail!("Github graphql returned empty data"),
+            },
+        };
+        let mut result = Vec::with_capacity(repos.len());
+        for idx in 0..repos.len() {
+            let repo = data.remove(&format!("r{idx}")).flatten();
+            result.push(repo.map(|repo| repo.to_api()));
+        }
+        Ok(result)
+    }
+
     fn execute_post<B, R>(&self, path: &str, body: B) -> Result<R>
     where
         B: Serialize,
@@ -185,18 +1072,127 @@ impl Github {
         self.execute(req)
     }
 
+    fn execute_put<B, R>(&self, path: &str, body: B) -> Result<R>
+    where
+        B: Serialize,
+        R: DeserializeOwned + ?Sized,
+    {
+        let body = serde_json::to_vec(&body).context("Encode Github request body")?;
+        let req = self.build_request(path, Method::PUT, Some(body))?;
+        self.execute(req)
+    }
+
+    fn execute_patch<B, R>(&self, path: &str, body: B) -> Result<R>
+    where
+        B: Serialize,
+        R: DeserializeOwned + ?Sized,
+    {
+        let body = serde_json::to_vec(&body).context("Encode Github request body")?;
+        let req = self.build_request(path, Method::PATCH, Some(body))?;
+        self.execute(req)
+    }
+
+    /// The delete API returns no content when succeeded.
+    fn execute_delete(&self, path: &str) -> Result<()> {
+        let req = self.build_request(path, Method::DELETE, None)?;
+        let resp = self.send(req)?;
+        if resp.status().is_success() {
+            return Ok(());
+        }
+        let data = resp.bytes().context("Read Github response body")?;
+        Self::parse_error(&data)
+    }
+
+    /// Some APIs (such as marking notification read) return no content when
+    /// succeeded.
+    fn execute_empty(&self, path: &str, method: Method) -> Result<()> {
+        let req = self.build_request(path, method, None)?;
+        let resp = self.send(req)?;
+        if resp.status().is_success() {
+            return Ok(());
+        }
+        let data = resp.bytes().context("Read Github response body")?;
+        Self::parse_error(&data)
+    }
+
+    fn execute_get_conditional<T>(
+        &self,
+        path: &str,
+        validator: Option<&Validator>,
+    ) -> Result<Conditional<T>>
+    where
+        T: DeserializeOwned,
+    {
+        let req = self.build_request(path, Method::GET, None)?;
+        let req = super::with_validator(req, validator)?;
+        let resp = self.send(req)?;
+        if resp.status() == StatusCode::NOT_MODIFIED {
+            return Ok(Conditional::NotModified);
+        }
+        let validator = super::get_validator(&resp);
+        let value = Self::parse_response(resp)?;
+        Ok(Conditional::Modified(value, validator))
+    }
+
+    /// Send the request, all requests should go through this to detect the
+    /// token problems.
+    fn send(&self, req: Request) -> Result<Response> {
+        let resp = self.client.execute(req).context("Github http request")?;
+        super::check_auth(&self.remote, &resp)?;
+        if let Some(expires_at) = Self::get_token_expire(&resp) {
+            super::remind_token_expire(&self.remote, expires_at);
+        }
+        Ok(resp)
+    }
+
+    /// The expiration of fine-grained token (or PAT with expiration) is
+    /// returned by response header, such as `2023-04-21 09:38:12 UTC`, see:
+    /// https://docs.github.com/en/rest/overview/troubleshooting-the-rest-api#token-expiration
+    fn get_token_expire(resp: &Response) -> Option<u64> {
+        let value = resp
+            .headers()
+            .get("GitHub-Authentication-Token-Expiration")?
+            .to_str()
+            .ok()?;
+        let time =
+            NaiveDateTime::parse_from_str(value.trim_end_matches(" UTC"), "%Y-%m-%d %H:%M:%S")
+                .ok()?;
+        Some(Utc.from_utc_datetime(&time).timestamp().max(0) as u64)
+    }
+
     fn execute<T>(&self, req: Request) -> Result<T>
     where
         T: DeserializeOwned + ?Sized,
     {
-        let resp = self.client.execute(req).context("Github http request")?;
+        let resp = self.send(req)?;
+        Self::parse_response(resp)
+    }
+
+    fn execute_get_text(&self, path: &str) -> Result<String> {
+        let req = self.build_request(path, Method::GET, None)?;
+        let resp = self.send(req)?;
+        let ok = resp.status().is_success();
+        let data = resp.bytes().context("Read Github response body")?;
+        if ok {
+            return Ok(String::from_utf8_lossy(&data).into_owned());
+        }
+        Self::parse_error(&data)
+    }
+
+    fn parse_response<T>(resp: Response) -> Result<T>
+    where
+        T: DeserializeOwned + ?Sized,
+    {
         let ok = resp.status().is_success();
         let data = resp.bytes().context("Read Github response body")?;
         if ok {
             return serde_json::from_slice(&data).context("Decode Github response data");
         }
+        Self::parse_error(&data)
+    }
 
-        match serde_json::from_slice::<Error>(&data) {
+    fn parse_error<T>(data: &[u8]) -> Result<T> {
+        match serde_json::from_slice::<Error>(data) {
             Ok(err) => bail!("Github api error: {}", err.message),
             Err(_err) => bail!(
                 "Unknown Github api error: {}",
@@ -1,33 +1,272 @@
 use anyhow::{bail, Context, Result};
-use reqwest::blocking::{Client, Request};
-use reqwest::{Method, Url};
-use serde::de::DeserializeOwned;
+use chrono::{NaiveDate, TimeZone, Utc};
+use reqwest::blocking::{Client, Request, Response};
+use reqwest::{Method, StatusCode, Url};
+use serde::de::{DeserializeOwned, IgnoredAny};
 use serde::{Deserialize, Serialize};
 
-use crate::api::types::{ApiRepo, MergeOptions, Provider};
+use crate::api::types::{
+    ApiAction, ApiCompare, ApiInfo, ApiJob, ApiMergeStatus, ApiNotification, ApiRateLimit, ApiRepo,
+    ApiRepoSettings, ApiSnippet, ApiUpstream, Conditional, MergeMethod, MergeOptions, Provider,
+    RepoUpdate, Validator,
+};
 use crate::config::types::Remote;
 
 #[derive(Debug, Deserialize)]
 struct GitlabRepo {
     pub path: String,
+    pub namespace: GitlabNamespace,
     pub default_branch: String,
     pub web_url: String,
+    pub description: Option<String>,
+    #[serde(default)]
+    pub topics: Vec<String>,
 }
 
 impl GitlabRepo {
     fn to_api(self) -> ApiRepo {
         ApiRepo {
+            owner: self.namespace.full_path,
             name: self.path,
             default_branch: self.default_branch,
             upstream: None,
             web_url: self.web_url,
+            description: self.description,
+            topics: self.topics,
         }
     }
 }
 
+#[derive(Debug, Deserialize)]
+struct GitlabRepoSettings {
+    visibility: String,
+    archived: bool,
+    #[serde(default)]
+    default_branch: String,
+
+    /// One of `merge`, `rebase_merge` and `ff`.
+    merge_method: String,
+    /// One of `never`, `always`, `default_on` and `default_off`.
+    squash_option: Option<String>,
+    remove_source_branch_after_merge: Option<bool>,
+}
+
+#[derive(Debug, Deserialize)]
+struct GitlabNamespace {
+    pub full_path: String,
+}
+
 #[derive(Debug, Deserialize)]
 struct MergeRequest {
     web_url: String,
+    #[serde(default)]
+    iid: u64,
+    #[serde(default)]
+    title: String,
+}
+
+#[derive(Debug, Deserialize)]
+struct MergeRequestDetail {
+    web_url: String,
+    title: String,
+    state: String,
+    draft: bool,
+    source_branch: String,
+    target_branch: String,
+    has_conflicts: bool,
+    /// See: https://docs.gitlab.com/ee/api/merge_requests.html#merge-status
+    detailed_merge_status: Option<String>,
+    head_pipeline: Option<HeadPipeline>,
+}
+
+#[derive(Debug, Deserialize)]
+struct HeadPipeline {
+    status: String,
+}
+
+#[derive(Debug, Deserialize)]
+struct Approvals {
+    approved_by: Vec<serde_json::Value>,
+}
+
+#[derive(Debug, Serialize)]
+struct UpdateProject {
+    #[serde(skip_serializing_if = "Option::is_none")]
+    description: Option<String>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    topics: Option<Vec<String>>,
+}
+
+#[derive(Debug, Serialize)]
+struct UpdateMergeRequest {
+    title: String,
+}
+
+#[derive(Debug, Serialize)]
+struct AcceptMergeRequest {
+    squash: bool,
+    should_remove_source_branch: bool,
+}
+
+#[derive(Debug, Deserialize)]
+struct Pipeline {
+    id: u64,
+    #[serde(rename = "ref")]
+    branch: String,
+    status: String,
+    source: String,
+    created_at: String,
+    updated_at: String,
+    web_url: String,
+}
+
+impl Pipeline {
+    const FINISHED_STATUS: [&str; 4] = ["success", "failed", "canceled", "skipped"];
+
+    fn to_api(self) -> Result<ApiAction> {
+        let created_at = super::parse_time(&self.created_at)?;
+        // The pipeline list api does not return duration, use the update time
+        // of the finished pipeline as its finish time.
+        let duration = if Self::FINISHED_STATUS.contains(&self.status.as_str()) {
+            let updated_at = super::parse_time(&self.updated_at)?;
+            Some(updated_at.saturating_sub(created_at))
+        } else {
+            None
+        };
+        Ok(ApiAction {
+            name: format!("#{}", self.id),
+            branch: self.branch,
+            status: self.status,
+            trigger: self.source,
+            created_at,
+            duration,
+            web_url: self.web_url,
+        })
+    }
+}
+
+#[derive(Debug, Deserialize)]
+struct Todo {
+    id: u64,
+    project: Option<TodoProject>,
+    action_name: String,
+    target_type: String,
+    /// The title of the target.
+    body: String,
+    target_url: String,
+    updated_at: String,
+}
+
+#[derive(Debug, Deserialize)]
+struct TodoProject {
+    path_with_namespace: String,
+}
+
+impl Todo {
+    /// Return None if the action is not interested.
+    fn to_api(self) -> Result<Option<ApiNotification>> {
+        let reason = match self.action_name.as_str() {
+            "mentioned" | "directly_addressed" => "mention",
+            "review_requested" => "review",
+            "build_failed" => "ci",
+            _ => return Ok(None),
+        };
+        // The todo of group (such as epic) has no project.
+        let (owner, name) = match self
+            .project
+            .as_ref()
+            .and_then(|project| project.path_with_namespace.rsplit_once('/'))
+        {
+            Some((owner, name)) => (owner.to_string(), name.to_string()),
+            None => return Ok(None),
+        };
+        Ok(Some(ApiNotification {
+            id: format!("{}", self.id),
+            owner,
+            name,
+            reason: String::from(reason),
+            kind: self.target_type,
+            title: self.body,
+            updated_at: super::parse_time(&self.updated_at)?,
+            web_url: self.target_url,
+        }))
+    }
+}
+
+#[derive(Debug, Deserialize)]
+struct Snippet {
+    id: u64,
+    title: String,
+    /// One of `private`, `internal` and `public`.
+    visibility: String,
+    /// The old Gitlab only returns one `file_name`.
+    file_name: Option<String>,
+    #[serde(default)]
+    files: Vec<SnippetFile>,
+    updated_at: String,
+    web_url: String,
+}
+
+#[derive(Debug, Deserialize)]
+struct SnippetFile {
+    path: String,
+}
+
+impl Snippet {
+    fn to_api(self) -> Result<ApiSnippet> {
+        let mut files: Vec<String> = self.files.into_iter().map(|file| file.path).collect();
+        if files.is_empty() {
+            files.extend(self.file_name);
+        }
+        Ok(ApiSnippet {
+            id: format!("{}", self.id),
+            title: self.title,
+            files,
+            public: self.visibility == "public",
+            updated_at: super::parse_time(&self.updated_at)?,
+            web_url: self.web_url,
+        })
+    }
+}
+
+#[derive(Debug, Serialize)]
+struct CreateSnippet {
+    title: String,
+    visibility: &'static str,
+    files: Vec<SnippetFileBody>,
+}
+
+#[derive(Debug, Serialize)]
+struct SnippetFileBody {
+    file_path: String,
+    content: String,
+}
+
+#[derive(Debug, Deserialize)]
+struct Job {
+    id: u64,
+    name: String,
+    status: String,
+    web_url: String,
+}
+
+impl Job {
+    fn to_api(self) -> ApiJob {
+        ApiJob {
+            id: self.id,
+            name: self.name,
+            failed: self.status == "failed",
+            status: self.status,
+            web_url: self.web_url,
+        }
+    }
+}
+
+#[derive(Debug, Deserialize)]
+struct PersonalAccessToken {
+    scopes: Vec<String>,
+    /// The date such as `2024-01-01`, None means never expire.
+    expires_at: Option<String>,
 }
 
 #[derive(Debug, Serialize)]
@@ -37,9 +276,23 @@ struct CreateMergeRequest {
     target_branch: String,
     title: String,
     description: String,
+
+    #[serde(skip_serializing_if = "Vec::is_empty")]
+    reviewer_ids: Vec<u64>,
+    #[serde(skip_serializing_if = "Vec::is_empty")]
+    assignee_ids: Vec<u64>,
+    /// The labels split by comma.
+    #[serde(skip_serializing_if = "String::is_empty")]
+    labels: String,
+}
+
+#[derive(Debug, Deserialize)]
+struct User {
+    id: u64,
 }
 
 pub struct Gitlab {
+    remote: String,
     token: Option<String>,
 
     client: Client,
@@ -56,17 +309,43 @@ struct GitlabError {
 
 impl Provider for Gitlab {
     fn list_repos(&self, owner: &str) -> Result<Vec<String>> {
-        let path = format!("groups/{owner}/projects?per_page={}", self.per_page);
-        let gitlab_repos = self.execute_get::<Vec<GitlabRepo>>(&path)?;
-        let repos: Vec<String> = gitlab_repos.into_iter().map(|repo| repo.path).collect();
-        Ok(repos)
+        self.list_repos_conditional(owner, None)?.into_modified()
     }
 
     fn get_repo(&self, owner: &str, name: &str) -> Result<ApiRepo> {
+        self.get_repo_conditional(owner, name, None)?
+            .into_modified()
+    }
+
+    fn get_repo_settings(&self, owner: &str, name: &str) -> Result<ApiRepoSettings> {
+        let id = format!("{owner}/{name}");
+        let id_encode = urlencoding::encode(&id);
+        let path = format!("projects/{id_encode}");
+        let settings = self.execute_get::<GitlabRepoSettings>(&path)?;
+        let mut merge_methods = vec![settings.merge_method];
+        match settings.squash_option.as_deref() {
+            Some("never") => {}
+            _ => merge_methods.push(String::from("squash")),
+        }
+        Ok(ApiRepoSettings {
+            visibility: settings.visibility,
+            archived: settings.archived,
+            default_branch: settings.default_branch,
+            merge_methods,
+            delete_branch_on_merge: settings.remove_source_branch_after_merge,
+        })
+    }
+
+    fn update_repo(&self, owner: &str, name: &str, update: RepoUpdate) -> Result<()> {
         let id = format!("{owner}/{name}");
         let id_encode = urlencoding::encode(&id);
         let path = format!("projects/{id_encode}");
-        Ok(self.execute_get::<GitlabRepo>(&path)?.to_api())
+        let body = UpdateProject {
+            description: update.description,
+            topics: update.topics,
+        };
+        self.execute_put::<_, serde_json::Value>(&path, body)?;
+        Ok(())
     }
 
     fn get_merge(&self, merge: MergeOptions) -> Result<Option<String>> {
@@ -77,7 +356,8 @@ impl Provider for Gitlab {
         let id_encode = urlencoding::encode(&id);
         let path = format!(
             "projects/{id_encode}/merge_requests?state=opened&source_branch={}&target_branch={}",
-            merge.source, merge.target
+            urlencoding::encode(&merge.source),
+            urlencoding::encode(&merge.target)
         );
         let mut mrs = self.execute_get::<Vec<MergeRequest>>(&path)?;
         if mrs.is_empty() {
@@ -93,23 +373,334 @@ impl Provider for Gitlab {
         let id = format!("{}/{}", merge.owner, merge.name);
         let id_encode = urlencoding::encode(&id);
         let path = format!("projects/{id_encode}/merge_requests");
+        // Gitlab marks merge request as draft by the title prefix.
+        let title = if merge.draft {
+            format!("Draft: {title}")
+        } else {
+            title
+        };
         let create = CreateMergeRequest {
             id,
             source_branch: merge.source,
             target_branch: merge.target,
             title,
             description: body,
+            reviewer_ids: self.get_user_ids(&merge.reviewers)?,
+            assignee_ids: self.get_user_ids(&merge.assignees)?,
+            labels: merge.labels.join(","),
         };
         let mr = self.execute_post::<CreateMergeRequest, MergeRequest>(&path, create)?;
         Ok(mr.web_url)
     }
+
+    fn ready_merge(&self, merge: MergeOptions) -> Result<Option<String>> {
+        if let Some(_) = merge.upstream {
+            bail!("Gitlab now does not support upstream");
+        }
+        let id = format!("{}/{}", merge.owner, merge.name);
+        let id_encode = urlencoding::encode(&id);
+        let path = format!(
+            "projects/{id_encode}/merge_requests?state=opened&source_branch={}&target_branch={}",
+            urlencoding::encode(&merge.source),
+            urlencoding::encode(&merge.target)
+        );
+        let mut mrs = self.execute_get::<Vec<MergeRequest>>(&path)?;
+        if mrs.is_empty() {
+            return Ok(None);
+        }
+        let mr = mrs.remove(0);
+
+        // Gitlab marks merge request as draft by the title prefix, remove it
+        // to mark ready.
+        let mut title = mr.title.as_str();
+        loop {
+            let prefix = Self::DRAFT_PREFIXES.iter().find(|prefix| {
+                title.len() >= prefix.len() && title[..prefix.len()].eq_ignore_ascii_case(prefix)
+            });
+            match prefix {
+                Some(prefix) => title = title[prefix.len()..].trim_start(),
+                None => break,
+            }
+        }
+        if title == mr.title {
+            return Ok(Some(mr.web_url));
+        }
+        let path = format!("projects/{id_encode}/merge_requests/{}", mr.iid);
+        let update = UpdateMergeRequest {
+            title: title.to_string(),
+        };
+        let mr = self.execute_put::<UpdateMergeRequest, MergeRequest>(&path, update)?;
+        Ok(Some(mr.web_url))
+    }
+
+    fn list_repos_conditional(
+        &self,
+        owner: &str,
+        validator: Option<&Validator>,
+    ) -> Result<Conditional<Vec<String>>> {
+        // The nested group, such as `group/subgroup`, should be encoded as
+        // the group id.
+        let owner_encode = urlencoding::encode(owner);
+        let path = format!("groups/{owner_encode}/projects?per_page={}", self.per_page);
+        Ok(
+            match self.execute_get_conditional::<Vec<GitlabRepo>>(&path, validator)? {
+                Conditional::NotModified => Conditional::NotModified,
+                Conditional::Modified(repos, validator) => Conditional::Modified(
+                    repos.into_iter().map(|repo| repo.path).collect(),
+                    validator,
+                ),
+            },
+        )
+    }
+
+    fn get_repo_conditional(
+        &self,
+        owner: &str,
+        name: &str,
+        validator: Option<&Validator>,
+    ) -> Result<Conditional<ApiRepo>> {
+        let id = format!("{owner}/{name}");
+        let id_encode = urlencoding::encode(&id);
+        let path = format!("projects/{id_encode}");
+        Ok(
+            match self.execute_get_conditional::<GitlabRepo>(&path, validator)? {
+                Conditional::NotModified => Conditional::NotModified,
+                Conditional::Modified(repo, validator) => {
+                    Conditional::Modified(repo.to_api(), validator)
+                }
+            },
+        )
+    }
+
+    fn compare_upstream(
+        &self,
+        _owner: &str,
+        _name: &str,
+        _branch: &str,
+        _upstream: &ApiUpstream,
+    ) -> Result<ApiCompare> {
+        bail!("Gitlab now does not support upstream");
+    }
+
+    fn list_notifications(&self) -> Result<Vec<ApiNotification>> {
+        let path = format!("todos?state=pending&per_page={}", self.per_page);
+        let todos = self.execute_get::<Vec<Todo>>(&path)?;
+        let mut result = Vec::with_capacity(todos.len());
+        for todo in todos {
+            if let Some(notification) = todo.to_api()? {
+                result.push(notification);
+            }
+        }
+        Ok(result)
+    }
+
+    fn mark_notification_read(&self, id: &str) -> Result<()> {
+        let path = format!("todos/{id}/mark_as_done");
+        self.execute_post::<_, serde_json::Value>(&path, serde_json::json!({}))?;
+        Ok(())
+    }
+
+    fn list_snippets(&self) -> Result<Vec<ApiSnippet>> {
+        let path = format!("snippets?per_page={}", self.per_page);
+        let snippets = self.execute_get::<Vec<Snippet>>(&path)?;
+        let mut snippets = snippets
+            .into_iter()
+            .map(|snippet| snippet.to_api())
+            .collect::<Result<Vec<_>>>()?;
+        snippets.sort_unstable_by(|a, b| b.updated_at.cmp(&a.updated_at));
+        Ok(snippets)
+    }
+
+    fn create_snippet(
+        &self,
+        title: &str,
+        file_name: &str,
+        content: &str,
+        public: bool,
+    ) -> Result<ApiSnippet> {
+        let body = CreateSnippet {
+            title: title.to_string(),
+            visibility: if public { "public" } else { "private" },
+            files: vec![SnippetFileBody {
+                file_path: file_name.to_string(),
+                content: content.to_string(),
+            }],
+        };
+        self.execute_post::<_, Snippet>("snippets", body)?.to_api()
+    }
+
+    fn get_info(&self) -> Result<ApiInfo> {
+        let req = self.build_request("personal_access_tokens/self", Method::GET, None)?;
+        let resp = self.client.execute(req).context("Gitlab http request")?;
+        if !resp.status().is_success() {
+            // The endpoint only accepts personal access tokens. The OAuth
+            // token (such as from `roxide auth login`) has no scopes and
+            // expiration to show, only verify it by getting the current user.
+            let req = self.build_request("user", Method::GET, None)?;
+            let resp = self.send(req)?;
+            let rate_limit = Self::get_rate_limit(&resp);
+            Self::parse_response::<IgnoredAny>(resp)?;
+            return Ok(ApiInfo {
+                scopes: None,
+                token_expires_at: None,
+                rate_limit,
+            });
+        }
+        let rate_limit = Self::get_rate_limit(&resp);
+        let token = Self::parse_response::<PersonalAccessToken>(resp)?;
+        // The token expires at the midnight of the date in UTC.
+        let token_expires_at = match &token.expires_at {
+            Some(date) => {
+                let date = NaiveDate::parse_from_str(date, "%Y-%m-%d")
+                    .with_context(|| format!("Parse token expiration date {date}"))?;
+                let time = Utc.from_utc_datetime(&date.and_hms_opt(0, 0, 0).unwrap());
+                Some(time.timestamp().max(0) as u64)
+            }
+            None => None,
+        };
+        if let Some(expires_at) = token_expires_at {
+            super::remind_token_expire(&self.remote, expires_at);
+        }
+        Ok(ApiInfo {
+            scopes: Some(token.scopes),
+            token_expires_at,
+            rate_limit,
+        })
+    }
+
+    fn accept_merge(
+        &self,
+        merge: MergeOptions,
+        method: MergeMethod,
+        delete_source: bool,
+    ) -> Result<Option<String>> {
+        if let Some(_) = merge.upstream {
+            bail!("Gitlab now does not support upstream");
+        }
+        // Whether to rebase or create merge commit is decided by the project's
+        // merge method setting, it cannot be chosen per merge request.
+        if let MergeMethod::Rebase = method {
+            bail!("Gitlab does not support rebase method, please config it in project");
+        }
+        let id = format!("{}/{}", merge.owner, merge.name);
+        let id_encode = urlencoding::encode(&id);
+        let path = format!(
+            "projects/{id_encode}/merge_requests?state=opened&source_branch={}&target_branch={}",
+            urlencoding::encode(&merge.source),
+            urlencoding::encode(&merge.target)
+        );
+        let mut mrs = self.execute_get::<Vec<MergeRequest>>(&path)?;
+        if mrs.is_empty() {
+            return Ok(None);
+        }
+        let mr = mrs.remove(0);
+
+        let path = format!("projects/{id_encode}/merge_requests/{}/merge", mr.iid);
+        let body = AcceptMergeRequest {
+            squash: method == MergeMethod::Squash,
+            should_remove_source_branch: delete_source,
+        };
+        let mr = self.execute_put::<AcceptMergeRequest, MergeRequest>(&path, body)?;
+        Ok(Some(mr.web_url))
+    }
+
+    fn get_merge_status(&self, merge: MergeOptions) -> Result<Option<ApiMergeStatus>> {
+        if let Some(_) = merge.upstream {
+            bail!("Gitlab now does not support upstream");
+        }
+        let id = format!("{}/{}", merge.owner, merge.name);
+        let id_encode = urlencoding::encode(&id);
+        let path = format!(
+            "projects/{id_encode}/merge_requests?state=opened&source_branch={}",
+            urlencoding::encode(&merge.source)
+        );
+        let mut mrs = self.execute_get::<Vec<MergeRequest>>(&path)?;
+        if mrs.is_empty() {
+            return Ok(None);
+        }
+        let iid = mrs.remove(0).iid;
+        let path = format!("projects/{id_encode}/merge_requests/{iid}");
+        let mr = self.execute_get::<MergeRequestDetail>(&path)?;
+        let path = format!("projects/{id_encode}/merge_requests/{iid}/approvals");
+        let approvals = self.execute_get::<Approvals>(&path)?;
+
+        // The merge status is computed asynchronously.
+        let mergeable = match mr.detailed_merge_status.as_deref() {
+            Some("mergeable") => Some(true),
+            Some("checking") | Some("unchecked") | None => None,
+            Some(_) => Some(false),
+        };
+        Ok(Some(ApiMergeStatus {
+            title: mr.title,
+            state: mr.state,
+            draft: mr.draft,
+            source: mr.source_branch,
+            target: mr.target_branch,
+            approvals: approvals.approved_by.len() as u64,
+            ci_status: mr.head_pipeline.map(|pipeline| pipeline.status),
+            mergeable,
+            conflicts: mr.has_conflicts,
+            web_url: mr.web_url,
+        }))
+    }
+
+    fn list_actions(
+        &self,
+        owner: &str,
+        name: &str,
+        branch: Option<&str>,
+        limit: u32,
+    ) -> Result<Vec<ApiAction>> {
+        let id = format!("{owner}/{name}");
+        let id_encode = urlencoding::encode(&id);
+        let mut path = format!("projects/{id_encode}/pipelines?per_page={limit}");
+        if let Some(branch) = branch {
+            path.push_str(&format!("&ref={}", urlencoding::encode(branch)));
+        }
+        let pipelines = self.execute_get::<Vec<Pipeline>>(&path)?;
+        pipelines
+            .into_iter()
+            .map(|pipeline| pipeline.to_api())
+            .collect()
+    }
+
+    fn list_jobs(&self, owner: &str, name: &str, commit: &str) -> Result<Vec<ApiJob>> {
+        let id = format!("{owner}/{name}");
+        let id_encode = urlencoding::encode(&id);
+        let path = format!(
+            "projects/{id_encode}/pipelines?sha={commit}&per_page={}",
+            self.per_page
+        );
+        let pipelines = self.execute_get::<Vec<Pipeline>>(&path)?;
+        let mut jobs = Vec::new();
+        for pipeline in pipelines {
+            let path = format!(
+                "projects/{id_encode}/pipelines/{}/jobs?per_page={}",
+                pipeline.id, self.per_page
+            );
+            let pipeline_jobs = self.execute_get::<Vec<Job>>(&path)?;
+            jobs.extend(pipeline_jobs.into_iter().map(|job| job.to_api()));
+        }
+        Ok(jobs)
+    }
+
+    fn get_job_log(&self, owner: &str, name: &str, id: u64) -> Result<String> {
+        let project = format!("{owner}/{name}");
+        let project_encode = urlencoding::encode(&project);
+        let path = format!("projects/{project_encode}/jobs/{id}/trace");
+        self.execute_get_text(&path)
+    }
 }
 
 impl Gitlab {
     const API_VERSION: u8 = 4;
 
-    pub fn new(remote: &Remote) -> Box<dyn Provider> {
-        let client = super::build_common_client(remote);
+    /// The title prefixes to mark merge request as draft, see:
+    /// https://docs.gitlab.com/ee/user/project/merge_requests/drafts.html
+    const DRAFT_PREFIXES: [&str; 5] = ["Draft:", "[Draft]", "(Draft)", "WIP:", "[WIP]"];
+
+    pub fn new(remote: &Remote, token: Option<String>) -> Result<Box<dyn Provider>> {
+        let client = super::build_common_client(remote)?;
         let domain = match &remote.api_domain {
             Some(domain) => domain.clone(),
             None => String::from("gitlab.com"),
@@ -117,12 +708,13 @@ impl Gitlab {
 
         let url = format!("https://{domain}/api/v{}", Self::API_VERSION);
 
-        Box::new(Gitlab {
-            token: remote.token.clone(),
+        Ok(Box::new(Gitlab {
+            remote: remote.name.clone(),
+            token,
             client,
             url,
             per_page: remote.list_limit,
-        })
+        }))
     }
 
     fn execute_get<T>(&self, path: &str) -> Result<T>
@@ -143,18 +735,104 @@ impl Gitlab {
         self.execute(req)
     }
 
+    /// Gitlab uses user ids in merge request, convert the usernames to them.
+    fn get_user_ids(&self, usernames: &[String]) -> Result<Vec<u64>> {
+        let mut ids = Vec::with_capacity(usernames.len());
+        for username in usernames {
+            let path = format!("users?username={}", urlencoding::encode(username));
+            let mut users = self.execute_get::<Vec<User>>(&path)?;
+            if users.is_empty() {
+                bail!("Could not find Gitlab user {username}");
+            }
+            ids.push(users.remove(0).id);
+        }
+        Ok(ids)
+    }
+
+    fn execute_put<B, R>(&self, path: &str, body: B) -> Result<R>
+    where
+        B: Serialize,
+        R: DeserializeOwned + ?Sized,
+    {
+        let body = serde_json::to_vec(&body).context("Encode Gitlab request body")?;
+        let req = self.build_request(path, Method::PUT, Some(body))?;
+        self.execute(req)
+    }
+
+    fn execute_get_conditional<T>(
+        &self,
+        path: &str,
+        validator: Option<&Validator>,
+    ) -> Result<Conditional<T>>
+    where
+        T: DeserializeOwned,
+    {
+        let req = self.build_request(path, Method::GET, None)?;
+        let req = super::with_validator(req, validator)?;
+        let resp = self.send(req)?;
+        if resp.status() == StatusCode::NOT_MODIFIED {
+            return Ok(Conditional::NotModified);
+        }
+        let validator = super::get_validator(&resp);
+        let value = Self::parse_response(resp)?;
+        Ok(Conditional::Modified(value, validator))
+    }
+
+    /// Gitlab returns rate limit in response headers, self-built Gitlab might
+    /// disable it.
+    fn get_rate_limit(resp: &Response) -> Option<ApiRateLimit> {
+        let header_value = |key: &str| -> Option<u64> {
+            let value = resp.headers().get(key)?;
+            value.to_str().ok()?.parse().ok()
+        };
+        Some(ApiRateLimit {
+            limit: header_value("RateLimit-Limit")?,
+            remaining: header_value("RateLimit-Remaining")?,
+            reset: header_value("RateLimit-Reset")?,
+        })
+    }
+
+    /// Send the request, all requests should go through this to detect the
+    /// token problems.
+    fn send(&self, req: Request) -> Result<Response> {
+        let resp = self.client.execute(req).context("Gitlab http request")?;
+        super::check_auth(&self.remote, &resp)?;
+        Ok(resp)
+    }
+
     fn execute<T>(&self, req: Request) -> Result<T>
     where
         T: DeserializeOwned + ?Sized,
     {
-        let resp = self.client.execute(req).context("Gitlab http request")?;
+        let resp = self.send(req)?;
+        Self::parse_response(resp)
+    }
+
+    fn execute_get_text(&self, path: &str) -> Result<String> {
+        let req = self.build_request(path, Method::GET, None)?;
+        let resp = self.send(req)?;
+        let ok = resp.status().is_success();
+        let data = resp.bytes().context("Read Gitlab response body")?;
+        if ok {
+            return Ok(String::from_utf8_lossy(&data).into_owned());
+        }
+        Self::parse_error(&data)
+    }
+
+    fn parse_response<T>(resp: Response) -> Result<T>
+    where
+        T: DeserializeOwned + ?Sized,
+    {
         let ok = resp.status().is_success();
         let data = resp.bytes().context("Read Gitlab response body")?;
         if ok {
             return serde_json::from_slice(&data).context("Decode Gitlab response data");
         }
+        Self::parse_error(&data)
+    }
 
-        match serde_json::from_slice::<GitlabError>(&data) {
+    fn parse_error<T>(data: &[u8]) -> Result<T> {
+        match serde_json::from_slice::<GitlabError>(data) {
             Ok(err) => bail!("Gitlab api error: {}", err.error),
             Err(_err) => bail!(
                 "Unknown Gitlab api error: {}",
@@ -169,8 +847,11 @@ impl Gitlab {
         let url = Url::parse(url.as_str()).with_context(|| format!("Parse gitlab url {url}"))?;
         let mut builder = self.client.request(method, url);
         builder = builder.header("User-Agent", "roxide-client");
+        // The bearer token works for both personal access tokens and OAuth
+        // tokens, while `PRIVATE-TOKEN` only accepts the former.
         if let Some(token) = &self.token {
-            builder = builder.header("PRIVATE-TOKEN", token);
+            let token_value = format!("Bearer {token}");
+            builder = builder.header("Authorization", token_value);
         }
         if let Some(body) = body {
             builder = builder
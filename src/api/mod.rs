@@ -1,42 +1,261 @@
-mod cache;
+pub mod auth;
+pub mod cache;
 mod github;
 mod gitlab;
 pub mod types;
 
+use std::collections::HashMap;
 use std::path::PathBuf;
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::thread::{self, JoinHandle};
 use std::time::Duration;
 
-use anyhow::{bail, Result};
-use reqwest::blocking::Client;
+use anyhow::{bail, Context, Result};
+use chrono::DateTime;
+use reqwest::blocking::{Client, Request, Response};
+use reqwest::header::{HeaderValue, ETAG, IF_MODIFIED_SINCE, IF_NONE_MATCH, LAST_MODIFIED};
+use reqwest::{NoProxy, Proxy, StatusCode};
+use sha2::{Digest, Sha256};
 
 use crate::api::cache::Cache;
 use crate::api::github::Github;
 use crate::api::gitlab::Gitlab;
-use crate::api::types::Provider;
+use crate::api::types::{Provider, Validator};
 use crate::config;
 use crate::config::types::Provider as ProviderType;
 use crate::config::types::Remote;
+use crate::utils;
 
-pub fn build_common_client(remote: &Remote) -> Client {
-    Client::builder()
-        .timeout(Duration::from_secs(remote.api_timeout))
-        .build()
-        .unwrap()
+pub fn build_common_client(remote: &Remote) -> Result<Client> {
+    let mut builder = Client::builder().timeout(Duration::from_secs(remote.api_timeout));
+    // The socks5 proxy takes precedence, the same as git.
+    let proxy = match &remote.socks5_proxy {
+        Some(proxy) => Some(proxy),
+        None => remote.http_proxy.as_ref(),
+    };
+    if let Some(proxy) = proxy {
+        let no_proxy = match &remote.no_proxy {
+            Some(no_proxy) => NoProxy::from_string(no_proxy),
+            None => None,
+        };
+        let proxy = Proxy::all(proxy)
+            .with_context(|| format!("Parse proxy {proxy}"))?
+            .no_proxy(no_proxy);
+        builder = builder.proxy(proxy);
+    }
+    builder.build().context("Build http client")
+}
+
+/// Add the conditional headers to request according to the validator.
+fn with_validator(mut req: Request, validator: Option<&Validator>) -> Result<Request> {
+    let validator = match validator {
+        Some(validator) => validator,
+        None => return Ok(req),
+    };
+    if let Some(etag) = &validator.etag {
+        let value = HeaderValue::from_str(etag).context("Build If-None-Match header")?;
+        req.headers_mut().insert(IF_NONE_MATCH, value);
+    }
+    if let Some(last_modified) = &validator.last_modified {
+        let value =
+            HeaderValue::from_str(last_modified).context("Build If-Modified-Since header")?;
+        req.headers_mut().insert(IF_MODIFIED_SINCE, value);
+    }
+    Ok(req)
+}
+
+/// Get the validator from response headers, return None if the server does
+/// not support conditional request.
+fn get_validator(resp: &Response) -> Option<Validator> {
+    let get_header = |name| {
+        resp.headers()
+            .get(name)
+            .and_then(|value: &HeaderValue| value.to_str().ok())
+            .map(|value| value.to_string())
+    };
+    let etag = get_header(ETAG);
+    let last_modified = get_header(LAST_MODIFIED);
+    if etag.is_none() && last_modified.is_none() {
+        return None;
+    }
+    Some(Validator {
+        etag,
+        last_modified,
+    })
+}
+
+/// Fail with a clear message if the remote rejects the token, which is usually
+/// caused by expiration or revocation.
+fn check_auth(remote: &str, resp: &Response) -> Result<()> {
+    if resp.status() == StatusCode::UNAUTHORIZED {
+        bail!("The token for remote {remote} is invalid or expired, please run `roxide auth login {remote}` or update the token config");
+    }
+    Ok(())
+}
+
+/// Remind to renew the token if it will expire in this duration.
+const TOKEN_EXPIRE_REMIND: u64 = 7 * utils::DAY;
+
+static TOKEN_REMINDED: AtomicBool = AtomicBool::new(false);
+
+/// Warn if the token is going to expire, only once per process.
+fn remind_token_expire(remote: &str, expires_at: u64) {
+    let now = config::now_secs();
+    if expires_at > now + TOKEN_EXPIRE_REMIND {
+        return;
+    }
+    if TOKEN_REMINDED.swap(true, Ordering::Relaxed) {
+        return;
+    }
+    let days = expires_at.saturating_sub(now) / utils::DAY;
+    utils::show_warn(format!(
+        "The token for remote {remote} will expire in {days} days, please renew it or run `roxide auth login {remote}`"
+    ));
+}
+
+/// Parse the rfc3339 time returned by remote API to unix timestamp.
+fn parse_time(time: &str) -> Result<u64> {
+    let time = DateTime::parse_from_rfc3339(time).with_context(|| format!("Parse time {time}"))?;
+    Ok(time.timestamp().max(0) as u64)
+}
+
+pub fn cache_dir(remote: &str) -> PathBuf {
+    PathBuf::from(&config::base().metadir)
+        .join("cache")
+        .join(remote)
+}
+
+/// The hit counters of the remote's cache, including the caches of owners'
+/// own tokens. They are kept in statedir, outside the cache directory, so
+/// that they are not counted or cleared as cache entries.
+pub fn cache_stats_path(remote: &str) -> PathBuf {
+    PathBuf::from(&config::base().statedir)
+        .join("cache_stats")
+        .join(format!("{remote}.json"))
+}
+
+/// The cache directory of the owner's own token, it is inside the remote's
+/// cache directory and named by the hash of token, so that the data visible
+/// to different identities won't be mixed, and the token won't be exposed.
+pub fn token_cache_dir(remote: &str, token: &str) -> PathBuf {
+    // The dir is persistent, so the hash must be stable across Rust releases,
+    // which is not guaranteed by `DefaultHasher`.
+    let hash = Sha256::digest(token.as_bytes());
+    cache_dir(remote).join(format!("token.{hash:x}"))
 }
 
+/// Init the provider of remote. The token in config has higher priority than
+/// the one saved by `roxide auth login`.
 pub fn init_provider(remote: &Remote, force: bool) -> Result<Box<dyn Provider>> {
-    if let None = remote.provider {
-        bail!("Missing provider config for remote {}", remote.name);
+    let token = match &remote.token {
+        Some(token) => Some(token.clone()),
+        None => auth::load(&remote.name)?,
+    };
+    build_provider(remote, token, cache_dir(&remote.name), force)
+}
+
+/// Init the provider to call api for the repos of owner. If the owner has its
+/// own token, use it instead of remote's.
+pub fn init_owner_provider(remote: &Remote, owner: &str, force: bool) -> Result<Box<dyn Provider>> {
+    let token = remote
+        .owners
+        .get(owner)
+        .and_then(|owner| owner.token.as_ref());
+    match token {
+        Some(token) => {
+            let cache_dir = token_cache_dir(&remote.name, token);
+            build_provider(remote, Some(token.clone()), cache_dir, force)
+        }
+        None => init_provider(remote, force),
     }
+}
+
+fn build_provider(
+    remote: &Remote,
+    token: Option<String>,
+    cache_dir: PathBuf,
+    force: bool,
+) -> Result<Box<dyn Provider>> {
+    remote.check_provider()?;
     let mut provider = match remote.provider.as_ref().unwrap() {
-        ProviderType::Github => Github::new(remote),
-        ProviderType::Gitlab => Gitlab::new(remote),
+        ProviderType::Github => Github::new(remote, token)?,
+        ProviderType::Gitlab => Gitlab::new(remote, token)?,
     };
     if !force && remote.cache_hours > 0 {
-        let cache_dir = PathBuf::from(&config::base().metadir)
-            .join("cache")
-            .join(&remote.name);
-        provider = Cache::new(cache_dir, remote.cache_hours as u64, provider)?;
+        provider = Cache::new(
+            cache_dir,
+            cache_stats_path(&remote.name),
+            remote.cache_hours as u64,
+            provider,
+        )?;
     }
     Ok(provider)
 }
+
+/// Fetch repos info from remote API in background and save them to cache, so
+/// that later `get_repo` calls can hit the cache. This is used to prefetch
+/// the likely-needed data while the user is selecting repo. The item of
+/// `repos` is `(remote, owner, name)`, the repos of the same remote (and the
+/// same owner if it has its own token) are fetched in one batch. Errors are
+/// ignored, the data will be fetched again if prefetching failed.
+///
+/// The cache is protected by file lock, so the caller must join the handle
+/// before initializing its own provider.
+pub fn prefetch_repos(repos: Vec<(String, String, String)>) -> JoinHandle<()> {
+    thread::spawn(move || {
+        let mut remotes: Vec<String> = Vec::new();
+        let mut groups: HashMap<String, Vec<(String, String)>> = HashMap::new();
+        for (remote, owner, name) in repos {
+            if !groups.contains_key(&remote) {
+                remotes.push(remote.clone());
+            }
+            groups.entry(remote).or_default().push((owner, name));
+        }
+        for remote in remotes {
+            let repos = groups.remove(&remote).unwrap();
+            let remote = match config::get_remote(&remote) {
+                Ok(Some(remote)) => remote,
+                _ => continue,
+            };
+            if let None = remote.provider {
+                continue;
+            }
+            if remote.cache_hours == 0 {
+                continue;
+            }
+
+            // The owners with their own tokens are fetched separately.
+            let mut owners: Vec<String> = Vec::new();
+            let mut owner_groups: HashMap<String, Vec<(String, String)>> = HashMap::new();
+            let mut shared = Vec::with_capacity(repos.len());
+            for (owner, name) in repos {
+                let has_token = match remote.owners.get(&owner) {
+                    Some(owner_cfg) => owner_cfg.token.is_some(),
+                    None => false,
+                };
+                if !has_token {
+                    shared.push((owner, name));
+                    continue;
+                }
+                if !owner_groups.contains_key(&owner) {
+                    owners.push(owner.clone());
+                }
+                owner_groups
+                    .entry(owner.clone())
+                    .or_default()
+                    .push((owner, name));
+            }
+            if !shared.is_empty() {
+                if let Ok(provider) = init_provider(&remote, false) {
+                    let _ = provider.get_repos(&shared);
+                }
+            }
+            for owner in owners {
+                let repos = owner_groups.remove(&owner).unwrap();
+                if let Ok(provider) = init_owner_provider(&remote, &owner, false) {
+                    let _ = provider.get_repos(&repos);
+                }
+            }
+        }
+    })
+}
@@ -1,9 +1,14 @@
-use anyhow::Result;
+use anyhow::{bail, Result};
+use clap::ValueEnum;
 use console::style;
 use serde::{Deserialize, Serialize};
 
 #[derive(Debug, PartialEq, Deserialize, Serialize)]
 pub struct ApiRepo {
+    /// The owner and name returned by remote API. If the repo was renamed or
+    /// transferred, the API follows the redirect, so these might be different
+    /// from the ones used in the request.
+    pub owner: String,
     pub name: String,
 
     pub default_branch: String,
@@ -11,6 +16,18 @@ pub struct ApiRepo {
     pub upstream: Option<ApiUpstream>,
 
     pub web_url: String,
+
+    pub description: Option<String>,
+    pub topics: Vec<String>,
+}
+
+impl ApiRepo {
+    /// Return true if the repo was renamed or transferred on the remote. The
+    /// owner is case-insensitive on the remote, the API might return it in
+    /// another case (such as `Fioncat` for `fioncat`), this is not a rename.
+    pub fn is_renamed(&self, owner: &str, name: &str) -> bool {
+        !self.owner.eq_ignore_ascii_case(owner) || self.name.as_str() != name
+    }
 }
 
 #[derive(Debug, PartialEq, Deserialize, Serialize, Clone)]
@@ -26,6 +43,41 @@ impl ToString for ApiUpstream {
     }
 }
 
+/// The commit counts of a fork's branch compared with the default branch of
+/// its upstream.
+#[derive(Debug, Serialize)]
+pub struct ApiCompare {
+    /// The commits in the fork's branch but not in upstream.
+    pub ahead: u64,
+    /// The commits in upstream but not in the fork's branch.
+    pub behind: u64,
+}
+
+/// The settings of a repo, they are not cached since they might be changed on
+/// remote at any time.
+#[derive(Debug, Serialize)]
+pub struct ApiRepoSettings {
+    /// Such as `public`, `private` and `internal`.
+    pub visibility: String,
+    /// The archived repo is read-only.
+    pub archived: bool,
+    pub default_branch: String,
+
+    /// The allowed methods to merge the merge request, such as `merge`,
+    /// `squash` and `rebase`.
+    pub merge_methods: Vec<String>,
+    /// None if the token has no permission to see it.
+    pub delete_branch_on_merge: Option<bool>,
+}
+
+/// The metadata to update for a repo, None means keep it unchanged.
+#[derive(Debug, Clone, Default)]
+pub struct RepoUpdate {
+    pub description: Option<String>,
+    /// Replace all the topics of the repo, empty to clear them.
+    pub topics: Option<Vec<String>>,
+}
+
 #[derive(Debug, Clone)]
 pub struct MergeOptions {
     pub owner: String,
@@ -34,6 +86,14 @@ pub struct MergeOptions {
 
     pub source: String,
     pub target: String,
+
+    pub draft: bool,
+
+    /// The usernames to request review and assign, and the labels to add,
+    /// only used when creating.
+    pub reviewers: Vec<String>,
+    pub assignees: Vec<String>,
+    pub labels: Vec<String>,
 }
 
 impl MergeOptions {
@@ -61,6 +121,152 @@ impl ToString for MergeOptions {
     }
 }
 
+/// The method to merge the merge request on remote.
+#[derive(Copy, Clone, Debug, PartialEq, Eq, ValueEnum)]
+pub enum MergeMethod {
+    Merge,
+    Squash,
+    Rebase,
+}
+
+impl MergeMethod {
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            MergeMethod::Merge => "merge",
+            MergeMethod::Squash => "squash",
+            MergeMethod::Rebase => "rebase",
+        }
+    }
+}
+
+#[derive(Debug, Serialize)]
+pub struct ApiInfo {
+    pub scopes: Option<Vec<String>>,
+
+    /// None if the token never expires or the remote does not report it.
+    pub token_expires_at: Option<u64>,
+
+    pub rate_limit: Option<ApiRateLimit>,
+}
+
+#[derive(Debug, Serialize)]
+pub struct ApiRateLimit {
+    pub limit: u64,
+    pub remaining: u64,
+    pub reset: u64,
+}
+
+/// A workflow run in Github Actions, or a pipeline in Gitlab CI.
+#[derive(Debug, Serialize)]
+pub struct ApiAction {
+    pub name: String,
+    pub branch: String,
+
+    /// The conclusion for the finished run, such as `success` and `failure`,
+    /// otherwise the running status, such as `queued` and `in_progress`.
+    pub status: String,
+    /// The event that triggered the run, such as `push` and `schedule`.
+    pub trigger: String,
+
+    pub created_at: u64,
+    /// The duration in seconds, None if the run is not finished.
+    pub duration: Option<u64>,
+
+    pub web_url: String,
+}
+
+/// The status of an open merge request (or PR for Github).
+#[derive(Debug, Serialize)]
+pub struct ApiMergeStatus {
+    pub title: String,
+    pub state: String,
+    pub draft: bool,
+
+    pub source: String,
+    pub target: String,
+
+    /// The number of approvals.
+    pub approvals: u64,
+    /// The CI status of the head commit, such as `success`, `failure` and
+    /// `pending`, None if no CI.
+    pub ci_status: Option<String>,
+    /// The mergeability computed by remote, None if it is still computing.
+    pub mergeable: Option<bool>,
+    pub conflicts: bool,
+
+    pub web_url: String,
+}
+
+/// An unread notification (or pending todo for Gitlab) of the token's user,
+/// only the mentions, review requests and CI failures are returned.
+#[derive(Debug, Serialize)]
+pub struct ApiNotification {
+    /// The thread id for Github, or the todo id for Gitlab.
+    pub id: String,
+
+    pub owner: String,
+    pub name: String,
+
+    /// One of `mention`, `review` and `ci`.
+    pub reason: String,
+    /// The subject type, such as `PullRequest` and `MergeRequest`.
+    pub kind: String,
+    pub title: String,
+
+    pub updated_at: u64,
+
+    pub web_url: String,
+}
+
+/// A gist for Github, or a personal snippet for Gitlab.
+#[derive(Debug, Serialize)]
+pub struct ApiSnippet {
+    pub id: String,
+    pub title: String,
+    pub files: Vec<String>,
+    pub public: bool,
+
+    pub updated_at: u64,
+
+    pub web_url: String,
+}
+
+/// A job of workflow run (or pipeline for Gitlab).
+#[derive(Debug, Serialize)]
+pub struct ApiJob {
+    pub id: u64,
+    pub name: String,
+    pub status: String,
+    pub failed: bool,
+    pub web_url: String,
+}
+
+/// The validator of a response, used to issue conditional request. If the
+/// resource is not modified, the server returns 304 without body, which
+/// does not count against the rate limit for Github.
+#[derive(Debug, Clone, Deserialize, Serialize)]
+pub struct Validator {
+    pub etag: Option<String>,
+    pub last_modified: Option<String>,
+}
+
+/// The result of conditional request.
+pub enum Conditional<T> {
+    NotModified,
+    Modified(T, Option<Validator>),
+}
+
+impl<T> Conditional<T> {
+    /// Return the value of the request without validator, which is never
+    /// responded with not modified.
+    pub fn into_modified(self) -> Result<T> {
+        match self {
+            Conditional::Modified(value, _) => Ok(value),
+            Conditional::NotModified => bail!("unexpected not modified response"),
+        }
+    }
+}
+
 pub trait Provider {
     // list all repos for a group, the group can be owner or org in Github.
     fn list_repos(&self, owner: &str) -> Result<Vec<String>>;
@@ -68,10 +274,119 @@ pub trait Provider {
     // Get default branch name.
     fn get_repo(&self, owner: &str, name: &str) -> Result<ApiRepo>;
 
+    // Get multiple repos at once, the result has the same order as `repos`,
+    // None means the repo could not be found. The default implementation
+    // calls `get_repo` one by one.
+    fn get_repos(&self, repos: &[(String, String)]) -> Result<Vec<Option<ApiRepo>>> {
+        repos
+            .iter()
+            .map(|(owner, name)| Ok(Some(self.get_repo(owner, name)?)))
+            .collect()
+    }
+
+    // Get the settings of repo, such as visibility and merge methods.
+    fn get_repo_settings(&self, owner: &str, name: &str) -> Result<ApiRepoSettings>;
+
+    // Update the metadata of repo, such as description and topics.
+    fn update_repo(&self, owner: &str, name: &str, update: RepoUpdate) -> Result<()>;
+
     // Try to get URL for merge request (or PR for Github). If merge request
     // not exists, return Ok(None).
     fn get_merge(&self, merge: MergeOptions) -> Result<Option<String>>;
 
     // Create merge request (or PR for Github), and return its URL.
     fn create_merge(&self, merge: MergeOptions, title: String, body: String) -> Result<String>;
+
+    // Mark the existing draft merge request (or PR for Github) as ready for
+    // review, and return its URL. If merge request not exists, return
+    // Ok(None).
+    fn ready_merge(&self, merge: MergeOptions) -> Result<Option<String>>;
+
+    // Merge the merge request (or PR for Github) on remote, and delete the
+    // source branch if `delete_source` is true. If merge request not exists,
+    // return Ok(None), otherwise return its url.
+    fn accept_merge(
+        &self,
+        merge: MergeOptions,
+        method: MergeMethod,
+        delete_source: bool,
+    ) -> Result<Option<String>>;
+
+    // Get the status of the open merge request (or PR for Github) of the
+    // source branch, the target is ignored. If the upstream is set, get the
+    // one opened in upstream from the fork. If merge request not exists,
+    // return Ok(None).
+    fn get_merge_status(&self, merge: MergeOptions) -> Result<Option<ApiMergeStatus>>;
+
+    // Compare the branch of the forked repo with the default branch of its
+    // upstream, return how many commits the branch is ahead and behind.
+    fn compare_upstream(
+        &self,
+        owner: &str,
+        name: &str,
+        branch: &str,
+        upstream: &ApiUpstream,
+    ) -> Result<ApiCompare>;
+
+    // List the unread notifications (or pending todos for Gitlab) of the
+    // user, see [`ApiNotification`].
+    fn list_notifications(&self) -> Result<Vec<ApiNotification>>;
+
+    // Mark the notification as read (or the todo as done for Gitlab).
+    fn mark_notification_read(&self, id: &str) -> Result<()>;
+
+    // List the snippets (or gists for Github) of the user, sorted by updated
+    // time desc.
+    fn list_snippets(&self) -> Result<Vec<ApiSnippet>>;
+
+    // Create a snippet (or gist for Github) with one file.
+    fn create_snippet(
+        &self,
+        title: &str,
+        file_name: &str,
+        content: &str,
+        public: bool,
+    ) -> Result<ApiSnippet>;
+
+    // Get the api diagnostics info, such as token scopes and rate limit.
+    fn get_info(&self) -> Result<ApiInfo>;
+
+    // List the latest workflow runs (or pipelines for Gitlab) of the repo,
+    // sorted by created time desc. If branch is provided, only list the runs
+    // for it.
+    fn list_actions(
+        &self,
+        owner: &str,
+        name: &str,
+        branch: Option<&str>,
+        limit: u32,
+    ) -> Result<Vec<ApiAction>>;
+
+    // List the jobs of all workflow runs (or pipelines for Gitlab) triggered
+    // by the commit.
+    fn list_jobs(&self, owner: &str, name: &str, commit: &str) -> Result<Vec<ApiJob>>;
+
+    // Get the plain text log of the job.
+    fn get_job_log(&self, owner: &str, name: &str, id: u64) -> Result<String>;
+
+    // Same as `list_repos`, but with conditional request. The default
+    // implementation does not support it and always returns modified.
+    fn list_repos_conditional(
+        &self,
+        owner: &str,
+        _validator: Option<&Validator>,
+    ) -> Result<Conditional<Vec<String>>> {
+        Ok(Conditional::Modified(self.list_repos(owner)?, None))
+    }
+
+    // Same as `get_repo`, but with conditional request. The default
+    // implementation does not support it and always returns modified.
+    fn get_repo_conditional(
+        &self,
+        owner: &str,
+        name: &str,
+        _validator: Option<&Validator>,
+    ) -> Result<Conditional<ApiRepo>> {
+        Ok(Conditional::Modified(self.get_repo(owner, name)?, None))
+    }
 }
@@ -1,28 +1,65 @@
+use std::env;
+use std::time::Instant;
+
 use anyhow::Result;
 use clap::{Parser, Subcommand};
 
 use crate::cmd::run::attach::AttachArgs;
+use crate::cmd::run::auth::AuthArgs;
 use crate::cmd::run::branch::BranchArgs;
+use crate::cmd::run::check::CheckArgs;
+use crate::cmd::run::code::CodeArgs;
+use crate::cmd::run::commit::CommitArgs;
 use crate::cmd::run::complete::CompleteArgs;
 use crate::cmd::run::config::ConfigArgs;
+use crate::cmd::run::create::CreateArgs;
 use crate::cmd::run::detach::DetachArgs;
+use crate::cmd::run::dirty::DirtyArgs;
+use crate::cmd::run::env::EnvArgs;
+use crate::cmd::run::exec::ExecArgs;
 use crate::cmd::run::get::GetArgs;
+use crate::cmd::run::grep::GrepArgs;
 use crate::cmd::run::home::HomeArgs;
 use crate::cmd::run::init::InitArgs;
+use crate::cmd::run::maintain::MaintainArgs;
 use crate::cmd::run::merge::MergeArgs;
+use crate::cmd::run::mv::MoveArgs;
 use crate::cmd::run::open::OpenArgs;
+use crate::cmd::run::patch::PatchArgs;
+use crate::cmd::run::pin::PinArgs;
+use crate::cmd::run::prompt::PromptArgs;
 use crate::cmd::run::rebase::RebaseArgs;
 use crate::cmd::run::release::ReleaseArgs;
+use crate::cmd::run::remote::RemoteArgs;
 use crate::cmd::run::remove::RemoveArgs;
+use crate::cmd::run::resume::ResumeArgs;
 use crate::cmd::run::squash::SquashArgs;
+use crate::cmd::run::sub::SubArgs;
+use crate::cmd::run::suggest_prune::SuggestPruneArgs;
+use crate::cmd::run::sync::SyncArgs;
 use crate::cmd::run::tag::TagArgs;
+use crate::cmd::run::tmux::TmuxArgs;
+use crate::cmd::run::version::VersionArgs;
+use crate::cmd::run::which_repo::WhichRepoArgs;
 use crate::cmd::Run;
+use crate::repo::metrics::Metric;
+use crate::{config, utils};
 
 #[derive(Parser)]
 #[command(author, version, about)]
 pub struct App {
     #[command(subcommand)]
     pub command: Commands,
+
+    /// Run in non-interactive mode, assume yes for all confirms, and fail
+    /// instead of prompting. Can also be enabled by env `ROXIDE_NONINTERACTIVE`.
+    #[clap(
+        long = "yes",
+        short = 'y',
+        visible_alias = "non-interactive",
+        global = true
+    )]
+    pub non_interactive: bool,
 }
 
 #[derive(Subcommand)]
@@ -42,11 +79,41 @@ pub enum Commands {
     Tag(TagArgs),
     Release(ReleaseArgs),
     Open(OpenArgs),
+    Remote(RemoteArgs),
+    Version(VersionArgs),
+    #[command(alias = "mv")]
+    Move(MoveArgs),
+    Sub(SubArgs),
+    Pin(PinArgs),
+    Tmux(TmuxArgs),
+    Code(CodeArgs),
+    Env(EnvArgs),
+    Patch(PatchArgs),
+    Maintain(MaintainArgs),
+    Dirty(DirtyArgs),
+    Check(CheckArgs),
+    Auth(AuthArgs),
+    Resume(ResumeArgs),
+    /// Deprecated, use `create commit` instead. It is kept for the commit-msg
+    /// hooks installed by older versions.
+    #[command(hide = true)]
+    Commit(CommitArgs),
+    Create(CreateArgs),
+    Prompt(PromptArgs),
+    Exec(ExecArgs),
+    Grep(GrepArgs),
+    WhichRepo(WhichRepoArgs),
+    SuggestPrune(SuggestPruneArgs),
+    Sync(SyncArgs),
 }
 
 impl Run for App {
     fn run(&self) -> Result<()> {
-        match &self.command {
+        if self.non_interactive || env::var_os("ROXIDE_NONINTERACTIVE").is_some() {
+            utils::set_non_interactive();
+        }
+        let start = Instant::now();
+        let result = match &self.command {
             Commands::Init(args) => args.run(),
             Commands::Home(args) => args.run(),
             Commands::Complete(args) => args.run(),
@@ -62,6 +129,83 @@ impl Run for App {
             Commands::Tag(args) => args.run(),
             Commands::Release(args) => args.run(),
             Commands::Open(args) => args.run(),
+            Commands::Remote(args) => args.run(),
+            Commands::Version(args) => args.run(),
+            Commands::Move(args) => args.run(),
+            Commands::Sub(args) => args.run(),
+            Commands::Pin(args) => args.run(),
+            Commands::Tmux(args) => args.run(),
+            Commands::Code(args) => args.run(),
+            Commands::Env(args) => args.run(),
+            Commands::Patch(args) => args.run(),
+            Commands::Maintain(args) => args.run(),
+            Commands::Dirty(args) => args.run(),
+            Commands::Check(args) => args.run(),
+            Commands::Auth(args) => args.run(),
+            Commands::Resume(args) => args.run(),
+            Commands::Commit(args) => args.run(),
+            Commands::Create(args) => args.run(),
+            Commands::Prompt(args) => args.run(),
+            Commands::Exec(args) => args.run(),
+            Commands::Grep(args) => args.run(),
+            Commands::WhichRepo(args) => args.run(),
+            Commands::SuggestPrune(args) => args.run(),
+            Commands::Sync(args) => args.run(),
+        };
+        // The completion is called too frequently to be recorded.
+        if config::base().metrics && !matches!(self.command, Commands::Complete(_)) {
+            // Metrics are best-effort, failing to save them should not affect
+            // the command result.
+            let _ = Metric::save(self.command.name(), start.elapsed(), result.is_ok());
+        }
+        result
+    }
+}
+
+impl Commands {
+    /// The name of the command recorded in metrics, such as `home`.
+    fn name(&self) -> &'static str {
+        match self {
+            Commands::Init(_) => "init",
+            Commands::Home(_) => "home",
+            Commands::Complete(_) => "complete",
+            Commands::Attach(_) => "attach",
+            // The sync is much slower than other branch operations, record it
+            // separately to see its own p95.
+            Commands::Branch(args) if args.sync => "branch --sync",
+            Commands::Branch(_) => "branch",
+            Commands::Merge(_) => "merge",
+            Commands::Remove(_) => "remove",
+            Commands::Detach(_) => "detach",
+            Commands::Config(_) => "config",
+            Commands::Get(_) => "get",
+            Commands::Rebase(_) => "rebase",
+            Commands::Squash(_) => "squash",
+            Commands::Tag(_) => "tag",
+            Commands::Release(_) => "release",
+            Commands::Open(_) => "open",
+            Commands::Remote(_) => "remote",
+            Commands::Version(_) => "version",
+            Commands::Move(_) => "move",
+            Commands::Sub(_) => "sub",
+            Commands::Pin(_) => "pin",
+            Commands::Tmux(_) => "tmux",
+            Commands::Code(_) => "code",
+            Commands::Env(_) => "env",
+            Commands::Patch(_) => "patch",
+            Commands::Maintain(_) => "maintain",
+            Commands::Dirty(_) => "dirty",
+            Commands::Check(_) => "check",
+            Commands::Auth(_) => "auth",
+            Commands::Resume(_) => "resume",
+            Commands::Commit(_) => "create commit",
+            Commands::Create(args) => args.name(),
+            Commands::Prompt(_) => "prompt",
+            Commands::Exec(_) => "exec",
+            Commands::Grep(_) => "grep",
+            Commands::WhichRepo(_) => "which-repo",
+            Commands::SuggestPrune(_) => "suggest-prune",
+            Commands::Sync(_) => "sync",
         }
     }
 }
@@ -1,7 +1,8 @@
 use anyhow::Result;
 
 use crate::cmd::complete::Complete;
-use crate::{config, repo::database::Database};
+use crate::repo::database::Database;
+use crate::{config, utils};
 
 pub fn complete(args: &[&str]) -> Result<Complete> {
     match args.len() {
@@ -15,12 +16,17 @@ pub fn complete(args: &[&str]) -> Result<Complete> {
         }
         2 => {
             let remote_name = &args[0];
-            let db = Database::read()?;
+            let db = Database::read_only()?;
+            let remote = config::must_get_remote(remote_name)?;
+            let owner_alias_map = utils::revert_map(&remote.owner_alias);
 
             let owners = db.list_owners(remote_name);
             let items: Vec<_> = owners
                 .into_iter()
-                .map(|owner| format!("{}/", owner))
+                .map(|owner| match owner_alias_map.get(owner.as_str()) {
+                    Some(alias_owner) => format!("{alias_owner}/"),
+                    None => format!("{owner}/"),
+                })
                 .collect();
             return Ok(Complete::from(items).no_space());
         }
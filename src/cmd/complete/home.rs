@@ -1,12 +1,26 @@
+use std::collections::HashSet;
+
 use anyhow::Result;
 
 use crate::cmd::complete::Complete;
+use crate::config::types::Remote;
 use crate::repo::database::Database;
-use crate::{config, utils};
+use crate::{api, config, utils};
 
 pub fn complete(args: &[&str]) -> Result<Complete> {
     match args.len() {
         0 | 1 => {
+            // The groups are completed after typing `@`, so that the remotes
+            // are not mixed with them.
+            if args.first().is_some_and(|arg| arg.starts_with("@")) {
+                let mut items: Vec<_> = config::base()
+                    .groups
+                    .keys()
+                    .map(|group| format!("@{group}"))
+                    .collect();
+                items.sort();
+                return Ok(Complete::from(items));
+            }
             let remotes = config::list_remotes();
             let items: Vec<_> = remotes
                 .into_iter()
@@ -16,7 +30,10 @@ pub fn complete(args: &[&str]) -> Result<Complete> {
         }
         2 => {
             let remote_name = &args[0];
-            let db = Database::read()?;
+            if remote_name.starts_with("@") {
+                return complete_group(remote_name);
+            }
+            let db = Database::read_only()?;
             let remote = config::must_get_remote(remote_name)?;
 
             let owner_alias_map = utils::revert_map(&remote.owner_alias);
@@ -39,25 +56,87 @@ pub fn complete(args: &[&str]) -> Result<Complete> {
             }
 
             let (owner, _) = utils::parse_query(&remote, query);
-            let repos = db.list_by_remote(remote_name);
-            let items: Vec<_> = repos
+            let repos = db.filter_ignored(db.list_by_remote(remote_name));
+            let mut names: Vec<String> = repos
                 .into_iter()
                 .filter(|repo| repo.owner.as_str().eq(&owner))
-                // .map(|repo| format!("{}", repo.long_name()))
-                .map(|repo| {
-                    let mut owner = format!("{}", repo.owner);
-                    let mut name = format!("{}", repo.name);
-                    if let Some(alias_owner) = owner_alias_map.get(&owner) {
-                        owner = alias_owner.clone();
-                    }
-                    if let Some(alias_name) = name_alias_map.get(&name) {
-                        name = alias_name.clone();
+                .map(|repo| format!("{}", repo.name))
+                .collect();
+
+            // The owner can have nested groups (such as Gitlab subgroups), they
+            // are completed as owners, so user can continue to go deeper.
+            let group_prefix = format!("{owner}/");
+            let mut groups: Vec<_> = db
+                .list_owners(remote_name)
+                .into_iter()
+                .filter_map(|sub_owner| {
+                    let group = sub_owner.strip_prefix(&group_prefix)?;
+                    let group = match group.split_once("/") {
+                        Some((group, _)) => group,
+                        None => group,
+                    };
+                    Some(format!("{group}/"))
+                })
+                .collect();
+            groups.sort();
+            groups.dedup();
+            if remote.complete_api {
+                // The api error should not break local completion, ignore it.
+                if let Ok(api_names) = list_api_repos(remote, &owner) {
+                    let local: HashSet<String> = names.iter().cloned().collect();
+                    for name in api_names {
+                        if !local.contains(&name) {
+                            names.push(name);
+                        }
                     }
+                }
+            }
+
+            let owner = match owner_alias_map.get(&owner) {
+                Some(alias_owner) => alias_owner.clone(),
+                None => owner,
+            };
+            let items: Vec<_> = names
+                .into_iter()
+                .map(|name| {
+                    let name = match name_alias_map.get(&name) {
+                        Some(alias_name) => alias_name.clone(),
+                        None => name,
+                    };
                     format!("{owner}/{name}")
                 })
+                .chain(groups.into_iter().map(|group| format!("{owner}/{group}")))
                 .collect();
+            if items.iter().all(|item| item.ends_with("/")) {
+                return Ok(Complete::from(items).no_space());
+            }
             Ok(Complete::from(items))
         }
         _ => Ok(Complete::empty()),
     }
 }
+
+/// Complete the repos in the `@group`, the args after `@lang` are not
+/// completed.
+fn complete_group(arg: &str) -> Result<Complete> {
+    let group = match config::base().parse_group(arg) {
+        Some(group) => group,
+        None => return Ok(Complete::empty()),
+    };
+    let db = Database::read_only()?;
+    let items: Vec<_> = db
+        .list_by_group(group)
+        .into_iter()
+        .map(|repo| repo.long_name())
+        .collect();
+    Ok(Complete::from(items))
+}
+
+fn list_api_repos(mut remote: Remote, owner: &str) -> Result<Vec<String>> {
+    if let None = remote.provider {
+        return Ok(vec![]);
+    }
+    remote.api_timeout = remote.complete_timeout;
+    let provider = api::init_owner_provider(&remote, owner, false)?;
+    provider.list_repos(owner)
+}
@@ -5,6 +5,19 @@ pub mod release;
 pub mod remote;
 pub mod tag;
 
+use std::fs;
+use std::io::ErrorKind;
+use std::path::PathBuf;
+use std::time::UNIX_EPOCH;
+
+use anyhow::{Context, Result};
+use serde::{Deserialize, Serialize};
+
+use crate::config;
+use crate::repo::database::Database;
+use crate::utils;
+
+#[derive(Debug, Deserialize, Serialize)]
 pub struct Complete {
     pub items: Vec<String>,
 
@@ -44,3 +57,68 @@ impl Complete {
         }
     }
 }
+
+/// The on-disk completion cache, to keep TAB latency low. The entry is valid
+/// until the database is modified or it is expired (the items might come from
+/// remote API).
+#[derive(Debug, Deserialize, Serialize)]
+struct CacheEntry {
+    key: String,
+    time: u64,
+    db_modified: u64,
+    complete: Complete,
+}
+
+const CACHE_EXPIRE_SECS: u64 = 60;
+const CACHE_MAX_ENTRIES: usize = 32;
+
+pub fn cached<F>(key: String, complete: F) -> Result<Complete>
+where
+    F: FnOnce() -> Result<Complete>,
+{
+    let path = PathBuf::from(&config::base().metadir).join("complete_cache.json");
+    let now = config::now_secs();
+    let db_modified = db_modified()?;
+
+    let mut entries = read_cache(&path).unwrap_or_default();
+    entries
+        .retain(|entry| entry.db_modified == db_modified && entry.time + CACHE_EXPIRE_SECS > now);
+    if let Some(idx) = entries.iter().position(|entry| entry.key == key) {
+        return Ok(entries.remove(idx).complete);
+    }
+
+    let complete = complete()?;
+    entries.push(CacheEntry {
+        key,
+        time: now,
+        db_modified,
+        complete,
+    });
+    if entries.len() > CACHE_MAX_ENTRIES {
+        entries.drain(..entries.len() - CACHE_MAX_ENTRIES);
+    }
+    let data = serde_json::to_vec(&entries).context("Encode complete cache")?;
+    // The cache is read and written by concurrent completions without lock.
+    utils::write_file_atomic(&path, &data, None)?;
+
+    Ok(entries.pop().unwrap().complete)
+}
+
+fn read_cache(path: &PathBuf) -> Result<Vec<CacheEntry>> {
+    let data = fs::read(path).with_context(|| format!("Read file {}", path.display()))?;
+    serde_json::from_slice(&data).context("Decode complete cache")
+}
+
+fn db_modified() -> Result<u64> {
+    let path = Database::default_path();
+    let meta = match fs::metadata(&path) {
+        Ok(meta) => meta,
+        Err(err) if err.kind() == ErrorKind::NotFound => return Ok(0),
+        Err(err) => return Err(err).with_context(|| format!("Read file {}", path.display())),
+    };
+    let modified = meta.modified().context("Get database modified time")?;
+    let modified = modified
+        .duration_since(UNIX_EPOCH)
+        .context("Database modified time set to invalid time")?;
+    Ok(modified.as_secs())
+}
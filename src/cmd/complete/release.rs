@@ -16,3 +16,12 @@ pub fn complete(args: &[&str]) -> Result<Complete> {
         _ => Ok(Complete::empty()),
     }
 }
+
+pub fn complete_bump(_args: &[&str]) -> Result<Complete> {
+    let items = vec![
+        String::from("patch"),
+        String::from("minor"),
+        String::from("major"),
+    ];
+    Ok(Complete::from(items))
+}
@@ -5,6 +5,14 @@ use crate::config;
 
 pub fn complete(args: &[&str]) -> Result<Complete> {
     match args.len() {
+        1 if args[0].starts_with('@') => {
+            let items: Vec<_> = config::base()
+                .groups
+                .keys()
+                .map(|group| format!("@{group}"))
+                .collect();
+            Ok(Complete::from(items))
+        }
         0 | 1 => {
             let remotes = config::list_remotes();
             let items: Vec<_> = remotes
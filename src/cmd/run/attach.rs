@@ -1,14 +1,23 @@
-use std::collections::HashSet;
+use std::collections::{HashMap, HashSet};
+use std::path::PathBuf;
 use std::rc::Rc;
 
 use anyhow::{bail, Result};
 use clap::Args;
 
+use crate::cmd::run::home;
 use crate::cmd::Run;
 use crate::config::types::Remote;
+use crate::repo::budget;
 use crate::repo::database::Database;
+use crate::repo::envrc;
+use crate::repo::event::{self, Event};
+use crate::repo::job::{Job, JobKind, JobTask};
+use crate::repo::lang;
+use crate::repo::manifest;
 use crate::repo::types::Repo;
 use crate::shell::Shell;
+use crate::utils::Table;
 use crate::{api, confirm, info, shell};
 use crate::{config, utils};
 
@@ -16,17 +25,36 @@ use crate::{config, utils};
 #[derive(Args)]
 pub struct AttachArgs {
     /// The remote name.
-    pub remote: String,
+    #[clap(required_unless_present_any = ["auto", "from_file"])]
+    pub remote: Option<String>,
     /// The repo query, format is `owner[/[name]]`
-    pub query: String,
+    #[clap(required_unless_present_any = ["auto", "from_file"])]
+    pub query: Option<String>,
 
     /// If true, the cache will not be used when calling the API search.
     #[clap(long, short)]
     pub force: bool,
+
+    /// Detect the remote, owner and name from the url of git remote `origin`,
+    /// the remote is matched by the `clone` domain or `ssh_alias`.
+    #[clap(long, short, conflicts_with_all = ["remote", "query"])]
+    pub auto: bool,
+
+    /// Attach or clone all the repos listed in the manifest file. The file
+    /// can be json or yaml (a list of `remote`, `owner`, `name` and optional
+    /// `path`, `pinned`, `branch`), or plain text with lines
+    /// `remote owner/name [path]`. The repo with path will be attached to it,
+    /// otherwise cloned into workspace. Use `roxide get --manifest` to export
+    /// the manifest.
+    #[clap(long, conflicts_with_all = ["remote", "query", "auto"])]
+    pub from_file: Option<String>,
 }
 
 impl Run for AttachArgs {
     fn run(&self) -> Result<()> {
+        if let Some(file) = &self.from_file {
+            return self.attach_manifest(file);
+        }
         let mut db = Database::read()?;
 
         if let Some(found) = db.current() {
@@ -36,8 +64,13 @@ impl Run for AttachArgs {
             );
         }
 
-        let remote = config::must_get_remote(&self.remote)?;
-        let repo = self.get_repo(&remote, &db)?;
+        let (remote, repo) = if self.auto {
+            self.detect_repo()?
+        } else {
+            let remote = config::must_get_remote(self.remote.as_ref().unwrap())?;
+            let repo = self.get_repo(&remote, &db, self.query.as_ref().unwrap())?;
+            (remote, repo)
+        };
 
         if let Some(found) = db.get(
             remote.name.as_str(),
@@ -55,35 +88,361 @@ impl Run for AttachArgs {
             "Do you want to attach current directory to {}",
             repo.long_name()
         );
+        Self::configure(&remote, &repo.get_path())?;
+        envrc::write(&repo, false)?;
+        info!("Attach current directory to {}", repo.long_name());
+        db.update(Rc::clone(&repo));
+        db.close()?;
+
+        event::emit(Event::RepoAttached {
+            repo: &repo.full_name(),
+            path: &format!("{}", repo.get_path().display()),
+        });
+        Ok(())
+    }
+}
+
+impl AttachArgs {
+    fn configure(remote: &Remote, dir: &PathBuf) -> Result<()> {
+        let path = format!("{}", dir.display());
         if let Some(user) = &remote.user {
-            Shell::git(&["config", "user.name", user.as_str()])
+            Shell::git(&["-C", path.as_str(), "config", "user.name", user.as_str()])
                 .with_desc(format!("Set user to {}", user))
                 .execute()?
                 .check()?;
         }
         if let Some(email) = &remote.email {
-            Shell::git(&["config", "user.email", email.as_str()])
+            Shell::git(&["-C", path.as_str(), "config", "user.email", email.as_str()])
                 .with_desc(format!("Set email to {}", email))
                 .execute()?
                 .check()?;
         }
-        info!("Attach current directory to {}", repo.long_name());
-        db.update(repo);
+        if let Some(ssh_command) = &remote.ssh_command {
+            Shell::git(&[
+                "-C",
+                path.as_str(),
+                "config",
+                "core.sshCommand",
+                ssh_command.as_str(),
+            ])
+            .with_desc(format!("Set ssh command to {}", ssh_command))
+            .execute()?
+            .check()?;
+        }
+        Ok(())
+    }
+
+    fn attach_manifest(&self, file: &str) -> Result<()> {
+        let entries = manifest::read(file)?;
+        let db = Database::read()?;
+
+        let mut tasks: Vec<(Remote, Rc<Repo>, manifest::Entry)> = Vec::with_capacity(entries.len());
+        let mut seen = HashSet::new();
+        for mut entry in entries {
+            let remote = config::must_get_remote(&entry.remote)?;
+            let owner = remote.resolve_owner(&entry.owner).to_string();
+            if let Some(found) = db.get(&remote.name, &owner, &entry.name) {
+                info!("Skip {}, it is already bound", found.full_name());
+                continue;
+            }
+            let path = match entry.path.take() {
+                Some(path) => {
+                    let path = config::current_dir().join(path);
+                    if !path.join(".git").exists() {
+                        bail!("The path {} is not a git repository", path.display());
+                    }
+                    Some(format!("{}", path.display()))
+                }
+                None => None,
+            };
+            let repo = Repo::new(&remote.name, &owner, &entry.name, path);
+            if !seen.insert(repo.full_name()) {
+                continue;
+            }
+            tasks.push((remote, repo, entry));
+        }
+        let default_branches = self.fetch_remote_repos(&mut tasks)?;
+        if tasks.is_empty() {
+            println!("Nothing to attach");
+            return Ok(());
+        }
 
-        db.close()
+        let mut table = Table::with_capacity(1 + tasks.len());
+        table.add(vec![
+            String::from("REPO"),
+            String::from("ACTION"),
+            String::from("PATH"),
+        ]);
+        for (_, repo, _) in tasks.iter() {
+            let action = match repo.path {
+                Some(_) => "attach",
+                None => "clone",
+            };
+            table.add(vec![
+                repo.full_name(),
+                String::from(action),
+                format!("{}", repo.get_path().display()),
+            ]);
+        }
+        table.show();
+        confirm!("Do you want to attach {} repos", tasks.len());
+        // The database is saved after each repo, release it now.
+        drop(db);
+
+        let tasks = tasks
+            .into_iter()
+            .map(|(remote, repo, entry)| {
+                let mut task = JobTask::new(&repo);
+                task.repo.remote = remote.name;
+                task.repo.pinned = entry.pinned;
+                task.repo.branch = entry.branch;
+                task.default_branch = default_branches.get(&repo.full_name()).cloned();
+                task
+            })
+            .collect();
+        let job = Job::create(JobKind::Attach, tasks)?;
+        Self::run_job(job)
     }
-}
 
-impl AttachArgs {
-    fn get_repo(&self, remote: &Remote, db: &Database) -> Result<Rc<Repo>> {
+    /// Attach or clone the repos of the job which are not done yet, the job
+    /// can be resumed by `roxide resume` if interrupted or failed.
+    pub fn run_job(mut job: Job) -> Result<()> {
+        let mut idxs = Vec::with_capacity(job.tasks.len());
+        let mut tasks = Vec::with_capacity(job.tasks.len());
+        let mut priorities = Vec::with_capacity(job.tasks.len());
+        for (idx, task) in job.tasks.iter().enumerate() {
+            if task.done {
+                continue;
+            }
+            idxs.push(idx);
+            tasks.push(task.clone());
+            priorities.push(task.repo.pinned);
+        }
+
+        // Attach the pinned repos first, so that the user can start working
+        // with them early.
+        let results = utils::batch_priority(&tasks, &priorities, Self::attach_task);
+
+        // Save the attached repos to database at once, together with the job
+        // progress. If the job is interrupted before this, resuming verifies
+        // and reuses the cloned directories.
+        let mut db = Database::read()?;
+        let mut failed = Vec::new();
+        let mut attached = Vec::new();
+        for ((idx, task), result) in idxs.into_iter().zip(tasks).zip(results) {
+            let languages = match result {
+                Ok(languages) => languages,
+                Err(err) => {
+                    failed.push((task.full_name(), err));
+                    continue;
+                }
+            };
+            let repo = task.to_repo();
+            db.update(Rc::clone(&repo));
+            if task.repo.pinned {
+                db.pin(&repo, true);
+            }
+            if let Some(languages) = languages {
+                db.set_languages(&repo, languages);
+            }
+            if let Some(branch) = &task.default_branch {
+                db.set_default_branch(&repo, branch.clone());
+            }
+            job.done_in(&mut db, idx);
+            attached.push(task.full_name());
+        }
+        db.close()?;
+        job.finish()?;
+        if !attached.is_empty() {
+            budget::check_all(&attached);
+        }
+
+        if !failed.is_empty() {
+            println!();
+            for (name, err) in failed.iter() {
+                println!("Attach {name} failed: {err:#}");
+            }
+            bail!(
+                "{} repos failed to attach, use `roxide resume` to retry",
+                failed.len()
+            );
+        }
+        Ok(())
+    }
+
+    /// Attach or clone the repo of task, return its detected languages. This
+    /// runs in batch workers, the database is updated by the caller.
+    fn attach_task(task: &JobTask) -> Result<Option<Vec<String>>> {
+        let remote = config::must_get_remote(&task.repo.remote)?;
+        let repo = task.to_repo();
+        let dir = repo.get_path();
+        match repo.path {
+            Some(_) => {
+                Self::configure(&remote, &dir)?;
+                event::emit(Event::RepoAttached {
+                    repo: &repo.full_name(),
+                    path: &format!("{}", dir.display()),
+                });
+            }
+            None if dir.exists() => {
+                Self::verify_origin(&remote, &repo, &dir)?;
+                Self::configure(&remote, &dir)?;
+            }
+            None => {
+                home::clone_repo(&remote, &repo, &dir)?;
+                Self::checkout(&dir, task.repo.branch.as_ref())?;
+                event::emit(Event::RepoCreated {
+                    repo: &repo.full_name(),
+                    path: &format!("{}", dir.display()),
+                });
+            }
+        }
+        envrc::write(&repo, false)?;
+        lang::detect_names(&dir)
+    }
+
+    /// The existing directory in workspace is reused instead of cloning, only
+    /// if it is a clone of the repo (such as the one cloned by an interrupted
+    /// job), to avoid binding an unrelated directory.
+    fn verify_origin(remote: &Remote, repo: &Repo, dir: &PathBuf) -> Result<()> {
+        let path = format!("{}", dir.display());
+        let origin = match Shell::git(&["-C", path.as_str(), "remote", "get-url", "origin"])
+            .set_mute(true)
+            .execute()?
+            .checked_read()
+        {
+            Ok(origin) => origin,
+            Err(_) => bail!(
+                "The directory {} exists, but it is not a git repository with origin",
+                dir.display()
+            ),
+        };
+        let origin = Self::normalize_url(&origin);
+        let expects = [repo.ssh_url(remote), repo.https_url(remote)];
+        if expects.iter().any(|url| Self::normalize_url(url) == origin) {
+            return Ok(());
+        }
+        bail!(
+            "The directory {} exists, but its origin is not {}",
+            dir.display(),
+            repo.full_name()
+        )
+    }
+
+    fn normalize_url(url: &str) -> String {
+        let url = url.trim().trim_end_matches("/");
+        let url = url.strip_suffix(".git").unwrap_or(url);
+        url.to_lowercase()
+    }
+
+    /// Fetch the repos to clone from remote API in batch (Github fetches up
+    /// to 100 repos in one GraphQL query), the repos not found on the remote
+    /// are dropped with warning. Return the default branches of the found
+    /// repos.
+    fn fetch_remote_repos(
+        &self,
+        tasks: &mut Vec<(Remote, Rc<Repo>, manifest::Entry)>,
+    ) -> Result<HashMap<String, String>> {
+        // The repos of the same remote and owner share one provider, since
+        // the owner might have its own token.
+        let mut keys: Vec<(String, String)> = Vec::new();
+        let mut groups: HashMap<(String, String), Vec<usize>> = HashMap::new();
+        for (idx, (remote, repo, _)) in tasks.iter().enumerate() {
+            if repo.path.is_some() || remote.provider.is_none() {
+                continue;
+            }
+            let key = (remote.name.clone(), repo.owner.to_string());
+            if !groups.contains_key(&key) {
+                keys.push(key.clone());
+            }
+            groups.entry(key).or_default().push(idx);
+        }
+        if keys.is_empty() {
+            return Ok(HashMap::new());
+        }
+
+        info!("Get repos from remote API");
+        let mut default_branches = HashMap::new();
+        let mut missing = HashSet::new();
+        for key in keys {
+            let idxs = groups.remove(&key).unwrap();
+            let (remote, _, _) = &tasks[idxs[0]];
+            let provider = api::init_owner_provider(remote, &key.1, self.force)?;
+            let names: Vec<(String, String)> = idxs
+                .iter()
+                .map(|idx| (key.1.clone(), tasks[*idx].1.name.to_string()))
+                .collect();
+            let api_repos = provider.get_repos(&names)?;
+            for (idx, api_repo) in idxs.into_iter().zip(api_repos) {
+                let name = tasks[idx].1.full_name();
+                match api_repo {
+                    // The default branch is empty for the empty repo.
+                    Some(api_repo) if !api_repo.default_branch.is_empty() => {
+                        default_branches.insert(name, api_repo.default_branch);
+                    }
+                    Some(_) => {}
+                    None => {
+                        missing.insert(name);
+                    }
+                }
+            }
+        }
+
+        tasks.retain(|(_, repo, _)| {
+            let name = repo.full_name();
+            if missing.contains(&name) {
+                utils::show_warn(format!("Skip {name}, it is not found on the remote"));
+                return false;
+            }
+            true
+        });
+        Ok(default_branches)
+    }
+
+    /// Checkout the branch recorded in manifest for the new cloned repo.
+    fn checkout(dir: &PathBuf, branch: Option<&String>) -> Result<()> {
+        let branch = match branch {
+            Some(branch) => branch,
+            None => return Ok(()),
+        };
+        let path = format!("{}", dir.display());
+        let current = Shell::git(&["-C", path.as_str(), "branch", "--show-current"])
+            .set_mute(true)
+            .execute()?
+            .checked_read()?;
+        if current.as_str() == branch.as_str() {
+            return Ok(());
+        }
+        Shell::git(&["-C", path.as_str(), "checkout", branch.as_str()])
+            .with_desc(format!("Checkout to {branch}"))
+            .execute()?
+            .check()
+    }
+
+    fn detect_repo(&self) -> Result<(Remote, Rc<Repo>)> {
+        let url = Shell::git(&["remote", "get-url", "origin"])
+            .with_desc("Get origin url")
+            .execute()?
+            .checked_read()?;
+        let (remote, owner, name) = match utils::parse_url(&url)? {
+            Some(parsed) => parsed,
+            None => {
+                bail!("No remote matches the origin url {url}")
+            }
+        };
+        info!("Detect repo {}:{owner}/{name} from origin url", remote.name);
+
+        let path = format!("{}", config::current_dir().display());
+        let repo = Repo::new(&remote.name, &owner, &name, Some(path));
+        Ok((remote, repo))
+    }
+
+    fn get_repo(&self, remote: &Remote, db: &Database, query: &str) -> Result<Rc<Repo>> {
         let path = config::current_dir();
         let path = format!("{}", path.display());
-        if self.query.ends_with("/") {
-            let mut owner = self.query.trim_end_matches("/");
-            if let Some(raw_owner) = remote.owner_alias.get(owner) {
-                owner = raw_owner.as_str();
-            }
-            let provider = api::init_provider(remote, self.force)?;
+        if query.ends_with("/") {
+            let owner = remote.resolve_owner(query);
+            let provider = api::init_owner_provider(remote, owner, self.force)?;
             let items = provider.list_repos(owner)?;
 
             let attached = db.list_by_owner(remote.name.as_str(), owner);
@@ -105,7 +464,7 @@ impl AttachArgs {
             ));
         }
 
-        let (owner, name) = utils::parse_query(remote, &self.query);
+        let (owner, name) = utils::parse_query(remote, query);
         Ok(Repo::new(&remote.name, &owner, &name, Some(path)))
     }
 }
@@ -0,0 +1,162 @@
+use anyhow::{bail, Result};
+use clap::{Args, Subcommand};
+
+use crate::api::auth;
+use crate::cmd::Run;
+use crate::config;
+use crate::config::types::Remote;
+use crate::utils::{self, Table};
+use crate::{api, info};
+
+/// Login to remote by OAuth device flow, so that the token need not be
+/// created manually.
+#[derive(Args)]
+pub struct AuthArgs {
+    #[command(subcommand)]
+    pub command: AuthCommands,
+}
+
+#[derive(Subcommand)]
+pub enum AuthCommands {
+    /// Login to remote, the token is saved to keychain if available, else to
+    /// a file only the current user can read. The file is not encrypted, use
+    /// a keychain to keep the token encrypted.
+    Login(AuthLoginArgs),
+    /// Show the token sources of remotes.
+    Status(AuthStatusArgs),
+    /// Remove the saved token of remote.
+    Logout(AuthLogoutArgs),
+}
+
+#[derive(Args)]
+pub struct AuthLoginArgs {
+    /// The remote name.
+    pub remote: String,
+}
+
+#[derive(Args)]
+pub struct AuthStatusArgs {
+    /// The remote name, default is all remotes.
+    pub remote: Option<String>,
+}
+
+#[derive(Args)]
+pub struct AuthLogoutArgs {
+    /// The remote name.
+    pub remote: String,
+}
+
+impl Run for AuthArgs {
+    fn run(&self) -> Result<()> {
+        match &self.command {
+            AuthCommands::Login(args) => args.run(),
+            AuthCommands::Status(args) => args.run(),
+            AuthCommands::Logout(args) => args.run(),
+        }
+    }
+}
+
+impl Run for AuthLoginArgs {
+    fn run(&self) -> Result<()> {
+        if utils::is_non_interactive() {
+            bail!("Login requires interactive mode");
+        }
+        let remote = config::must_get_remote(&self.remote)?;
+        login(&remote)
+    }
+}
+
+impl Run for AuthStatusArgs {
+    fn run(&self) -> Result<()> {
+        let remotes: Vec<String> = match &self.remote {
+            Some(remote) => {
+                let remote = config::must_get_remote(remote)?;
+                vec![remote.name]
+            }
+            None => config::list_remotes()
+                .into_iter()
+                .map(|remote| remote.to_string())
+                .collect(),
+        };
+
+        let mut table = Table::with_capacity(1 + remotes.len());
+        table.add(vec![
+            String::from("REMOTE"),
+            String::from("TOKEN"),
+            String::from("LOGIN"),
+        ]);
+        for name in remotes {
+            let remote = config::must_get_remote(&name)?;
+            let login = auth::get_login(&name)?;
+            let login_at = match &login {
+                Some(login) => utils::format_time(login.login_at)?,
+                None => String::new(),
+            };
+            let source = match (&remote.token, &login) {
+                (Some(_), _) => String::from("config"),
+                (None, Some(login)) => String::from(login.store.as_str()),
+                (None, None) => String::from("none"),
+            };
+            table.add(vec![name, source, login_at]);
+        }
+        table.show();
+        Ok(())
+    }
+}
+
+impl Run for AuthLogoutArgs {
+    fn run(&self) -> Result<()> {
+        let remote = config::must_get_remote(&self.remote)?;
+        if !auth::delete(&remote.name)? {
+            println!("Not logged in to {}", remote.name);
+            return Ok(());
+        }
+        info!("Logout from {}", remote.name);
+        Ok(())
+    }
+}
+
+/// Login to the remote by the device authorization flow, and save the token.
+pub fn login(remote: &Remote) -> Result<()> {
+    if let Some(_) = remote.token {
+        utils::show_warn(format!(
+            "The remote {} has token in config, it has higher priority than the login token",
+            remote.name
+        ));
+    }
+
+    info!("Request device code from remote");
+    let code = auth::request_device_code(remote)?;
+    println!();
+    println!("Please open {} and enter code:", code.verification_uri);
+    println!();
+    println!("    {}", code.user_code);
+    println!();
+    // The url is printed above, the user can open it manually if there is no
+    // browser.
+    if let Err(err) = utils::open_url(remote.browser(), &code.verification_uri) {
+        utils::show_warn(format!("{err:#}"));
+    }
+
+    info!("Waiting for authorization");
+    let token = auth::poll_token(remote, &code)?;
+    let store = auth::save(&remote.name, &token)?;
+    info!(
+        "Login to {} succeeded, the token is saved to {}",
+        remote.name,
+        store.as_str()
+    );
+    if let auth::TokenStore::File = store {
+        utils::show_warn(
+            "No keychain is available, the token is saved unencrypted, protected only by file permissions",
+        );
+    }
+
+    // Verify the token, and let the user know the granted scopes.
+    let provider = api::init_provider(remote, true)?;
+    let api_info = provider.get_info()?;
+    if let Some(scopes) = api_info.scopes {
+        info!("The token scopes: {}", scopes.join(", "));
+    }
+    Ok(())
+}
@@ -2,9 +2,16 @@ use anyhow::{bail, Context, Result};
 use clap::Args;
 use console::style;
 
+use crate::api::types::MergeOptions;
+use crate::cmd::run::merge::MergeArgs;
 use crate::cmd::Run;
-use crate::confirm;
+use crate::config::types::Provider;
+use crate::repo::budget;
+use crate::repo::database::Database;
+use crate::repo::event::{self, Event};
+use crate::repo::report::SyncReport;
 use crate::shell::{self, BranchStatus, GitBranch, Shell};
+use crate::{api, config, confirm, info, utils};
 
 /// Git branch operations
 #[derive(Args)]
@@ -31,10 +38,27 @@ pub struct BranchArgs {
     /// Push change (create or delete) to remote
     #[clap(long, short)]
     pub push: bool,
+
+    /// Donot apply owner's branch template when creating branch
+    #[clap(long)]
+    pub raw: bool,
+
+    /// The base branch to create the new branch from, it is freshly fetched
+    /// from origin before creating. Default is the default branch. Use `HEAD`
+    /// to create from the current commit without fetching.
+    #[clap(long, short, requires = "create")]
+    pub base: Option<String>,
+
+    /// After creating branch, push it and open a draft merge request (or PR for
+    /// Github) targeting the base branch. Github does not allow PR without
+    /// commits, so an empty commit is created after confirmation.
+    #[clap(long, short, requires = "create")]
+    pub merge: bool,
 }
 
 enum SyncBranchTask<'a> {
-    Sync(&'a str, &'a str),
+    Push(&'a GitBranch),
+    Pull(&'a GitBranch),
     Delete(&'a str),
 }
 
@@ -58,11 +82,15 @@ impl Run for BranchArgs {
             self.show(&branches);
             return Ok(());
         }
-        let name = self.name.as_ref().unwrap();
+        let mut name = self.name.as_ref().unwrap().clone();
         if self.create {
-            Shell::git(&["checkout", "-b", name.as_str()])
-                .execute()?
-                .check()?;
+            if !self.raw {
+                name = self.apply_template(name)?;
+            }
+            let base = self.create(&name)?;
+            if self.merge {
+                return self.start_merge(&name, base);
+            }
         } else {
             Shell::git(&["checkout", name.as_str()])
                 .execute()?
@@ -90,12 +118,37 @@ impl BranchArgs {
             return;
         }
         for branch in branches {
-            println!("{} {}", branch.name.as_str(), branch.status.display(),);
+            let counts = branch.counts_desc();
+            if counts.is_empty() {
+                println!("{} {}", branch.name.as_str(), branch.status.display());
+            } else {
+                println!(
+                    "{} {} {}",
+                    branch.name.as_str(),
+                    branch.status.display(),
+                    counts
+                );
+            }
         }
     }
 
     fn sync(&self, branches: &Vec<GitBranch>) -> Result<()> {
+        // Syncing checks out other branches, the commits in detached HEAD
+        // would be lost.
+        GitBranch::must_current()?;
+        // Always get the fresh default branch when syncing, and refresh the
+        // one cached in database.
         let default = GitBranch::default().context("Get default branch")?;
+        let mut db = Database::read()?;
+        let repo = db.current();
+        let report_name = match &repo {
+            Some(repo) => {
+                db.set_default_branch(repo, default.clone());
+                repo.full_name()
+            }
+            None => format!("{}", config::current_dir().display()),
+        };
+        db.close()?;
 
         let mut back = &default;
         let mut tasks: Vec<SyncBranchTask> = vec![];
@@ -109,8 +162,8 @@ impl BranchArgs {
                 }
             }
             let task = match branch.status {
-                BranchStatus::Ahead => Some(SyncBranchTask::Sync("push", branch.name.as_str())),
-                BranchStatus::Behind => Some(SyncBranchTask::Sync("pull", branch.name.as_str())),
+                BranchStatus::Ahead => Some(SyncBranchTask::Push(branch)),
+                BranchStatus::Behind => Some(SyncBranchTask::Pull(branch)),
                 BranchStatus::Gone => {
                     if branch.name == default {
                         // we cannot delete default branch
@@ -136,9 +189,18 @@ impl BranchArgs {
         println!("Sync {} ({}):", word, tasks.len());
         for task in &tasks {
             match task {
-                SyncBranchTask::Sync(op, branch) => {
-                    println!("{} {} {} ", style("+").green(), op, style(branch).magenta())
-                }
+                SyncBranchTask::Push(branch) => println!(
+                    "{} push {}({})",
+                    style("↑").green(),
+                    style(&branch.name).magenta(),
+                    branch.ahead
+                ),
+                SyncBranchTask::Pull(branch) => println!(
+                    "{} pull {}({})",
+                    style("↓").green(),
+                    style(&branch.name).magenta(),
+                    branch.behind
+                ),
                 SyncBranchTask::Delete(branch) => {
                     println!("{} delete {} ", style("-").red(), style(branch).magenta())
                 }
@@ -147,35 +209,195 @@ impl BranchArgs {
         confirm!("Continue");
 
         println!();
+        let mut report = SyncReport::new(report_name);
+        for branch in branches {
+            if let BranchStatus::Conflict = branch.status {
+                report.add_conflict(&branch.name, branch.ahead, branch.behind);
+            }
+        }
         for task in tasks {
-            match task {
-                SyncBranchTask::Sync(op, branch) => {
-                    if current != branch {
-                        // checkout to this branch to perform push/pull
-                        Shell::git(&["checkout", branch]).execute()?.check()?;
-                        current = branch;
-                    }
-                    Shell::git(&[op]).execute()?.check()?;
+            let (op, branch, commits, result) = match task {
+                SyncBranchTask::Push(branch) => {
+                    let name = branch.name.as_str();
+                    let result = (|| -> Result<()> {
+                        if current != name {
+                            // checkout to this branch to perform push
+                            Shell::git(&["checkout", name]).execute()?.check()?;
+                            current = name;
+                        }
+                        Shell::git(&["push"]).execute()?.check()
+                    })();
+                    ("push", name, branch.ahead, result)
+                }
+                SyncBranchTask::Pull(branch) => {
+                    let name = branch.name.as_str();
+                    let result = Self::pull(branch, current == name);
+                    ("pull", name, branch.behind, result)
                 }
                 SyncBranchTask::Delete(branch) => {
-                    if current == branch {
-                        // we cannot delete branch when we are inside it, checkout
-                        // to default branch first.
-                        let default = default.as_str();
-                        Shell::git(&["checkout", default]).execute()?.check()?;
-                        current = default;
-                    }
-                    Shell::git(&["branch", "-D", branch]).execute()?.check()?;
+                    let result = (|| -> Result<()> {
+                        if current == branch {
+                            // we cannot delete branch when we are inside it, checkout
+                            // to default branch first.
+                            let default = default.as_str();
+                            Shell::git(&["checkout", default]).execute()?.check()?;
+                            current = default;
+                        }
+                        Shell::git(&["branch", "-D", branch]).execute()?.check()
+                    })();
+                    ("delete", branch, 0, result)
                 }
+            };
+            if op == "delete" && result.is_ok() {
+                event::emit(Event::BranchDeleted {
+                    path: &format!("{}", config::current_dir().display()),
+                    branch,
+                });
+            }
+            report.add(op, branch, commits, &result);
+            if let Err(err) = result {
+                // Stop at the first failure (such as a conflict), the report
+                // is still saved so it can be reviewed by `roxide get -r`.
+                report.save()?;
+                return Err(err);
             }
         }
+        report.save()?;
         if current != back {
             Shell::git(&["checkout", back]).execute()?.check()?;
         }
+        if let Some(repo) = repo {
+            budget::check(&[repo.full_name()]);
+        }
 
         Ok(())
     }
 
+    /// Pull the branch which is only behind its upstream, so it can always be
+    /// fast-forwarded. The branch not checked out is updated in place, without
+    /// touching the worktree.
+    fn pull(branch: &GitBranch, current: bool) -> Result<()> {
+        if branch.ahead > 0 {
+            bail!(
+                "The branch {} has diverged from upstream, could not fast-forward",
+                branch.name
+            );
+        }
+        if current {
+            return Shell::git(&["pull", "--ff-only"]).execute()?.check();
+        }
+        let upstream = match &branch.upstream {
+            Some(upstream) => upstream,
+            None => bail!("The branch {} has no upstream", branch.name),
+        };
+        // Fetching from the local repository only allows fast-forward.
+        let refspec = format!("{upstream}:{}", branch.name);
+        Shell::git(&["fetch", ".", refspec.as_str()])
+            .execute()?
+            .check()
+    }
+
+    /// Create the branch and return its base branch, which is the target of
+    /// merge.
+    fn create(&self, name: &str) -> Result<String> {
+        let base = match self.base.as_deref() {
+            Some("HEAD") => {
+                if self.merge {
+                    bail!("Could not open merge for branch created from HEAD, please specify a base branch");
+                }
+                Shell::git(&["checkout", "-b", name]).execute()?.check()?;
+                return Ok(String::from("HEAD"));
+            }
+            Some(base) => base.to_string(),
+            None => GitBranch::default_fast()?,
+        };
+
+        Shell::git(&["fetch", "origin", base.as_str()])
+            .execute()?
+            .check()?;
+        let start = format!("origin/{base}");
+        Shell::git(&["checkout", "--no-track", "-b", name, start.as_str()])
+            .execute()?
+            .check()?;
+        Ok(base)
+    }
+
+    fn start_merge(&self, name: &str, base: String) -> Result<()> {
+        let db = Database::read()?;
+        let repo = db.must_current()?;
+        let remote = config::must_get_remote(repo.remote.as_str())?;
+        let provider = api::init_owner_provider(&remote, &repo.owner, false)?;
+
+        let title = utils::input("Please input merge title", true, Some(name))?;
+        // Github does not allow to create PR without commit, Gitlab does.
+        if let Some(Provider::Github) = remote.provider {
+            confirm!("Github requires a commit to open PR, create an empty commit");
+            Shell::git(&["commit", "--allow-empty", "-m", title.as_str()])
+                .execute()?
+                .check()?;
+        }
+        Shell::git(&["push", "--set-upstream", "origin", name])
+            .execute()?
+            .check()?;
+
+        let merge = MergeOptions {
+            owner: format!("{}", repo.owner),
+            name: format!("{}", repo.name),
+            upstream: None,
+            source: name.to_string(),
+            target: base,
+            draft: true,
+            reviewers: vec![],
+            assignees: vec![],
+            labels: vec![],
+        };
+        let merge = MergeArgs::with_owner_defaults(merge, &remote);
+        let body = MergeArgs::get_template(&repo.get_path(), &remote)?.unwrap_or_default();
+        info!("Call remote API to create draft merge");
+        let url = provider.create_merge(merge, title, body)?;
+        utils::open_url(remote.browser(), &url)
+    }
+
+    fn apply_template(&self, name: String) -> Result<String> {
+        let db = Database::read()?;
+        let repo = match db.current() {
+            Some(repo) => repo,
+            None => return Ok(name),
+        };
+        let remote = config::must_get_remote(repo.remote.as_str())?;
+        let template = match remote.owners.get(repo.owner.as_str()) {
+            Some(owner) => match &owner.branch_template {
+                Some(template) => template,
+                None => return Ok(name),
+            },
+            None => return Ok(name),
+        };
+
+        let mut branch = template.replace("{name}", &name);
+        if branch.contains("{user}") {
+            let user = match &remote.user {
+                Some(user) => user,
+                None => bail!(
+                    "The branch template {} requires user, please config it for remote {}",
+                    style(template).yellow(),
+                    remote.name
+                ),
+            };
+            // The user is a display name (such as `John Doe`), which might
+            // not be valid in ref name.
+            branch = branch.replace("{user}", &utils::slugify(user));
+        }
+        if branch != name {
+            info!("Apply branch template: {}", style(&branch).magenta());
+        }
+        Shell::git(&["check-ref-format", "--branch", branch.as_str()])
+            .set_mute(true)
+            .execute()?
+            .check()
+            .with_context(|| format!("Invalid branch name {}", style(&branch).yellow()))?;
+        Ok(branch)
+    }
+
     fn fetch(&self) -> Result<()> {
         let mut git = Shell::git(&["fetch", "origin", "--prune"]);
         git.execute()?.check()?;
@@ -187,7 +409,7 @@ impl BranchArgs {
 
         if branch.current {
             shell::ensure_no_uncommitted()?;
-            let default = GitBranch::default()?;
+            let default = GitBranch::default_fast()?;
             if branch.name.eq(&default) {
                 bail!("Could not delete default branch");
             }
@@ -199,6 +421,10 @@ impl BranchArgs {
         Shell::git(&["branch", "-D", &branch.name])
             .execute()?
             .check()?;
+        event::emit(Event::BranchDeleted {
+            path: &format!("{}", config::current_dir().display()),
+            branch: &branch.name,
+        });
         if self.push {
             Shell::git(&["push", "origin", "--delete", &branch.name])
                 .execute()?
@@ -228,7 +454,10 @@ impl BranchArgs {
     fn must_get_current_branch(branches: &Vec<GitBranch>) -> Result<&GitBranch> {
         match branches.iter().find(|b| b.current) {
             Some(b) => Ok(b),
-            None => bail!("Could not find current branch"),
+            None => {
+                GitBranch::must_current()?;
+                bail!("Could not find current branch")
+            }
         }
     }
 }
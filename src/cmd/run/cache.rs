@@ -0,0 +1,190 @@
+use std::fs;
+use std::io::ErrorKind;
+use std::path::PathBuf;
+
+use anyhow::{Context, Result};
+use clap::{Args, Subcommand};
+
+use crate::api::cache;
+use crate::cmd::Run;
+use crate::utils::{self, Lock, Table};
+use crate::{api, config, confirm, info};
+
+/// Show or clear the remote API cache.
+#[derive(Args)]
+pub struct CacheArgs {
+    #[command(subcommand)]
+    pub command: CacheCommands,
+}
+
+#[derive(Subcommand)]
+pub enum CacheCommands {
+    /// Show the number and size of cache files.
+    Stats(CacheTargetArgs),
+    /// Remove the cache files.
+    Clear(CacheTargetArgs),
+}
+
+#[derive(Args)]
+pub struct CacheTargetArgs {
+    /// The remote name, default is all remotes.
+    pub remote: Option<String>,
+
+    /// The owner name, only handle the cache of this owner.
+    pub owner: Option<String>,
+}
+
+#[derive(Default)]
+struct CacheStats {
+    lists: usize,
+    repos: usize,
+    bytes: u64,
+}
+
+impl Run for CacheArgs {
+    fn run(&self) -> Result<()> {
+        match &self.command {
+            CacheCommands::Stats(args) => {
+                let (remotes, owner) = args.resolve()?;
+                Self::show(&remotes, owner.as_deref())
+            }
+            CacheCommands::Clear(args) => {
+                let (remotes, owner) = args.resolve()?;
+                Self::clear(&remotes, owner.as_deref())
+            }
+        }
+    }
+}
+
+impl CacheTargetArgs {
+    /// Return the remotes to handle, and the owner in cache file name.
+    fn resolve(&self) -> Result<(Vec<String>, Option<String>)> {
+        let remotes: Vec<String> = match &self.remote {
+            Some(remote) => {
+                let remote = config::must_get_remote(remote)?;
+                vec![remote.name]
+            }
+            None => config::list_remotes()
+                .into_iter()
+                .map(|remote| remote.to_string())
+                .collect(),
+        };
+
+        let owner = match (&self.remote, &self.owner) {
+            (Some(remote), Some(owner)) => {
+                let remote = config::must_get_remote(remote)?;
+                let owner = remote.resolve_owner(owner);
+                Some(cache::encode_name(&owner))
+            }
+            _ => None,
+        };
+        Ok((remotes, owner))
+    }
+}
+
+impl CacheArgs {
+    fn show(remotes: &[String], owner: Option<&str>) -> Result<()> {
+        let mut table = Table::with_capacity(1 + remotes.len());
+        table.add(vec![
+            String::from("REMOTE"),
+            String::from("LISTS"),
+            String::from("REPOS"),
+            String::from("SIZE"),
+        ]);
+        for remote in remotes {
+            let mut stats = CacheStats::default();
+            for (path, kind) in Self::list_files(remote, owner)? {
+                match kind {
+                    "list" => stats.lists += 1,
+                    _ => stats.repos += 1,
+                }
+                let meta = fs::metadata(&path)
+                    .with_context(|| format!("Get metadata for {}", path.display()))?;
+                stats.bytes += meta.len();
+            }
+            table.add(vec![
+                remote.clone(),
+                format!("{}", stats.lists),
+                format!("{}", stats.repos),
+                utils::human_bytes(stats.bytes as f64),
+            ]);
+        }
+        table.show();
+        Ok(())
+    }
+
+    fn clear(remotes: &[String], owner: Option<&str>) -> Result<()> {
+        let _lock = Lock::acquire("cache")?;
+        let mut files = Vec::new();
+        for remote in remotes {
+            files.extend(Self::list_files(remote, owner)?);
+        }
+        if files.is_empty() {
+            println!("Nothing to clear");
+            return Ok(());
+        }
+
+        let word = if files.len() == 1 { "file" } else { "files" };
+        confirm!("Do you want to clear {} cache {}", files.len(), word);
+        for (path, _) in files {
+            fs::remove_file(&path)
+                .with_context(|| format!("Remove cache file {}", path.display()))?;
+        }
+        info!("Cache cleared");
+        Ok(())
+    }
+
+    /// List the cache files of remote, the file is named `list.{owner}` or
+    /// `repo.{owner}.{name}`, the owner and name are encoded by
+    /// [`cache::encode_name`]. The files of owners' own tokens are in the
+    /// `token.{hash}` sub directories.
+    fn list_files(remote: &str, owner: Option<&str>) -> Result<Vec<(PathBuf, &'static str)>> {
+        let dir = api::cache_dir(remote);
+        Self::list_dir_files(dir, owner, true)
+    }
+
+    fn list_dir_files(
+        dir: PathBuf,
+        owner: Option<&str>,
+        with_tokens: bool,
+    ) -> Result<Vec<(PathBuf, &'static str)>> {
+        let read_dir = match fs::read_dir(&dir) {
+            Ok(read_dir) => read_dir,
+            Err(err) if err.kind() == ErrorKind::NotFound => return Ok(Vec::new()),
+            Err(err) => {
+                return Err(err).with_context(|| format!("Read directory {}", dir.display()))
+            }
+        };
+
+        let mut files = Vec::new();
+        for item in read_dir {
+            let item =
+                item.with_context(|| format!("Read directory item for {}", dir.display()))?;
+            let name = item.file_name();
+            let name = match name.to_str() {
+                Some(name) => name,
+                None => continue,
+            };
+            let (kind, rest) = match name.split_once(".") {
+                Some(("list", rest)) => ("list", rest),
+                Some(("repo", rest)) => ("repo", rest),
+                Some(("token", _)) if with_tokens => {
+                    files.extend(Self::list_dir_files(item.path(), owner, false)?);
+                    continue;
+                }
+                _ => continue,
+            };
+            if let Some(owner) = owner {
+                let file_owner = match kind {
+                    "list" => rest,
+                    _ => rest.split_once(".").map(|(owner, _)| owner).unwrap_or(rest),
+                };
+                if file_owner != owner {
+                    continue;
+                }
+            }
+            files.push((item.path(), kind));
+        }
+        Ok(files)
+    }
+}
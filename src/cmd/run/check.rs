@@ -0,0 +1,326 @@
+use std::path::PathBuf;
+
+use anyhow::{bail, Context, Result};
+use clap::Args;
+
+use crate::cmd::Run;
+use crate::repo::database::Database;
+use crate::repo::lang;
+use crate::shell::Shell;
+use crate::utils::{self, Table};
+use crate::{config, confirm, info};
+
+/// Audit repos for drift, such as the origin url or git user not matching the
+/// config, and fix them with `--fix`.
+#[derive(Args)]
+pub struct CheckArgs {
+    /// The remote name or group (`@group`).
+    pub remote: Option<String>,
+
+    /// The repo query, format is `owner[/[name]]`.
+    pub query: Option<String>,
+
+    /// Fix the drifts that can be fixed, the missing language cannot be fixed.
+    #[clap(long, short)]
+    pub fix: bool,
+}
+
+/// The expected state of a repo, computed from config and database, it
+/// contains no `Rc` so that can be checked concurrently.
+struct CheckTarget {
+    path: PathBuf,
+
+    url: Option<String>,
+    user: Option<String>,
+    email: Option<String>,
+    ssh_command: Option<String>,
+    default_branch: Option<String>,
+    /// The cached languages, empty means not detected or no language.
+    languages: Vec<String>,
+}
+
+#[derive(Clone, Copy, PartialEq, Eq)]
+enum DriftKind {
+    Origin,
+    User,
+    Email,
+    SshCommand,
+    DefaultBranch,
+    Language,
+}
+
+impl DriftKind {
+    fn as_str(&self) -> &'static str {
+        match self {
+            DriftKind::Origin => "origin",
+            DriftKind::User => "user",
+            DriftKind::Email => "email",
+            DriftKind::SshCommand => "ssh_command",
+            DriftKind::DefaultBranch => "default_branch",
+            DriftKind::Language => "language",
+        }
+    }
+
+    /// The git config key to set for fixing, None means the drift cannot be
+    /// fixed by git config.
+    fn config_key(&self) -> Option<&'static str> {
+        match self {
+            DriftKind::Origin => Some("remote.origin.url"),
+            DriftKind::User => Some("user.name"),
+            DriftKind::Email => Some("user.email"),
+            DriftKind::SshCommand => Some("core.sshCommand"),
+            DriftKind::DefaultBranch | DriftKind::Language => None,
+        }
+    }
+
+    fn fixable(&self) -> bool {
+        !matches!(self, DriftKind::Language)
+    }
+}
+
+struct Drift {
+    kind: DriftKind,
+    expect: String,
+    actual: String,
+}
+
+impl Run for CheckArgs {
+    fn run(&self) -> Result<()> {
+        // Only fixing the default branch requires writing database.
+        let mut db = if self.fix {
+            Database::read()?
+        } else {
+            Database::read_only()?
+        };
+        let (repos, level) = db.list_by_query(self.remote.as_deref(), self.query.as_deref())?;
+        if repos.is_empty() {
+            println!("No repo to check");
+            return Ok(());
+        }
+
+        let mut targets = Vec::with_capacity(repos.len());
+        for repo in repos.iter() {
+            let remote = config::must_get_remote(repo.remote.as_str())?;
+            // The remote without clone domain is created locally, there is no
+            // expected origin.
+            let url = match remote.clone {
+                Some(_) => Some(repo.clone_url(&remote)),
+                None => None,
+            };
+            targets.push(CheckTarget {
+                path: repo.get_path(),
+                url,
+                user: remote.user.clone(),
+                email: remote.email.clone(),
+                ssh_command: remote.ssh_command.clone(),
+                default_branch: repo.default_branch.clone(),
+                languages: repo.languages.clone().unwrap_or_default(),
+            });
+        }
+        let results = utils::batch(&targets, |target| Self::check(target));
+
+        let mut table = Table::with_capacity(1 + repos.len());
+        table.add(vec![
+            String::from("REPO"),
+            String::from("CHECK"),
+            String::from("EXPECT"),
+            String::from("ACTUAL"),
+        ]);
+        let mut drifts = Vec::new();
+        let mut errors = 0;
+        for ((repo, target), result) in repos.iter().zip(targets.iter()).zip(results) {
+            let name = repo.as_string(&level);
+            let result = match result {
+                Ok(result) => result,
+                Err(err) => {
+                    errors += 1;
+                    table.add(vec![
+                        name,
+                        String::from("error"),
+                        String::new(),
+                        format!("{err:#}"),
+                    ]);
+                    continue;
+                }
+            };
+            for drift in result {
+                table.add(vec![
+                    name.clone(),
+                    String::from(drift.kind.as_str()),
+                    drift.expect.clone(),
+                    drift.actual.clone(),
+                ]);
+                drifts.push((repo, target, drift));
+            }
+        }
+        if drifts.is_empty() && errors == 0 {
+            println!("All {} repos are healthy", repos.len());
+            return Ok(());
+        }
+        table.show();
+        if !self.fix {
+            return Ok(());
+        }
+
+        let fixable = drifts
+            .iter()
+            .filter(|(_, _, drift)| drift.kind.fixable())
+            .count();
+        if fixable == 0 {
+            println!();
+            println!("Nothing can be fixed");
+            return Ok(());
+        }
+        confirm!("Do you want to fix {} drifts", fixable);
+
+        for (repo, target, drift) in drifts {
+            if let DriftKind::DefaultBranch = drift.kind {
+                info!(
+                    "Set default branch of {} to {}",
+                    repo.long_name(),
+                    drift.expect
+                );
+                db.set_default_branch(repo, drift.expect);
+                continue;
+            }
+            if let Some(key) = drift.kind.config_key() {
+                let path = format!("{}", target.path.display());
+                let mut args = match drift.kind {
+                    DriftKind::Origin => {
+                        vec!["-C", path.as_str(), "remote", "set-url", "origin"]
+                    }
+                    _ => vec!["-C", path.as_str(), "config", key],
+                };
+                args.push(drift.expect.as_str());
+                Shell::git(&args)
+                    .with_desc(format!(
+                        "Set {key} of {} to {}",
+                        repo.long_name(),
+                        drift.expect
+                    ))
+                    .execute()?
+                    .check()?;
+            }
+        }
+
+        db.close()
+    }
+}
+
+impl CheckArgs {
+    fn check(target: &CheckTarget) -> Result<Vec<Drift>> {
+        if !target.path.exists() {
+            bail!("The repo directory does not exist");
+        }
+        let path = format!("{}", target.path.display());
+        let mut drifts = Vec::new();
+
+        let checks = [
+            (DriftKind::Origin, &target.url),
+            (DriftKind::User, &target.user),
+            (DriftKind::Email, &target.email),
+            (DriftKind::SshCommand, &target.ssh_command),
+        ];
+        for (kind, expect) in checks {
+            let expect = match expect {
+                Some(expect) => expect,
+                None => continue,
+            };
+            let key = kind.config_key().unwrap();
+            let actual = Self::get_config(&path, key);
+            if actual.as_ref() != Some(expect) {
+                drifts.push(Drift {
+                    kind,
+                    expect: expect.clone(),
+                    actual: actual.unwrap_or(String::from("<none>")),
+                });
+            }
+        }
+
+        // The default branch cached in database might be stale if it was
+        // changed on remote. The local `refs/remotes/origin/HEAD` is only set
+        // when cloning, so ask the remote for its HEAD.
+        if let Some(actual) = &target.default_branch {
+            let output = Shell::git(&[
+                "-C",
+                path.as_str(),
+                "ls-remote",
+                "--symref",
+                "origin",
+                "HEAD",
+            ])
+            .set_mute(true)
+            .execute()?
+            .checked_read()
+            .context("Get default branch from origin")?;
+            if let Some(expect) = Self::parse_symref(&output) {
+                if &expect != actual {
+                    drifts.push(Drift {
+                        kind: DriftKind::DefaultBranch,
+                        expect,
+                        actual: actual.clone(),
+                    });
+                }
+            }
+        }
+
+        // Only detect when no language is cached, the repo might have got
+        // some code since the last detection.
+        if target.languages.is_empty() && lang::detect(&target.path)?.is_empty() {
+            drifts.push(Drift {
+                kind: DriftKind::Language,
+                expect: String::from("<any>"),
+                actual: String::from("<none>"),
+            });
+        }
+
+        Ok(drifts)
+    }
+
+    /// Parse the branch from the output of `git ls-remote --symref origin
+    /// HEAD`, such as `ref: refs/heads/main\tHEAD`. The remote without HEAD
+    /// (such as an empty repo) has no symref line.
+    fn parse_symref(output: &str) -> Option<String> {
+        output.lines().find_map(|line| {
+            let (target, name) = line.strip_prefix("ref:")?.split_once('\t')?;
+            if name != "HEAD" {
+                return None;
+            }
+            let branch = target.trim().strip_prefix("refs/heads/")?;
+            Some(branch.to_string())
+        })
+    }
+
+    fn get_config(path: &str, key: &str) -> Option<String> {
+        let value = Shell::git(&["-C", path, "config", "--local", "--get", key])
+            .set_mute(true)
+            .execute()
+            .ok()?
+            .checked_read()
+            .ok()?;
+        if value.is_empty() {
+            return None;
+        }
+        Some(value)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse_symref() {
+        let output = "ref: refs/heads/main\tHEAD\n0123456789abcdef\tHEAD";
+        assert_eq!(CheckArgs::parse_symref(output), Some(String::from("main")));
+
+        let output = "ref: refs/heads/release/v1\tHEAD\n0123456789abcdef\tHEAD";
+        assert_eq!(
+            CheckArgs::parse_symref(output),
+            Some(String::from("release/v1"))
+        );
+
+        assert_eq!(CheckArgs::parse_symref(""), None);
+        assert_eq!(CheckArgs::parse_symref("0123456789abcdef\tHEAD"), None);
+    }
+}
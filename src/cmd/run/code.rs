@@ -0,0 +1,63 @@
+use std::process::{Command, Stdio};
+
+use anyhow::{bail, Context, Result};
+use clap::Args;
+
+use crate::cmd::run::home::HomeArgs;
+use crate::cmd::Run;
+use crate::config;
+use crate::repo::types::Repo;
+
+/// Open a repo with editor, the repo will be created or cloned like `home`.
+#[derive(Args)]
+pub struct CodeArgs {
+    #[clap(flatten)]
+    pub home: HomeArgs,
+
+    /// The editor command to use, default will use the owner's or global
+    /// `editor` config.
+    #[clap(long, short)]
+    pub editor: Option<String>,
+}
+
+impl Run for CodeArgs {
+    fn run(&self) -> Result<()> {
+        let (repo, dir) = self.home.enter()?;
+        let editor = self.get_editor(&repo)?;
+
+        // The editor command might contain args, such as `code --new-window`.
+        let mut fields = editor.split_whitespace();
+        let program = match fields.next() {
+            Some(program) => program,
+            None => bail!("The editor command is empty, please check your config"),
+        };
+        let path = format!("{}", dir.display());
+
+        let mut cmd = Command::new(program);
+        cmd.stdout(Stdio::inherit());
+        cmd.stderr(Stdio::inherit());
+        cmd.stdin(Stdio::inherit());
+        cmd.args(fields);
+        cmd.arg(&path);
+        cmd.current_dir(&dir);
+        cmd.output()
+            .with_context(|| format!("Use editor {editor} to open repo {path}"))?;
+
+        Ok(())
+    }
+}
+
+impl CodeArgs {
+    fn get_editor(&self, repo: &Repo) -> Result<String> {
+        if let Some(editor) = &self.editor {
+            return Ok(editor.clone());
+        }
+        let remote = config::must_get_remote(repo.remote.as_str())?;
+        if let Some(owner) = remote.owners.get(repo.owner.as_str()) {
+            if let Some(editor) = &owner.editor {
+                return Ok(editor.clone());
+            }
+        }
+        Ok(config::base().editor.clone())
+    }
+}
@@ -0,0 +1,121 @@
+use std::fs;
+
+use anyhow::{bail, Context, Result};
+use clap::Args;
+
+use crate::cmd::Run;
+use crate::config::types::CommitRule;
+use crate::repo::commit;
+use crate::repo::database::Database;
+use crate::shell::{self, Shell};
+use crate::{config, info, utils};
+
+/// Commit with the conventional commit message, such as `feat(api): add login`.
+/// The type, scope and subject will be prompted if not provided. The rule can
+/// be configured by owner's `commit`.
+#[derive(Args)]
+pub struct CommitArgs {
+    /// The commit type, such as `feat` and `fix`.
+    #[clap(long, short = 't')]
+    pub kind: Option<String>,
+
+    /// The commit scope.
+    #[clap(long, short)]
+    pub scope: Option<String>,
+
+    /// The commit subject.
+    #[clap(long, short)]
+    pub message: Option<String>,
+
+    /// Mark the commit as a breaking change, a `!` will be added after the
+    /// type (or scope).
+    #[clap(long, short)]
+    pub breaking: bool,
+
+    /// Stage all modified and deleted files before committing, the same as
+    /// `git commit -a`.
+    #[clap(long, short)]
+    pub all: bool,
+
+    /// Lint the message in the file instead of committing, this is used by
+    /// the commit-msg hook.
+    #[clap(long)]
+    pub lint: Option<String>,
+
+    /// Install the commit-msg hook to current repo, so that the messages
+    /// committed by git directly are also linted. An existing hook will be
+    /// overwritten.
+    #[clap(long)]
+    pub hook: bool,
+}
+
+impl Run for CommitArgs {
+    fn run(&self) -> Result<()> {
+        let rule = Self::get_rule()?;
+        if let Some(path) = &self.lint {
+            let message = fs::read_to_string(path).with_context(|| format!("Read file {path}"))?;
+            return commit::lint(&rule, &message);
+        }
+        if self.hook {
+            let dir = config::current_dir();
+            if !dir.join(".git").exists() {
+                bail!("Current directory is not a git repo root");
+            }
+            return commit::install_hook(dir, true);
+        }
+
+        let kind = match &self.kind {
+            Some(kind) => kind.clone(),
+            None => {
+                let idx = shell::search(&rule.types)?;
+                rule.types[idx].clone()
+            }
+        };
+        let scope = match &self.scope {
+            Some(scope) => scope.clone(),
+            None if !rule.scopes.is_empty() => {
+                let mut items = rule.scopes.clone();
+                if !rule.require_scope {
+                    items.insert(0, String::from("<none>"));
+                }
+                let idx = shell::search(&items)?;
+                if !rule.require_scope && idx == 0 {
+                    String::new()
+                } else {
+                    items.swap_remove(idx)
+                }
+            }
+            None => utils::input("Please input scope", rule.require_scope, None)?,
+        };
+        let subject = match &self.message {
+            Some(message) => message.clone(),
+            None => utils::input("Please input subject", true, None)?,
+        };
+
+        let message = commit::build(&kind, &scope, self.breaking, &subject);
+        commit::lint(&rule, &message)?;
+        info!("Commit message: {message}");
+
+        let mut args = vec!["commit"];
+        if self.all {
+            args.push("-a");
+        }
+        args.push("-m");
+        args.push(message.as_str());
+        Shell::git(&args).execute()?.check()
+    }
+}
+
+impl CommitArgs {
+    /// Get the commit rule of current repo's owner, use the default rule if
+    /// not in a repo.
+    pub fn get_rule() -> Result<CommitRule> {
+        let db = Database::read_only()?;
+        let repo = match db.current() {
+            Some(repo) => repo,
+            None => return Ok(CommitRule::default()),
+        };
+        let remote = config::must_get_remote(repo.remote.as_str())?;
+        Ok(remote.commit_rule(&repo.owner))
+    }
+}
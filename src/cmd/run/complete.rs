@@ -1,7 +1,7 @@
 use std::collections::HashMap;
 
 use anyhow::{Error, Result};
-use clap::Args;
+use clap::{Args, ValueEnum};
 
 use crate::cmd::complete::attach;
 use crate::cmd::complete::branch;
@@ -9,8 +9,11 @@ use crate::cmd::complete::home;
 use crate::cmd::complete::release;
 use crate::cmd::complete::remote;
 use crate::cmd::complete::tag;
-use crate::cmd::complete::Complete;
+use crate::cmd::complete::{self, Complete};
+use crate::cmd::run::commit::CommitArgs;
+use crate::cmd::run::config::Editor;
 use crate::cmd::Run;
+use crate::config;
 
 /// Complete support command, please donot use directly.
 #[derive(Args)]
@@ -37,6 +40,130 @@ fn no_complete(_args: &[&str]) -> Result<Complete> {
     Ok(Complete::empty())
 }
 
+fn editor_complete(_args: &[&str]) -> Result<Complete> {
+    let items: Vec<_> = Editor::value_variants()
+        .iter()
+        .map(|editor| editor.to_string())
+        .collect();
+    Ok(Complete::from(items))
+}
+
+/// Complete the editors configured globally and by owners, used by `code`.
+fn code_editor_complete(_args: &[&str]) -> Result<Complete> {
+    let mut items = vec![config::base().editor.clone()];
+    for name in config::list_remotes() {
+        let remote = config::must_get_remote(name)?;
+        for owner in remote.owners.values() {
+            if let Some(editor) = &owner.editor {
+                items.push(editor.clone());
+            }
+        }
+    }
+    items.sort();
+    items.dedup();
+    Ok(Complete::from(items))
+}
+
+fn config_complete(args: &[&str]) -> Result<Complete> {
+    if args.len() <= 1 {
+        let mut items = vec![
+            String::from("path"),
+            String::from("new-remote"),
+            String::from("schema"),
+            String::from("repo"),
+            String::from("cache"),
+        ];
+        items.extend(
+            config::list_remotes()
+                .into_iter()
+                .map(|remote| remote.to_string()),
+        );
+        return Ok(Complete::from(items));
+    }
+    match args[0] {
+        "repo" => remote::complete(&args[1..]),
+        "cache" if args.len() == 2 => Ok(Complete::from(vec![
+            String::from("stats"),
+            String::from("clear"),
+        ])),
+        "cache" => home::complete(&args[2..]),
+        _ => Ok(Complete::empty()),
+    }
+}
+
+fn url_complete(_args: &[&str]) -> Result<Complete> {
+    let items = vec![
+        String::from("clone"),
+        String::from("ssh"),
+        String::from("https"),
+        String::from("web"),
+    ];
+    Ok(Complete::from(items))
+}
+
+fn merge_method_complete(_args: &[&str]) -> Result<Complete> {
+    let items = vec![
+        String::from("merge"),
+        String::from("squash"),
+        String::from("rebase"),
+    ];
+    Ok(Complete::from(items))
+}
+
+fn commit_kind_complete(_args: &[&str]) -> Result<Complete> {
+    let rule = CommitArgs::get_rule()?;
+    Ok(Complete::from(rule.types))
+}
+
+fn commit_scope_complete(_args: &[&str]) -> Result<Complete> {
+    let rule = CommitArgs::get_rule()?;
+    Ok(Complete::from(rule.scopes))
+}
+
+fn patch_complete(args: &[&str]) -> Result<Complete> {
+    // The last arg is the word being completed.
+    if args.len() <= 1 {
+        let items = vec![String::from("apply"), String::from("export")];
+        return Ok(Complete::from(items));
+    }
+    match args[0] {
+        "export" => branch::complete(&args[1..]),
+        "apply" => home::complete(&args[1..]),
+        _ => Ok(Complete::empty()),
+    }
+}
+
+fn auth_complete(args: &[&str]) -> Result<Complete> {
+    if args.len() <= 1 {
+        let items = vec![
+            String::from("login"),
+            String::from("logout"),
+            String::from("status"),
+        ];
+        return Ok(Complete::from(items));
+    }
+    remote::complete(&args[1..])
+}
+
+fn create_complete(args: &[&str]) -> Result<Complete> {
+    if args.len() <= 1 {
+        let items = vec![String::from("commit"), String::from("snippet")];
+        return Ok(Complete::from(items));
+    }
+    match args[0] {
+        "snippet" => remote::complete(&args[1..]),
+        _ => Ok(Complete::empty()),
+    }
+}
+
+fn grep_complete(args: &[&str]) -> Result<Complete> {
+    // The first arg is the pattern, used by `grep` and `which-repo`.
+    if args.len() <= 1 {
+        return Ok(Complete::empty());
+    }
+    remote::complete(&args[1..])
+}
+
 impl CompleteArgs {
     fn get_cmds() -> HashMap<&'static str, fn(&[&str]) -> Result<Complete>> {
         get_cmds! {
@@ -46,16 +173,89 @@ impl CompleteArgs {
             "merge" => branch::complete,
             "remove" => home::complete,
             "detach" => no_complete,
-            "config" => remote::complete,
+            "config" => config_complete,
             "get" => home::complete,
             "rebase" => branch::complete,
             "squash" => branch::complete,
             "tag" => tag::complete,
-            "open" => no_complete,
+            "open" => home::complete,
             "release" => release::complete,
+            "remote" => remote::complete,
+            "version" => no_complete,
+            "move" => home::complete,
+            "mv" => home::complete,
+            "sub" => no_complete,
+            "pin" => no_complete,
+            "tmux" => home::complete,
+            "code" => home::complete,
+            "env" => no_complete,
+            "patch" => patch_complete,
+            "maintain" => home::complete,
+            "dirty" => remote::complete,
+            "sync" => remote::complete,
+            "check" => home::complete,
+            "auth" => auth_complete,
+            "resume" => no_complete,
+            "commit" => no_complete,
+            "create" => create_complete,
+            "prompt" => no_complete,
+            "exec" => remote::complete,
+            "grep" => grep_complete,
+            "which-repo" => grep_complete,
+            "suggest-prune" => remote::complete,
+        }
+    }
+
+    fn get_flags() -> HashMap<&'static str, fn(&[&str]) -> Result<Complete>> {
+        get_cmds! {
+            "branch --base" => branch::complete,
+            "branch -b" => branch::complete,
+            "release --bump" => release::complete_bump,
+            "release -b" => release::complete_bump,
+            "config --editor" => editor_complete,
+            "config -e" => editor_complete,
+            "code --editor" => code_editor_complete,
+            "code -e" => code_editor_complete,
+            "get --url" => url_complete,
+            "get -u" => url_complete,
+            "get --branches" => branch::complete,
+            "get -b" => branch::complete,
+            "get --tags" => tag::complete,
+            "get -t" => tag::complete,
+            "merge --method" => merge_method_complete,
+            "create commit --kind" => commit_kind_complete,
+            "create commit -t" => commit_kind_complete,
+            "create commit --scope" => commit_scope_complete,
+            "create commit -s" => commit_scope_complete,
         }
     }
 
+    /// The commands whose completion only depends on database and remote API,
+    /// their results can be cached.
+    const CACHED_CMDS: [&'static str; 9] = [
+        "home", "remove", "get", "open", "move", "mv", "attach", "tmux", "code",
+    ];
+
+    /// The completion result doesn't depend on the word being typed, except
+    /// its owner part, so the cache key only keeps the last arg until `/`.
+    /// The `@` prefix is kept, since it completes the groups and languages
+    /// instead of the remotes.
+    fn cache_key(cmd: &str, args: &[&str]) -> String {
+        let mut key = vec![cmd.to_string()];
+        for (idx, arg) in args.iter().enumerate() {
+            if idx == args.len() - 1 {
+                match arg.rfind("/") {
+                    Some(pos) => key.push(arg[..=pos].to_string()),
+                    None if arg.starts_with("@") => key.push(String::from("@")),
+                    None => key.push(String::new()),
+                }
+                continue;
+            }
+            key.push(arg.to_string());
+        }
+        key.join(" ")
+    }
+
     fn handle_err(_err: Error) {
         // TODO: implement this, write error log to a file.
     }
@@ -74,6 +274,27 @@ impl Run for CompleteArgs {
             return Ok(());
         }
 
+        // If the previous arg is a flag that requires value, complete the flag
+        // value instead of the command args.
+        if self.args.len() > 2 {
+            let flag = &self.args[self.args.len() - 2];
+            if flag.starts_with("-") {
+                // The flags of nested subcommands, such as `create commit`,
+                // are keyed with the subcommand.
+                let flags = Self::get_flags();
+                let nested = format!("{} {} {}", self.args[0], self.args[1], flag);
+                let key = format!("{} {}", self.args[0], flag);
+                let complete = flags.get(nested.as_str()).or(flags.get(key.as_str()));
+                if let Some(complete) = complete {
+                    match complete(&[]) {
+                        Ok(cmp) => cmp.show(),
+                        Err(err) => Self::handle_err(err),
+                    }
+                    return Ok(());
+                }
+            }
+        }
+
         if let Some(complete) = cmds.get(self.args[0].as_str()) {
             let args: Vec<&str> = self
                 .args
@@ -82,7 +303,11 @@ impl Run for CompleteArgs {
                 .map(|arg| arg.as_str())
                 .collect();
             let args = &args[1..];
-            let result = complete(args);
+            let result = if Self::CACHED_CMDS.contains(&self.args[0].as_str()) {
+                complete::cached(Self::cache_key(&self.args[0], args), || complete(args))
+            } else {
+                complete(args)
+            };
             match result {
                 Ok(cmp) => cmp.show(),
                 Err(err) => Self::handle_err(err),
@@ -92,3 +317,27 @@ impl Run for CompleteArgs {
         Ok(())
     }
 }
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_cache_key() {
+        let cases: [(&[&str], &str); 6] = [
+            (&[""], "home "),
+            (&["gi"], "home "),
+            (&["@"], "home @"),
+            (&["@pla"], "home @"),
+            (&["github", "fioncat/ro"], "home github fioncat/"),
+            (&["gitlab", "group/sub/"], "home gitlab group/sub/"),
+        ];
+        for (args, expect) in cases {
+            assert_eq!(
+                CompleteArgs::cache_key("home", args),
+                expect,
+                "args {args:?}"
+            );
+        }
+    }
+}
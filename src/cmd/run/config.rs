@@ -4,12 +4,18 @@ use std::process::{Command, Stdio};
 use std::{env, fs};
 
 use anyhow::{bail, Context, Result};
-use clap::{Args, ValueEnum};
+use clap::{Args, Subcommand, ValueEnum};
 
+use crate::api::types::RepoUpdate;
+use crate::cmd::run::cache::CacheArgs;
 use crate::cmd::Run;
+use crate::config::schema;
 use crate::config::types::Config;
+use crate::repo::database::Database;
+use crate::repo::types::NameLevel;
+use crate::{api, config, confirm, info, utils};
 
-/// Edit roxide config file in terminal.
+/// Edit roxide config file in terminal, or print the paths used by roxide.
 #[derive(Args)]
 pub struct ConfigArgs {
     /// If provided, edit remote config file.
@@ -18,6 +24,103 @@ pub struct ConfigArgs {
     /// The editor to use, default will use `EDITOR` env.
     #[clap(long, short)]
     pub editor: Option<Editor>,
+
+    /// Age the access count of all repos now instead of editing config. This
+    /// is also done automatically when the total access count exceeds
+    /// `max_accessed`.
+    #[clap(long, short)]
+    pub age: bool,
+
+    #[command(subcommand)]
+    pub command: Option<ConfigCommands>,
+}
+
+#[derive(Subcommand)]
+pub enum ConfigCommands {
+    /// Print the resolved paths used by roxide, for scripting.
+    Path(ConfigPathArgs),
+    /// Create a remote config file from the commented template.
+    NewRemote(ConfigNewRemoteArgs),
+    /// Print the JSON Schema of config files, so that editors can validate
+    /// them.
+    Schema(ConfigSchemaArgs),
+    /// Update the metadata of repos on remote, such as description and
+    /// topics.
+    Repo(ConfigRepoArgs),
+    /// Show or clear the remote API cache.
+    Cache(CacheArgs),
+}
+
+#[derive(Args)]
+pub struct ConfigRepoArgs {
+    /// The remote name or group (`@group`), default is current repo.
+    pub remote: Option<String>,
+
+    /// The repo query, format is `owner[/[name]]`.
+    pub query: Option<String>,
+
+    /// Set the description of repos.
+    #[clap(long, short)]
+    pub description: Option<String>,
+
+    /// Replace the topics of repos, split by comma, such as
+    /// `--topics cli,productivity`. Use an empty value to clear them.
+    #[clap(long, short, value_delimiter = ',', num_args = 0..)]
+    pub topics: Option<Vec<String>>,
+}
+
+#[derive(Args)]
+pub struct ConfigNewRemoteArgs {
+    /// The remote name, the config file will be `{name}.yml`.
+    pub name: String,
+
+    /// The remote type.
+    #[clap(long = "type", short, default_value = "github")]
+    pub kind: RemoteKind,
+
+    /// Overwrite the existing config file.
+    #[clap(long, short)]
+    pub force: bool,
+}
+
+#[derive(Copy, Clone, PartialEq, Eq, PartialOrd, Ord, ValueEnum)]
+pub enum RemoteKind {
+    Github,
+    Gitlab,
+    /// The local remote, repos are created by mkdir.
+    Local,
+}
+
+#[derive(Args)]
+pub struct ConfigSchemaArgs {
+    /// Print the schema of remote config, default is base config.
+    #[clap(long, short)]
+    pub remote: bool,
+
+    /// Print the schema in JSON format, default is YAML.
+    #[clap(long)]
+    pub json: bool,
+}
+
+#[derive(Args)]
+pub struct ConfigPathArgs {
+    /// Only print this path. Default will print all paths in `name=path`
+    /// format.
+    pub name: Option<PathName>,
+}
+
+#[derive(Copy, Clone, PartialEq, Eq, PartialOrd, Ord, ValueEnum)]
+pub enum PathName {
+    /// The config directory.
+    Config,
+    /// The metadir, storing database and cache.
+    Data,
+    /// The statedir, storing metrics, jobs and logs.
+    State,
+    /// The workspace, where repos are stored.
+    Workspace,
+    /// The database file.
+    Database,
 }
 
 #[derive(Copy, Clone, PartialEq, Eq, PartialOrd, Ord, ValueEnum)]
@@ -39,6 +142,21 @@ impl ToString for Editor {
 
 impl Run for ConfigArgs {
     fn run(&self) -> Result<()> {
+        match &self.command {
+            Some(ConfigCommands::Path(args)) => return args.run(),
+            Some(ConfigCommands::NewRemote(args)) => return args.run(),
+            Some(ConfigCommands::Schema(args)) => return args.run(),
+            Some(ConfigCommands::Repo(args)) => return args.run(),
+            Some(ConfigCommands::Cache(args)) => return args.run(),
+            None => {}
+        }
+        if self.age {
+            let mut db = Database::read()?;
+            db.age(Database::AGE_RATIO);
+            info!("Aged the access count of all repos");
+            return db.close();
+        }
+
         let editor = self.get_editor()?;
         let path = self.get_path()?;
         let path = format!("{}", path.display());
@@ -98,3 +216,146 @@ impl ConfigArgs {
         }
     }
 }
+
+impl Run for ConfigPathArgs {
+    fn run(&self) -> Result<()> {
+        if let Some(name) = self.name {
+            println!("{}", Self::get_path(name)?.display());
+            return Ok(());
+        }
+        for name in PathName::value_variants() {
+            let value = name.to_possible_value().unwrap();
+            println!("{}={}", value.get_name(), Self::get_path(*name)?.display());
+        }
+        Ok(())
+    }
+}
+
+impl ConfigPathArgs {
+    fn get_path(name: PathName) -> Result<PathBuf> {
+        Ok(match name {
+            PathName::Config => Config::dir()?,
+            PathName::Data => PathBuf::from(&config::base().metadir),
+            PathName::State => PathBuf::from(&config::base().statedir),
+            PathName::Workspace => PathBuf::from(&config::base().workspace),
+            PathName::Database => Database::default_path(),
+        })
+    }
+}
+
+impl Run for ConfigNewRemoteArgs {
+    fn run(&self) -> Result<()> {
+        if self.name == "config" {
+            bail!("The remote name cannot be \"config\"");
+        }
+        let dir = Config::dir()?;
+        if !self.force {
+            for ext in ["yml", "yaml"] {
+                let path = dir.join(format!("{}.{ext}", self.name));
+                if path.exists() {
+                    bail!(
+                        "The config file {} already exists, use `-f` to overwrite it",
+                        path.display()
+                    );
+                }
+            }
+        }
+
+        let (clone, provider, api_domain) = match self.kind {
+            RemoteKind::Github => (
+                "clone: \"github.com\"",
+                "provider: \"github\"",
+                "# api_domain: \"\"",
+            ),
+            RemoteKind::Gitlab => (
+                "clone: \"gitlab.com\"",
+                "provider: \"gitlab\"",
+                "# api_domain: \"gitlab.com\"",
+            ),
+            RemoteKind::Local => ("# clone: \"\"", "# provider: \"\"", "# api_domain: \"\""),
+        };
+        let template = include_str!("../../../config/remote_template.yml");
+        let content = template
+            .replace("{clone}", clone)
+            .replace("{provider}", provider)
+            .replace("{api_domain}", api_domain);
+
+        let path = dir.join(format!("{}.yml", self.name));
+        utils::write_file(&path, content.as_bytes())?;
+        info!("Write {}", path.display());
+        Ok(())
+    }
+}
+
+impl Run for ConfigSchemaArgs {
+    fn run(&self) -> Result<()> {
+        let schema = if self.remote {
+            schema::remote()
+        } else {
+            schema::base()
+        };
+        if self.json {
+            let json = serde_json::to_string_pretty(&schema).context("Encode schema json")?;
+            println!("{json}");
+        } else {
+            let yaml = serde_yaml::to_string(&schema).context("Encode schema yaml")?;
+            print!("{yaml}");
+        }
+        Ok(())
+    }
+}
+
+impl Run for ConfigRepoArgs {
+    fn run(&self) -> Result<()> {
+        if self.description.is_none() && self.topics.is_none() {
+            bail!("Nothing to update, please provide `--description` or `--topics`");
+        }
+        let topics = self.topics.as_ref().map(|topics| {
+            topics
+                .iter()
+                .map(|topic| topic.trim().to_string())
+                .filter(|topic| !topic.is_empty())
+                .collect::<Vec<_>>()
+        });
+
+        let db = Database::read_only()?;
+        let (repos, level) = match &self.remote {
+            Some(_) => db.list_by_query(self.remote.as_deref(), self.query.as_deref())?,
+            None => (vec![db.must_current()?], NameLevel::Full),
+        };
+        if repos.is_empty() {
+            println!("No repo to update");
+            return Ok(());
+        }
+        if repos.len() > 1 {
+            confirm!("Do you want to update {} repos", repos.len());
+        }
+
+        let mut failed = Vec::new();
+        for repo in repos.iter() {
+            let name = repo.as_string(&level);
+            let update = RepoUpdate {
+                description: self.description.clone(),
+                topics: topics.clone(),
+            };
+            let result = config::must_get_remote(repo.remote.as_str()).and_then(|remote| {
+                remote.check_provider()?;
+                let provider = api::init_owner_provider(&remote, &repo.owner, false)?;
+                provider.update_repo(&repo.owner, &repo.name, update)
+            });
+            match result {
+                Ok(()) => info!("Update {name} done"),
+                Err(err) => failed.push((name, err)),
+            }
+        }
+
+        if !failed.is_empty() {
+            println!();
+            for (name, err) in failed.iter() {
+                println!("Update {name} failed: {err:#}");
+            }
+            bail!("{} repos failed to update", failed.len());
+        }
+        Ok(())
+    }
+}
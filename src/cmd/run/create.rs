@@ -0,0 +1,42 @@
+use anyhow::Result;
+use clap::{Args, Subcommand};
+
+use crate::cmd::run::commit::CommitArgs;
+use crate::cmd::run::snippet::SnippetArgs;
+use crate::cmd::Run;
+
+/// Create something, such as a conventional commit or a snippet.
+#[derive(Args)]
+pub struct CreateArgs {
+    #[command(subcommand)]
+    pub command: CreateCommands,
+}
+
+#[derive(Subcommand)]
+pub enum CreateCommands {
+    /// Commit with the conventional commit message, such as
+    /// `feat(api): add login`.
+    Commit(CommitArgs),
+    /// Create a snippet of remote (gist for Github) from a file or stdin,
+    /// and print its url.
+    Snippet(SnippetArgs),
+}
+
+impl Run for CreateArgs {
+    fn run(&self) -> Result<()> {
+        match &self.command {
+            CreateCommands::Commit(args) => args.run(),
+            CreateCommands::Snippet(args) => args.run(),
+        }
+    }
+}
+
+impl CreateArgs {
+    /// The name of the command recorded in metrics, such as `create commit`.
+    pub fn name(&self) -> &'static str {
+        match &self.command {
+            CreateCommands::Commit(_) => "create commit",
+            CreateCommands::Snippet(_) => "create snippet",
+        }
+    }
+}
@@ -1,9 +1,12 @@
-use anyhow::Result;
+use std::rc::Rc;
+
+use anyhow::{bail, Result};
 use clap::Args;
 
 use crate::cmd::Run;
 use crate::confirm;
 use crate::repo::database::Database;
+use crate::repo::event::{self, Event};
 
 /// Detach current path in database, donot remove directory
 #[derive(Args)]
@@ -13,14 +16,25 @@ impl Run for DetachArgs {
     fn run(&self) -> Result<()> {
         let mut db = Database::read()?;
         let repo = db.must_current()?;
+        if repo.pinned {
+            bail!(
+                "The repo {} is pinned, please unpin it first",
+                repo.long_name()
+            );
+        }
 
         confirm!(
             "Do you want to detach current path from {}",
             repo.long_name()
         );
 
-        db.remove(repo);
+        db.remove(Rc::clone(&repo));
+        db.close()?;
 
-        db.close()
+        event::emit(Event::RepoDetached {
+            repo: &repo.full_name(),
+            path: &format!("{}", repo.get_path().display()),
+        });
+        Ok(())
     }
 }
@@ -0,0 +1,229 @@
+use std::env;
+use std::path::PathBuf;
+use std::process::{Command, Stdio};
+
+use anyhow::{bail, Context, Result};
+use clap::{Args, ValueEnum};
+
+use crate::cmd::Run;
+use crate::repo::database::Database;
+use crate::utils::{self, Table};
+use crate::{info, shell};
+
+/// List repos with uncommitted changes, unpushed commits or branches diverged
+/// from upstream (conflicts).
+#[derive(Args)]
+pub struct DirtyArgs {
+    /// The remote name or group (`@group`), default will scan all repos.
+    pub remote: Option<String>,
+
+    /// Exit with error if any repo has the problems, such as
+    /// `--fail-on conflict,dirty`. This is useful for CI jobs keeping mirrors
+    /// in sync.
+    #[clap(long, value_enum, value_delimiter = ',')]
+    pub fail_on: Vec<DirtyKind>,
+
+    /// After listing, go through the problem repos one by one, and open a
+    /// subshell in the repo to fix it. The repo is scanned again after the
+    /// subshell exits.
+    #[clap(long, short, conflicts_with = "fail_on")]
+    pub resolve: bool,
+
+    /// With `--resolve`, enter the selected problem repo instead of opening a
+    /// subshell. The path is printed for the shell wrapper, run again to
+    /// enter the next one.
+    #[clap(long, requires = "resolve")]
+    pub cd: bool,
+}
+
+#[derive(Copy, Clone, PartialEq, Eq, PartialOrd, Ord, ValueEnum)]
+pub enum DirtyKind {
+    /// The repo has uncommitted changes.
+    Dirty,
+    /// The repo has unpushed commits.
+    Unpushed,
+    /// The repo has branches both ahead and behind upstream.
+    Conflict,
+}
+
+/// The number of repos with each kind of problem.
+#[derive(Default)]
+pub struct DirtyCounts {
+    pub dirty: usize,
+    pub unpushed: usize,
+    pub conflict: usize,
+}
+
+impl DirtyCounts {
+    /// Count a repo by its number of changes, unpushed commits and conflict
+    /// branches.
+    pub fn add(&mut self, changes: usize, unpushed: usize, conflicts: usize) {
+        if changes > 0 {
+            self.dirty += 1;
+        }
+        if unpushed > 0 {
+            self.unpushed += 1;
+        }
+        if conflicts > 0 {
+            self.conflict += 1;
+        }
+    }
+
+    pub fn is_empty(&self) -> bool {
+        self.dirty + self.unpushed + self.conflict == 0
+    }
+
+    pub fn show(&self) {
+        println!(
+            "Summary: {} dirty, {} unpushed, {} conflict",
+            self.dirty, self.unpushed, self.conflict
+        );
+    }
+
+    /// Return error with the counts if any repo has the problems of `kinds`,
+    /// used by `--fail-on`.
+    pub fn check(&self, kinds: &[DirtyKind]) -> Result<()> {
+        let mut kinds = kinds.to_vec();
+        kinds.sort();
+        kinds.dedup();
+        let mut failed = Vec::new();
+        for kind in kinds {
+            let (count, name) = match kind {
+                DirtyKind::Dirty => (self.dirty, "dirty"),
+                DirtyKind::Unpushed => (self.unpushed, "unpushed"),
+                DirtyKind::Conflict => (self.conflict, "conflict"),
+            };
+            if count > 0 {
+                failed.push(format!("{count} {name}"));
+            }
+        }
+        if !failed.is_empty() {
+            bail!("Found problem repos: {}", failed.join(", "));
+        }
+        Ok(())
+    }
+}
+
+impl Run for DirtyArgs {
+    fn run(&self) -> Result<()> {
+        if self.resolve && utils::is_non_interactive() {
+            bail!("The `--resolve` requires interactive mode");
+        }
+        let db = Database::read_only()?;
+        let (mut repos, level) = db.list_by_query(self.remote.as_deref(), None)?;
+        repos.sort_unstable_by(|a, b| b.last_accessed.cmp(&a.last_accessed));
+
+        let dirs: Vec<PathBuf> = repos.iter().map(|repo| repo.get_path()).collect();
+        let results = utils::batch(&dirs, |dir| {
+            let info = shell::scan_dirty(dir)?;
+            let conflicts = shell::scan_diverged(dir)
+                .map(|branches| branches.len())
+                .unwrap_or_default();
+            Some((info, conflicts))
+        });
+
+        let mut table = Table::with_capacity(1 + repos.len());
+        table.add(vec![
+            String::from("REPO"),
+            String::from("CHANGES"),
+            String::from("UNPUSHED"),
+            String::from("CONFLICTS"),
+            String::from("LAST_ACCESS"),
+        ]);
+        let mut counts = DirtyCounts::default();
+        let mut problems = Vec::new();
+        for (repo, result) in repos.iter().zip(results) {
+            let (info, conflicts) = match result {
+                Some(result) => result,
+                None => continue,
+            };
+            if info.is_clean() && conflicts == 0 {
+                continue;
+            }
+            counts.add(info.changes.len(), info.unpushed, conflicts);
+            problems.push((repo.as_string(&level), repo.get_path()));
+            table.add(vec![
+                repo.as_string(&level),
+                format!("{}", info.changes.len()),
+                format!("{}", info.unpushed),
+                format!("{conflicts}"),
+                utils::format_since(repo.last_accessed),
+            ]);
+        }
+        if counts.is_empty() {
+            println!("All repos are clean");
+            return Ok(());
+        }
+        table.show();
+        println!();
+        counts.show();
+        if self.resolve {
+            return resolve(&problems, self.cd);
+        }
+        counts.check(&self.fail_on)
+    }
+}
+
+/// Go through the problem repos (the name and dir) one by one to fix them,
+/// used by `dirty --resolve` and `sync --resolve`. Open a subshell in the repo
+/// and scan it again after the subshell exits. Or if `cd`, print the path of
+/// the selected repo for the shell wrapper to enter it, and stop.
+pub fn resolve(repos: &[(String, PathBuf)], cd: bool) -> Result<()> {
+    let items = if cd {
+        vec!["<cd>", "<skip>", "<stop>"]
+    } else {
+        vec!["<open shell>", "<skip>", "<stop>"]
+    };
+    for (idx, (name, dir)) in repos.iter().enumerate() {
+        utils::write_stderr(String::new());
+        info!("[{}/{}] Resolve {}", idx + 1, repos.len(), name);
+        loop {
+            match shell::search(&items)? {
+                0 => {}
+                1 => break,
+                _ => return Ok(()),
+            }
+            if cd {
+                println!("{}", dir.display());
+                return Ok(());
+            }
+            open_shell(dir)?;
+
+            let info = shell::scan_dirty(dir);
+            let conflicts = shell::scan_diverged(dir)
+                .map(|branches| branches.len())
+                .unwrap_or_default();
+            match info {
+                Some(info) if info.is_clean() && conflicts == 0 => {
+                    info!("The repo is clean now");
+                    break;
+                }
+                Some(info) => info!(
+                    "The repo still has {} changes, {} unpushed, {conflicts} conflicts",
+                    info.changes.len(),
+                    info.unpushed
+                ),
+                None => {
+                    utils::show_warn("Could not scan the repo");
+                    break;
+                }
+            }
+        }
+    }
+    Ok(())
+}
+
+/// Open the user's shell in the repo, the exit code is ignored, since the
+/// user might exit with the last command's failure.
+fn open_shell(dir: &PathBuf) -> Result<()> {
+    let shell = env::var("SHELL").unwrap_or(String::from("sh"));
+    info!("Open {shell} in {}, exit to continue", dir.display());
+    Command::new(&shell)
+        .current_dir(dir)
+        .stdin(Stdio::inherit())
+        .stdout(Stdio::inherit())
+        .stderr(Stdio::inherit())
+        .status()
+        .with_context(|| format!("Run shell {shell}"))?;
+    Ok(())
+}
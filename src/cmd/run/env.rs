@@ -0,0 +1,35 @@
+use anyhow::{bail, Result};
+use clap::Args;
+
+use crate::cmd::Run;
+use crate::info;
+use crate::repo::database::Database;
+use crate::repo::envrc;
+
+/// Print the rendered envrc of current repo.
+#[derive(Args)]
+pub struct EnvArgs {
+    /// Write the rendered envrc to `.envrc` in the repo root, the existing
+    /// file will be overwritten.
+    #[clap(long, short)]
+    pub write: bool,
+}
+
+impl Run for EnvArgs {
+    fn run(&self) -> Result<()> {
+        let db = Database::read_only()?;
+        let repo = db.must_current()?;
+        if self.write {
+            if let None = envrc::render(&repo)? {
+                bail!("The owner {} does not have envrc template", repo.owner);
+            }
+            envrc::write(&repo, true)?;
+            info!("Write envrc for {}", repo.long_name());
+            return Ok(());
+        }
+        if let Some(content) = envrc::render(&repo)? {
+            print!("{content}");
+        }
+        Ok(())
+    }
+}
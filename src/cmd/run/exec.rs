@@ -0,0 +1,110 @@
+use std::path::PathBuf;
+
+use anyhow::{bail, Result};
+use clap::Args;
+use console::style;
+
+use crate::cmd::Run;
+use crate::confirm;
+use crate::repo::database::Database;
+use crate::shell::Shell;
+use crate::utils;
+
+/// Run a shell command in repos in parallel, such as
+/// `roxide exec @platform -- make test`.
+#[derive(Args)]
+pub struct ExecArgs {
+    /// The remote name or group (`@group`), default will run in all repos.
+    pub remote: Option<String>,
+
+    /// The repo query, format is `owner[/[name]]`.
+    pub query: Option<String>,
+
+    /// Only show the output of failed repos.
+    #[clap(long, short)]
+    pub failed: bool,
+
+    /// The command to run, it is run by `sh -c` in the repo directory.
+    #[clap(last = true, required = true)]
+    pub command: Vec<String>,
+}
+
+struct ExecResult {
+    success: bool,
+    output: String,
+}
+
+impl Run for ExecArgs {
+    fn run(&self) -> Result<()> {
+        let db = Database::read_only()?;
+        let (repos, level) = db.list_by_query(self.remote.as_deref(), self.query.as_deref())?;
+        if repos.is_empty() {
+            println!("No repo to run");
+            return Ok(());
+        }
+
+        let script = self.command.join(" ");
+        confirm!("Do you want to run `{script}` in {} repos", repos.len());
+
+        let dirs: Vec<PathBuf> = repos.iter().map(|repo| repo.get_path()).collect();
+        let results = utils::batch(&dirs, |dir| Self::exec(dir, &script));
+
+        let mut failed = 0;
+        for (repo, result) in repos.iter().zip(results) {
+            let name = repo.as_string(&level);
+            let result = match result {
+                Ok(result) => result,
+                Err(err) => {
+                    failed += 1;
+                    println!("{} {name}: {err:#}", style("==>").red());
+                    continue;
+                }
+            };
+            if !result.success {
+                failed += 1;
+            } else if self.failed {
+                continue;
+            }
+            let mark = if result.success {
+                style("==>").green()
+            } else {
+                style("==>").red()
+            };
+            println!("{mark} {name}");
+            if !result.output.is_empty() {
+                println!("{}", result.output);
+            }
+        }
+
+        println!();
+        println!(
+            "Summary: {} succeeded, {failed} failed",
+            repos.len() - failed
+        );
+        if failed > 0 {
+            bail!("Command failed in {failed} repos");
+        }
+        Ok(())
+    }
+}
+
+impl ExecArgs {
+    /// The output is captured and shown after all repos are done, so that
+    /// the outputs of repos won't be mixed up.
+    fn exec(dir: &PathBuf, script: &str) -> Result<ExecResult> {
+        if !dir.exists() {
+            bail!("The repo is not cloned");
+        }
+        // Redirect stderr to stdout to keep their order. The command runs by
+        // `Shell`, so that it is killed when exceeding the `batch_timeout`.
+        let script = format!("exec 2>&1\n{script}");
+        let mut result = Shell::with_args("sh", &["-c", script.as_str()])
+            .with_path(dir)
+            .set_mute(true)
+            .execute()?;
+        Ok(ExecResult {
+            success: result.code == Some(0),
+            output: result.read()?,
+        })
+    }
+}
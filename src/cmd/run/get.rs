@@ -1,17 +1,80 @@
+use std::collections::HashMap;
+use std::path::PathBuf;
 use std::rc::Rc;
 
 use anyhow::{bail, Context, Result};
-use clap::Args;
+use chrono::{Datelike, Duration, Local, LocalResult, NaiveDate, TimeZone};
+use clap::{ArgGroup, Args, ValueEnum};
+use regex::Regex;
 use serde::Serialize;
 
+use crate::api::types::{ApiCompare, ApiNotification, ApiRepo, ApiUpstream, MergeOptions};
 use crate::cmd::Run;
-use crate::config;
+use crate::config::types::ref_glob_to_regex;
+use crate::repo::budget;
 use crate::repo::database::Database;
+use crate::repo::lang;
+use crate::repo::license::{self, LicenseInfo};
+use crate::repo::manifest;
+use crate::repo::metrics::Metric;
+use crate::repo::report::SyncReport;
 use crate::repo::types::{NameLevel, Repo};
+use crate::shell::{self, GitBranch, GitTag, GitTagInfo, Shell};
 use crate::utils::{self, Table};
+use crate::{api, config, confirm, info};
+
+/// The flags selecting what to get, at most one of them can be used. Without
+/// any, the repo info is shown (or listed).
+const MODES: &[&str] = &[
+    "owners",
+    "branches",
+    "tags",
+    "shortcuts",
+    "metrics",
+    "url",
+    "manifest",
+    "remote_info",
+    "merge",
+    "job_logs",
+    "reports",
+    "conflicts",
+    "forks",
+    "notifications",
+    "licenses",
+    "activity",
+    "snippets",
+];
+
+/// The modes supporting `--json` output.
+const JSON_MODES: &[&str] = &[
+    "tags",
+    "manifest",
+    "remote_info",
+    "merge",
+    "notifications",
+    "licenses",
+    "snippets",
+];
+
+/// The modes supporting `--open` to select one item to open in browser.
+const OPEN_MODES: &[&str] = &["notifications", "snippets"];
+
+/// The modes except the given ones, used by the modifier flags to conflict
+/// with the modes they do not apply to. The `requires` alone is not enough,
+/// clap skips it once another mode is present.
+fn modes_except(modes: &[&str]) -> Vec<&'static str> {
+    MODES
+        .iter()
+        .filter(|mode| !modes.contains(mode))
+        .copied()
+        .collect()
+}
 
 /// Get or list repo info.
 #[derive(Args)]
+#[clap(group(ArgGroup::new("mode").args(MODES)))]
+#[clap(group(ArgGroup::new("json_mode").multiple(true).args(JSON_MODES)))]
+#[clap(group(ArgGroup::new("open_mode").multiple(true).args(OPEN_MODES)))]
 pub struct GetArgs {
     /// The remote name.
     pub remote: Option<String>,
@@ -21,8 +84,253 @@ pub struct GetArgs {
 
     /// Show size in list info. If your workspace is large, this can cause
     /// command to take too long to execute.
-    #[clap(long, short)]
+    #[clap(long, short, conflicts_with_all = modes_except(&["owners"]))]
     pub size: bool,
+
+    /// Only list repos with this language, you can also use `@lang` in query,
+    /// such as `roxide get @rust`.
+    #[clap(long, short, conflicts_with = "mode")]
+    pub language: Option<String>,
+
+    /// List owners instead of repos, with the aggregated info of their repos.
+    #[clap(long, short)]
+    pub owners: bool,
+
+    /// The sort field when listing owners.
+    #[clap(
+        long,
+        value_enum,
+        requires = "owners",
+        conflicts_with_all = modes_except(&["owners"]),
+        default_value_t = OwnerSort::Score
+    )]
+    pub sort: OwnerSort,
+
+    /// Copy the repo path (or the url with `--url`) to clipboard instead of
+    /// showing its info.
+    #[clap(long, short, conflicts_with_all = modes_except(&["url"]))]
+    pub copy: bool,
+
+    /// Only list pinned repos.
+    #[clap(long, short, conflicts_with = "mode")]
+    pub pinned: bool,
+
+    /// Include the repos matched by ignore patterns.
+    #[clap(long, short)]
+    pub all: bool,
+
+    /// List the branches of current repo as a table, only show the branches
+    /// matched by the glob pattern if provided.
+    #[clap(long, short, num_args = 0..=1, default_missing_value = "")]
+    pub branches: Option<String>,
+
+    /// Used with `--branches`, find all repos that have branches matched by
+    /// the pattern, the repos can be filtered by remote and owner query.
+    #[clap(long, requires = "branches", conflicts_with_all = modes_except(&["branches"]))]
+    pub all_repos: bool,
+
+    /// List the tags of current repo as a table, only show the tags matched by
+    /// the glob pattern if provided, such as `v1.*`.
+    #[clap(long, short, num_args = 0..=1, default_missing_value = "")]
+    pub tags: Option<String>,
+
+    /// Used with `--tags`, only show the name of the latest matched tag.
+    #[clap(long, requires = "tags", conflicts_with_all = modes_except(&["tags"]))]
+    pub latest: bool,
+
+    /// Used with `--tags`, `--manifest`, `--merge`, `--remote-info`,
+    /// `--notifications`, `--licenses` or `--snippets`, output in json format.
+    #[clap(long, requires = "json_mode", conflicts_with_all = modes_except(JSON_MODES))]
+    pub json: bool,
+
+    /// Show the numeric shortcuts of repos, which can be used by
+    /// `roxide home {num}`.
+    #[clap(long)]
+    pub shortcuts: bool,
+
+    /// Show the command metrics, the `metrics` config should be enabled.
+    #[clap(long)]
+    pub metrics: bool,
+
+    /// Print the url of the repo instead of showing its info, for scripts to
+    /// use. The clone url follows the ssh/https config of the remote and owner.
+    /// If no query is provided, use the current repo.
+    #[clap(long, short, value_enum, num_args = 0..=1, default_missing_value = "clone")]
+    pub url: Option<UrlKind>,
+
+    /// Export the repos as a manifest in yaml, which can be used by
+    /// `roxide attach --from-file` to recreate the workspace on another
+    /// machine. The repos can be filtered by remote and owner query.
+    #[clap(long, short)]
+    pub manifest: bool,
+
+    /// Show the settings of the repo from remote API, such as visibility and
+    /// merge methods. If no query is provided, use the current repo.
+    #[clap(long)]
+    pub remote_info: bool,
+
+    /// Show the status of the merge request (or PR for Github) of current
+    /// branch, such as approvals, CI status and mergeability.
+    #[clap(long)]
+    pub merge: bool,
+
+    /// Show the logs of failed jobs of the current commit's workflow runs (or
+    /// pipelines for Gitlab), only the error lines (matched by remote config
+    /// `job_error_pattern`) and their context are printed.
+    #[clap(long)]
+    pub job_logs: bool,
+
+    /// Used with `--job-logs`, search the logs of all jobs with the regex
+    /// instead of extracting failures.
+    #[clap(long, requires = "job_logs", conflicts_with_all = modes_except(&["job_logs"]))]
+    pub grep: Option<String>,
+
+    /// Show the latest N branch sync reports, default is 10.
+    #[clap(long, short, num_args = 0..=1, default_missing_value = "10")]
+    pub reports: Option<usize>,
+
+    /// Used with `--reports`, group the reports by remote and owner, and show
+    /// a summary of operations per owner at the end.
+    #[clap(long, requires = "reports", conflicts_with_all = modes_except(&["reports"]))]
+    pub group: bool,
+
+    /// Find the branches diverged from their upstreams (both ahead and
+    /// behind) in repos, and resolve them one by one by rebase or merge. The
+    /// repos can be filtered by remote and owner query. The status is based on
+    /// the last fetch, use `roxide branch --sync` to refresh it.
+    #[clap(long)]
+    pub conflicts: bool,
+
+    /// List the forked repos with how many commits their default branches
+    /// are behind (and ahead of) upstream. The upstream is got from remote
+    /// API (cached), the repos can be filtered by remote and owner query.
+    #[clap(long)]
+    pub forks: bool,
+
+    /// Used with `--forks`, fast-forward the default branches of the forks
+    /// behind upstream, and push them to origin. The diverged ones are
+    /// skipped.
+    #[clap(long, requires = "forks", conflicts_with_all = modes_except(&["forks"]))]
+    pub fast_forward: bool,
+
+    /// List the unread mentions, review requests and CI failures from remote
+    /// API (notifications for Github, todos for Gitlab), only the ones of
+    /// tracked repos are shown. Use the remote arg to filter.
+    #[clap(long)]
+    pub notifications: bool,
+
+    /// Used with `--notifications` or `--snippets`, select one item to open
+    /// in browser, the selected notification is marked as read.
+    #[clap(
+        long,
+        requires = "open_mode",
+        conflicts_with = "mark_read",
+        conflicts_with_all = modes_except(OPEN_MODES)
+    )]
+    pub open: bool,
+
+    /// Used with `--notifications`, mark all the listed notifications as
+    /// read.
+    #[clap(
+        long,
+        requires = "notifications",
+        conflicts_with_all = modes_except(&["notifications"])
+    )]
+    pub mark_read: bool,
+
+    /// Show the license of repos by inspecting the license file in their
+    /// roots, the unknown and missing ones are flagged. The repos can be
+    /// filtered by remote and owner query.
+    #[clap(long)]
+    pub licenses: bool,
+
+    /// Show a heatmap of your daily commits in all local branches, and the
+    /// commit counts per repo. Only the commits whose author matches the
+    /// repo's `user.email` are counted, the repos without `user.email` are
+    /// skipped. The repos can be filtered by remote and owner query.
+    #[clap(long)]
+    pub activity: bool,
+
+    /// Used with `--activity`, the time range to count, such as `30d`, `12w`.
+    #[clap(
+        long,
+        requires = "activity",
+        conflicts_with_all = modes_except(&["activity"]),
+        default_value = "30d"
+    )]
+    pub since: String,
+
+    /// List the snippets of the token's user from remote API (gists for
+    /// Github), the remote arg is required.
+    #[clap(long)]
+    pub snippets: bool,
+}
+
+#[derive(Copy, Clone, PartialEq, Eq, PartialOrd, Ord, ValueEnum)]
+pub enum OwnerSort {
+    Name,
+    Repos,
+    Score,
+    Access,
+    Size,
+}
+
+#[derive(Copy, Clone, PartialEq, Eq, PartialOrd, Ord, ValueEnum)]
+pub enum UrlKind {
+    Clone,
+    Ssh,
+    Https,
+    Web,
+}
+
+/// A branch which is both ahead and behind its upstream.
+struct ConflictBranch {
+    dir: PathBuf,
+    repo: String,
+    branch: String,
+    upstream: String,
+    ahead: usize,
+    behind: usize,
+}
+
+/// A forked repo whose default branch is compared with upstream.
+struct ForkStatus {
+    repo: Rc<Repo>,
+    name: String,
+    branch: String,
+    upstream: ApiUpstream,
+    compare: ApiCompare,
+}
+
+/// The license of a tracked repo.
+#[derive(Debug, Serialize)]
+struct RepoLicense {
+    repo: String,
+    #[serde(flatten)]
+    license: LicenseInfo,
+}
+
+/// The commits of a repo in the activity time range.
+struct RepoActivity {
+    name: String,
+    commits: usize,
+    last_commit: u64,
+}
+
+/// A notification of tracked repo.
+#[derive(Debug, Serialize)]
+struct NotificationInfo {
+    remote: String,
+    #[serde(flatten)]
+    notification: ApiNotification,
+}
+
+struct OwnerInfo {
+    name: String,
+    repos: usize,
+    score: f64,
+    last_accessed: u64,
+    size: u64,
 }
 
 #[derive(Debug, Serialize)]
@@ -37,17 +345,31 @@ struct RepoInfo {
 
     path: String,
     workspace: bool,
+    pinned: bool,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    boost_until: Option<String>,
 
     size: String,
+
+    languages: Vec<String>,
+
+    subprojects: Vec<String>,
 }
 
 impl RepoInfo {
-    fn from_repo(repo: Rc<Repo>) -> Result<Self> {
+    /// Build the info of the repo, the languages are always detected to show
+    /// the percentages, and the cached ones in database are refreshed.
+    fn from_repo(db: &mut Database, repo: Rc<Repo>) -> Result<Self> {
         let workspace = match repo.path {
             Some(_) => false,
             None => true,
         };
         let path = repo.get_path();
+        let languages = lang::detect(&path)?;
+        if path.exists() {
+            let names = languages.iter().map(|lang| lang.name.clone()).collect();
+            db.set_languages(&repo, names);
+        }
         let path = format!("{}", path.display());
         let score = format!("{:.2}", repo.score());
         Ok(RepoInfo {
@@ -59,30 +381,121 @@ impl RepoInfo {
             score,
             path,
             workspace,
+            pinned: repo.pinned,
+            boost_until: if repo.boosted() {
+                Some(utils::format_time(repo.boost_until)?)
+            } else {
+                None
+            },
             size: utils::dir_size(repo.get_path())?,
+            languages: languages
+                .into_iter()
+                .map(|lang| format!("{} {:.1}%", lang.name, lang.percent))
+                .collect(),
+            subprojects: db
+                .list_subprojects(&repo)
+                .into_iter()
+                .map(|subproject| subproject.path.clone())
+                .collect(),
         })
     }
 }
 
 impl Run for GetArgs {
     fn run(&self) -> Result<()> {
-        let db = Database::read()?;
-        if let None = self.remote {
-            return self.list(&db, None, None);
+        if let Some(limit) = self.reports {
+            return self.list_reports(limit);
+        }
+        if self.metrics {
+            return self.list_metrics();
+        }
+        if self.job_logs {
+            return self.show_job_logs();
+        }
+        if self.merge {
+            return self.show_merge();
+        }
+        if self.snippets {
+            return self.list_snippets();
+        }
+        if let Some(pattern) = &self.tags {
+            return self.list_tags(pattern);
+        }
+        if let Some(pattern) = &self.branches {
+            if !self.all_repos {
+                return self.list_branches(pattern);
+            }
+        }
+        let mut db = Database::read()?;
+        if self.all {
+            db.show_ignored();
+        }
+        let mut args = Vec::with_capacity(2);
+        if let Some(remote) = &self.remote {
+            args.push(remote.clone());
+        }
+        if let Some(query) = &self.query {
+            args.push(query.clone());
+        }
+        let (args, language) = lang::split_query(&args);
+        let language = match &self.language {
+            Some(language) => Some(language.as_str()),
+            None => language.as_ref().map(|language| language.as_str()),
+        };
+
+        if let Some(kind) = self.url {
+            return self.show_url(&db, &args, kind);
+        }
+        if self.remote_info {
+            return self.show_remote_info(&db, &args);
+        }
+        if self.shortcuts {
+            return self.list_shortcuts(&db);
         }
-        let remote_name = self.remote.as_ref().unwrap().as_str();
-        if let None = self.query {
-            return self.list(&db, Some(remote_name), None);
+        if self.manifest {
+            return self.export_manifest(&db, &args);
+        }
+        if self.owners {
+            return self.list_owners(&db, args.first().map(|remote| remote.as_str()));
+        }
+        if let Some(pattern) = &self.branches {
+            return self.list_repos_branches(&db, &args, pattern);
+        }
+        if self.conflicts {
+            return self.list_conflicts(&db, &args);
+        }
+        if self.forks {
+            return self.list_forks(&db, &args);
+        }
+        if self.activity {
+            return self.show_activity(&db, &args);
+        }
+        if self.licenses {
+            return self.list_licenses(&db, &args);
+        }
+        if self.notifications {
+            return self.list_notifications(&db, args.first().map(|arg| arg.as_str()));
+        }
+        if args.is_empty() {
+            return self.list(db, None, None, language);
+        }
+        let remote_name = args[0].as_str();
+        if let Some(group) = config::base().parse_group(remote_name) {
+            if args.len() > 1 {
+                bail!("The query cannot be used with group {remote_name}");
+            }
+            let repos = db.list_by_group(group);
+            return self.list_repos(db, repos, NameLevel::Full, language);
+        }
+        if args.len() == 1 {
+            return self.list(db, Some(remote_name), None, language);
         }
 
         let remote = config::must_get_remote(remote_name)?;
-        let query = self.query.as_ref().unwrap().as_str();
+        let query = args[1].as_str();
         if query.ends_with("/") {
-            let mut owner = query.trim_end_matches("/");
-            if let Some(raw_owner) = remote.owner_alias.get(owner) {
-                owner = raw_owner.as_str();
-            }
-            return self.list(&db, Some(remote_name), Some(owner));
+            let owner = remote.resolve_owner(query);
+            return self.list(db, Some(remote_name), Some(owner), language);
         }
 
         let (owner, name) = utils::parse_query(&remote, query);
@@ -91,7 +504,11 @@ impl Run for GetArgs {
         }
 
         let repo = db.must_get(remote_name, owner.as_str(), name.as_str())?;
-        let info = RepoInfo::from_repo(repo)?;
+        if self.copy {
+            return utils::copy_to_clipboard(format!("{}", repo.get_path().display()));
+        }
+        let info = RepoInfo::from_repo(&mut db, repo)?;
+        db.close()?;
         let yaml = serde_yaml::to_string(&info).context("Encode info yaml")?;
         print!("{yaml}");
         Ok(())
@@ -99,7 +516,125 @@ impl Run for GetArgs {
 }
 
 impl GetArgs {
-    fn list(&self, db: &Database, remote: Option<&str>, owner: Option<&str>) -> Result<()> {
+    /// The default regex to find error lines in job logs.
+    const JOB_ERROR_PATTERN: &str = r"(?i)\b(error|failed|failure|panic|fatal)\b";
+
+    /// The number of lines to show before and after the error line.
+    const JOB_LOG_CONTEXT: usize = 3;
+
+    fn export_manifest(&self, db: &Database, args: &[String]) -> Result<()> {
+        let (mut repos, _) = Self::list_by_args(db, args)?;
+        if self.pinned {
+            repos.retain(|repo| repo.pinned);
+        }
+
+        // The branches are read from disk, do it concurrently since the repo
+        // list might be large.
+        let dirs: Vec<PathBuf> = repos.iter().map(|repo| repo.get_path()).collect();
+        let branches = utils::batch(&dirs, |dir| {
+            if !dir.exists() {
+                return None;
+            }
+            let path = format!("{}", dir.display());
+            let branch = Shell::git(&["-C", path.as_str(), "branch", "--show-current"])
+                .set_mute(true)
+                .execute()
+                .ok()?
+                .checked_read()
+                .ok()?;
+            if branch.is_empty() {
+                return None;
+            }
+            Some(branch)
+        });
+
+        let entries: Vec<manifest::Entry> = repos
+            .iter()
+            .zip(branches)
+            .map(|(repo, branch)| manifest::Entry {
+                remote: format!("{}", repo.remote),
+                owner: format!("{}", repo.owner),
+                name: format!("{}", repo.name),
+                path: repo.path.clone(),
+                pinned: repo.pinned,
+                branch,
+            })
+            .collect();
+        if self.json {
+            let json = serde_json::to_string_pretty(&entries).context("Encode manifest json")?;
+            println!("{json}");
+            return Ok(());
+        }
+        let yaml = serde_yaml::to_string(&entries).context("Encode manifest yaml")?;
+        print!("{yaml}");
+        Ok(())
+    }
+
+    fn show_url(&self, db: &Database, args: &[String], kind: UrlKind) -> Result<()> {
+        let repo = Self::select_repo(db, args)?;
+        let remote = config::must_get_remote(repo.remote.as_str())?;
+        let url = match kind {
+            UrlKind::Clone => repo.clone_url(&remote),
+            UrlKind::Ssh => repo.ssh_url(&remote),
+            UrlKind::Https => repo.https_url(&remote),
+            UrlKind::Web => repo.web_url(&remote),
+        };
+        if self.copy {
+            return utils::copy_to_clipboard(&url);
+        }
+        println!("{url}");
+        Ok(())
+    }
+
+    fn show_remote_info(&self, db: &Database, args: &[String]) -> Result<()> {
+        let repo = Self::select_repo(db, args)?;
+        let remote = config::must_get_remote(repo.remote.as_str())?;
+        remote.check_provider()?;
+        let provider = api::init_owner_provider(&remote, &repo.owner, false)?;
+        info!("Get repo settings from remote API");
+        let settings = provider.get_repo_settings(&repo.owner, &repo.name)?;
+        if self.json {
+            let json = serde_json::to_string_pretty(&settings).context("Encode settings json")?;
+            println!("{json}");
+            return Ok(());
+        }
+        let yaml = serde_yaml::to_string(&settings).context("Encode settings yaml")?;
+        print!("{yaml}");
+        Ok(())
+    }
+
+    /// Select one repo by the args, if no args, use the current repo.
+    fn select_repo(db: &Database, args: &[String]) -> Result<Rc<Repo>> {
+        Ok(match args.len() {
+            0 => db.must_current()?,
+            1 => match config::get_remote(&args[0])? {
+                Some(remote) => db.must_latest(&remote.name)?,
+                None => db.must_get_fuzzy("", &args[0])?,
+            },
+            _ => {
+                let remote = config::must_get_remote(&args[0])?;
+                let (owner, name) = utils::parse_query(&remote, &args[1]);
+                if owner.is_empty() {
+                    db.must_get_fuzzy(&remote.name, &name)?
+                } else {
+                    // The repo is not required to be tracked, its url can be
+                    // built from the remote config.
+                    match db.get(&remote.name, &owner, &name) {
+                        Some(repo) => repo,
+                        None => Repo::new(&remote.name, &owner, &name, None),
+                    }
+                }
+            }
+        })
+    }
+
+    fn list(
+        &self,
+        db: Database,
+        remote: Option<&str>,
+        owner: Option<&str>,
+        language: Option<&str>,
+    ) -> Result<()> {
         let (repos, level) = match remote {
             Some(remote) => match owner {
                 Some(owner) => (db.list_by_owner(remote, owner), NameLevel::Name),
@@ -107,6 +642,25 @@ impl GetArgs {
             },
             None => (db.list_all(), NameLevel::Full),
         };
+        let repos = db.filter_ignored(repos);
+        self.list_repos(db, repos, level, language)
+    }
+
+    fn list_repos(
+        &self,
+        mut db: Database,
+        mut repos: Vec<Rc<Repo>>,
+        level: NameLevel,
+        language: Option<&str>,
+    ) -> Result<()> {
+        if let Some(language) = language {
+            repos = lang::filter(&mut db, repos, language)?;
+            // Save the languages detected for the first time.
+            db.close()?;
+        }
+        if self.pinned {
+            repos.retain(|repo| repo.pinned);
+        }
         if repos.is_empty() {
             println!("Nothing to show");
             return Ok(());
@@ -124,15 +678,24 @@ impl GetArgs {
         }
         table.add(titles);
 
+        let mut sizes = if self.size {
+            let dirs = repos.iter().map(|repo| repo.get_path()).collect();
+            utils::dir_bytes_batch(dirs)?.into_iter()
+        } else {
+            Vec::new().into_iter()
+        };
         for repo in repos {
-            let name = repo.as_string(&level);
+            let mut name = repo.as_string(&level);
+            if repo.pinned && !self.pinned {
+                name = format!("{name} (pinned)");
+            }
             let access = format!("{}", repo.accessed as u64);
             let last_access = utils::format_since(repo.last_accessed);
             let score = format!("{:.2}", repo.score());
 
             let mut row = vec![name, access, last_access, score];
-            if self.size {
-                row.push(utils::dir_size(repo.get_path())?);
+            if let Some(size) = sizes.next() {
+                row.push(utils::human_bytes(size as f64));
             }
 
             table.add(row);
@@ -141,4 +704,1289 @@ impl GetArgs {
         table.show();
         Ok(())
     }
+
+    fn list_branches(&self, pattern: &str) -> Result<()> {
+        let re = Self::pattern_regex(pattern)?;
+        let branches: Vec<GitBranch> = GitBranch::list()?
+            .into_iter()
+            .filter(|branch| re.is_match(&branch.name))
+            .collect();
+        if branches.is_empty() {
+            println!("Nothing to show");
+            return Ok(());
+        }
+
+        let mut table = Table::with_capacity(1 + branches.len());
+        table.add(vec![
+            String::from("BRANCH"),
+            String::from("STATUS"),
+            String::from("COMMIT"),
+            String::from("UPSTREAM"),
+        ]);
+        for branch in branches {
+            let status = branch.status_desc();
+            let name = if branch.current {
+                format!("* {}", branch.name)
+            } else {
+                branch.name
+            };
+            let upstream = match branch.upstream {
+                Some(upstream) => upstream,
+                None => String::from("<none>"),
+            };
+            table.add(vec![name, status, branch.commit, upstream]);
+        }
+        table.show();
+        Ok(())
+    }
+
+    /// Find the repos having branches matched by pattern, the repos are
+    /// checked concurrently.
+    fn list_repos_branches(&self, db: &Database, args: &[String], pattern: &str) -> Result<()> {
+        if pattern.is_empty() {
+            bail!("Please provide the branch pattern to search in all repos");
+        }
+        let re = Self::pattern_regex(pattern)?;
+        let (repos, level) = Self::list_by_args(db, args)?;
+
+        let dirs: Vec<PathBuf> = repos.iter().map(|repo| repo.get_path()).collect();
+        let results = utils::batch(&dirs, |dir| -> Result<Vec<String>> {
+            let path = format!("{}", dir.display());
+            let lines = Shell::git(&["-C", &path, "branch", "--format=%(refname:short)"])
+                .set_mute(true)
+                .execute()?
+                .checked_lines()?;
+            Ok(lines.into_iter().filter(|line| re.is_match(line)).collect())
+        });
+
+        let mut table = Table::with_capacity(1 + repos.len());
+        table.add(vec![String::from("REPO"), String::from("BRANCHES")]);
+        let mut found = false;
+        for (repo, result) in repos.iter().zip(results) {
+            // The repo might not be cloned yet, skip it.
+            let branches = match result {
+                Ok(branches) => branches,
+                Err(_) => continue,
+            };
+            if branches.is_empty() {
+                continue;
+            }
+            found = true;
+            table.add(vec![repo.as_string(&level), branches.join(", ")]);
+        }
+        if !found {
+            println!("Nothing to show");
+            return Ok(());
+        }
+        table.show();
+        Ok(())
+    }
+
+    /// List the repos filtered by remote (or group) and owner in args, and the
+    /// name level to show them.
+    fn list_by_args(db: &Database, args: &[String]) -> Result<(Vec<Rc<Repo>>, NameLevel)> {
+        db.list_by_query(
+            args.get(0).map(|arg| arg.as_str()),
+            args.get(1).map(|arg| arg.as_str()),
+        )
+    }
+
+    fn list_conflicts(&self, db: &Database, args: &[String]) -> Result<()> {
+        let (repos, level) = Self::list_by_args(db, args)?;
+        let dirs: Vec<PathBuf> = repos.iter().map(|repo| repo.get_path()).collect();
+        let results = utils::batch(&dirs, |dir| shell::scan_diverged(dir));
+
+        let mut conflicts = Vec::new();
+        let mut scan_failed = Vec::new();
+        for ((repo, dir), result) in repos.iter().zip(dirs).zip(results) {
+            let branches = match result {
+                Ok(branches) => branches,
+                // The repo might not be cloned yet, skip it.
+                Err(_) if !dir.exists() => continue,
+                Err(err) => {
+                    scan_failed.push((repo.as_string(&level), err));
+                    continue;
+                }
+            };
+            for (branch, upstream, ahead, behind) in branches {
+                conflicts.push(ConflictBranch {
+                    dir: dir.clone(),
+                    repo: repo.as_string(&level),
+                    branch,
+                    upstream,
+                    ahead,
+                    behind,
+                });
+            }
+        }
+        for (name, err) in scan_failed.iter() {
+            utils::show_warn(format!("Scan {name} failed: {err:#}"));
+        }
+        if conflicts.is_empty() {
+            println!("Nothing to show");
+            return Ok(());
+        }
+
+        let mut table = Table::with_capacity(1 + conflicts.len());
+        table.add(vec![
+            String::from("REPO"),
+            String::from("BRANCH"),
+            String::from("UPSTREAM"),
+            String::from("AHEAD"),
+            String::from("BEHIND"),
+        ]);
+        for conflict in conflicts.iter() {
+            table.add(vec![
+                conflict.repo.clone(),
+                conflict.branch.clone(),
+                conflict.upstream.clone(),
+                format!("{}", conflict.ahead),
+                format!("{}", conflict.behind),
+            ]);
+        }
+        table.show();
+        if utils::is_non_interactive() {
+            return Ok(());
+        }
+
+        println!();
+        let mut failed = Vec::new();
+        for conflict in conflicts.iter() {
+            let items = vec![
+                format!(
+                    "rebase {} {} onto {}",
+                    conflict.repo, conflict.branch, conflict.upstream
+                ),
+                format!(
+                    "merge {} into {} {}",
+                    conflict.upstream, conflict.repo, conflict.branch
+                ),
+                format!("skip {} {}", conflict.repo, conflict.branch),
+            ];
+            let op = match shell::search(&items)? {
+                0 => "rebase",
+                1 => "merge",
+                _ => continue,
+            };
+            if let Err(err) = Self::resolve_conflict(conflict, op) {
+                failed.push((conflict, err));
+            }
+        }
+
+        if !failed.is_empty() {
+            println!();
+            for (conflict, err) in failed.iter() {
+                println!(
+                    "Resolve {} {} failed: {err:#}",
+                    conflict.repo, conflict.branch
+                );
+            }
+            bail!("{} branches failed to resolve", failed.len());
+        }
+        Ok(())
+    }
+
+    /// Rebase the branch onto, or merge into it, its upstream. If git fails
+    /// (such as real conflicts), the operation will be aborted and the user
+    /// should resolve it manually. The original branch is always checked out
+    /// again.
+    fn resolve_conflict(conflict: &ConflictBranch, op: &str) -> Result<()> {
+        if let Some(dirty) = shell::scan_dirty(&conflict.dir) {
+            if !dirty.changes.is_empty() {
+                bail!("The repo has uncommitted changes");
+            }
+        }
+        let path = format!("{}", conflict.dir.display());
+        let current = Shell::git(&["-C", path.as_str(), "branch", "--show-current"])
+            .set_mute(true)
+            .execute()?
+            .checked_read()?;
+        if current != conflict.branch {
+            Shell::git(&["-C", path.as_str(), "checkout", conflict.branch.as_str()])
+                .execute()?
+                .check()?;
+        }
+
+        let result = Self::run_resolve(&path, conflict, op);
+        if !current.is_empty() && current != conflict.branch {
+            let restore = Shell::git(&["-C", path.as_str(), "checkout", current.as_str()])
+                .execute()
+                .and_then(|result| result.check())
+                .with_context(|| format!("Checkout back to branch {current}"));
+            if let Err(err) = restore {
+                return match result {
+                    Ok(()) => Err(err),
+                    Err(resolve_err) => Err(err.context(format!("{resolve_err:#}"))),
+                };
+            }
+        }
+        result?;
+
+        info!("Resolve {} {} by {op} done", conflict.repo, conflict.branch);
+        Ok(())
+    }
+
+    fn run_resolve(path: &str, conflict: &ConflictBranch, op: &str) -> Result<()> {
+        let result = Shell::git(&["-C", path, op, conflict.upstream.as_str()])
+            .execute()?
+            .check();
+        if result.is_ok() {
+            return Ok(());
+        }
+        // When git fails for other reasons (such as invalid upstream), there is
+        // no operation in progress to abort.
+        let abort = Shell::git(&["-C", path, op, "--abort"])
+            .execute()
+            .and_then(|result| result.check());
+        match abort {
+            Ok(()) => bail!(
+                "The {op} has conflicts and was aborted, please resolve it manually in {}",
+                conflict.dir.display()
+            ),
+            Err(_) => bail!(
+                "The {op} failed, please check the git output in {}",
+                conflict.dir.display()
+            ),
+        }
+    }
+
+    fn list_forks(&self, db: &Database, args: &[String]) -> Result<()> {
+        let (repos, level) = Self::list_by_args(db, args)?;
+
+        // The repos of the same remote and owner share one provider, since
+        // the owner might have its own token.
+        let mut keys: Vec<(Rc<String>, Rc<String>)> = Vec::new();
+        let mut groups: HashMap<(Rc<String>, Rc<String>), Vec<Rc<Repo>>> = HashMap::new();
+        for repo in repos {
+            let key = (Rc::clone(&repo.remote), Rc::clone(&repo.owner));
+            if !groups.contains_key(&key) {
+                keys.push(key.clone());
+            }
+            groups.entry(key).or_default().push(repo);
+        }
+
+        info!("Get fork status from remote API");
+        let mut forks = Vec::new();
+        for key in keys {
+            let repos = groups.remove(&key).unwrap();
+            let (remote_name, owner) = key;
+            let remote = config::must_get_remote(remote_name.as_str())?;
+            if let None = remote.provider {
+                continue;
+            }
+            let provider = api::init_owner_provider(&remote, &owner, false)?;
+            let names: Vec<(String, String)> = repos
+                .iter()
+                .map(|repo| (owner.to_string(), repo.name.to_string()))
+                .collect();
+            let api_repos = provider.get_repos(&names)?;
+            for (repo, api_repo) in repos.into_iter().zip(api_repos) {
+                let (branch, upstream) = match api_repo {
+                    Some(ApiRepo {
+                        default_branch,
+                        upstream: Some(upstream),
+                        ..
+                    }) => (default_branch, upstream),
+                    _ => continue,
+                };
+                let name = repo.as_string(&level);
+                let compare =
+                    match provider.compare_upstream(&repo.owner, &repo.name, &branch, &upstream) {
+                        Ok(compare) => compare,
+                        Err(err) => {
+                            utils::show_warn(format!("Compare {name} with upstream: {err:#}"));
+                            continue;
+                        }
+                    };
+                forks.push(ForkStatus {
+                    repo,
+                    name,
+                    branch,
+                    upstream,
+                    compare,
+                });
+            }
+        }
+        if forks.is_empty() {
+            println!("No forked repo");
+            return Ok(());
+        }
+
+        let mut table = Table::with_capacity(1 + forks.len());
+        table.add(vec![
+            String::from("REPO"),
+            String::from("BRANCH"),
+            String::from("UPSTREAM"),
+            String::from("BEHIND"),
+            String::from("AHEAD"),
+        ]);
+        for fork in forks.iter() {
+            table.add(vec![
+                fork.name.clone(),
+                fork.branch.clone(),
+                fork.upstream.to_string(),
+                format!("{}", fork.compare.behind),
+                format!("{}", fork.compare.ahead),
+            ]);
+        }
+        table.show();
+        if !self.fast_forward {
+            return Ok(());
+        }
+
+        let mut to_update = Vec::new();
+        for fork in forks.iter() {
+            if fork.compare.behind == 0 {
+                continue;
+            }
+            if fork.compare.ahead > 0 {
+                utils::show_warn(format!(
+                    "The {} {} has diverged from upstream, skip it",
+                    fork.name, fork.branch
+                ));
+                continue;
+            }
+            to_update.push(fork);
+        }
+        if to_update.is_empty() {
+            println!();
+            println!("All forks are up to date");
+            return Ok(());
+        }
+        println!();
+        confirm!("Do you want to fast-forward {} forks", to_update.len());
+
+        let mut failed = Vec::new();
+        let mut synced = Vec::new();
+        for fork in to_update {
+            match Self::fast_forward_fork(fork) {
+                Ok(()) => synced.push(fork.repo.full_name()),
+                Err(err) => failed.push((fork, err)),
+            }
+        }
+        if !synced.is_empty() {
+            budget::check_all(&synced);
+        }
+        if !failed.is_empty() {
+            println!();
+            for (fork, err) in failed.iter() {
+                println!("Fast-forward {} failed: {err:#}", fork.name);
+            }
+            bail!("{} forks failed to fast-forward", failed.len());
+        }
+        Ok(())
+    }
+
+    /// Fetch the upstream's default branch in the local repo, and push it to
+    /// the fork's branch. Without `--force`, git rejects the push if it is not
+    /// a fast-forward, so the commits in fork won't be lost.
+    fn fast_forward_fork(fork: &ForkStatus) -> Result<()> {
+        let remote = config::must_get_remote(fork.repo.remote.as_str())?;
+        let dir = fork.repo.get_path();
+        if !dir.exists() {
+            bail!("The repo is not cloned");
+        }
+        let path = format!("{}", dir.display());
+
+        let upstream = Repo::from_upstream(&remote.name, fork.upstream.clone());
+        let url = upstream.clone_url(&remote);
+        let mut git = Shell::git(&[
+            "-C",
+            path.as_str(),
+            "fetch",
+            url.as_str(),
+            fork.upstream.default_branch.as_str(),
+        ]);
+        git.with_desc(format!("Fetch {}", upstream.full_name()))
+            .with_remote(&remote);
+        if upstream.use_https_auth(&remote) {
+            git.with_https_auth(&remote)?;
+        }
+        git.execute()?.check()?;
+
+        let refspec = format!("FETCH_HEAD:refs/heads/{}", fork.branch);
+        let mut git = Shell::git(&["-C", path.as_str(), "push", "origin", refspec.as_str()]);
+        git.with_desc(format!("Push {} to {}", fork.branch, fork.repo.full_name()))
+            .with_remote(&remote);
+        if fork.repo.use_https_auth(&remote) {
+            git.with_https_auth(&remote)?;
+        }
+        git.execute()?.check()?;
+
+        info!("Fast-forward {} {} done", fork.name, fork.branch);
+        Ok(())
+    }
+
+    fn show_activity(&self, db: &Database, args: &[String]) -> Result<()> {
+        let since = utils::parse_duration(&self.since)?;
+        let start_time = config::now_secs().saturating_sub(since);
+        let (repos, level) = Self::list_by_args(db, args)?;
+
+        let dirs: Vec<PathBuf> = repos.iter().map(|repo| repo.get_path()).collect();
+        let results = utils::batch(&dirs, |dir| Self::commit_times(dir, start_time));
+
+        let mut days: HashMap<NaiveDate, usize> = HashMap::new();
+        let mut activities = Vec::new();
+        let mut skipped = Vec::new();
+        let mut failed = 0;
+        for (repo, result) in repos.iter().zip(results) {
+            let name = repo.as_string(&level);
+            let times = match result {
+                Ok(Some(times)) if !times.is_empty() => times,
+                Ok(Some(_)) => continue,
+                Ok(None) => {
+                    skipped.push(name);
+                    continue;
+                }
+                Err(err) => {
+                    utils::show_warn(format!("Count commits for {name}: {err:#}"));
+                    failed += 1;
+                    continue;
+                }
+            };
+            for time in times.iter() {
+                *days.entry(Self::local_date(*time)?).or_default() += 1;
+            }
+            activities.push(RepoActivity {
+                name,
+                commits: times.len(),
+                last_commit: times.iter().copied().max().unwrap_or_default(),
+            });
+        }
+        if !skipped.is_empty() {
+            utils::show_warn(format!(
+                "Skip {} repos without user.email: {}",
+                skipped.len(),
+                skipped.join(", ")
+            ));
+        }
+        if activities.is_empty() {
+            println!("No commit in the last {}", self.since);
+        } else {
+            Self::show_activities(&days, start_time, activities)?;
+        }
+        if failed > 0 {
+            bail!("{failed} repos failed to count commits");
+        }
+        Ok(())
+    }
+
+    fn show_activities(
+        days: &HashMap<NaiveDate, usize>,
+        start_time: u64,
+        mut activities: Vec<RepoActivity>,
+    ) -> Result<()> {
+        let start = Self::local_date(start_time)?;
+        let end = Local::now().date_naive();
+        Self::show_heatmap(days, start, end);
+
+        activities.sort_unstable_by(|a, b| b.commits.cmp(&a.commits));
+        let mut table = Table::with_capacity(1 + activities.len());
+        table.add(vec![
+            String::from("REPO"),
+            String::from("COMMITS"),
+            String::from("LAST_COMMIT"),
+        ]);
+        let mut total = 0;
+        for activity in activities.iter() {
+            total += activity.commits;
+            table.add(vec![
+                activity.name.clone(),
+                format!("{}", activity.commits),
+                utils::format_since(activity.last_commit),
+            ]);
+        }
+        println!();
+        table.show();
+        println!();
+        println!(
+            "Summary: {total} commits in {} repos, {} active days",
+            activities.len(),
+            days.len()
+        );
+        Ok(())
+    }
+
+    /// Return the author times of the user's commits since the time. The
+    /// user is identified by the repo's `user.email`, return None if it is not
+    /// configured.
+    fn commit_times(dir: &PathBuf, since: u64) -> Result<Option<Vec<u64>>> {
+        // The repo might not be cloned yet, it has no commit.
+        if !dir.exists() {
+            return Ok(Some(Vec::new()));
+        }
+        let path = format!("{}", dir.display());
+        let email = Shell::git(&["-C", &path, "config", "user.email"])
+            .set_mute(true)
+            .execute()?
+            .read()?;
+        if email.is_empty() {
+            return Ok(None);
+        }
+        // Filter the author in git, the email is matched as a fixed string
+        // with the brackets, such as `<user@example.com>`.
+        let author = format!("--author=<{email}>");
+        let since = format!("--since=@{since}");
+        let lines = Shell::git(&[
+            "-C",
+            &path,
+            "log",
+            "--branches",
+            "--fixed-strings",
+            "--regexp-ignore-case",
+            &author,
+            &since,
+            "--format=%at",
+        ])
+        .set_mute(true)
+        .execute()?
+        .checked_lines()?;
+
+        let mut times = Vec::with_capacity(lines.len());
+        for line in lines {
+            let time = line
+                .parse::<u64>()
+                .with_context(|| format!("Parse commit time {line:?}"))?;
+            times.push(time);
+        }
+        Ok(Some(times))
+    }
+
+    fn local_date(time: u64) -> Result<NaiveDate> {
+        match Local.timestamp_opt(time as i64, 0) {
+            LocalResult::Single(time) => Ok(time.date_naive()),
+            LocalResult::Ambiguous(time, _) => Ok(time.date_naive()),
+            LocalResult::None => bail!("Invalid timestamp {time}"),
+        }
+    }
+
+    /// Render the commit counts like the contribution graph, the rows are
+    /// weekdays and the columns are weeks. The levels are relative to the
+    /// busiest day.
+    fn show_heatmap(days: &HashMap<NaiveDate, usize>, start: NaiveDate, end: NaiveDate) {
+        const LEVELS: [&str; 5] = ["·", "░", "▒", "▓", "█"];
+        let max = days.values().copied().max().unwrap_or_default();
+        let first = start - Duration::days(start.weekday().num_days_from_monday() as i64);
+        let weeks = (end - first).num_days() / 7 + 1;
+
+        // The month names are shown above the week they start in.
+        let mut header = String::from("    ");
+        let mut last_month = 0;
+        let mut skip = false;
+        for week in 0..weeks {
+            let month = (first + Duration::days(week * 7)).month();
+            if skip {
+                skip = false;
+            } else if month != last_month {
+                let date = first + Duration::days(week * 7);
+                header.push_str(&format!("{:<4}", date.format("%b")));
+                skip = true;
+            } else {
+                header.push_str("  ");
+            }
+            last_month = month;
+        }
+        println!("{}", header.trim_end());
+
+        for weekday in 0..7 {
+            let label = match weekday {
+                0 => "Mon",
+                2 => "Wed",
+                4 => "Fri",
+                _ => "",
+            };
+            let mut line = format!("{label:<4}");
+            for week in 0..weeks {
+                let date = first + Duration::days(week * 7 + weekday);
+                if date < start || date > end {
+                    line.push_str("  ");
+                    continue;
+                }
+                let count = days.get(&date).copied().unwrap_or_default();
+                let level = if count == 0 {
+                    0
+                } else {
+                    1 + (count - 1) * 4 / max
+                };
+                line.push_str(LEVELS[level]);
+                line.push(' ');
+            }
+            println!("{}", line.trim_end());
+        }
+        println!();
+        println!("Less {} More", LEVELS.join(" "));
+    }
+
+    fn list_licenses(&self, db: &Database, args: &[String]) -> Result<()> {
+        let (repos, level) = Self::list_by_args(db, args)?;
+        let dirs: Vec<PathBuf> = repos.iter().map(|repo| repo.get_path()).collect();
+        let results = utils::batch(&dirs, |dir| license::detect(dir));
+
+        let mut licenses = Vec::with_capacity(repos.len());
+        let mut failed = 0;
+        for (repo, result) in repos.iter().zip(results) {
+            let name = repo.as_string(&level);
+            let license = match result {
+                Ok(Some(license)) => license,
+                // The repo is not cloned yet, skip it.
+                Ok(None) => continue,
+                Err(err) => {
+                    utils::show_warn(format!("Detect license for {name}: {err:#}"));
+                    failed += 1;
+                    continue;
+                }
+            };
+            licenses.push(RepoLicense {
+                repo: name,
+                license,
+            });
+        }
+        if self.json {
+            let json = serde_json::to_string_pretty(&licenses).context("Encode licenses json")?;
+            println!("{json}");
+        } else if licenses.is_empty() {
+            println!("Nothing to show");
+        } else {
+            Self::show_licenses(&licenses, failed);
+        }
+        if failed > 0 {
+            bail!("{failed} repos failed to detect license");
+        }
+        Ok(())
+    }
+
+    fn show_licenses(licenses: &[RepoLicense], failed: usize) {
+        let mut table = Table::with_capacity(1 + licenses.len());
+        table.add(vec![
+            String::from("REPO"),
+            String::from("LICENSE"),
+            String::from("FILE"),
+        ]);
+        let (mut unknown, mut missing) = (0, 0);
+        for item in licenses.iter() {
+            let name = match (&item.license.file, item.license.name) {
+                (_, Some(name)) => String::from(name),
+                (Some(_), None) => {
+                    unknown += 1;
+                    String::from("<unknown>")
+                }
+                (None, None) => {
+                    missing += 1;
+                    String::from("<missing>")
+                }
+            };
+            table.add(vec![
+                item.repo.clone(),
+                name,
+                item.license.file.clone().unwrap_or_default(),
+            ]);
+        }
+        table.show();
+        println!();
+        println!(
+            "Summary: {} known, {unknown} unknown, {missing} missing, {failed} failed",
+            licenses.len() - unknown - missing
+        );
+    }
+
+    fn list_notifications(&self, db: &Database, remote: Option<&str>) -> Result<()> {
+        let remotes = match remote {
+            Some(remote) => vec![config::must_get_remote(remote)?],
+            None => {
+                let mut remotes = Vec::new();
+                for name in config::list_remotes() {
+                    let remote = config::must_get_remote(name)?;
+                    if let Some(_) = remote.provider {
+                        remotes.push(remote);
+                    }
+                }
+                remotes
+            }
+        };
+
+        info!("Get notifications from remote API");
+        let mut notifications = Vec::new();
+        for remote in remotes.iter() {
+            let provider = api::init_provider(remote, false)?;
+            let items = match provider.list_notifications() {
+                Ok(items) => items,
+                Err(err) => {
+                    utils::show_warn(format!(
+                        "List notifications for remote {}: {err:#}",
+                        remote.name
+                    ));
+                    continue;
+                }
+            };
+            for notification in items {
+                if let None = db.get(&remote.name, &notification.owner, &notification.name) {
+                    continue;
+                }
+                notifications.push(NotificationInfo {
+                    remote: remote.name.clone(),
+                    notification,
+                });
+            }
+        }
+        notifications
+            .sort_unstable_by(|a, b| b.notification.updated_at.cmp(&a.notification.updated_at));
+
+        if self.json {
+            let json = serde_json::to_string_pretty(&notifications)
+                .context("Encode notifications json")?;
+            println!("{json}");
+            return Ok(());
+        }
+        if notifications.is_empty() {
+            println!("No unread notification");
+            return Ok(());
+        }
+        let names: Vec<String> = notifications
+            .iter()
+            .map(|info| {
+                format!(
+                    "{}:{}/{}",
+                    info.remote, info.notification.owner, info.notification.name
+                )
+            })
+            .collect();
+
+        if self.open {
+            let items: Vec<String> = notifications
+                .iter()
+                .zip(names.iter())
+                .map(|(info, name)| {
+                    format!(
+                        "[{}] {name} {}",
+                        info.notification.reason, info.notification.title
+                    )
+                })
+                .collect();
+            let idx = shell::search(&items)?;
+            let info = &notifications[idx];
+            let remote = config::must_get_remote(&info.remote)?;
+            utils::open_url(remote.browser(), &info.notification.web_url)?;
+            return Self::mark_notifications_read(&[info]);
+        }
+
+        let mut table = Table::with_capacity(1 + notifications.len());
+        table.add(vec![
+            String::from("REPO"),
+            String::from("REASON"),
+            String::from("TYPE"),
+            String::from("TITLE"),
+            String::from("UPDATED"),
+        ]);
+        for (info, name) in notifications.iter().zip(names) {
+            table.add(vec![
+                name,
+                info.notification.reason.clone(),
+                info.notification.kind.clone(),
+                info.notification.title.clone(),
+                utils::format_since(info.notification.updated_at),
+            ]);
+        }
+        table.show();
+        if !self.mark_read {
+            return Ok(());
+        }
+
+        println!();
+        confirm!(
+            "Do you want to mark {} notifications as read",
+            notifications.len()
+        );
+        let notifications: Vec<&NotificationInfo> = notifications.iter().collect();
+        Self::mark_notifications_read(&notifications)
+    }
+
+    fn list_snippets(&self) -> Result<()> {
+        let remote = match &self.remote {
+            Some(remote) => config::must_get_remote(remote)?,
+            None => bail!("The remote is required to list snippets"),
+        };
+        remote.check_provider()?;
+        let provider = api::init_provider(&remote, false)?;
+        info!("List snippets from remote API");
+        let snippets = provider.list_snippets()?;
+
+        if self.json {
+            let json = serde_json::to_string_pretty(&snippets).context("Encode snippets json")?;
+            println!("{json}");
+            return Ok(());
+        }
+        if snippets.is_empty() {
+            println!("No snippet");
+            return Ok(());
+        }
+        if self.open {
+            let items: Vec<String> = snippets
+                .iter()
+                .map(|snippet| {
+                    if snippet.title.is_empty() {
+                        return format!("{} {}", snippet.id, snippet.files.join(","));
+                    }
+                    format!("{} {}", snippet.id, snippet.title)
+                })
+                .collect();
+            let idx = shell::search(&items)?;
+            return utils::open_url(remote.browser(), &snippets[idx].web_url);
+        }
+
+        let mut table = Table::with_capacity(1 + snippets.len());
+        table.add(vec![
+            String::from("ID"),
+            String::from("TITLE"),
+            String::from("FILES"),
+            String::from("VISIBILITY"),
+            String::from("UPDATED"),
+        ]);
+        for snippet in snippets {
+            table.add(vec![
+                snippet.id,
+                snippet.title,
+                snippet.files.join(","),
+                String::from(if snippet.public { "public" } else { "private" }),
+                utils::format_since(snippet.updated_at),
+            ]);
+        }
+        table.show();
+        Ok(())
+    }
+
+    /// Mark the notifications as read, a failure does not stop marking
+    /// others, the failures are reported at the end.
+    fn mark_notifications_read(notifications: &[&NotificationInfo]) -> Result<()> {
+        let mut remotes: Vec<&str> = notifications
+            .iter()
+            .map(|info| info.remote.as_str())
+            .collect();
+        remotes.sort_unstable();
+        remotes.dedup();
+        let mut marked = 0;
+        let mut failed = Vec::new();
+        for name in remotes {
+            let infos = notifications.iter().filter(|info| info.remote == name);
+            let provider = match config::must_get_remote(name)
+                .and_then(|remote| api::init_provider(&remote, false))
+            {
+                Ok(provider) => provider,
+                Err(err) => {
+                    let err = format!("{err:#}");
+                    failed.extend(infos.map(|info| (&info.notification.id, err.clone())));
+                    continue;
+                }
+            };
+            for info in infos {
+                match provider.mark_notification_read(&info.notification.id) {
+                    Ok(()) => marked += 1,
+                    Err(err) => failed.push((&info.notification.id, format!("{err:#}"))),
+                }
+            }
+        }
+        info!("Mark {} notifications as read", marked);
+        if !failed.is_empty() {
+            println!();
+            for (id, err) in failed.iter() {
+                println!("Mark notification {id} as read failed: {err}");
+            }
+            bail!("{} notifications failed to mark as read", failed.len());
+        }
+        Ok(())
+    }
+
+    fn show_merge(&self) -> Result<()> {
+        let db = Database::read_only()?;
+        let repo = db.must_current()?;
+        let remote = config::must_get_remote(repo.remote.as_str())?;
+        let branch = GitBranch::must_current()?;
+
+        let provider = api::init_owner_provider(&remote, &repo.owner, false)?;
+        info!("Get merge status from remote API");
+        let mut merge = MergeOptions {
+            owner: repo.owner.to_string(),
+            name: repo.name.to_string(),
+            upstream: None,
+            source: branch,
+            target: String::new(),
+            draft: false,
+            reviewers: Vec::new(),
+            assignees: Vec::new(),
+            labels: Vec::new(),
+        };
+        let mut status = provider.get_merge_status(merge.clone())?;
+        // The branch of a fork is usually merged to upstream.
+        if let None = status {
+            let api_repo = provider.get_repo(&repo.owner, &repo.name)?;
+            if let Some(upstream) = api_repo.upstream {
+                merge.upstream = Some(upstream);
+                status = provider.get_merge_status(merge.clone())?;
+            }
+        }
+        let status = match status {
+            Some(status) => status,
+            None => bail!("No open merge for branch {}", merge.source),
+        };
+        if self.json {
+            let json = serde_json::to_string_pretty(&status).context("Encode merge json")?;
+            println!("{json}");
+            return Ok(());
+        }
+        let yaml = serde_yaml::to_string(&status).context("Encode merge yaml")?;
+        print!("{yaml}");
+        Ok(())
+    }
+
+    fn show_job_logs(&self) -> Result<()> {
+        let db = Database::read_only()?;
+        let repo = db.must_current()?;
+        let remote = config::must_get_remote(repo.remote.as_str())?;
+        let commit = Shell::git(&["rev-parse", "HEAD"])
+            .with_desc("Get current commit")
+            .execute()?
+            .checked_read()?;
+
+        let provider = api::init_owner_provider(&remote, &repo.owner, false)?;
+        info!("Call remote API to list jobs");
+        let mut jobs = provider.list_jobs(&repo.owner, &repo.name, &commit)?;
+        let (re, context) = match &self.grep {
+            Some(pattern) => {
+                let re = Regex::new(pattern).with_context(|| format!("Parse regex {pattern}"))?;
+                (re, 0)
+            }
+            None => {
+                jobs.retain(|job| job.failed);
+                let pattern = match &remote.job_error_pattern {
+                    Some(pattern) => pattern.as_str(),
+                    None => Self::JOB_ERROR_PATTERN,
+                };
+                let re = Regex::new(pattern)
+                    .with_context(|| format!("Parse job error pattern {pattern}"))?;
+                (re, Self::JOB_LOG_CONTEXT)
+            }
+        };
+        if jobs.is_empty() {
+            println!("No job to show for commit {commit}");
+            return Ok(());
+        }
+
+        for (job_idx, job) in jobs.iter().enumerate() {
+            if job_idx > 0 {
+                println!();
+            }
+            println!("[{}] {} {}", job.status, job.name, job.web_url);
+            let log = provider.get_job_log(&repo.owner, &repo.name, job.id)?;
+            let lines: Vec<&str> = log.lines().collect();
+            let mut last: Option<usize> = None;
+            for (idx, line) in lines.iter().enumerate() {
+                if !re.is_match(line) {
+                    continue;
+                }
+                let start = idx.saturating_sub(context);
+                let start = match last {
+                    Some(last) if last + 1 >= start => last + 1,
+                    Some(_) => {
+                        println!("--");
+                        start
+                    }
+                    None => start,
+                };
+                let end = (idx + context).min(lines.len() - 1);
+                for line in lines[start..=end].iter() {
+                    println!("{line}");
+                }
+                last = Some(end);
+            }
+        }
+        Ok(())
+    }
+
+    fn list_tags(&self, pattern: &str) -> Result<()> {
+        let re = Self::pattern_regex(pattern)?;
+        let mut tags: Vec<GitTagInfo> = GitTag::list_info()?
+            .into_iter()
+            .filter(|tag| re.is_match(&tag.name))
+            .collect();
+        if self.latest {
+            if tags.is_empty() {
+                bail!("No tag matched");
+            }
+            let tag = tags.remove(0);
+            if self.json {
+                let json = serde_json::to_string(&tag).context("Encode tag json")?;
+                println!("{json}");
+            } else {
+                println!("{}", tag.name);
+            }
+            return Ok(());
+        }
+        if self.json {
+            let json = serde_json::to_string(&tags).context("Encode tags json")?;
+            println!("{json}");
+            return Ok(());
+        }
+        if tags.is_empty() {
+            println!("Nothing to show");
+            return Ok(());
+        }
+
+        let mut table = Table::with_capacity(1 + tags.len());
+        table.add(vec![
+            String::from("TAG"),
+            String::from("COMMIT"),
+            String::from("TIME"),
+            String::from("SUBJECT"),
+        ]);
+        for tag in tags {
+            table.add(vec![
+                tag.name,
+                tag.commit,
+                utils::format_since(tag.time),
+                tag.subject,
+            ]);
+        }
+        table.show();
+        Ok(())
+    }
+
+    fn pattern_regex(pattern: &str) -> Result<Regex> {
+        if pattern.is_empty() {
+            return Ok(Regex::new(".*").unwrap());
+        }
+        ref_glob_to_regex(pattern).with_context(|| format!("Parse pattern {pattern}"))
+    }
+
+    fn list_metrics(&self) -> Result<()> {
+        let summaries = Metric::summary()?;
+        if summaries.is_empty() {
+            if !config::base().metrics {
+                println!("Metrics is disabled, please enable it in config");
+                return Ok(());
+            }
+            println!("Nothing to show");
+            return Ok(());
+        }
+        let mut table = Table::with_capacity(1 + summaries.len());
+        table.add(vec![
+            String::from("COMMAND"),
+            String::from("COUNT"),
+            String::from("FAILED"),
+            String::from("AVG"),
+            String::from("P95"),
+            String::from("MAX"),
+        ]);
+        let format_ms = |ms: u64| format!("{}ms", ms);
+        for summary in summaries {
+            table.add(vec![
+                summary.command,
+                format!("{}", summary.count),
+                format!("{}", summary.failed),
+                format_ms(summary.avg),
+                format_ms(summary.p95),
+                format_ms(summary.max),
+            ]);
+        }
+        table.show();
+        Ok(())
+    }
+
+    fn list_shortcuts(&self, db: &Database) -> Result<()> {
+        let repos = db.list_shortcuts();
+        if repos.is_empty() {
+            println!("Nothing to show");
+            return Ok(());
+        }
+        let mut table = Table::with_capacity(1 + repos.len());
+        table.add(vec![
+            String::from("NUM"),
+            String::from("NAME"),
+            String::from("SCORE"),
+        ]);
+        for (num, repo) in repos {
+            table.add(vec![
+                format!("{num}"),
+                repo.as_string(&NameLevel::Full),
+                format!("{:.2}", repo.score()),
+            ]);
+        }
+        table.show();
+        Ok(())
+    }
+
+    fn list_owners(&self, db: &Database, remote: Option<&str>) -> Result<()> {
+        let repos = match remote {
+            Some(remote) => db.list_by_remote(remote),
+            None => db.list_all(),
+        };
+        let repos = db.filter_ignored(repos);
+        let with_size = self.size || self.sort == OwnerSort::Size;
+        let mut sizes = if with_size {
+            let dirs = repos.iter().map(|repo| repo.get_path()).collect();
+            utils::dir_bytes_batch(dirs)?.into_iter()
+        } else {
+            Vec::new().into_iter()
+        };
+
+        let mut owners: Vec<OwnerInfo> = Vec::new();
+        let mut owner_index: HashMap<String, usize> = HashMap::new();
+        for repo in repos {
+            let name = match remote {
+                Some(_) => format!("{}", repo.owner),
+                None => format!("{}:{}", repo.remote, repo.owner),
+            };
+            let idx = match owner_index.get(&name) {
+                Some(idx) => *idx,
+                None => {
+                    let idx = owners.len();
+                    owner_index.insert(name.clone(), idx);
+                    owners.push(OwnerInfo {
+                        name,
+                        repos: 0,
+                        score: 0.0,
+                        last_accessed: 0,
+                        size: 0,
+                    });
+                    idx
+                }
+            };
+            let owner = &mut owners[idx];
+            owner.repos += 1;
+            owner.score += repo.score();
+            if repo.last_accessed > owner.last_accessed {
+                owner.last_accessed = repo.last_accessed;
+            }
+            if let Some(size) = sizes.next() {
+                owner.size += size;
+            }
+        }
+        if owners.is_empty() {
+            println!("Nothing to show");
+            return Ok(());
+        }
+
+        match self.sort {
+            OwnerSort::Name => owners.sort_unstable_by(|a, b| a.name.cmp(&b.name)),
+            OwnerSort::Repos => owners.sort_unstable_by(|a, b| b.repos.cmp(&a.repos)),
+            OwnerSort::Score => owners.sort_unstable_by(|a, b| b.score.total_cmp(&a.score)),
+            OwnerSort::Access => {
+                owners.sort_unstable_by(|a, b| b.last_accessed.cmp(&a.last_accessed))
+            }
+            OwnerSort::Size => owners.sort_unstable_by(|a, b| b.size.cmp(&a.size)),
+        }
+
+        let mut table = Table::with_capacity(1 + owners.len());
+        let mut titles = vec![
+            String::from("OWNER"),
+            String::from("REPOS"),
+            String::from("LAST_ACCESS"),
+            String::from("SCORE"),
+        ];
+        if with_size {
+            titles.push(String::from("SIZE"));
+        }
+        table.add(titles);
+        for owner in owners {
+            let mut row = vec![
+                owner.name,
+                format!("{}", owner.repos),
+                utils::format_since(owner.last_accessed),
+                format!("{:.2}", owner.score),
+            ];
+            if with_size {
+                row.push(utils::human_bytes(owner.size as f64));
+            }
+            table.add(row);
+        }
+
+        table.show();
+        Ok(())
+    }
+
+    fn list_reports(&self, limit: usize) -> Result<()> {
+        let reports = SyncReport::list(limit)?;
+        if reports.is_empty() {
+            println!("Nothing to show");
+            return Ok(());
+        }
+
+        if self.group {
+            return SyncReport::show_grouped(&reports, true);
+        }
+
+        let mut table = Table::with_capacity(1 + reports.len());
+        table.add(vec![
+            String::from("TIME"),
+            String::from("REPO"),
+            String::from("OP"),
+            String::from("BRANCH"),
+            String::from("RESULT"),
+        ]);
+        for report in reports {
+            let time = utils::format_time(report.time)?;
+            for item in report.items {
+                let result = match &item.error {
+                    Some(err) => format!("failed: {err}"),
+                    None => String::from("ok"),
+                };
+                table.add(vec![
+                    time.clone(),
+                    report.repo.clone(),
+                    item.op.clone(),
+                    item.branch_desc(),
+                    result,
+                ]);
+            }
+        }
+
+        table.show();
+        Ok(())
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use clap::Parser;
+
+    use super::*;
+
+    #[derive(Parser)]
+    struct TestApp {
+        #[clap(flatten)]
+        get: GetArgs,
+    }
+
+    fn parse(args: &[&str]) -> bool {
+        let args = ["roxide"].iter().chain(args.iter());
+        TestApp::try_parse_from(args).is_ok()
+    }
+
+    #[test]
+    fn test_mode_args() {
+        assert!(parse(&[]));
+        assert!(parse(&["github", "fioncat", "--size", "--pinned"]));
+        assert!(parse(&["--owners", "--size", "--sort", "access"]));
+        assert!(parse(&["--tags", "v1.*", "--latest", "--json"]));
+        assert!(parse(&["--branches", "feature*", "--all-repos"]));
+        assert!(parse(&["--url", "ssh", "--copy"]));
+        assert!(parse(&["--activity", "--since", "8w"]));
+
+        assert!(!parse(&["--tags", "--branches"]));
+        assert!(!parse(&["--licenses", "--forks"]));
+        assert!(!parse(&["--latest"]));
+        assert!(!parse(&["--json"]));
+        assert!(!parse(&["--owners", "--json"]));
+        assert!(!parse(&["--all-repos"]));
+        assert!(!parse(&["--licenses", "--all-repos"]));
+        assert!(!parse(&["--sort", "name"]));
+        assert!(!parse(&["--since", "8w"]));
+        assert!(!parse(&["--merge", "--copy"]));
+        assert!(!parse(&["--forks", "--size"]));
+        assert!(!parse(&["--tags", "--pinned"]));
+        assert!(!parse(&["--forks", "--all-repos"]));
+        assert!(!parse(&["--reports", "--latest"]));
+        assert!(!parse(&["--licenses", "--since", "8w"]));
+        assert!(!parse(&["--forks", "--grep", "error"]));
+        assert!(parse(&["--licenses"]));
+        assert!(parse(&["--owners"]));
+    }
 }
@@ -0,0 +1,217 @@
+use std::io::Read;
+use std::path::PathBuf;
+use std::process::{Command, Stdio};
+use std::rc::Rc;
+
+use anyhow::{bail, Context, Result};
+use clap::Args;
+use console::style;
+
+use crate::cmd::run::home;
+use crate::cmd::Run;
+use crate::repo::database::Database;
+use crate::shell::Shell;
+use crate::utils;
+
+/// Search code in repos in parallel, use ripgrep if it is installed, else
+/// `git grep`.
+#[derive(Args)]
+pub struct GrepArgs {
+    /// The pattern to search, it is a regex by default.
+    pub pattern: String,
+
+    /// The remote name or group (`@group`), default will search all repos.
+    pub remote: Option<String>,
+
+    /// The repo query, format is `owner[/[name]]`.
+    pub query: Option<String>,
+
+    /// Search case insensitively.
+    #[clap(long, short)]
+    pub ignore_case: bool,
+
+    /// Treat the pattern as a literal string instead of a regex.
+    #[clap(long, short = 'F')]
+    pub fixed_strings: bool,
+
+    /// Select one match, and jump to the repo containing it. This requires
+    /// the shell function created by `roxide init`.
+    #[clap(long, short)]
+    pub open: bool,
+}
+
+struct GrepMatch {
+    path: String,
+    line: String,
+    text: String,
+}
+
+impl Run for GrepArgs {
+    fn run(&self) -> Result<()> {
+        if self.open && utils::is_non_interactive() {
+            bail!("The `--open` requires interactive mode");
+        }
+        let db = Database::read_only()?;
+        let (repos, level) = db.list_by_query(self.remote.as_deref(), self.query.as_deref())?;
+        if repos.is_empty() {
+            println!("No repo to search");
+            return Ok(());
+        }
+
+        let ripgrep = Self::has_ripgrep();
+        let dirs: Vec<PathBuf> = repos.iter().map(|repo| repo.get_path()).collect();
+        let results = utils::batch(&dirs, |dir| self.grep(dir, ripgrep));
+
+        let mut matches = Vec::new();
+        let mut matched_repos = 0;
+        for (repo, result) in repos.iter().zip(results) {
+            let name = repo.as_string(&level);
+            match result {
+                Ok(items) => {
+                    if !items.is_empty() {
+                        matched_repos += 1;
+                    }
+                    for item in items {
+                        matches.push((Rc::clone(repo), format!("{name}/{}", item.path), item));
+                    }
+                }
+                Err(err) => utils::show_warn(format!("Search {name}: {err:#}")),
+            }
+        }
+        if matches.is_empty() {
+            bail!("No match found");
+        }
+
+        if self.open {
+            let (repos, items): (Vec<_>, Vec<_>) = matches
+                .iter()
+                .map(|(repo, path, item)| {
+                    let text = format!("{path}:{}: {}", item.line, item.text.trim());
+                    (Rc::clone(repo), text)
+                })
+                .unzip();
+            return home::select_enter(&repos, &items);
+        }
+
+        for (_, path, item) in matches.iter() {
+            println!(
+                "{}:{}:{}",
+                style(path).magenta(),
+                style(&item.line).green(),
+                item.text
+            );
+        }
+        println!();
+        println!(
+            "Summary: {} matches in {matched_repos} repos",
+            matches.len()
+        );
+        Ok(())
+    }
+}
+
+impl GrepArgs {
+    fn has_ripgrep() -> bool {
+        Command::new("rg")
+            .arg("--version")
+            .stdout(Stdio::null())
+            .stderr(Stdio::null())
+            .status()
+            .map(|status| status.success())
+            .unwrap_or(false)
+    }
+
+    fn grep(&self, dir: &PathBuf, ripgrep: bool) -> Result<Vec<GrepMatch>> {
+        // The repo might not be cloned yet, skip it.
+        if !dir.exists() {
+            return Ok(Vec::new());
+        }
+        // The path is followed by NUL, so that the paths containing `:` can
+        // be parsed.
+        let mut args = if ripgrep {
+            vec![
+                "--null",
+                "--line-number",
+                "--no-heading",
+                "--color",
+                "never",
+            ]
+        } else {
+            vec!["grep", "--null", "--line-number", "-I"]
+        };
+        if self.ignore_case {
+            args.push("-i");
+        }
+        if self.fixed_strings {
+            args.push("-F");
+        }
+        args.push("-e");
+        args.push(self.pattern.as_str());
+        // Run by `Shell`, so that the command is killed when exceeding the
+        // `batch_timeout`.
+        let program = if ripgrep { "rg" } else { "git" };
+        let mut result = Shell::with_args(program, &args)
+            .with_path(dir)
+            .without_stdin()
+            .set_mute(true)
+            .execute()?;
+
+        // Both ripgrep and git grep exit with 1 if nothing matched, the error
+        // message is printed to stderr.
+        match result.code {
+            Some(0) => {}
+            Some(1) => return Ok(Vec::new()),
+            Some(code) => bail!("{program} exited with code {code}"),
+            None => bail!("{program} was terminated by signal"),
+        }
+
+        let mut output = Vec::new();
+        result
+            .stdout
+            .read_to_end(&mut output)
+            .with_context(|| format!("Read output of {program}"))?;
+        let output = String::from_utf8_lossy(&output);
+        Ok(output.lines().filter_map(parse_match).collect())
+    }
+}
+
+/// Parse a line of `rg --null` (`path\0line:text`) or `git grep --null`
+/// (`path\0line\0text`) output.
+fn parse_match(line: &str) -> Option<GrepMatch> {
+    let (path, rest) = line.split_once('\0')?;
+    let (line, text) = rest.split_once(|c| c == '\0' || c == ':')?;
+    if path.is_empty() || line.is_empty() || !line.chars().all(|c| c.is_ascii_digit()) {
+        return None;
+    }
+    Some(GrepMatch {
+        path: path.to_string(),
+        line: line.to_string(),
+        text: text.to_string(),
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse_match() {
+        let cases = [
+            ("a.rs\x002:fn main() {", "a.rs", "2", "fn main() {"),
+            ("a.rs\x002\x00fn main() {", "a.rs", "2", "fn main() {"),
+            ("a:b/c.txt\x0012:k:v", "a:b/c.txt", "12", "k:v"),
+            ("a:b/c.txt\x0012\x00k:v", "a:b/c.txt", "12", "k:v"),
+            ("file\x001:", "file", "1", ""),
+        ];
+        for (line, path, num, text) in cases {
+            let item = parse_match(line).unwrap();
+            assert_eq!(item.path, path, "line {line:?}");
+            assert_eq!(item.line, num, "line {line:?}");
+            assert_eq!(item.text, text, "line {line:?}");
+        }
+
+        for line in ["", "a.rs:2:text", "\x001:text", "a.rs\x00x:text"] {
+            assert!(parse_match(line).is_none(), "line {line:?}");
+        }
+    }
+}
@@ -1,23 +1,36 @@
+use std::collections::HashMap;
 use std::fs;
 use std::io::ErrorKind;
 use std::path::PathBuf;
 use std::rc::Rc;
 
 use anyhow::{bail, Context, Result};
+use chrono::{Datelike, Local};
 use clap::Args;
 
+use crate::cmd::run::tmux;
 use crate::cmd::Run;
-use crate::config::types::Remote;
+use crate::config::types::{Owner, Remote};
+use crate::repo::budget;
+use crate::repo::commit;
 use crate::repo::database::Database;
-use crate::repo::types::{NameLevel, Repo};
+use crate::repo::envrc;
+use crate::repo::event::{self, Event};
+use crate::repo::lang;
+use crate::repo::types::{NameLevel, Repo, SubProject};
 use crate::shell::Shell;
-use crate::{api, info, shell};
+use crate::{api, exec, info, shell};
 use crate::{config, confirm, utils};
 
 /// Print the home path of a repo, recommand to use `zz` command instead.
 #[derive(Args)]
 pub struct HomeArgs {
-    /// The repo query, format is `[remote] [owner[/[name]]]`
+    /// The repo query, format is `[remote] [owner[/[name]]]`. You can add
+    /// `@lang` to filter repos by language, such as `@rust keyword`. A clone
+    /// url or web url of the repo is also accepted. Use `repo/path` to jump
+    /// to a sub-project. Use a number to jump to the repo with that shortcut,
+    /// see `roxide get --shortcuts`. Use `@group [keyword]` to jump to a repo
+    /// in the group.
     pub query: Vec<String>,
 
     /// If true, use search instead of fuzzy matching.
@@ -27,37 +40,179 @@ pub struct HomeArgs {
     /// If true, the cache will not be used when calling the API search.
     #[clap(long, short)]
     pub force: bool,
+
+    /// Include the repos matched by ignore patterns.
+    #[clap(long, short)]
+    pub all: bool,
 }
 
 impl Run for HomeArgs {
     fn run(&self) -> Result<()> {
+        let (repo, dir) = self.enter()?;
+        println!("{}", dir.display());
+        if config::base().tmux.rename_on_home {
+            // The session name might be taken by another session, this should
+            // not prevent entering the repo.
+            let _ = tmux::rename_current(&repo);
+        }
+        Ok(())
+    }
+}
+
+impl HomeArgs {
+    /// Resolve the repo (or sub-project) to enter, create it if it does not
+    /// exist, and record the access in database. Return the repo and the
+    /// directory to enter.
+    pub fn enter(&self) -> Result<(Rc<Repo>, PathBuf)> {
         let mut db = Database::read()?;
-        let (remote, repo) = self.query_repo(&db)?;
+        if self.all {
+            db.show_ignored();
+        }
+        if let Some((repo, subproject)) = self.query_subproject(&db) {
+            let dir = subproject.get_path(&repo);
+            db.update(Rc::clone(&repo));
+            db.update_subproject(subproject);
+            db.close()?;
+            return Ok((repo, dir));
+        }
+
+        let (query, language) = lang::split_query(&self.query);
+        let group = query
+            .first()
+            .and_then(|arg| config::base().parse_group(arg));
+        let (remote, mut repo) = match (language, group) {
+            (Some(language), group) => self.query_language(&mut db, &query, group, &language)?,
+            (None, Some(group)) => self.query_group(&db, group, query.get(1))?,
+            (None, None) => self.query_repo(&db, &query)?,
+        };
 
         let dir = repo.get_path();
+        let mut created = false;
         match fs::read_dir(&dir) {
             Ok(_) => {}
             Err(err) if err.kind() == ErrorKind::NotFound => {
+                created = true;
                 self.create_dir(&remote, &repo, &dir)?;
+                // The new repo created locally has no file yet, its languages
+                // are detected later when filtering.
+                if let Some(_) = remote.clone {
+                    if let Some(languages) = lang::detect_names(&dir)? {
+                        repo = repo.with_languages(languages);
+                    }
+                }
+                envrc::write(&repo, false)?;
+                event::emit(Event::RepoCreated {
+                    repo: &repo.full_name(),
+                    path: &format!("{}", dir.display()),
+                });
             }
             Err(err) => {
                 return Err(err).with_context(|| format!("Read repo directory {}", dir.display()));
             }
         }
 
-        println!("{}", dir.display());
-        db.update(repo);
+        db.update(Rc::clone(&repo));
+        db.close()?;
+        if created {
+            budget::check(&[repo.full_name()]);
+        }
 
-        db.close()
+        Ok((repo, dir))
     }
-}
 
-impl HomeArgs {
-    fn query_repo(&self, db: &Database) -> Result<(Remote, Rc<Repo>)> {
-        match self.query.len() {
+    /// The sub-project query format is `repo/path`, such as `myrepo/services/api`.
+    fn query_subproject(&self, db: &Database) -> Option<(Rc<Repo>, Rc<SubProject>)> {
+        if self.query.len() != 1 {
+            return None;
+        }
+        let query = &self.query[0];
+        if query.contains(":") || query.starts_with("@") {
+            return None;
+        }
+        // The `owner/name` query of a repo takes precedence.
+        if db.get_fuzzy("", query.as_str()).is_some() {
+            return None;
+        }
+        let (repo_query, path) = query.split_once("/")?;
+        if repo_query.is_empty() || path.is_empty() {
+            return None;
+        }
+        db.get_subproject_fuzzy(repo_query, path.trim_end_matches("/"))
+    }
+
+    fn query_language(
+        &self,
+        db: &mut Database,
+        query: &[String],
+        group: Option<&str>,
+        language: &str,
+    ) -> Result<(Remote, Rc<Repo>)> {
+        let (repos, keyword, level) = match (group, query.first()) {
+            // The ignored repos in group are included, the same as
+            // `query_group`.
+            (Some(group), _) => (db.list_by_group(group), query.get(1), NameLevel::Full),
+            (None, Some(maybe_remote)) => match config::get_remote(maybe_remote)? {
+                Some(remote) => (
+                    db.filter_ignored(db.list_by_remote(&remote.name)),
+                    query.get(1),
+                    NameLevel::Owner,
+                ),
+                None => (
+                    db.filter_ignored(db.list_all()),
+                    Some(maybe_remote),
+                    NameLevel::Full,
+                ),
+            },
+            (None, None) => (db.filter_ignored(db.list_all()), None, NameLevel::Full),
+        };
+        let repos = lang::filter(db, repos, language)?;
+
+        let repo = match keyword {
+            Some(keyword) => repos
+                .into_iter()
+                .find(|repo| repo.fuzzy_match(keyword.as_str())),
+            None if self.search => Some(self.search_local(repos, level)?),
+            // The repos are sorted by score, so the first one is the best.
+            None => repos.into_iter().next(),
+        };
+        let repo = match repo {
+            Some(repo) => repo,
+            None => bail!("Could not find matched repo with language {language}"),
+        };
+        let remote = config::must_get_remote(repo.remote.as_str())?;
+        Ok((remote, repo))
+    }
+
+    /// The group query format is `@group [keyword]`, the ignored repos are
+    /// included, since they are added to the group explicitly.
+    fn query_group(
+        &self,
+        db: &Database,
+        group: &str,
+        keyword: Option<&String>,
+    ) -> Result<(Remote, Rc<Repo>)> {
+        let repos = db.list_by_group(group);
+        let repo = match keyword {
+            Some(keyword) => repos
+                .into_iter()
+                .find(|repo| repo.fuzzy_match(keyword.as_str())),
+            None if self.search => Some(self.search_local(repos, NameLevel::Full)?),
+            // The repos are sorted by score, so the first one is the best.
+            None => repos.into_iter().next(),
+        };
+        let repo = match repo {
+            Some(repo) => repo,
+            None => bail!("Could not find matched repo in group {group}"),
+        };
+        let remote = config::must_get_remote(repo.remote.as_str())?;
+        Ok((remote, repo))
+    }
+
+    fn query_repo(&self, db: &Database, query: &[String]) -> Result<(Remote, Rc<Repo>)> {
+        match query.len() {
             0 => {
                 let repo = if self.search {
-                    self.search_local(db.list_all(), NameLevel::Full)?
+                    self.search_local(db.filter_ignored(db.list_all()), NameLevel::Full)?
                 } else {
                     db.must_latest("")?
                 };
@@ -65,11 +220,33 @@ impl HomeArgs {
                 Ok((remote, repo))
             }
             1 => {
-                let maybe_remote = &self.query[0];
+                let maybe_remote = &query[0];
+                if let Some((remote, owner, name)) = utils::parse_url(maybe_remote)? {
+                    let repo = self.get_repo(db, remote.name.as_str(), &owner, &name)?;
+                    return Ok((remote, repo));
+                }
+                // Only the numbers in shortcuts range are treated as shortcuts,
+                // others might be the names of remote, owner or repo, such as
+                // `2048`.
+                let shortcut = maybe_remote
+                    .parse::<usize>()
+                    .ok()
+                    .filter(|num| (1..=config::base().shortcuts as usize).contains(num));
+                if let Some(num) = shortcut {
+                    let repo = match db.get_shortcut(num) {
+                        Some(repo) => repo,
+                        None => bail!("Could not find repo with shortcut {num}"),
+                    };
+                    let remote = config::must_get_remote(repo.remote.as_str())?;
+                    return Ok((remote, repo));
+                }
                 match config::get_remote(maybe_remote)? {
                     Some(remote) => {
                         let repo = if self.search {
-                            self.search_local(db.list_by_remote(&remote.name), NameLevel::Owner)?
+                            self.search_local(
+                                db.filter_ignored(db.list_by_remote(&remote.name)),
+                                NameLevel::Owner,
+                            )?
                         } else {
                             db.must_latest(&remote.name)?
                         };
@@ -84,14 +261,11 @@ impl HomeArgs {
                 }
             }
             _ => {
-                let remote_name = &self.query[0];
+                let remote_name = &query[0];
                 let remote = config::must_get_remote(remote_name)?;
-                let query = &self.query[1];
+                let query = &query[1];
                 if query.ends_with("/") {
-                    let mut owner = query.strip_suffix("/").unwrap();
-                    if let Some(raw_owner) = remote.owner_alias.get(owner) {
-                        owner = raw_owner.as_str();
-                    }
+                    let owner = remote.resolve_owner(query);
                     let repo = self.search_api(db, &remote, &owner)?;
                     return Ok((remote, repo));
                 }
@@ -122,8 +296,18 @@ impl HomeArgs {
     }
 
     fn search_local(&self, vec: Vec<Rc<Repo>>, level: NameLevel) -> Result<Rc<Repo>> {
-        let items: Vec<_> = vec.iter().map(|repo| repo.as_string(&level)).collect();
-        let idx = shell::search(&items)?;
+        // When pin_first is enabled, pinned repos are placed at the top, mark
+        // them so that they appear as a dedicated section. The items are built
+        // lazily while streaming to fzf.
+        let items = vec.iter().map(|repo| {
+            let name = repo.as_string(&level);
+            if repo.pinned {
+                format!("[pinned] {name}")
+            } else {
+                name
+            }
+        });
+        let idx = shell::search_iter(items)?;
         let repo = Rc::clone(&vec[idx]);
         Ok(repo)
     }
@@ -131,14 +315,14 @@ impl HomeArgs {
     fn search_api(&self, db: &Database, remote: &Remote, owner: &str) -> Result<Rc<Repo>> {
         match remote.provider {
             Some(_) => {
-                let provider = api::init_provider(remote, self.force)?;
+                let provider = api::init_owner_provider(remote, owner, self.force)?;
                 let api_repos = provider.list_repos(owner)?;
                 let idx = shell::search(&api_repos)?;
                 let name = &api_repos[idx];
                 self.get_repo(db, remote.name.as_str(), owner, name.as_str())
             }
             None => self.search_local(
-                db.list_by_owner(remote.name.as_str(), owner),
+                db.filter_ignored(db.list_by_owner(remote.name.as_str(), owner)),
                 NameLevel::Name,
             ),
         }
@@ -146,16 +330,24 @@ impl HomeArgs {
 
     fn create_dir(&self, remote: &Remote, repo: &Rc<Repo>, dir: &PathBuf) -> Result<()> {
         if let Some(_) = remote.clone {
-            return self.clone(remote, repo, dir);
+            self.check_archived(remote, repo);
+            return clone_repo(remote, repo, dir);
         }
         fs::create_dir_all(dir)
             .with_context(|| format!("Create repo directory {}", dir.display()))?;
         let path = format!("{}", dir.display());
-        Shell::git(&["-C", path.as_str(), "init"])
-            .with_desc("Git init")
-            .execute()?
-            .check()?;
-        if let Some(owner) = remote.owners.get(repo.owner.as_str()) {
+        let owner = remote.owners.get(repo.owner.as_str());
+        let mut args = vec!["-C", path.as_str(), "init"];
+        if let Some(owner) = owner {
+            if let Some(branch) = &owner.default_branch_name {
+                args.push("--initial-branch");
+                args.push(branch.as_str());
+            }
+        }
+        Shell::git(&args).with_desc("Git init").execute()?.check()?;
+        install_commit_hook(remote, repo, dir)?;
+        self.bootstrap(remote, owner, dir)?;
+        if let Some(owner) = owner {
             if let Some(workflow_names) = &owner.on_create {
                 for workflow_name in workflow_names.iter() {
                     let maybe_workflow = config::base().workflows.get(workflow_name);
@@ -176,26 +368,156 @@ impl HomeArgs {
         Ok(())
     }
 
-    fn clone(&self, remote: &Remote, repo: &Rc<Repo>, dir: &PathBuf) -> Result<()> {
-        let url = repo.clone_url(&remote);
-        let path = format!("{}", dir.display());
-        Shell::git(&["clone", url.as_str(), path.as_str()])
-            .with_desc(format!("Clone {}", repo.full_name()))
-            .execute()?
-            .check()?;
+    /// Warn if the repo to clone is archived, since it is read-only. Failing to
+    /// get the settings should not prevent cloning.
+    fn check_archived(&self, remote: &Remote, repo: &Repo) {
+        if let None = remote.provider {
+            return;
+        }
+        let settings = api::init_owner_provider(remote, &repo.owner, self.force)
+            .and_then(|provider| provider.get_repo_settings(&repo.owner, &repo.name));
+        if let Ok(settings) = settings {
+            if settings.archived {
+                utils::show_warn(format!(
+                    "The repo {} is archived, it is read-only",
+                    repo.long_name()
+                ));
+            }
+        }
+    }
 
-        if let Some(user) = &remote.user {
-            Shell::git(&["-C", path.as_str(), "config", "user.name", user.as_str()])
-                .with_desc(format!("Set user to {}", user))
-                .execute()?
-                .check()?;
+    /// Generate `.gitignore` and `LICENSE` for the new created repo. If the
+    /// owner does not declare them, let user select the templates.
+    fn bootstrap(&self, remote: &Remote, owner: Option<&Owner>, dir: &PathBuf) -> Result<()> {
+        let bootstrap = &config::base().bootstrap;
+        let language = owner.and_then(|owner| owner.language.as_ref());
+        let gitignore = Self::select_template(&bootstrap.gitignore, language, ".gitignore")?;
+        if let Some(content) = gitignore {
+            exec!("Create file .gitignore");
+            utils::write_file(&dir.join(".gitignore"), content.as_bytes())?;
         }
-        if let Some(email) = &remote.email {
-            Shell::git(&["-C", path.as_str(), "config", "user.email", email.as_str()])
-                .with_desc(format!("Set email to {}", email))
-                .execute()?
-                .check()?;
+
+        let license = owner.and_then(|owner| owner.license.as_ref());
+        let license = Self::select_template(&bootstrap.license, license, "LICENSE")?;
+        if let Some(content) = license {
+            let user = match &remote.user {
+                Some(user) => user.as_str(),
+                None => "",
+            };
+            let content = content
+                .replace("{year}", &Local::now().year().to_string())
+                .replace("{user}", user);
+            exec!("Create file LICENSE");
+            utils::write_file(&dir.join("LICENSE"), content.as_bytes())?;
         }
         Ok(())
     }
+
+    fn select_template<'a>(
+        templates: &'a HashMap<String, String>,
+        declared: Option<&String>,
+        file: &str,
+    ) -> Result<Option<&'a String>> {
+        if let Some(declared) = declared {
+            return match templates.get(declared) {
+                Some(template) => Ok(Some(template)),
+                None => {
+                    bail!("Could not find {file} template {declared}, please check your config")
+                }
+            };
+        }
+        if templates.is_empty() || utils::is_non_interactive() {
+            return Ok(None);
+        }
+        if !utils::confirm(format!("Do you want to create {file}"))? {
+            return Ok(None);
+        }
+        let mut keys: Vec<&String> = templates.keys().collect();
+        keys.sort();
+        let idx = shell::search(&keys)?;
+        Ok(templates.get(keys[idx]))
+    }
+}
+
+/// Select one of the items to jump to its repo, used by the `--open` of
+/// multi-repo searching commands. The access is recorded in database, and the
+/// repo path is printed for the shell function created by `roxide init` to
+/// enter. The selection is skipped if there is only one item.
+pub fn select_enter(repos: &[Rc<Repo>], items: &[String]) -> Result<()> {
+    let idx = if items.len() == 1 {
+        0
+    } else {
+        shell::search_iter(items.iter())?
+    };
+    let repo = Rc::clone(&repos[idx]);
+    let dir = repo.get_path();
+
+    let mut db = Database::read()?;
+    db.update(repo);
+    db.close()?;
+    println!("{}", dir.display());
+    Ok(())
+}
+
+/// Clone the repo into dir, and apply the git configs of remote to it.
+pub fn clone_repo(remote: &Remote, repo: &Rc<Repo>, dir: &PathBuf) -> Result<()> {
+    let url = repo.clone_url(&remote);
+    let path = format!("{}", dir.display());
+    let mut git = Shell::git(&["clone", url.as_str(), path.as_str()]);
+    git.with_desc(format!("Clone {}", repo.full_name()))
+        .with_remote(remote);
+    if repo.use_https_auth(remote) {
+        git.with_https_auth(remote)?;
+    }
+    git.execute()?.check()?;
+
+    if let Some(ssh_command) = &remote.ssh_command {
+        Shell::git(&[
+            "-C",
+            path.as_str(),
+            "config",
+            "core.sshCommand",
+            ssh_command.as_str(),
+        ])
+        .with_desc(format!("Set ssh command to {}", ssh_command))
+        .execute()?
+        .check()?;
+    }
+    // Persist proxy to the repo config, so that later git operations in this
+    // repo (such as fetch and push) go through the proxy too. Git does not
+    // respect `no_proxy` for `http.proxy`, so skip it for the excluded hosts.
+    let proxy = match &remote.socks5_proxy {
+        Some(proxy) => Some(proxy),
+        None => remote.http_proxy.as_ref(),
+    };
+    let proxy = proxy.filter(|_| !remote.is_no_proxy());
+    if let Some(proxy) = proxy {
+        Shell::git(&["-C", path.as_str(), "config", "http.proxy", proxy.as_str()])
+            .with_desc(format!("Set proxy to {}", proxy))
+            .execute()?
+            .check()?;
+    }
+
+    if let Some(user) = &remote.user {
+        Shell::git(&["-C", path.as_str(), "config", "user.name", user.as_str()])
+            .with_desc(format!("Set user to {}", user))
+            .execute()?
+            .check()?;
+    }
+    if let Some(email) = &remote.email {
+        Shell::git(&["-C", path.as_str(), "config", "user.email", email.as_str()])
+            .with_desc(format!("Set email to {}", email))
+            .execute()?
+            .check()?;
+    }
+    install_commit_hook(remote, repo, dir)
+}
+
+/// Install the commit-msg hook to the repo just cloned or created, if enabled
+/// by the owner's commit rule.
+fn install_commit_hook(remote: &Remote, repo: &Rc<Repo>, dir: &PathBuf) -> Result<()> {
+    if remote.commit_rule(&repo.owner).hook {
+        commit::install_hook(dir, false)?;
+    }
+    Ok(())
 }
@@ -1,39 +1,63 @@
-use anyhow::Result;
+use std::env;
+use std::path::PathBuf;
+
+use anyhow::{bail, Context, Result};
 use clap::{Args, ValueEnum};
 
+use crate::cmd::run::auth;
 use crate::cmd::Run;
-use crate::config;
+use crate::config::types::Config;
+use crate::{config, info, shell, utils};
 
-/// Print the init script.
+/// Print the init script. For `systemd` and `launchd`, install the units to
+/// run the `schedule` commands periodically instead. For `setup`, create the
+/// config files interactively.
 #[derive(Args)]
 pub struct InitArgs {
-    /// The shell type.
+    /// The shell type, or the system scheduler.
     pub shell: Shell,
 }
 
 #[derive(Copy, Clone, PartialEq, Eq, PartialOrd, Ord, ValueEnum)]
 pub enum Shell {
     Zsh,
+    /// The PowerShell, for Windows.
+    Powershell,
+    /// The systemd user service and timer, for Linux.
+    Systemd,
+    /// The launchd agent, for macOS.
+    Launchd,
+    /// The first-run wizard, create the base config and a remote config.
+    Setup,
 }
 
 impl Run for InitArgs {
     fn run(&self) -> Result<()> {
-        let complete_bytes = match self.shell {
-            Shell::Zsh => include_bytes!("../../../scripts/complete_zsh.zsh"),
+        let (complete_bytes, init_bytes): (&[u8], &[u8]) = match self.shell {
+            Shell::Zsh => (
+                include_bytes!("../../../scripts/complete_zsh.zsh"),
+                include_bytes!("../../../scripts/init.sh"),
+            ),
+            Shell::Powershell => (
+                include_bytes!("../../../scripts/complete_powershell.ps1"),
+                include_bytes!("../../../scripts/init.ps1"),
+            ),
+            Shell::Systemd => return Self::install_systemd(),
+            Shell::Launchd => return Self::install_launchd(),
+            Shell::Setup => return Self::setup(),
         };
         println!("{}", String::from_utf8_lossy(complete_bytes));
         println!();
 
         if let Some(base) = &config::base().command.base {
-            let init_bytes = include_bytes!("../../../scripts/init.sh");
             let init_script = String::from_utf8_lossy(init_bytes);
             let script = init_script.replace("_roxide_base", base);
             println!("{script}");
             if let Some(home) = &config::base().command.home {
-                println!("alias {home}='{base} home'")
+                println!("{}", self.alias(home, &format!("{base} home")));
             }
             for (remote, alias) in &config::base().command.remotes {
-                println!("alias {alias}='{base} home {remote}'");
+                println!("{}", self.alias(alias, &format!("{base} home {remote}")));
             }
             println!();
         }
@@ -41,3 +65,279 @@ impl Run for InitArgs {
         Ok(())
     }
 }
+
+impl InitArgs {
+    const SYSTEMD_UNIT: &str = "roxide-schedule";
+    const LAUNCHD_LABEL: &str = "com.roxide.schedule";
+
+    /// The PowerShell alias cannot take arguments, use function instead.
+    fn alias(&self, name: &str, command: &str) -> String {
+        match self.shell {
+            Shell::Powershell => format!("function {name} {{ {command} @args }}"),
+            _ => format!("alias {name}='{command}'"),
+        }
+    }
+
+    /// Build the shell script running the schedule commands one by one, a
+    /// failed command won't stop the others.
+    fn schedule_script() -> Result<String> {
+        let schedule = &config::base().schedule;
+        if schedule.commands.is_empty() {
+            bail!("No schedule command to run, please check your config");
+        }
+        if schedule.interval == 0 {
+            bail!("The schedule interval should not be 0");
+        }
+        let exe = env::current_exe().context("Get roxide executable path")?;
+        let exe = format!("{}", exe.display());
+        let commands: Vec<String> = schedule
+            .commands
+            .iter()
+            .map(|command| format!("'{exe}' --yes {command}"))
+            .collect();
+        Ok(commands.join("; "))
+    }
+
+    fn install_systemd() -> Result<()> {
+        let script = Self::schedule_script()?;
+        let interval = config::base().schedule.interval;
+        let dir = utils::home_dir()?
+            .join(".config")
+            .join("systemd")
+            .join("user");
+
+        let service = format!(
+            "[Unit]\nDescription=Run roxide schedule commands\n\n[Service]\nType=oneshot\nExecStart=/bin/sh -c \"{}\"\n",
+            // The `%` is the specifier prefix in systemd units.
+            script.replace('"', "\\\"").replace('%', "%%")
+        );
+        let timer = format!(
+            "[Unit]\nDescription=Run roxide schedule commands periodically\n\n[Timer]\nOnBootSec=15min\nOnUnitActiveSec={interval}s\nPersistent=true\n\n[Install]\nWantedBy=timers.target\n"
+        );
+        let service_path = dir.join(format!("{}.service", Self::SYSTEMD_UNIT));
+        let timer_path = dir.join(format!("{}.timer", Self::SYSTEMD_UNIT));
+        utils::write_file(&service_path, service.as_bytes())?;
+        utils::write_file(&timer_path, timer.as_bytes())?;
+        info!("Write {}", service_path.display());
+        info!("Write {}", timer_path.display());
+
+        println!();
+        println!("Please enable the timer by:");
+        println!();
+        println!("    systemctl --user daemon-reload");
+        println!(
+            "    systemctl --user enable --now {}.timer",
+            Self::SYSTEMD_UNIT
+        );
+        Ok(())
+    }
+
+    fn install_launchd() -> Result<()> {
+        let script = Self::schedule_script()?;
+        let interval = config::base().schedule.interval;
+        let path = utils::home_dir()?
+            .join("Library")
+            .join("LaunchAgents")
+            .join(format!("{}.plist", Self::LAUNCHD_LABEL));
+        let log = PathBuf::from(&config::base().statedir).join("schedule.log");
+
+        let plist = format!(
+            r#"<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+    <key>Label</key>
+    <string>{label}</string>
+    <key>ProgramArguments</key>
+    <array>
+        <string>/bin/sh</string>
+        <string>-c</string>
+        <string>{script}</string>
+    </array>
+    <key>StartInterval</key>
+    <integer>{interval}</integer>
+    <key>StandardOutPath</key>
+    <string>{log}</string>
+    <key>StandardErrorPath</key>
+    <string>{log}</string>
+</dict>
+</plist>
+"#,
+            label = Self::LAUNCHD_LABEL,
+            script = Self::escape_xml(&script),
+            log = Self::escape_xml(&format!("{}", log.display())),
+        );
+        utils::write_file(&path, plist.as_bytes())?;
+        info!("Write {}", path.display());
+
+        println!();
+        println!("Please load the agent by:");
+        println!();
+        println!("    launchctl load -w {}", path.display());
+        Ok(())
+    }
+
+    fn setup() -> Result<()> {
+        if utils::is_non_interactive() {
+            bail!("Setup requires interactive mode");
+        }
+        let dir = Config::dir()?;
+        info!("The config will be written to {}", dir.display());
+
+        if Self::setup_file(&dir, "config")? {
+            let workspace = utils::input("Workspace to store repos", true, Some("~/src"))?;
+            let base = utils::input(
+                "Shell command to jump between repos (empty to disable)",
+                false,
+                Some("z"),
+            )?;
+            let mut yaml = format!("workspace: {}\n\n", Self::quote(&workspace));
+            if base.is_empty() {
+                // The default command is not empty, disable it explicitly.
+                yaml.push_str("command: {}\n");
+            } else {
+                let home = utils::input("Shell command to jump to a repo", false, Some("zz"))?;
+                yaml.push_str("command:\n");
+                yaml.push_str(&format!("  base: {}\n", Self::quote(&base)));
+                if !home.is_empty() {
+                    yaml.push_str(&format!("  home: {}\n", Self::quote(&home)));
+                }
+            }
+            Self::write_config(&dir, "config", &yaml)?;
+        }
+
+        let name = utils::input("Remote name", true, Some("github"))?;
+        if name == "config" {
+            bail!("The remote name cannot be \"config\"");
+        }
+        let mut login = false;
+        if Self::setup_file(&dir, &name)? {
+            let (yaml, need_login) = Self::setup_remote()?;
+            Self::write_config(&dir, &name, &yaml)?;
+            login = need_login;
+        }
+
+        // Read the config again to validate what we wrote.
+        let cfg = Config::read().context("Validate config")?;
+        let remote = cfg
+            .must_get_remote(&name)
+            .context("Validate remote config")?;
+        info!("Config is valid");
+
+        if login {
+            auth::login(&remote)?;
+        }
+
+        println!();
+        println!("Setup done, please add the following line to your shell profile:");
+        println!();
+        if cfg!(windows) {
+            println!("    roxide init powershell | Out-String | Invoke-Expression");
+        } else {
+            println!("    source <(roxide init zsh)");
+        }
+        Ok(())
+    }
+
+    /// Build the remote config, return whether need to login by device flow.
+    fn setup_remote() -> Result<(String, bool)> {
+        let providers = vec!["github", "gitlab", "<none>"];
+        let idx = shell::search(&providers)?;
+        let provider = providers[idx];
+        let domain = match provider {
+            "github" => "github.com",
+            "gitlab" => "gitlab.com",
+            _ => "",
+        };
+
+        let clone = utils::input(
+            "Clone domain (empty means a local remote)",
+            false,
+            Some(domain),
+        )?;
+        let mut yaml = String::new();
+        if !clone.is_empty() {
+            yaml.push_str(&format!("clone: {}\n", Self::quote(&clone)));
+        }
+        let user = utils::input("Git user name (optional)", false, None)?;
+        if !user.is_empty() {
+            yaml.push_str(&format!("user: {}\n", Self::quote(&user)));
+        }
+        let email = utils::input("Git user email (optional)", false, None)?;
+        if !email.is_empty() {
+            yaml.push_str(&format!("email: {}\n", Self::quote(&email)));
+        }
+        if !clone.is_empty() {
+            let ssh = utils::confirm("Clone repos by ssh")?;
+            yaml.push_str(&format!("ssh: {ssh}\n"));
+        }
+
+        let mut login = false;
+        if idx < 2 {
+            yaml.push_str(&format!("\nprovider: {provider}\n"));
+            let items = vec![
+                "<login by device flow>",
+                "<read token from env>",
+                "<no token>",
+            ];
+            match shell::search(&items)? {
+                0 => {
+                    let client_id = utils::input("OAuth app client id", true, None)?;
+                    yaml.push_str(&format!("oauth_client_id: {}\n", Self::quote(&client_id)));
+                    login = true;
+                }
+                1 => {
+                    let default = format!("{}_TOKEN", provider.to_uppercase());
+                    let env = utils::input("Token env name", true, Some(&default))?;
+                    yaml.push_str(&format!("token: {}\n", Self::quote(&format!("${{{env}}}"))));
+                }
+                _ => {}
+            }
+        }
+
+        let owner = utils::input(
+            "Default owner, such as your username (optional)",
+            false,
+            None,
+        )?;
+        if !owner.is_empty() {
+            yaml.push_str(&format!("\nowners:\n  {}:\n", Self::quote(&owner)));
+            let ssh = utils::confirm(format!("Clone repos of {owner} by ssh"))?;
+            yaml.push_str(&format!("    ssh: {ssh}\n"));
+        }
+        Ok((yaml, login))
+    }
+
+    /// Return false if the config file exists and the user wants to keep it.
+    fn setup_file(dir: &PathBuf, name: &str) -> Result<bool> {
+        for ext in ["yml", "yaml"] {
+            let path = dir.join(format!("{name}.{ext}"));
+            if path.exists() {
+                return utils::confirm(format!("Overwrite {}", path.display()));
+            }
+        }
+        Ok(true)
+    }
+
+    fn write_config(dir: &PathBuf, name: &str, yaml: &str) -> Result<()> {
+        let mut path = dir.join(format!("{name}.yml"));
+        let yaml_path = dir.join(format!("{name}.yaml"));
+        if !path.exists() && yaml_path.exists() {
+            path = yaml_path;
+        }
+        utils::write_file(&path, yaml.as_bytes())?;
+        info!("Write {}", path.display());
+        Ok(())
+    }
+
+    /// The json string is also a valid yaml string.
+    fn quote(s: &str) -> String {
+        serde_json::to_string(s).unwrap()
+    }
+
+    fn escape_xml(s: &str) -> String {
+        s.replace('&', "&amp;")
+            .replace('<', "&lt;")
+            .replace('>', "&gt;")
+    }
+}
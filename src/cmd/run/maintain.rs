@@ -0,0 +1,220 @@
+use std::path::PathBuf;
+use std::sync::Mutex;
+
+use anyhow::{bail, Result};
+use clap::Args;
+
+use crate::cmd::Run;
+use crate::repo::budget;
+use crate::repo::database::Database;
+use crate::repo::job::{Job, JobKind, JobTask};
+use crate::repo::types::NameLevel;
+use crate::shell::Shell;
+use crate::utils::{self, Table};
+use crate::{config, confirm};
+
+/// Run git maintenance tasks (such as gc) for repos in parallel.
+#[derive(Args)]
+pub struct MaintainArgs {
+    /// The remote name or group (`@group`).
+    pub remote: Option<String>,
+
+    /// The repo query, format is `owner[/[name]]`.
+    pub query: Option<String>,
+
+    /// The git tasks to run, such as `gc --aggressive`, default will use the
+    /// `maintain_tasks` config.
+    #[clap(long, short)]
+    pub tasks: Vec<String>,
+
+    /// Register the repos with `git maintenance register`, and start the
+    /// scheduler with `git maintenance start`, so that git runs maintenance
+    /// for them periodically in background.
+    #[clap(long, short)]
+    pub register: bool,
+}
+
+struct MaintainResult {
+    before: u64,
+    after: u64,
+}
+
+impl Run for MaintainArgs {
+    fn run(&self) -> Result<()> {
+        // Maintenance might take a long time, don't block other commands.
+        let db = Database::read_only()?;
+        let (repos, level) = db.list_by_query(self.remote.as_deref(), self.query.as_deref())?;
+        if repos.is_empty() {
+            println!("No repo to maintain");
+            return Ok(());
+        }
+
+        let tasks = if self.tasks.is_empty() {
+            config::base().maintain_tasks.clone()
+        } else {
+            self.tasks.clone()
+        };
+        if tasks.is_empty() && !self.register {
+            bail!("No maintenance task to run, please check your config");
+        }
+        confirm!("Do you want to run maintenance for {} repos", repos.len());
+
+        let kind = JobKind::Maintain {
+            tasks,
+            register: self.register,
+        };
+        let tasks = repos.iter().map(|repo| JobTask::new(repo)).collect();
+        let job = Job::create(kind, tasks)?;
+        Self::run_job(job, level)
+    }
+}
+
+impl MaintainArgs {
+    /// Run maintenance for the repos of the job which are not done yet, the
+    /// job can be resumed by `roxide resume` if interrupted or failed.
+    pub fn run_job(job: Job, level: NameLevel) -> Result<()> {
+        let (tasks, register) = match &job.kind {
+            JobKind::Maintain { tasks, register } => (tasks.clone(), *register),
+            _ => bail!("Unexpected job kind {}", job.kind.as_str()),
+        };
+        let mut names = Vec::with_capacity(job.tasks.len());
+        let mut full_names = Vec::with_capacity(job.tasks.len());
+        let mut items: Vec<(usize, PathBuf)> = Vec::with_capacity(job.tasks.len());
+        let mut priorities = Vec::with_capacity(job.tasks.len());
+        for (idx, task) in job.tasks.iter().enumerate() {
+            if task.done {
+                continue;
+            }
+            let repo = task.to_repo();
+            names.push(repo.as_string(&level));
+            full_names.push(repo.full_name());
+            items.push((idx, repo.get_path()));
+            priorities.push(task.priority());
+        }
+
+        // The pinned and frequently used repos are maintained first. Mark the
+        // task done as soon as it completes, so that the progress is kept if
+        // the job is interrupted. Saving the progress is best-effort here,
+        // since the database might be locked by another roxide for a moment,
+        // the progress is saved again after the batch. When registering, the
+        // task is done after the repo is registered.
+        let job = Mutex::new(job);
+        let mut results = utils::batch_priority(
+            &items,
+            &priorities,
+            |(idx, dir)| -> Result<MaintainResult> {
+                let result = Self::maintain(dir, &tasks)?;
+                if !register {
+                    let _ = job.lock().unwrap().done(*idx);
+                }
+                Ok(result)
+            },
+        );
+        let mut job = job.into_inner().unwrap();
+
+        // Registering rewrites the global git config and the scheduler, so it
+        // cannot run in the batch workers concurrently.
+        if register {
+            let mut registered = None;
+            for ((_, dir), result) in items.iter().zip(results.iter_mut()) {
+                if result.is_err() {
+                    continue;
+                }
+                if let Err(err) = Self::register(dir) {
+                    *result = Err(err);
+                    continue;
+                }
+                registered = Some(dir);
+            }
+            if let Some(dir) = registered {
+                Self::start_scheduler(dir)?;
+            }
+        }
+        let mut db = Database::read()?;
+        for ((idx, _), result) in items.iter().zip(results.iter()) {
+            if result.is_ok() {
+                job.done_in(&mut db, *idx);
+            }
+        }
+        db.close()?;
+        job.finish()?;
+
+        let mut table = Table::with_capacity(1 + names.len());
+        table.add(vec![
+            String::from("REPO"),
+            String::from("BEFORE"),
+            String::from("AFTER"),
+            String::from("RECLAIMED"),
+        ]);
+        let mut total: u64 = 0;
+        let mut failed = Vec::new();
+        let mut maintained = Vec::with_capacity(full_names.len());
+        for ((name, full_name), result) in names.into_iter().zip(full_names).zip(results) {
+            let result = match result {
+                Ok(result) => result,
+                Err(err) => {
+                    failed.push((name, err));
+                    continue;
+                }
+            };
+            maintained.push(full_name);
+            let reclaimed = result.before.saturating_sub(result.after);
+            total += reclaimed;
+            table.add(vec![
+                name,
+                utils::human_bytes(result.before as f64),
+                utils::human_bytes(result.after as f64),
+                utils::human_bytes(reclaimed as f64),
+            ]);
+        }
+        table.show();
+        println!();
+        println!("Total reclaimed: {}", utils::human_bytes(total as f64));
+        // The sizes of the maintained repos are changed, refresh them.
+        if !maintained.is_empty() {
+            budget::check_all(&maintained);
+        }
+
+        if !failed.is_empty() {
+            println!();
+            for (name, err) in failed.iter() {
+                println!("Maintain {name} failed: {err:#}");
+            }
+            bail!(
+                "{} repos failed to maintain, use `roxide resume` to retry",
+                failed.len()
+            );
+        }
+        Ok(())
+    }
+
+    fn maintain(dir: &PathBuf, tasks: &[String]) -> Result<MaintainResult> {
+        let before = utils::dir_bytes(dir.clone())?;
+        let path = format!("{}", dir.display());
+        for task in tasks.iter() {
+            let mut args = vec!["-C", path.as_str()];
+            args.extend(task.split_whitespace());
+            Shell::git(&args).set_mute(true).execute()?.check()?;
+        }
+        let after = utils::dir_bytes(dir.clone())?;
+        Ok(MaintainResult { before, after })
+    }
+
+    fn register(dir: &PathBuf) -> Result<()> {
+        let path = format!("{}", dir.display());
+        Shell::git(&["-C", path.as_str(), "maintenance", "register"])
+            .set_mute(true)
+            .execute()?
+            .check()
+    }
+
+    /// The scheduler runs maintenance for all the registered repos, so it
+    /// only needs to be started once. The `start` needs to run in a repo.
+    fn start_scheduler(dir: &PathBuf) -> Result<()> {
+        let path = format!("{}", dir.display());
+        Shell::git(&["-C", path.as_str(), "maintenance", "start"])
+            .with_desc("Start git maintenance scheduler")
+            .execute()?
+            .check()
+    }
+}
@@ -1,13 +1,18 @@
+use std::fs;
+use std::io::ErrorKind;
+use std::path::PathBuf;
+
 use anyhow::bail;
-use anyhow::Result;
+use anyhow::{Context, Result};
 use clap::Args;
 
 use crate::api;
-use crate::api::types::MergeOptions;
+use crate::api::types::{MergeMethod, MergeOptions, Provider};
 use crate::cmd::Run;
 use crate::config;
+use crate::config::types::Remote;
 use crate::repo::database::Database;
-use crate::shell::{self, GitBranch, GitRemote};
+use crate::shell::{self, BranchStatus, GitBranch, GitRemote, Shell};
 use crate::utils;
 use crate::{confirm, info};
 
@@ -24,16 +29,60 @@ pub struct MergeArgs {
     /// If true, the cache will not be used when calling the API search.
     #[clap(long, short)]
     pub force: bool,
+
+    /// Copy the url to clipboard instead of opening it in browser.
+    #[clap(long, short)]
+    pub copy: bool,
+
+    /// Print the url instead of opening it in browser, for headless
+    /// environments and scripts.
+    #[clap(long)]
+    pub print: bool,
+
+    /// Create the merge as draft.
+    #[clap(long, short)]
+    pub draft: bool,
+
+    /// Mark the existing draft merge ready for review.
+    #[clap(long, short, conflicts_with = "draft")]
+    pub ready: bool,
+
+    /// The usernames to request review, default will use the owner's
+    /// `reviewers` config.
+    #[clap(long)]
+    pub reviewer: Vec<String>,
+
+    /// The usernames to assign, default will use the owner's `assignees`
+    /// config.
+    #[clap(long)]
+    pub assignee: Vec<String>,
+
+    /// The labels to add, default will use the owner's `labels` config.
+    #[clap(long)]
+    pub label: Vec<String>,
+
+    /// Merge the existing merge on remote. It is refused if the merge is draft,
+    /// has conflicts or its CI is not passed.
+    #[clap(long, conflicts_with_all = ["draft", "ready", "upstream"])]
+    pub remote: bool,
+
+    /// Used with `--remote`, the method to merge.
+    #[clap(long, value_enum, requires = "remote", default_value = "merge")]
+    pub method: MergeMethod,
+
+    /// Used with `--remote`, delete the source branch after merging.
+    #[clap(long, requires = "remote")]
+    pub delete_source: bool,
 }
 
 impl Run for MergeArgs {
     fn run(&self) -> Result<()> {
         shell::ensure_no_uncommitted()?;
-        let db = Database::read()?;
+        let mut db = Database::read()?;
         let repo = db.must_current()?;
 
         let remote = config::must_get_remote(repo.remote.as_str())?;
-        let provider = api::init_provider(&remote, self.force)?;
+        let provider = api::init_owner_provider(&remote, &repo.owner, self.force)?;
 
         info!("Get repo info from remote API");
         let mut api_repo = provider.get_repo(repo.owner.as_str(), repo.name.as_str())?;
@@ -56,11 +105,12 @@ impl Run for MergeArgs {
                     }
                     upstream.default_branch.clone()
                 }
-                None => GitBranch::default()?,
+                None => GitBranch::default_cached(&mut db)?,
             },
         };
+        db.close()?;
 
-        let source = GitBranch::current()?;
+        let source = GitBranch::current_or_create()?;
 
         if !self.upstream && target == source {
             bail!("Could not merge myself");
@@ -72,12 +122,30 @@ impl Run for MergeArgs {
             upstream: api_repo.upstream,
             source,
             target,
+            draft: self.draft,
+            reviewers: self.reviewer.clone(),
+            assignees: self.assignee.clone(),
+            labels: self.label.clone(),
         };
+        let merge = Self::with_owner_defaults(merge, &remote);
+
+        if self.remote {
+            return self.accept(provider.as_ref(), merge);
+        }
+
+        if self.ready {
+            info!("Call remote API to mark merge ready");
+            return match provider.ready_merge(merge)? {
+                Some(url) => self.open(&remote, &url),
+                None => bail!("Could not find merge to mark ready"),
+            };
+        }
+
+        Self::ensure_pushed(&merge.source)?;
 
         info!("Get merge info from remote API");
         if let Some(url) = provider.get_merge(merge.clone())? {
-            utils::open_url(&url)?;
-            return Ok(());
+            return self.open(&remote, &url);
         }
 
         let git_remote = if self.upstream {
@@ -85,7 +153,8 @@ impl Run for MergeArgs {
         } else {
             GitRemote::new()
         };
-        let commits = git_remote.commits_between(Some(&merge.target))?;
+        let target = git_remote.target(Some(&merge.target))?;
+        let commits = GitRemote::commits_between(&target)?;
         if commits.is_empty() {
             bail!("No commit to merge");
         }
@@ -101,16 +170,176 @@ impl Run for MergeArgs {
         println!("With {commit_desc}");
         confirm!("Continue");
         let title = utils::input("Please input title", true, init_title)?;
-        let body = if utils::confirm("Do you need body")? {
-            utils::edit("", ".md", true)?
-        } else {
-            String::new()
+        let body = match Self::get_template(&repo.get_path(), &remote)? {
+            Some(template) => utils::edit(template.as_str(), ".md", false)?,
+            None if utils::confirm("Do you need body")? => utils::edit("", ".md", true)?,
+            None => String::new(),
         };
 
         info!("Call remote API to create merge");
         let url = provider.create_merge(merge, title, body)?;
-        utils::open_url(&url)?;
+        self.open(&remote, &url)
+    }
+}
+
+impl MergeArgs {
+    fn accept(&self, provider: &dyn Provider, merge: MergeOptions) -> Result<()> {
+        info!("Get merge status from remote API");
+        let status = match provider.get_merge_status(merge.clone())? {
+            Some(status) => status,
+            None => bail!("Could not find merge to accept"),
+        };
+        if status.draft {
+            bail!("The merge is draft, please mark it ready first");
+        }
+        if status.conflicts {
+            bail!("The merge has conflicts, please resolve them first");
+        }
+        if let Some(false) = status.mergeable {
+            bail!("The merge is not mergeable now, please check it on remote");
+        }
+        match status.ci_status.as_deref() {
+            None | Some("success") => {}
+            Some(ci) => bail!("The CI status of merge is {ci}, it must be passed to merge"),
+        }
+
+        println!();
+        println!("About to merge: {}", merge.pretty_display());
+        println!("Title: {}", status.title);
+        println!("Approvals: {}", status.approvals);
+        confirm!("Do you want to merge it by {}", self.method.as_str());
 
-        Ok(())
+        info!("Call remote API to merge");
+        match provider.accept_merge(merge, self.method, self.delete_source)? {
+            Some(url) => {
+                info!("Merged {}", url);
+                Ok(())
+            }
+            None => bail!("Could not find merge to accept"),
+        }
+    }
+
+    /// The merge cannot be created if the source branch was never pushed, and
+    /// the unpushed commits won't be included. Offer to push it first.
+    fn ensure_pushed(source: &str) -> Result<()> {
+        let branch = match GitBranch::list()?
+            .into_iter()
+            .find(|branch| branch.name == source)
+        {
+            Some(branch) => branch,
+            None => return Ok(()),
+        };
+        match branch.status {
+            BranchStatus::Detached | BranchStatus::Gone => {
+                confirm!(
+                    "The branch {} has no upstream, do you want to push it",
+                    source
+                );
+                Shell::git(&["push", "--set-upstream", "origin", source])
+                    .execute()?
+                    .check()
+            }
+            BranchStatus::Ahead => {
+                if utils::confirm(format!(
+                    "The branch {source} has unpushed commits, do you want to push them"
+                ))? {
+                    Shell::git(&["push", "origin", source]).execute()?.check()?;
+                }
+                Ok(())
+            }
+            BranchStatus::Conflict => {
+                bail!("The branch {source} has diverged from its upstream, please sync it first")
+            }
+            _ => Ok(()),
+        }
+    }
+
+    /// Fill the empty reviewers, assignees and labels with the owner's config.
+    pub fn with_owner_defaults(mut merge: MergeOptions, remote: &Remote) -> MergeOptions {
+        let owner = match remote.owners.get(merge.owner.as_str()) {
+            Some(owner) => owner,
+            None => return merge,
+        };
+        let fill = |values: &mut Vec<String>, defaults: &Option<Vec<String>>| {
+            if values.is_empty() {
+                if let Some(defaults) = defaults {
+                    *values = defaults.clone();
+                }
+            }
+        };
+        fill(&mut merge.reviewers, &owner.reviewers);
+        fill(&mut merge.assignees, &owner.assignees);
+        fill(&mut merge.labels, &owner.labels);
+        merge
+    }
+
+    /// The merge template files in repository, see:
+    /// https://docs.github.com/en/communities/using-templates-to-encourage-useful-issues-and-pull-requests/creating-a-pull-request-template-for-your-repository
+    const TEMPLATE_FILES: [&str; 6] = [
+        ".github/PULL_REQUEST_TEMPLATE.md",
+        ".github/pull_request_template.md",
+        "PULL_REQUEST_TEMPLATE.md",
+        "pull_request_template.md",
+        "docs/PULL_REQUEST_TEMPLATE.md",
+        "docs/pull_request_template.md",
+    ];
+
+    /// Gitlab allows multiple templates in this directory, see:
+    /// https://docs.gitlab.com/ee/user/project/description_templates.html
+    const GITLAB_TEMPLATE_DIR: &str = ".gitlab/merge_request_templates";
+
+    pub fn get_template(root: &PathBuf, remote: &Remote) -> Result<Option<String>> {
+        for file in Self::TEMPLATE_FILES {
+            let path = root.join(file);
+            if path.is_file() {
+                let template = fs::read_to_string(&path)
+                    .with_context(|| format!("Read merge template {}", path.display()))?;
+                return Ok(Some(template));
+            }
+        }
+
+        let dir = root.join(Self::GITLAB_TEMPLATE_DIR);
+        let mut names = Vec::new();
+        match fs::read_dir(&dir) {
+            Ok(read_dir) => {
+                for item in read_dir {
+                    let item = item.with_context(|| format!("Read dir item {}", dir.display()))?;
+                    let name = item.file_name();
+                    let name = name.to_str().unwrap_or("");
+                    if name.ends_with(".md") {
+                        names.push(name.to_string());
+                    }
+                }
+            }
+            Err(err) if err.kind() == ErrorKind::NotFound => {}
+            Err(err) => {
+                return Err(err).with_context(|| format!("Read dir {}", dir.display()));
+            }
+        }
+        if !names.is_empty() {
+            names.sort();
+            let idx = if names.len() == 1 {
+                0
+            } else {
+                shell::search(&names)?
+            };
+            let path = dir.join(&names[idx]);
+            let template = fs::read_to_string(&path)
+                .with_context(|| format!("Read merge template {}", path.display()))?;
+            return Ok(Some(template));
+        }
+
+        Ok(remote.merge_template.clone())
+    }
+
+    fn open(&self, remote: &Remote, url: &str) -> Result<()> {
+        if self.print {
+            println!("{url}");
+            return Ok(());
+        }
+        if self.copy {
+            return utils::copy_to_clipboard(url);
+        }
+        utils::open_url(remote.browser(), url)
     }
 }
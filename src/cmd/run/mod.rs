@@ -1,15 +1,39 @@
 pub mod attach;
+pub mod auth;
 pub mod branch;
+pub mod cache;
+pub mod check;
+pub mod code;
+pub mod commit;
 pub mod complete;
 pub mod config;
+pub mod create;
 pub mod detach;
+pub mod dirty;
+pub mod env;
+pub mod exec;
 pub mod get;
+pub mod grep;
 pub mod home;
 pub mod init;
+pub mod maintain;
 pub mod merge;
+pub mod mv;
 pub mod open;
+pub mod patch;
+pub mod pin;
+pub mod prompt;
 pub mod rebase;
 pub mod release;
+pub mod remote;
 pub mod remove;
+pub mod resume;
+pub mod snippet;
 pub mod squash;
+pub mod sub;
+pub mod suggest_prune;
+pub mod sync;
 pub mod tag;
+pub mod tmux;
+pub mod version;
+pub mod which_repo;
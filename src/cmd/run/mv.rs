@@ -0,0 +1,155 @@
+use std::fs;
+use std::io::ErrorKind;
+use std::rc::Rc;
+
+use anyhow::{bail, Context, Result};
+use clap::Args;
+
+use crate::cmd::Run;
+use crate::repo::database::Database;
+use crate::repo::event::{self, Event};
+use crate::shell::Shell;
+use crate::{api, config, confirm, info, utils};
+
+/// Move current repo to another remote or owner.
+#[derive(Args)]
+pub struct MoveArgs {
+    /// The target remote name.
+    pub remote: Option<String>,
+
+    /// The target query, format is `owner[/[name]]`. If name is omitted, keep
+    /// the current name.
+    pub query: Option<String>,
+
+    /// Detect the repo name from remote API. If the repo was renamed or
+    /// transferred on the remote, move it to the new owner and name.
+    #[clap(long, short)]
+    pub sync: bool,
+
+    /// If true, the cache will not be used when calling the API.
+    #[clap(long, short)]
+    pub force: bool,
+}
+
+impl Run for MoveArgs {
+    fn run(&self) -> Result<()> {
+        let mut db = Database::read()?;
+        let repo = db.must_current()?;
+
+        let (remote, owner, name) = if self.sync {
+            let remote = config::must_get_remote(repo.remote.as_str())?;
+            let provider = api::init_owner_provider(&remote, &repo.owner, self.force)?;
+
+            info!("Get repo info from remote API");
+            let api_repo = provider.get_repo(repo.owner.as_str(), repo.name.as_str())?;
+            if !api_repo.is_renamed(repo.owner.as_str(), repo.name.as_str()) {
+                info!("The repo {} was not renamed", repo.long_name());
+                return Ok(());
+            }
+            // Keep the local owner if only its case is different.
+            let owner = if api_repo.owner.eq_ignore_ascii_case(repo.owner.as_str()) {
+                repo.owner.to_string()
+            } else {
+                api_repo.owner
+            };
+            info!(
+                "The repo {} was renamed to {}/{} on the remote",
+                repo.long_name(),
+                owner,
+                api_repo.name
+            );
+            (remote, owner, api_repo.name)
+        } else {
+            let (remote_name, query) = match (&self.remote, &self.query) {
+                (Some(remote), Some(query)) => (remote, query),
+                _ => bail!("Please provide target remote and query, or use `--sync`"),
+            };
+            let remote = config::must_get_remote(remote_name)?;
+            let (owner, mut name) = match query.strip_suffix("/") {
+                Some(owner) => (remote.resolve_owner(owner).to_string(), String::new()),
+                None => utils::parse_query(&remote, query),
+            };
+            if owner.is_empty() {
+                bail!("Invalid query {}, you should provide owner", query);
+            }
+            if name.is_empty() {
+                name = format!("{}", repo.name);
+            }
+            (remote, owner, name)
+        };
+
+        if let Some(found) = db.get(remote.name.as_str(), owner.as_str(), name.as_str()) {
+            bail!(
+                "The repo {} already exists in {}",
+                found.long_name(),
+                found.get_path().display()
+            );
+        }
+
+        let target = repo.moved(&remote.name, &owner, &name, repo.path.clone());
+        confirm!(
+            "Do you want to move {} to {}",
+            repo.full_name(),
+            target.full_name()
+        );
+
+        let src = repo.get_path();
+        let dst = target.get_path();
+        if src != dst {
+            match fs::read_dir(&dst) {
+                Ok(_) => bail!("The target directory {} already exists", dst.display()),
+                Err(err) if err.kind() == ErrorKind::NotFound => {}
+                Err(err) => {
+                    return Err(err).with_context(|| format!("Read directory {}", dst.display()))
+                }
+            }
+            utils::ensure_dir(&dst)?;
+            info!("Move dir {} to {}", src.display(), dst.display());
+            fs::rename(&src, &dst).context("Move directory")?;
+            utils::remove_empty_parents(&src)?;
+        }
+
+        let path = format!("{}", dst.display());
+        if let Some(_) = remote.clone {
+            let url = target.clone_url(&remote);
+            Shell::git(&[
+                "-C",
+                path.as_str(),
+                "remote",
+                "set-url",
+                "origin",
+                url.as_str(),
+            ])
+            .with_desc(format!("Set origin to {}", url))
+            .execute()?
+            .check()?;
+        }
+        if let Some(user) = &remote.user {
+            Shell::git(&["-C", path.as_str(), "config", "user.name", user.as_str()])
+                .with_desc(format!("Set user to {}", user))
+                .execute()?
+                .check()?;
+        }
+        if let Some(email) = &remote.email {
+            Shell::git(&["-C", path.as_str(), "config", "user.email", email.as_str()])
+                .with_desc(format!("Set email to {}", email))
+                .execute()?
+                .check()?;
+        }
+
+        db.move_subprojects(&repo, &target);
+        db.remove(Rc::clone(&repo));
+        db.update(Rc::clone(&target));
+        db.close()?;
+
+        event::emit(Event::RepoMoved {
+            from: &repo.full_name(),
+            to: &target.full_name(),
+            path: &path,
+        });
+        // The current directory is moved, print the new path so that the shell
+        // function can change to it.
+        println!("{}", dst.display());
+        Ok(())
+    }
+}
@@ -1,42 +1,224 @@
 use std::path::PathBuf;
+use std::rc::Rc;
 
-use anyhow::Result;
+use anyhow::{bail, Result};
 use clap::Args;
 
+use crate::api::types::Provider;
 use crate::cmd::Run;
+use crate::config::types::Remote;
 use crate::repo::database::Database;
+use crate::repo::types::{NameLevel, Repo};
 use crate::shell::GitBranch;
-use crate::{api, config, utils};
+use crate::utils::Table;
+use crate::{api, config, info, shell, utils};
 
-/// Open current repository in default browser
+/// Open a repository in default browser, default is current repository.
 #[derive(Args)]
 pub struct OpenArgs {
+    /// The repo query, format is `[remote] [owner[/[name]]]`. If omitted, open
+    /// current repository. This will not change the current directory.
+    pub query: Vec<String>,
+
     /// Open current branch
     #[clap(long, short)]
     pub branch: bool,
 
+    /// If true, use search instead of fuzzy matching.
+    #[clap(long, short)]
+    pub search: bool,
+
     /// If true, the cache will not be used when calling the API search.
     #[clap(long, short)]
     pub force: bool,
+
+    /// Copy the url to clipboard instead of opening it in browser.
+    #[clap(long, short)]
+    pub copy: bool,
+
+    /// Print the url instead of opening it in browser, for headless
+    /// environments and scripts.
+    #[clap(long, short)]
+    pub print: bool,
+
+    /// List the latest N workflow runs (or pipelines for Gitlab) of the repo,
+    /// default is 10, and open the selected one. Use with `--branch` to only
+    /// list the runs of current branch.
+    #[clap(long, short, num_args = 0..=1, default_missing_value = "10")]
+    pub runs: Option<u32>,
 }
 
 impl Run for OpenArgs {
     fn run(&self) -> Result<()> {
         let db = Database::read()?;
-        let repo = db.must_current()?;
+        let repo = if self.query.is_empty() {
+            db.must_current()?
+        } else {
+            if self.branch {
+                bail!("The `--branch` flag can only be used for current repository");
+            }
+            self.query_repo(&db)?
+        };
         let remote = config::must_get_remote(repo.remote.as_str())?;
 
-        let provider = api::init_provider(&remote, self.force)?;
+        let provider = api::init_owner_provider(&remote, &repo.owner, self.force)?;
+        if let Some(limit) = self.runs {
+            return self.open_runs(&remote, provider.as_ref(), &repo, limit);
+        }
 
         let api_repo = provider.get_repo(&repo.owner, &repo.name)?;
+        if api_repo.is_renamed(repo.owner.as_str(), repo.name.as_str()) {
+            info!(
+                "The repo was renamed to {}/{} on the remote, use `roxide move --sync` to rename it locally",
+                api_repo.owner,
+                api_repo.name
+            );
+        }
         let mut url = api_repo.web_url;
 
         if self.branch {
-            let branch = GitBranch::current()?;
+            let branch = GitBranch::must_current()?;
             let path = PathBuf::from(url).join("tree").join(branch);
             url = format!("{}", path.display());
         }
 
-        utils::open_url(&url)
+        self.open(&remote, &url)
+    }
+}
+
+impl OpenArgs {
+    const PREFETCH_COUNT: usize = 3;
+
+    fn open_runs(
+        &self,
+        remote: &Remote,
+        provider: &dyn Provider,
+        repo: &Repo,
+        limit: u32,
+    ) -> Result<()> {
+        let branch = if self.branch {
+            Some(GitBranch::must_current()?)
+        } else {
+            None
+        };
+        info!("Call remote API to list runs");
+        let actions = provider.list_actions(
+            &repo.owner,
+            &repo.name,
+            branch.as_ref().map(|branch| branch.as_str()),
+            limit,
+        )?;
+        if actions.is_empty() {
+            bail!("No run found for {}", repo.long_name());
+        }
+
+        let mut table = Table::with_capacity(actions.len());
+        for action in actions.iter() {
+            let duration = match action.duration {
+                Some(duration) => format_duration(duration),
+                None => String::from("-"),
+            };
+            table.add(vec![
+                action.status.clone(),
+                action.name.clone(),
+                action.branch.clone(),
+                action.trigger.clone(),
+                duration,
+                utils::format_since(action.created_at),
+            ]);
+        }
+        let items = table.lines();
+        let idx = shell::search(&items)?;
+        self.open(remote, &actions[idx].web_url)
+    }
+
+    fn open(&self, remote: &Remote, url: &str) -> Result<()> {
+        if self.print {
+            println!("{url}");
+            return Ok(());
+        }
+        if self.copy {
+            return utils::copy_to_clipboard(url);
+        }
+        utils::open_url(remote.browser(), url)
+    }
+
+    fn query_repo(&self, db: &Database) -> Result<Rc<Repo>> {
+        let maybe_remote = &self.query[0];
+        if self.query.len() == 1 {
+            return match config::get_remote(maybe_remote)? {
+                Some(remote) if self.search => self.search_local(
+                    db.filter_ignored(db.list_by_remote(&remote.name)),
+                    NameLevel::Owner,
+                ),
+                Some(remote) => db.must_latest(&remote.name),
+                None => db.must_get_fuzzy("", maybe_remote),
+            };
+        }
+
+        let remote = config::must_get_remote(maybe_remote)?;
+        let query = &self.query[1];
+        if query.ends_with("/") {
+            let owner = remote.resolve_owner(query);
+            let repos = db.filter_ignored(db.list_by_owner(remote.name.as_str(), owner));
+            if repos.is_empty() {
+                bail!("No repo under {}:{}", remote.name, owner);
+            }
+            return self.search_local(repos, NameLevel::Name);
+        }
+
+        let (owner, name) = utils::parse_query(&remote, query);
+        if owner.is_empty() {
+            return db.must_get_fuzzy(&remote.name, &name);
+        }
+        // The repo to open is not required to be tracked, the web url will
+        // be fetched from remote API.
+        match db.get(remote.name.as_str(), owner.as_str(), name.as_str()) {
+            Some(repo) => Ok(repo),
+            None => Ok(Repo::new(&remote.name, &owner, &name, None)),
+        }
+    }
+
+    fn search_local(&self, vec: Vec<Rc<Repo>>, level: NameLevel) -> Result<Rc<Repo>> {
+        // The repos are sorted by score, prefetch the top ones while user is
+        // selecting, so that the api call after selection is instant.
+        let prefetch = if self.force {
+            None
+        } else {
+            let repos = vec
+                .iter()
+                .take(Self::PREFETCH_COUNT)
+                .map(|repo| {
+                    (
+                        format!("{}", repo.remote),
+                        format!("{}", repo.owner),
+                        format!("{}", repo.name),
+                    )
+                })
+                .collect();
+            Some(api::prefetch_repos(repos))
+        };
+
+        let items = vec.iter().map(|repo| repo.as_string(&level));
+        let result = shell::search_iter(items);
+        if let Some(prefetch) = prefetch {
+            let _ = prefetch.join();
+        }
+        let idx = result?;
+        Ok(Rc::clone(&vec[idx]))
+    }
+}
+
+fn format_duration(secs: u64) -> String {
+    if secs < utils::MINUTE {
+        return format!("{secs}s");
+    }
+    if secs < utils::HOUR {
+        return format!("{}m{}s", secs / utils::MINUTE, secs % utils::MINUTE);
     }
+    format!(
+        "{}h{}m",
+        secs / utils::HOUR,
+        (secs % utils::HOUR) / utils::MINUTE
+    )
 }
@@ -0,0 +1,174 @@
+use std::fs;
+use std::io::ErrorKind;
+use std::path::PathBuf;
+
+use anyhow::{bail, Context, Result};
+use clap::{Args, Subcommand};
+use console::style;
+
+use crate::cmd::run::home::HomeArgs;
+use crate::cmd::Run;
+use crate::repo::database::Database;
+use crate::shell::{self, GitBranch, GitRemote, Shell};
+use crate::{api, config, confirm, info};
+
+/// Move commits between repos by patch files.
+#[derive(Args)]
+pub struct PatchArgs {
+    #[command(subcommand)]
+    pub command: PatchCommands,
+}
+
+#[derive(Subcommand)]
+pub enum PatchCommands {
+    /// Export the commits not on target branch as patch files.
+    Export(PatchExportArgs),
+    /// Apply the exported patch files to another repo.
+    Apply(PatchApplyArgs),
+}
+
+#[derive(Args)]
+pub struct PatchExportArgs {
+    /// The target branch (optional), default will use HEAD branch.
+    pub target: Option<String>,
+
+    /// Upstream mode, only used for forked repo.
+    #[clap(long, short)]
+    pub upstream: bool,
+
+    /// If true, the cache will not be used when calling the API search.
+    #[clap(long, short)]
+    pub force: bool,
+}
+
+#[derive(Args)]
+pub struct PatchApplyArgs {
+    #[clap(flatten)]
+    pub home: HomeArgs,
+}
+
+impl Run for PatchArgs {
+    fn run(&self) -> Result<()> {
+        match &self.command {
+            PatchCommands::Export(args) => args.run(),
+            PatchCommands::Apply(args) => args.run(),
+        }
+    }
+}
+
+impl Run for PatchExportArgs {
+    fn run(&self) -> Result<()> {
+        shell::ensure_no_uncommitted()?;
+        let remote = if self.upstream {
+            let db = Database::read()?;
+            let repo = db.must_current()?;
+            let remote = config::must_get_remote(repo.remote.as_str())?;
+            let provider = api::init_owner_provider(&remote, &repo.owner, self.force)?;
+
+            GitRemote::from_upstream(&remote, &repo, &provider)?
+        } else {
+            GitRemote::new()
+        };
+
+        let default;
+        let branch = match &self.target {
+            Some(target) => Some(target.as_str()),
+            None if !self.upstream => {
+                default = GitBranch::default_fast()?;
+                Some(default.as_str())
+            }
+            None => None,
+        };
+        let target = remote.target(branch)?;
+        let commits = GitRemote::commits_between(&target)?;
+        if commits.is_empty() {
+            println!("No commit to export");
+            return Ok(());
+        }
+        println!();
+        println!("Found {} commits ahead:", style(commits.len()).yellow());
+        for commit in commits.iter() {
+            println!("  * {}", commit);
+        }
+        confirm!("Continue");
+
+        // Only keep the latest exported patches, so that `apply` knows which
+        // ones to use.
+        let dir = patch_dir();
+        clear_patches(&dir)?;
+        let path = format!("{}", dir.display());
+        // Use the same range as listing the commits, `HEAD~n` is a different
+        // set of commits if there are merges.
+        let range = format!("{target}..HEAD");
+        Shell::git(&["format-patch", "-o", path.as_str(), range.as_str()])
+            .with_desc(format!("Export {} patches", commits.len()))
+            .execute()?
+            .check()?;
+        info!("Export patches to {}", dir.display());
+        Ok(())
+    }
+}
+
+impl Run for PatchApplyArgs {
+    fn run(&self) -> Result<()> {
+        let patches = list_patches(&patch_dir())?;
+        if patches.is_empty() {
+            bail!("No patch to apply, please use `roxide patch export` first");
+        }
+        let (repo, dir) = self.home.enter()?;
+        confirm!(
+            "Do you want to apply {} patches to {}",
+            patches.len(),
+            repo.long_name()
+        );
+
+        let path = format!("{}", dir.display());
+        let mut args = vec!["-C", path.as_str(), "am", "--3way"];
+        let patch_paths: Vec<String> = patches
+            .iter()
+            .map(|patch| format!("{}", patch.display()))
+            .collect();
+        args.extend(patch_paths.iter().map(|patch| patch.as_str()));
+        Shell::git(&args)
+            .with_desc(format!("Apply {} patches", patches.len()))
+            .execute()?
+            .check()?;
+
+        clear_patches(&patch_dir())?;
+        info!("Apply patches to {} done", repo.long_name());
+        Ok(())
+    }
+}
+
+fn patch_dir() -> PathBuf {
+    PathBuf::from(&config::base().metadir).join("patches")
+}
+
+/// List the patch files in dir, sorted by name, which is the order of commits.
+fn list_patches(dir: &PathBuf) -> Result<Vec<PathBuf>> {
+    let entries = match fs::read_dir(dir) {
+        Ok(entries) => entries,
+        Err(err) if err.kind() == ErrorKind::NotFound => return Ok(Vec::new()),
+        Err(err) => return Err(err).with_context(|| format!("Read dir {}", dir.display())),
+    };
+    let mut patches = Vec::new();
+    for entry in entries {
+        let entry = entry.with_context(|| format!("Read dir entry {}", dir.display()))?;
+        let path = entry.path();
+        if let Some(ext) = path.extension() {
+            if ext == "patch" {
+                patches.push(path);
+            }
+        }
+    }
+    patches.sort();
+    Ok(patches)
+}
+
+fn clear_patches(dir: &PathBuf) -> Result<()> {
+    for patch in list_patches(dir)? {
+        fs::remove_file(&patch)
+            .with_context(|| format!("Remove patch file {}", patch.display()))?;
+    }
+    Ok(())
+}
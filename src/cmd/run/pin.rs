@@ -0,0 +1,81 @@
+use anyhow::Result;
+use clap::Args;
+
+use crate::cmd::Run;
+use crate::repo::database::Database;
+use crate::repo::types::Repo;
+use crate::{config, info, utils};
+
+/// Pin current repo. Pinned repo could not be removed, and is ranked above
+/// others in fuzzy matching and searching.
+#[derive(Args)]
+pub struct PinArgs {
+    /// Unpin current repo.
+    #[clap(long, short)]
+    pub unpin: bool,
+
+    /// Instead of pinning, boost current repo for a while, such as `7d`,
+    /// `12h`. The boosted repo is ranked above others (but below pinned
+    /// ones), the boost expires automatically. Useful for a temporary
+    /// context switch.
+    #[clap(long, short, conflicts_with_all = ["unpin", "unboost"])]
+    pub boost: Option<String>,
+
+    /// Remove the boost of current repo.
+    #[clap(long, conflicts_with = "unpin")]
+    pub unboost: bool,
+}
+
+impl Run for PinArgs {
+    fn run(&self) -> Result<()> {
+        let mut db = Database::read()?;
+        let repo = db.must_current()?;
+
+        if let Some(duration) = &self.boost {
+            let duration = utils::parse_duration(duration)?;
+            return Self::boost(db, &repo, config::now_secs() + duration);
+        }
+        if self.unboost {
+            if !repo.boosted() {
+                info!("The repo {} is not boosted", repo.long_name());
+                return Ok(());
+            }
+            return Self::boost(db, &repo, 0);
+        }
+
+        let pinned = !self.unpin;
+        if repo.pinned == pinned {
+            info!(
+                "The repo {} is already {}",
+                repo.long_name(),
+                if pinned { "pinned" } else { "unpinned" }
+            );
+            return Ok(());
+        }
+
+        db.pin(repo.as_ref(), pinned);
+        if pinned {
+            info!("Pin repo {}", repo.long_name());
+        } else {
+            info!("Unpin repo {}", repo.long_name());
+        }
+
+        db.close()
+    }
+}
+
+impl PinArgs {
+    fn boost(mut db: Database, repo: &Repo, until: u64) -> Result<()> {
+        db.boost(repo, until);
+        if until > 0 {
+            info!(
+                "Boost repo {} until {}",
+                repo.long_name(),
+                utils::format_time(until)?
+            );
+        } else {
+            info!("Unboost repo {}", repo.long_name());
+        }
+        db.close()
+    }
+}
@@ -0,0 +1,335 @@
+use std::fs;
+use std::path::PathBuf;
+use std::process::{Command, Stdio};
+
+use anyhow::{Context, Result};
+use clap::Args;
+use serde::Serialize;
+use serde_json::Value;
+use sha2::{Digest, Sha256};
+
+use crate::cmd::Run;
+use crate::repo::database::Database;
+use crate::repo::types::Repo;
+use crate::{config, shell, utils};
+
+/// Print the info of the repo in current directory in one line, for embedding
+/// in shell prompt (such as starship or PS1). Nothing is printed if not in a
+/// repo, and errors are ignored, so that the prompt is never broken. Use
+/// `--json` or `--porcelain` for the stable output consumed by other tools
+/// (such as editors and status bars).
+#[derive(Args)]
+pub struct PromptArgs {
+    /// The output template, supports placeholders `{remote}`, `{owner}`,
+    /// `{name}`, `{branch}`, `{ahead}`, `{behind}` and `{pin}`. Default is
+    /// `key=value` pairs separated by space.
+    #[clap(long, short)]
+    pub format: Option<String>,
+
+    /// Don't compute the ahead and behind counts. The counts are cached, but
+    /// git is called to refresh them after the branch or its upstream moved.
+    #[clap(long, short)]
+    pub quick: bool,
+
+    /// Output in json format. If not in a repo, print `null`. The fields are
+    /// stable, new fields may be added, but existing ones won't be changed.
+    #[clap(long)]
+    pub json: bool,
+
+    /// Output in `key=value` format, one field per line, the keys are the
+    /// same as `--json`. The format is stable like `--json`.
+    #[clap(long)]
+    pub porcelain: bool,
+}
+
+struct PromptInfo {
+    branch: String,
+    detached: bool,
+    ahead: usize,
+    behind: usize,
+}
+
+/// The output contract of `--json` and `--porcelain`, keep the fields stable.
+#[derive(Serialize)]
+struct PorcelainInfo {
+    repo: String,
+    remote: String,
+    owner: String,
+    name: String,
+    path: String,
+    workspace: bool,
+    branch: String,
+    detached: bool,
+    ahead: usize,
+    behind: usize,
+    pinned: bool,
+}
+
+impl Run for PromptArgs {
+    fn run(&self) -> Result<()> {
+        // The database is read without lock, the prompt should not wait for
+        // other commands.
+        let db = match Database::read_only() {
+            Ok(db) => db,
+            Err(_) => return Ok(()),
+        };
+        let repo = match db.get_by_dir(config::current_dir()) {
+            Some(repo) => repo,
+            None if self.json => {
+                println!("null");
+                return Ok(());
+            }
+            None => return Ok(()),
+        };
+        let info = self.get_info(&repo);
+        if self.json || self.porcelain {
+            return self.show_porcelain(&repo, info);
+        }
+
+        let pin = if repo.pinned { "pinned" } else { "" };
+        let line = match &self.format {
+            Some(format) => repo
+                .render(format)
+                .replace("{branch}", &info.branch)
+                .replace("{ahead}", &format!("{}", info.ahead))
+                .replace("{behind}", &format!("{}", info.behind))
+                .replace("{pin}", pin),
+            None => format!(
+                "repo={} branch={} ahead={} behind={} pinned={}",
+                repo.full_name(),
+                info.branch,
+                info.ahead,
+                info.behind,
+                repo.pinned
+            ),
+        };
+        println!("{line}");
+        Ok(())
+    }
+}
+
+impl PromptArgs {
+    fn get_info(&self, repo: &Repo) -> PromptInfo {
+        let dir = repo.get_path();
+        let (branch, detached) = Self::read_head(&dir).unwrap_or_default();
+        let (ahead, behind) = if self.quick || detached || branch.is_empty() {
+            (0, 0)
+        } else {
+            Self::get_counts(&dir, &branch).unwrap_or_default()
+        };
+        PromptInfo {
+            branch,
+            detached,
+            ahead,
+            behind,
+        }
+    }
+
+    fn show_porcelain(&self, repo: &Repo, info: PromptInfo) -> Result<()> {
+        let porcelain = PorcelainInfo {
+            repo: repo.full_name(),
+            remote: repo.remote.to_string(),
+            owner: repo.owner.to_string(),
+            name: repo.name.to_string(),
+            path: format!("{}", repo.get_path().display()),
+            workspace: repo.path.is_none(),
+            branch: info.branch,
+            detached: info.detached,
+            ahead: info.ahead,
+            behind: info.behind,
+            pinned: repo.pinned,
+        };
+        if self.json {
+            let json = serde_json::to_string(&porcelain).context("Encode porcelain json")?;
+            println!("{json}");
+            return Ok(());
+        }
+        // Reuse the json encoding to keep the keys same as `--json`.
+        let value = serde_json::to_value(&porcelain).context("Encode porcelain")?;
+        if let Value::Object(map) = value {
+            for (key, value) in map {
+                match value {
+                    Value::String(s) => println!("{key}={s}"),
+                    value => println!("{key}={value}"),
+                }
+            }
+        }
+        Ok(())
+    }
+
+    /// Read the current branch from `.git/HEAD` directly, which is much faster
+    /// than calling git. For detached HEAD, return the short commit id and
+    /// true. Return None if the `.git` is not a directory (such as worktree).
+    fn read_head(dir: &PathBuf) -> Option<(String, bool)> {
+        let head = fs::read_to_string(dir.join(".git").join("HEAD")).ok()?;
+        let head = head.trim();
+        match head.strip_prefix("ref: refs/heads/") {
+            Some(branch) => Some((branch.to_string(), false)),
+            None => Some((head.chars().take(7).collect(), true)),
+        }
+    }
+
+    /// Get the ahead and behind counts of the branch. The counts are cached
+    /// with the commits of the branch and its upstream, which are read from
+    /// the ref files directly. So git is only called after the branch or its
+    /// upstream moved, such as committing or fetching.
+    fn get_counts(dir: &PathBuf, branch: &str) -> Option<(usize, usize)> {
+        let git_dir = dir.join(".git");
+        let config = fs::read_to_string(git_dir.join("config")).ok()?;
+        let upstream = parse_upstream(&config, branch)?;
+        let head = Self::resolve_ref(&git_dir, &format!("refs/heads/{branch}"))?;
+        let upstream = Self::resolve_ref(&git_dir, &upstream)?;
+        let key = format!("{head} {upstream}");
+
+        let path = Self::cache_path(dir);
+        if let Ok(cache) = fs::read_to_string(&path) {
+            if let Some(counts) = parse_cache(&cache, &key) {
+                return Some(counts);
+            }
+        }
+
+        let (ahead, behind) = Self::count_ahead_behind(dir)?;
+        // The cache is best-effort, the prompt should not be broken.
+        let _ = utils::write_file(&path, format!("{key} {ahead} {behind}").as_bytes());
+        Some((ahead, behind))
+    }
+
+    /// Resolve the ref to commit id, from the loose ref file or packed-refs.
+    fn resolve_ref(git_dir: &PathBuf, name: &str) -> Option<String> {
+        if let Ok(commit) = fs::read_to_string(git_dir.join(name)) {
+            return Some(commit.trim().to_string());
+        }
+        let packed = fs::read_to_string(git_dir.join("packed-refs")).ok()?;
+        packed.lines().find_map(|line| {
+            let (commit, ref_name) = line.split_once(' ')?;
+            if ref_name == name {
+                return Some(commit.to_string());
+            }
+            None
+        })
+    }
+
+    fn cache_path(dir: &PathBuf) -> PathBuf {
+        let hash = Sha256::digest(format!("{}", dir.display()).as_bytes());
+        PathBuf::from(&config::base().statedir)
+            .join("prompt")
+            .join(format!("{hash:x}"))
+    }
+
+    fn count_ahead_behind(dir: &PathBuf) -> Option<(usize, usize)> {
+        // The git error (such as no upstream) should not be printed to the
+        // prompt, so the shell is not used here.
+        let output = Command::new("git")
+            .arg("-C")
+            .arg(dir)
+            .args(["rev-list", "--left-right", "--count", "HEAD...@{upstream}"])
+            .stderr(Stdio::null())
+            .output()
+            .ok()?;
+        if !output.status.success() {
+            return None;
+        }
+        let output = String::from_utf8(output.stdout).ok()?;
+        shell::parse_counts(&output).ok()
+    }
+}
+
+/// Parse the upstream ref of the branch from the git config, such as
+/// `refs/remotes/origin/main`, from the section:
+///
+/// ```text
+/// [branch "main"]
+///     remote = origin
+///     merge = refs/heads/main
+/// ```
+fn parse_upstream(config: &str, branch: &str) -> Option<String> {
+    let section = format!("[branch \"{branch}\"]");
+    let mut in_section = false;
+    let mut remote = None;
+    let mut merge = None;
+    for line in config.lines() {
+        let line = line.trim();
+        if line.starts_with('[') {
+            in_section = line == section;
+            continue;
+        }
+        if !in_section {
+            continue;
+        }
+        let (key, value) = match line.split_once('=') {
+            Some((key, value)) => (key.trim().to_lowercase(), value.trim()),
+            None => continue,
+        };
+        match key.as_str() {
+            "remote" => remote = Some(value),
+            "merge" => merge = Some(value),
+            _ => {}
+        }
+    }
+    let merge = merge?;
+    match remote? {
+        // The upstream is a local branch.
+        "." => Some(merge.to_string()),
+        remote => {
+            let name = merge.strip_prefix("refs/heads/")?;
+            Some(format!("refs/remotes/{remote}/{name}"))
+        }
+    }
+}
+
+/// Parse the cache `<head> <upstream> <ahead> <behind>`, return the counts if
+/// the commits are the same as `key`.
+fn parse_cache(cache: &str, key: &str) -> Option<(usize, usize)> {
+    let counts = cache.trim().strip_prefix(key)?;
+    let (ahead, behind) = counts.trim().split_once(' ')?;
+    Some((ahead.parse().ok()?, behind.parse().ok()?))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse_upstream() {
+        let config = r#"
+[core]
+	bare = false
+[remote "origin"]
+	url = git@github.com:fioncat/roxide.git
+	fetch = +refs/heads/*:refs/remotes/origin/*
+[branch "main"]
+	remote = origin
+	merge = refs/heads/main
+[branch "feature/x"]
+	remote = upstream
+	merge = refs/heads/dev
+[branch "local"]
+	remote = .
+	merge = refs/heads/main
+[branch "none"]
+	rebase = true
+"#;
+        let cases = [
+            ("main", Some("refs/remotes/origin/main")),
+            ("feature/x", Some("refs/remotes/upstream/dev")),
+            ("local", Some("refs/heads/main")),
+            ("none", None),
+            ("missing", None),
+        ];
+        for (branch, expect) in cases {
+            assert_eq!(
+                parse_upstream(config, branch).as_deref(),
+                expect,
+                "branch {branch}"
+            );
+        }
+    }
+
+    #[test]
+    fn test_parse_cache() {
+        assert_eq!(parse_cache("a1 b2 3 4\n", "a1 b2"), Some((3, 4)));
+        assert_eq!(parse_cache("a1 b2 3 4", "a1 b3"), None);
+        assert_eq!(parse_cache("a1 b2 3", "a1 b2"), None);
+        assert_eq!(parse_cache("", "a1 b2"), None);
+    }
+}
@@ -1,11 +1,11 @@
-use anyhow::Result;
+use anyhow::{bail, Result};
 use clap::Args;
 
 use crate::api;
 use crate::cmd::Run;
 use crate::config;
 use crate::repo::database::Database;
-use crate::shell::{self, GitRemote, Shell};
+use crate::shell::{self, GitBranch, GitRemote, Shell};
 
 /// Rebase current branch
 #[derive(Args)]
@@ -20,33 +20,61 @@ pub struct RebaseArgs {
     /// If true, the cache will not be used when calling the API search.
     #[clap(long, short)]
     pub force: bool,
+
+    /// Abort the rebase if there are conflicts, so that the branch is left
+    /// unchanged. This is useful in scripts.
+    #[clap(long)]
+    pub abort: bool,
 }
 
 impl Run for RebaseArgs {
     fn run(&self) -> Result<()> {
         shell::ensure_no_uncommitted()?;
+        // Rebasing in detached HEAD would leave the result on no branch.
+        GitBranch::current_or_create()?;
         let remote = if self.upstream {
             let db = Database::read()?;
             let repo = db.must_current()?;
             let remote = config::must_get_remote(repo.remote.as_str())?;
-            let provider = api::init_provider(&remote, self.force)?;
+            let provider = api::init_owner_provider(&remote, &repo.owner, self.force)?;
 
             GitRemote::from_upstream(&remote, &repo, &provider)?
         } else {
             GitRemote::new()
         };
 
+        let default;
         let branch = match &self.target {
             Some(target) => Some(target.as_str()),
+            None if !self.upstream => {
+                default = GitBranch::default_fast()?;
+                Some(default.as_str())
+            }
             None => None,
         };
 
         let target = remote.target(branch)?;
 
-        Shell::git(&["rebase", target.as_str()])
-            .execute()?
-            .check()?;
-
-        Ok(())
+        let result = Shell::git(&["rebase", target.as_str()]).execute()?.check();
+        if let Ok(_) = result {
+            return Ok(());
+        }
+        let files = shell::conflict_files()?;
+        if files.is_empty() {
+            return result;
+        }
+        if self.abort {
+            Shell::git(&["rebase", "--abort"]).execute()?.check()?;
+            bail!(
+                "Rebase onto {target} has conflicts in {} files, aborted: {}",
+                files.len(),
+                files.join(", ")
+            );
+        }
+        bail!(
+            "Rebase onto {target} has conflicts in {} files: {}, please resolve them and run `git rebase --continue`, or run `git rebase --abort` to cancel",
+            files.len(),
+            files.join(", ")
+        )
     }
 }
@@ -1,5 +1,5 @@
 use anyhow::{bail, Result};
-use clap::Args;
+use clap::{Args, ValueEnum};
 
 use crate::cmd::Run;
 use crate::shell::{GitTag, Shell};
@@ -9,18 +9,49 @@ use crate::{config, confirm};
 #[derive(Args)]
 pub struct ReleaseArgs {
     /// Rlease rule name
-    pub rule: String,
+    pub rule: Option<String>,
+
+    /// Bump the semantic version directly, no need to config rule
+    #[clap(long, short)]
+    pub bump: Option<Bump>,
+}
+
+#[derive(Copy, Clone, PartialEq, Eq, PartialOrd, Ord, ValueEnum)]
+pub enum Bump {
+    Patch,
+    Minor,
+    Major,
+}
+
+impl ToString for Bump {
+    fn to_string(&self) -> String {
+        match self {
+            Bump::Patch => String::from("patch"),
+            Bump::Minor => String::from("minor"),
+            Bump::Major => String::from("major"),
+        }
+    }
 }
 
 impl Run for ReleaseArgs {
     fn run(&self) -> Result<()> {
         let tag = GitTag::latest()?;
-        let rule = match config::base().release.get(&self.rule) {
-            Some(rule) => rule,
-            None => bail!("Could not find rule {}", self.rule),
+        let rule = match &self.bump {
+            Some(bump) => GitTag::bump_rule(&bump.to_string())?,
+            None => {
+                let name = match &self.rule {
+                    Some(name) => name,
+                    None => bail!("Please provide rule name or use `--bump`"),
+                };
+                match config::base().release.get(name) {
+                    Some(rule) => rule.as_str(),
+                    None => bail!("Could not find rule {}", name),
+                }
+            }
         };
 
-        let new_tag = tag.apply_rule(rule)?;
+        let tags = GitTag::list()?;
+        let new_tag = tag.apply_rule(rule, &tags)?;
         confirm!(
             "Do you want to release: {} -> {}",
             tag.as_str(),
@@ -0,0 +1,188 @@
+use std::fs;
+use std::io::ErrorKind;
+use std::path::PathBuf;
+use std::time::Instant;
+
+use anyhow::{Context, Result};
+use clap::Args;
+use serde::Serialize;
+
+use crate::api;
+use crate::api::cache::CacheStats;
+use crate::cmd::Run;
+use crate::config;
+use crate::config::types::{Provider, Remote};
+use crate::repo::database::Database;
+use crate::utils::{self, Table};
+
+/// Show remote info, including api diagnostics.
+#[derive(Args)]
+pub struct RemoteArgs {
+    /// The remote name, if not provided, list all remotes.
+    pub name: Option<String>,
+}
+
+#[derive(Debug, Serialize)]
+struct RemoteInfo {
+    name: String,
+    clone: Option<String>,
+    provider: Option<String>,
+
+    repos: usize,
+    owners: usize,
+
+    cache: CacheInfo,
+
+    api: Option<ApiDiagnostics>,
+}
+
+#[derive(Debug, Serialize)]
+struct CacheInfo {
+    hours: u32,
+    files: usize,
+    size: String,
+    hits: u64,
+    misses: u64,
+    renewed: u64,
+    hit_rate: String,
+}
+
+#[derive(Debug, Serialize)]
+struct ApiDiagnostics {
+    latency: String,
+    scopes: Option<Vec<String>>,
+    token_expire: Option<String>,
+    rate_limit: Option<String>,
+}
+
+impl Run for RemoteArgs {
+    fn run(&self) -> Result<()> {
+        let db = Database::read()?;
+        match &self.name {
+            Some(name) => {
+                let remote = config::must_get_remote(name)?;
+                let info = self.get_info(&db, &remote)?;
+                let yaml = serde_yaml::to_string(&info).context("Encode info yaml")?;
+                print!("{yaml}");
+                Ok(())
+            }
+            None => self.list(&db),
+        }
+    }
+}
+
+impl RemoteArgs {
+    fn list(&self, db: &Database) -> Result<()> {
+        let remotes = config::list_remotes();
+        if remotes.is_empty() {
+            println!("Nothing to show");
+            return Ok(());
+        }
+
+        let mut table = Table::with_capacity(1 + remotes.len());
+        table.add(vec![
+            String::from("NAME"),
+            String::from("CLONE"),
+            String::from("PROVIDER"),
+            String::from("REPOS"),
+        ]);
+        for name in remotes {
+            let remote = config::must_get_remote(name)?;
+            let clone = match &remote.clone {
+                Some(clone) => clone.clone(),
+                None => String::from("<local>"),
+            };
+            let provider = match Self::provider_name(&remote) {
+                Some(provider) => provider,
+                None => String::from("<none>"),
+            };
+            let repos = db.filter_ignored(db.list_by_remote(name)).len();
+            table.add(vec![
+                String::from(name),
+                clone,
+                provider,
+                format!("{repos}"),
+            ]);
+        }
+        table.show();
+        Ok(())
+    }
+
+    fn get_info(&self, db: &Database, remote: &Remote) -> Result<RemoteInfo> {
+        let cache_dir = api::cache_dir(&remote.name);
+        let stats = CacheStats::read(&api::cache_stats_path(&remote.name))?;
+        let total = stats.hits + stats.misses + stats.renewed;
+        let hit_rate = if total == 0 {
+            String::from("<none>")
+        } else {
+            // The renewed values are not downloaded again, count them as hits.
+            let rate = (stats.hits + stats.renewed) as f64 / total as f64 * 100.0;
+            format!("{rate:.1}%")
+        };
+        let cache = CacheInfo {
+            hours: remote.cache_hours,
+            files: Self::count_files(&cache_dir)?,
+            size: utils::dir_size(cache_dir)?,
+            hits: stats.hits,
+            misses: stats.misses,
+            renewed: stats.renewed,
+            hit_rate,
+        };
+
+        let api = match remote.provider {
+            Some(_) => {
+                // Always call the remote api directly to get the real latency.
+                let provider = api::init_provider(remote, true)?;
+                let start = Instant::now();
+                let api_info = provider.get_info()?;
+                let latency = format!("{}ms", start.elapsed().as_millis());
+                let rate_limit = match api_info.rate_limit {
+                    Some(rate) => Some(format!(
+                        "{}/{}, reset at {}",
+                        rate.remaining,
+                        rate.limit,
+                        utils::format_time(rate.reset)?
+                    )),
+                    None => None,
+                };
+                let token_expire = match api_info.token_expires_at {
+                    Some(time) => Some(utils::format_time(time)?),
+                    None => None,
+                };
+                Some(ApiDiagnostics {
+                    latency,
+                    scopes: api_info.scopes,
+                    token_expire,
+                    rate_limit,
+                })
+            }
+            None => None,
+        };
+
+        Ok(RemoteInfo {
+            name: remote.name.clone(),
+            clone: remote.clone.clone(),
+            provider: Self::provider_name(remote),
+            repos: db.filter_ignored(db.list_by_remote(&remote.name)).len(),
+            owners: db.list_owners(&remote.name).len(),
+            cache,
+            api,
+        })
+    }
+
+    fn provider_name(remote: &Remote) -> Option<String> {
+        match remote.provider {
+            Some(Provider::Github) => Some(String::from("github")),
+            Some(Provider::Gitlab) => Some(String::from("gitlab")),
+            None => None,
+        }
+    }
+
+    fn count_files(dir: &PathBuf) -> Result<usize> {
+        match fs::read_dir(dir) {
+            Ok(read_dir) => Ok(read_dir.count()),
+            Err(err) if err.kind() == ErrorKind::NotFound => Ok(0),
+            Err(err) => Err(err).with_context(|| format!("Read dir {}", dir.display())),
+        }
+    }
+}
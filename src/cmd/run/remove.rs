@@ -1,16 +1,18 @@
 use std::fs;
-use std::io::ErrorKind;
 use std::path::PathBuf;
 use std::rc::Rc;
 
-use anyhow::{Context, Result};
+use anyhow::{bail, Context, Result};
 use clap::Args;
 
 use crate::cmd::Run;
 use crate::config::types::Remote;
 use crate::repo::database::Database;
+use crate::repo::event::{self, Event};
 use crate::repo::types::Repo;
-use crate::{config, confirm, info, shell, utils};
+use crate::shell::DirtyInfo;
+use crate::utils::{self, Table};
+use crate::{config, confirm, info, shell};
 
 /// Remove a repo from database and disk.
 #[derive(Args)]
@@ -20,6 +22,11 @@ pub struct RemoveArgs {
 
     /// The repo query, format is `owner[/[name]]`.
     pub query: String,
+
+    /// Remove the repo even if it has uncommitted changes or unpushed
+    /// commits.
+    #[clap(long, short)]
+    pub force: bool,
 }
 
 impl Run for RemoveArgs {
@@ -28,10 +35,26 @@ impl Run for RemoveArgs {
         let remote = config::must_get_remote(&self.remote)?;
 
         let repo = self.get_repo(&remote, &db)?;
+        if repo.pinned {
+            bail!(
+                "The repo {} is pinned, please unpin it first",
+                repo.long_name()
+            );
+        }
+        let path = repo.get_path();
+        let dirty = shell::scan_dirty(&path);
+        if !self.force {
+            self.ensure_clean(dirty.as_ref())?;
+        }
+        self.show_details(&repo, &path, dirty.as_ref())?;
         confirm!("Do you want to remove repo {}", repo.long_name());
 
-        let path = repo.get_path();
+        let path_str = format!("{}", path.display());
         self.remove_path(path)?;
+        event::emit(Event::RepoRemoved {
+            repo: &repo.full_name(),
+            path: &path_str,
+        });
 
         db.remove(repo);
 
@@ -42,10 +65,7 @@ impl Run for RemoveArgs {
 impl RemoveArgs {
     fn get_repo(&self, remote: &Remote, db: &Database) -> Result<Rc<Repo>> {
         if self.query.ends_with("/") {
-            let mut owner = self.query.trim_end_matches("/");
-            if let Some(raw_owner) = remote.owner_alias.get(owner) {
-                owner = raw_owner.as_str();
-            }
+            let owner = remote.resolve_owner(&self.query);
             let mut repos = db.list_by_owner(remote.name.as_str(), owner);
             let items: Vec<_> = repos.iter().map(|repo| format!("{}", repo.name)).collect();
 
@@ -57,32 +77,65 @@ impl RemoveArgs {
         db.must_get(&remote.name, &owner, &name)
     }
 
-    fn remove_path(&self, path: PathBuf) -> Result<()> {
-        info!("Remove dir {}", path.display());
-        fs::remove_dir_all(&path).context("Remove directory")?;
-
-        let dir = path.parent();
-        if let None = dir {
+    /// Refuse to remove the repo with local work, which would be lost.
+    fn ensure_clean(&self, dirty: Option<&DirtyInfo>) -> Result<()> {
+        let dirty = match dirty {
+            Some(dirty) => dirty,
+            None => return Ok(()),
+        };
+        if dirty.is_clean() {
             return Ok(());
         }
-        let mut dir = dir.unwrap();
-        loop {
-            match fs::read_dir(dir) {
-                Ok(dir_read) => {
-                    let count = dir_read.count();
-                    if count > 0 {
-                        return Ok(());
-                    }
-                    info!("Remove dir {}", dir.display());
-                    fs::remove_dir(dir).context("Remove directory")?;
-                    match dir.parent() {
-                        Some(parent) => dir = parent,
-                        None => return Ok(()),
-                    }
-                }
-                Err(err) if err.kind() == ErrorKind::NotFound => return Ok(()),
-                Err(err) => return Err(err).with_context(|| format!("Read dir {}", dir.display())),
+        if !dirty.changes.is_empty() {
+            println!("Uncommitted changes:");
+            for change in dirty.changes.iter() {
+                println!("  {change}");
             }
         }
+        if dirty.unpushed > 0 {
+            println!("Found {} unpushed commits", dirty.unpushed);
+        }
+        bail!("The repo has local work that would be lost, use `--force` to remove it anyway");
+    }
+
+    /// Show the size, last access time and local work of the repo, so that
+    /// user can make an informed decision before removing.
+    fn show_details(&self, repo: &Repo, path: &PathBuf, dirty: Option<&DirtyInfo>) -> Result<()> {
+        let size = if path.exists() {
+            utils::human_bytes(utils::dir_bytes(path.clone())? as f64)
+        } else {
+            String::from("<none>")
+        };
+        let dirty = match dirty {
+            Some(dirty) if dirty.is_clean() => String::from("clean"),
+            Some(dirty) => format!(
+                "{} changes, {} unpushed",
+                dirty.changes.len(),
+                dirty.unpushed
+            ),
+            None => String::from("<none>"),
+        };
+
+        let mut table = Table::with_capacity(2);
+        table.add(vec![
+            String::from("REPO"),
+            String::from("SIZE"),
+            String::from("LAST_ACCESS"),
+            String::from("DIRTY"),
+        ]);
+        table.add(vec![
+            repo.full_name(),
+            size,
+            utils::format_since(repo.last_accessed),
+            dirty,
+        ]);
+        table.show();
+        Ok(())
+    }
+
+    fn remove_path(&self, path: PathBuf) -> Result<()> {
+        info!("Remove dir {}", path.display());
+        fs::remove_dir_all(&path).context("Remove directory")?;
+        utils::remove_empty_parents(&path)
     }
 }
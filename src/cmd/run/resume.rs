@@ -0,0 +1,106 @@
+use anyhow::Result;
+use clap::Args;
+
+use crate::cmd::run::attach::AttachArgs;
+use crate::cmd::run::maintain::MaintainArgs;
+use crate::cmd::run::sync::SyncArgs;
+use crate::cmd::Run;
+use crate::repo::job::{Job, JobKind};
+use crate::repo::types::NameLevel;
+use crate::shell;
+use crate::utils::{self, Table};
+use crate::{confirm, info};
+
+/// Resume the interrupted batch operation, such as `attach --from-file`,
+/// `maintain` and `sync`.
+#[derive(Args)]
+pub struct ResumeArgs {
+    /// List the unfinished jobs.
+    #[clap(long, short)]
+    pub list: bool,
+
+    /// Drop the job rather than resuming it.
+    #[clap(long, short)]
+    pub drop: bool,
+}
+
+impl Run for ResumeArgs {
+    fn run(&self) -> Result<()> {
+        let mut jobs = Job::list()?;
+        if self.list {
+            return self.show(&jobs);
+        }
+        if jobs.is_empty() {
+            println!("No job to resume");
+            return Ok(());
+        }
+
+        let idx = if jobs.len() > 1 {
+            let items: Vec<String> = jobs
+                .iter()
+                .map(|job| {
+                    format!(
+                        "{} {} {}/{}",
+                        job.id,
+                        job.kind.as_str(),
+                        job.done_count(),
+                        job.tasks.len()
+                    )
+                })
+                .collect();
+            shell::search(&items)?
+        } else {
+            0
+        };
+        let job = jobs.remove(idx);
+        let left = job.tasks.len() - job.done_count();
+
+        if self.drop {
+            confirm!(
+                "Do you want to drop {} job {}, {} repos are not done",
+                job.kind.as_str(),
+                job.id,
+                left
+            );
+            job.remove()?;
+            info!("Drop job done");
+            return Ok(());
+        }
+
+        confirm!(
+            "Do you want to resume {} job for {} repos",
+            job.kind.as_str(),
+            left
+        );
+        match job.kind {
+            JobKind::Attach => AttachArgs::run_job(job),
+            JobKind::Maintain { .. } => MaintainArgs::run_job(job, NameLevel::Full),
+            JobKind::Sync => SyncArgs::default().run_job(job),
+        }
+    }
+}
+
+impl ResumeArgs {
+    fn show(&self, jobs: &[Job]) -> Result<()> {
+        if jobs.is_empty() {
+            return Ok(());
+        }
+        let mut table = Table::with_capacity(1 + jobs.len());
+        table.add(vec![
+            String::from("ID"),
+            String::from("KIND"),
+            String::from("PROGRESS"),
+            String::from("CREATED"),
+        ]);
+        for job in jobs.iter() {
+            table.add(vec![
+                format!("{}", job.id),
+                String::from(job.kind.as_str()),
+                format!("{}/{}", job.done_count(), job.tasks.len()),
+                utils::format_time(job.created)?,
+            ]);
+        }
+        table.show();
+        Ok(())
+    }
+}
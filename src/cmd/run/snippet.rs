@@ -0,0 +1,83 @@
+use std::fs;
+use std::io::{self, Read};
+use std::path::PathBuf;
+
+use anyhow::{bail, Context, Result};
+use clap::Args;
+
+use crate::cmd::Run;
+use crate::{api, config, info, utils};
+
+/// Create a snippet of remote (gist for Github) from a file or stdin, and
+/// print its url.
+#[derive(Args)]
+pub struct SnippetArgs {
+    /// The remote name.
+    pub remote: String,
+
+    /// The file to upload, read from stdin if omitted or `-`.
+    pub file: Option<String>,
+
+    /// The title (description for Github) of the snippet.
+    #[clap(long, short)]
+    pub title: Option<String>,
+
+    /// The file name in snippet, default is the name of uploaded file, or
+    /// `snippet.txt` for stdin.
+    #[clap(long, short)]
+    pub name: Option<String>,
+
+    /// Create a public snippet, default is private (secret gist for Github).
+    #[clap(long, short)]
+    pub public: bool,
+
+    /// Open the created snippet in browser.
+    #[clap(long, short)]
+    pub open: bool,
+}
+
+impl Run for SnippetArgs {
+    fn run(&self) -> Result<()> {
+        let remote = config::must_get_remote(&self.remote)?;
+        remote.check_provider()?;
+        let (content, file_name) = match self.file.as_deref() {
+            None | Some("-") => {
+                let mut content = String::new();
+                io::stdin()
+                    .read_to_string(&mut content)
+                    .context("Read snippet from stdin")?;
+                (content, String::from("snippet.txt"))
+            }
+            Some(file) => {
+                let path = PathBuf::from(file);
+                let content = fs::read_to_string(&path)
+                    .with_context(|| format!("Read snippet file {}", path.display()))?;
+                let name = match path.file_name() {
+                    Some(name) => name.to_string_lossy().into_owned(),
+                    None => bail!("Invalid snippet file {file}"),
+                };
+                (content, name)
+            }
+        };
+        if content.trim().is_empty() {
+            bail!("The snippet content is empty");
+        }
+        let file_name = match &self.name {
+            Some(name) => name.clone(),
+            None => file_name,
+        };
+        let title = match &self.title {
+            Some(title) => title.clone(),
+            None => file_name.clone(),
+        };
+
+        let provider = api::init_provider(&remote, false)?;
+        info!("Create snippet {file_name} on {}", remote.name);
+        let snippet = provider.create_snippet(&title, &file_name, &content, self.public)?;
+        if self.open {
+            return utils::open_url(remote.browser(), &snippet.web_url);
+        }
+        println!("{}", snippet.web_url);
+        Ok(())
+    }
+}
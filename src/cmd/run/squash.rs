@@ -5,11 +5,10 @@ use clap::Args;
 use console::style;
 
 use crate::cmd::Run;
-use crate::errors::SilentExit;
 use crate::repo::database::Database;
-use crate::shell::{self, GitRemote, Shell};
+use crate::shell::{self, GitBranch, GitRemote, Shell};
 use crate::{api, confirm};
-use crate::{config, exec};
+use crate::{config, exec, utils};
 
 /// Squash multiple commits into one
 #[derive(Args)]
@@ -21,34 +20,53 @@ pub struct SquashArgs {
     #[clap(long, short)]
     pub upstream: bool,
 
-    /// Commit message
+    /// Commit message, if not provided, pick one from the squashed commits
+    /// or edit them combined.
     #[clap(long, short)]
     pub message: Option<String>,
 
     /// If true, the cache will not be used when calling the API search.
     #[clap(long, short)]
     pub force: bool,
+
+    /// Don't open editor, if `-m` is not provided, use the messages of the
+    /// squashed commits combined. This can be used in non-interactive mode.
+    #[clap(long)]
+    pub no_edit: bool,
 }
 
 impl Run for SquashArgs {
     fn run(&self) -> Result<()> {
+        if utils::is_non_interactive() && self.message.is_none() && !self.no_edit {
+            bail!(
+                "Please provide commit message by `-m` or use `--no-edit` in non-interactive mode"
+            );
+        }
         shell::ensure_no_uncommitted()?;
+        // Squashing in detached HEAD would leave the result on no branch.
+        GitBranch::current_or_create()?;
         let remote = if self.upstream {
             let db = Database::read()?;
             let repo = db.must_current()?;
             let remote = config::must_get_remote(repo.remote.as_str())?;
-            let provider = api::init_provider(&remote, self.force)?;
+            let provider = api::init_owner_provider(&remote, &repo.owner, self.force)?;
 
             GitRemote::from_upstream(&remote, &repo, &provider)?
         } else {
             GitRemote::new()
         };
 
+        let default;
         let branch = match &self.target {
             Some(target) => Some(target.as_str()),
+            None if !self.upstream => {
+                default = GitBranch::default_fast()?;
+                Some(default.as_str())
+            }
             None => None,
         };
-        let commits = remote.commits_between(branch)?;
+        let target = remote.target(branch)?;
+        let commits = GitRemote::commits_between(&target)?;
         if commits.is_empty() {
             println!("No commit to squash");
             return Ok(());
@@ -65,17 +83,30 @@ impl Run for SquashArgs {
         }
         confirm!("Continue");
 
-        let set = format!("HEAD~{}", commits.len());
-        Shell::git(&["reset", "--soft", set.as_str()])
-            .execute()?
-            .check()?;
-
+        // Select the message before resetting, so that the commits are kept
+        // if the selection is aborted.
         let mut args = vec!["commit"];
-        if let Some(msg) = &self.message {
+        let message = match &self.message {
+            Some(msg) => Some(msg.clone()),
+            None => {
+                let messages = GitRemote::messages_between(&target)?;
+                if self.no_edit {
+                    Some(Self::combine_messages(&messages))
+                } else {
+                    Self::select_message(&messages, &mut args)?
+                }
+            }
+        };
+        if let Some(msg) = &message {
             args.push("-m");
             args.push(msg);
         }
 
+        let set = format!("HEAD~{}", commits.len());
+        Shell::git(&["reset", "--soft", set.as_str()])
+            .execute()?
+            .check()?;
+
         exec!("git commit");
         let mut cmd = Command::new("git");
         cmd.stdout(Stdio::inherit());
@@ -83,11 +114,44 @@ impl Run for SquashArgs {
         cmd.stdin(Stdio::inherit());
         cmd.args(args);
 
-        match cmd.output() {
-            Ok(_) => {}
-            Err(_) => bail!(SilentExit { code: 101 }),
+        match cmd.status() {
+            Ok(status) if status.success() => Ok(()),
+            // The commits have been reset, let user know how to recover them.
+            _ => bail!(
+                "Commit failed, the squashed changes are staged, please commit them manually, or run `git reset ORIG_HEAD` to restore the commits"
+            ),
         }
+    }
+}
+
+impl SquashArgs {
+    /// Let user pick the message from the squashed commits, or edit them
+    /// combined in editor. Return None to write a new message in editor. The
+    /// messages are shown by their subjects.
+    fn select_message(messages: &[String], args: &mut Vec<&str>) -> Result<Option<String>> {
+        let mut items = Vec::with_capacity(messages.len() + 2);
+        items.push(String::from("<edit combined messages>"));
+        items.push(String::from("<write new message>"));
+        items.extend(
+            messages
+                .iter()
+                .map(|message| message.lines().next().unwrap_or_default().to_string()),
+        );
+        match shell::search(&items)? {
+            0 => {
+                // The `-e` opens the editor with the message pre-populated.
+                args.push("-e");
+                Ok(Some(Self::combine_messages(messages)))
+            }
+            1 => Ok(None),
+            idx => Ok(Some(messages[idx - 2].clone())),
+        }
+    }
 
-        Ok(())
+    /// The git log shows the newest commit first, combine the messages in the
+    /// commit order.
+    fn combine_messages(messages: &[String]) -> String {
+        let messages: Vec<&str> = messages.iter().rev().map(|s| s.as_str()).collect();
+        messages.join("\n\n")
     }
 }
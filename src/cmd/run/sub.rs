@@ -0,0 +1,143 @@
+use std::fs;
+use std::path::PathBuf;
+use std::rc::Rc;
+
+use anyhow::{bail, Context, Result};
+use clap::Args;
+
+use crate::cmd::Run;
+use crate::repo::database::Database;
+use crate::repo::types::{Repo, SubProject};
+use crate::utils::{self, Table};
+use crate::{config, confirm, info};
+
+/// Manage sub-projects of current repo. A sub-project is a directory inside
+/// the repo (such as a service in monorepo), which can be jumped to by
+/// `home`, such as `roxide home myrepo/services/api`.
+#[derive(Args)]
+pub struct SubArgs {
+    /// The sub-project path relative to the repo root, default is current
+    /// directory.
+    pub path: Option<String>,
+
+    /// List sub-projects of current repo.
+    #[clap(long, short)]
+    pub list: bool,
+
+    /// Remove the sub-project.
+    #[clap(long, short)]
+    pub delete: bool,
+}
+
+impl Run for SubArgs {
+    fn run(&self) -> Result<()> {
+        let mut db = Database::read()?;
+        let repo = self.find_repo(&db)?;
+        if self.list {
+            return self.list(&db, &repo);
+        }
+
+        let path = self.get_path(&repo)?;
+        let found = db
+            .list_subprojects(&repo)
+            .into_iter()
+            .find(|subproject| subproject.path == path);
+
+        if self.delete {
+            let subproject = match found {
+                Some(subproject) => subproject,
+                None => bail!("Could not find sub-project {path} in {}", repo.long_name()),
+            };
+            confirm!(
+                "Do you want to remove sub-project {}",
+                subproject.full_name()
+            );
+            db.remove_subproject(subproject);
+            return db.close();
+        }
+
+        if let Some(_) = found {
+            bail!(
+                "The sub-project {path} already exists in {}",
+                repo.long_name()
+            );
+        }
+        let dir = repo.get_path().join(&path);
+        let meta = fs::metadata(&dir).with_context(|| format!("Read dir {}", dir.display()))?;
+        if !meta.is_dir() {
+            bail!("The path {} is not a directory", dir.display());
+        }
+
+        let subproject = SubProject::new(&repo, &path);
+        info!("Add sub-project {}", subproject.full_name());
+        db.update_subproject(subproject);
+        db.close()
+    }
+}
+
+impl SubArgs {
+    /// Find the repo that contains current directory, the directory might be
+    /// a sub-directory of the repo.
+    fn find_repo(&self, db: &Database) -> Result<Rc<Repo>> {
+        let dir = config::current_dir();
+        let mut found: Option<(Rc<Repo>, usize)> = None;
+        for repo in db.list_all() {
+            let path = repo.get_path();
+            if !dir.starts_with(&path) {
+                continue;
+            }
+            let depth = path.components().count();
+            match &found {
+                Some((_, found_depth)) if *found_depth >= depth => {}
+                _ => found = Some((repo, depth)),
+            }
+        }
+        match found {
+            Some((repo, _)) => Ok(repo),
+            None => bail!("You are not in a roxide repository"),
+        }
+    }
+
+    fn get_path(&self, repo: &Repo) -> Result<String> {
+        let path = match &self.path {
+            Some(path) => PathBuf::from(path.trim_matches('/')),
+            None => {
+                let dir = config::current_dir();
+                let root = repo.get_path();
+                PathBuf::from(dir.strip_prefix(&root).unwrap())
+            }
+        };
+        let path = format!("{}", path.display());
+        if path.is_empty() {
+            bail!("The repo root could not be a sub-project, please provide a path");
+        }
+        Ok(path)
+    }
+
+    fn list(&self, db: &Database, repo: &Repo) -> Result<()> {
+        let subprojects = db.list_subprojects(repo);
+        if subprojects.is_empty() {
+            println!("Nothing to show");
+            return Ok(());
+        }
+
+        let mut table = Table::with_capacity(1 + subprojects.len());
+        table.add(vec![
+            String::from("PATH"),
+            String::from("ACCESS"),
+            String::from("LAST_ACCESS"),
+            String::from("SCORE"),
+        ]);
+        for subproject in subprojects {
+            table.add(vec![
+                subproject.path.clone(),
+                format!("{}", subproject.accessed as u64),
+                utils::format_since(subproject.last_accessed),
+                format!("{:.2}", subproject.score()),
+            ]);
+        }
+
+        table.show();
+        Ok(())
+    }
+}
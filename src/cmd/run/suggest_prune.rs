@@ -0,0 +1,288 @@
+use std::fs;
+use std::path::PathBuf;
+use std::rc::Rc;
+
+use anyhow::{bail, Context, Result};
+use clap::Args;
+
+use crate::cmd::Run;
+use crate::repo::database::Database;
+use crate::repo::event::{self, Event};
+use crate::repo::types::Repo;
+use crate::shell::Shell;
+use crate::utils::{self, Table};
+use crate::{config, confirm, info, shell};
+
+/// Suggest the stale repos to prune, and remove (or archive) the selected
+/// ones. The repos not visited for a long time, with low score and large
+/// size are ranked first. Only the clean repos (no uncommitted changes,
+/// unpushed commits or stashes) are suggested, the pinned and boosted ones are
+/// never suggested.
+#[derive(Args)]
+pub struct SuggestPruneArgs {
+    /// The remote name or group (`@group`), default will scan all repos.
+    pub remote: Option<String>,
+
+    /// The repo query, format is `owner[/[name]]`.
+    pub query: Option<String>,
+
+    /// Only suggest the repos not visited for this long, such as `30d` or
+    /// `8w`.
+    #[clap(long, default_value = "30d")]
+    pub age: String,
+
+    /// The max number of repos to suggest.
+    #[clap(long, short = 'n', default_value = "20")]
+    pub limit: usize,
+
+    /// Only list the suggestions, donot prune.
+    #[clap(long, short)]
+    pub list: bool,
+
+    /// Archive the selected repos instead of removing them. The local clone is
+    /// removed but the repo is kept in database, so it will be cloned again
+    /// when entering it.
+    #[clap(long, short)]
+    pub archive: bool,
+}
+
+struct Suggestion {
+    repo: Rc<Repo>,
+    size: u64,
+    staleness: f64,
+    ignored: bool,
+}
+
+/// The local state of a repo which decides whether it is safe to prune.
+struct ScanInfo {
+    /// Has uncommitted changes, unpushed commits or stashes.
+    dirty: bool,
+    /// Has ignored files, such as build outputs or local configs. They are
+    /// not tracked by git, so they are lost after pruning.
+    ignored: bool,
+}
+
+impl Run for SuggestPruneArgs {
+    fn run(&self) -> Result<()> {
+        if !self.list && utils::is_non_interactive() {
+            bail!("Pruning requires interactive mode, use `--list` to only list the suggestions");
+        }
+        let age = utils::parse_duration(&self.age)?;
+        let now = config::now_secs();
+        let deadline = now.saturating_sub(age);
+
+        let db = Database::read_only()?;
+        let (repos, level) = db.list_by_query(self.remote.as_deref(), self.query.as_deref())?;
+        // The repos attached from other places are not owned by roxide, they
+        // should not be removed here.
+        let repos: Vec<Rc<Repo>> = repos
+            .into_iter()
+            .filter(|repo| !repo.pinned && !repo.boosted() && repo.path.is_none())
+            .filter(|repo| repo.last_accessed <= deadline)
+            .filter(|repo| repo.get_path().exists())
+            .collect();
+        if repos.is_empty() {
+            println!("No stale repo to prune");
+            return Ok(());
+        }
+
+        let dirs: Vec<PathBuf> = repos.iter().map(|repo| repo.get_path()).collect();
+        let results = utils::batch(&dirs, |dir| -> Result<Option<(u64, bool)>> {
+            let info = Self::scan(dir)?;
+            if info.dirty {
+                return Ok(None);
+            }
+            let size = utils::dir_bytes(dir.clone())?;
+            Ok(Some((size, info.ignored)))
+        });
+
+        let mut dirty = 0;
+        let mut failed = Vec::new();
+        let mut suggestions = Vec::with_capacity(repos.len());
+        for (repo, result) in repos.into_iter().zip(results) {
+            let (size, ignored) = match result {
+                Ok(Some(result)) => result,
+                Ok(None) => {
+                    dirty += 1;
+                    continue;
+                }
+                Err(err) => {
+                    failed.push((repo.full_name(), err));
+                    continue;
+                }
+            };
+            let staleness = Self::staleness(&repo, size, now);
+            suggestions.push(Suggestion {
+                repo,
+                size,
+                staleness,
+                ignored,
+            });
+        }
+        if dirty > 0 {
+            info!("Skip {} dirty repos", dirty);
+        }
+        for (name, err) in failed.iter() {
+            utils::show_warn(format!("Skip {name}, scan failed: {err:#}"));
+        }
+        if suggestions.is_empty() {
+            println!("No stale repo to prune");
+            return Ok(());
+        }
+        suggestions.sort_unstable_by(|a, b| b.staleness.total_cmp(&a.staleness));
+        suggestions.truncate(self.limit);
+
+        let mut table = Table::with_capacity(1 + suggestions.len());
+        table.add(vec![
+            String::from("REPO"),
+            String::from("SIZE"),
+            String::from("LAST_ACCESS"),
+            String::from("SCORE"),
+        ]);
+        for suggestion in suggestions.iter() {
+            table.add(vec![
+                suggestion.repo.as_string(&level),
+                utils::human_bytes(suggestion.size as f64),
+                utils::format_since(suggestion.repo.last_accessed),
+                format!("{:.2}", suggestion.repo.score()),
+            ]);
+        }
+        let mut lines = table.lines();
+        let total: u64 = suggestions.iter().map(|suggestion| suggestion.size).sum();
+        if self.list {
+            for line in lines {
+                println!("{line}");
+            }
+            Self::warn_ignored(suggestions.iter());
+            println!();
+            println!(
+                "Summary: {} repos, {} in total",
+                suggestions.len(),
+                utils::human_bytes(total as f64)
+            );
+            return Ok(());
+        }
+
+        // The header is not selectable.
+        lines.remove(0);
+        let idxs = shell::search_multi(&lines)?;
+        let selected: Vec<&Suggestion> = idxs.into_iter().map(|idx| &suggestions[idx]).collect();
+        let size: u64 = selected.iter().map(|suggestion| suggestion.size).sum();
+
+        let action = if self.archive { "archive" } else { "remove" };
+        println!("Selected repos:");
+        for suggestion in selected.iter() {
+            println!("  {}", suggestion.repo.full_name());
+        }
+        Self::warn_ignored(selected.iter().copied());
+        confirm!(
+            "Do you want to {action} {} repos ({})",
+            selected.len(),
+            utils::human_bytes(size as f64)
+        );
+
+        let mut db = Database::read()?;
+        let mut failed = Vec::new();
+        for suggestion in selected {
+            let repo = &suggestion.repo;
+            if let Err(err) = self.prune(repo) {
+                failed.push((repo.full_name(), err));
+                continue;
+            }
+            if !self.archive {
+                db.remove(Rc::clone(repo));
+            }
+        }
+        db.close()?;
+
+        if !failed.is_empty() {
+            println!();
+            for (name, err) in failed.iter() {
+                println!("Prune {name} failed: {err:#}");
+            }
+            bail!("{} repos failed to prune", failed.len());
+        }
+        Ok(())
+    }
+}
+
+impl SuggestPruneArgs {
+    /// The staleness grows with the days since the last visit and the size,
+    /// and drops with the score. The logarithms keep one huge repo (or one
+    /// ancient visit) from dominating the ranking.
+    fn staleness(repo: &Repo, size: u64, now: u64) -> f64 {
+        let days = now.saturating_sub(repo.last_accessed) / utils::DAY;
+        let megabytes = size / 1000 / 1000;
+        (days as f64).ln_1p() * (megabytes as f64).ln_1p() / (1.0 + repo.score())
+    }
+
+    /// Scan whether the repo in `dir` is dirty or has ignored files. The
+    /// stashes are counted as dirty, since they are only kept in the local
+    /// clone.
+    fn scan(dir: &PathBuf) -> Result<ScanInfo> {
+        let dirty = match shell::scan_dirty(dir) {
+            Some(dirty) => dirty,
+            None => bail!("could not scan the uncommitted changes and unpushed commits"),
+        };
+        let path = format!("{}", dir.display());
+        let git = |args: &[&str]| -> Result<Vec<String>> {
+            let mut full = vec!["-C", path.as_str()];
+            full.extend_from_slice(args);
+            Shell::git(&full).set_mute(true).execute()?.checked_lines()
+        };
+        let stashes = git(&["stash", "list"]).context("List stashes")?;
+        let ignored = git(&[
+            "ls-files",
+            "--others",
+            "--ignored",
+            "--exclude-standard",
+            "--directory",
+        ])
+        .context("List ignored files")?;
+        Ok(ScanInfo {
+            dirty: !dirty.is_clean() || !stashes.is_empty(),
+            ignored: !ignored.is_empty(),
+        })
+    }
+
+    fn warn_ignored<'a>(suggestions: impl Iterator<Item = &'a Suggestion>) {
+        let names: Vec<String> = suggestions
+            .filter(|suggestion| suggestion.ignored)
+            .map(|suggestion| suggestion.repo.full_name())
+            .collect();
+        if names.is_empty() {
+            return;
+        }
+        utils::show_warn(format!(
+            "The ignored files (such as build outputs or local configs) are pruned too: {}",
+            names.join(", ")
+        ));
+    }
+
+    fn prune(&self, repo: &Repo) -> Result<()> {
+        if self.archive {
+            // The repo without clone url could not be cloned again.
+            let remote = config::must_get_remote(repo.remote.as_str())?;
+            if let None = remote.clone {
+                bail!(
+                    "The remote {} has no clone url, could not archive",
+                    remote.name
+                );
+            }
+        }
+        let path = repo.get_path();
+        // The repo might be changed after scanning, such as by another shell,
+        // check it again right before removing.
+        if Self::scan(&path)?.dirty {
+            bail!("the repo has uncommitted changes, unpushed commits or stashes now");
+        }
+        info!("Remove dir {}", path.display());
+        fs::remove_dir_all(&path).context("Remove directory")?;
+        utils::remove_empty_parents(&path)?;
+        event::emit(Event::RepoRemoved {
+            repo: &repo.full_name(),
+            path: &format!("{}", path.display()),
+        });
+        Ok(())
+    }
+}
@@ -0,0 +1,303 @@
+use std::path::PathBuf;
+use std::sync::Mutex;
+
+use anyhow::{bail, Result};
+use clap::Args;
+
+use crate::cmd::run::dirty::{self, DirtyCounts, DirtyKind};
+use crate::cmd::Run;
+use crate::repo::budget;
+use crate::repo::database::Database;
+use crate::repo::event::{self, Event};
+use crate::repo::job::{Job, JobKind, JobTask};
+use crate::repo::report::SyncReport;
+use crate::shell::{self, BranchStatus, GitBranch, Shell};
+use crate::{confirm, info, utils};
+
+/// Sync the branches of many repos with remote, the results are grouped by
+/// owner, and summarized at the end.
+#[derive(Args, Default)]
+pub struct SyncArgs {
+    /// The remote name or group (`@group`), default will sync all repos.
+    pub remote: Option<String>,
+
+    /// The owner or repo to sync.
+    pub query: Option<String>,
+
+    /// Exit with error if any synced repo has the problems after syncing,
+    /// such as `--fail-on conflict,dirty`. The conflicts are the branches
+    /// diverged from upstream. This is useful for CI jobs keeping mirrors in
+    /// sync.
+    #[clap(long, value_enum, value_delimiter = ',')]
+    pub fail_on: Vec<DirtyKind>,
+
+    /// After syncing, go through the repos left with conflicts, uncommitted
+    /// changes or unpushed commits one by one, and open a subshell in the
+    /// repo to fix it.
+    #[clap(long, conflicts_with = "fail_on")]
+    pub resolve: bool,
+
+    /// With `--resolve`, enter the selected problem repo instead of opening a
+    /// subshell. The path is printed for the shell wrapper.
+    #[clap(long, requires = "resolve")]
+    pub cd: bool,
+}
+
+/// The result of syncing a repo, with the number of uncommitted changes and
+/// unpushed commits after syncing, they are zero if not scanned.
+struct SyncResult {
+    report: SyncReport,
+    dirty: (usize, usize),
+}
+
+/// The repo to sync, the `Rc<Repo>` could not be shared with batch workers.
+struct SyncTask {
+    name: String,
+    dir: PathBuf,
+    default_branch: Option<String>,
+}
+
+impl Run for SyncArgs {
+    fn run(&self) -> Result<()> {
+        if self.resolve && utils::is_non_interactive() {
+            bail!("The `--resolve` requires interactive mode");
+        }
+        let db = Database::read_only()?;
+        let (repos, _) = db.list_by_query(self.remote.as_deref(), self.query.as_deref())?;
+        // The repos not cloned yet have nothing to sync.
+        let tasks: Vec<JobTask> = repos
+            .iter()
+            .filter(|repo| repo.get_path().exists())
+            .map(|repo| JobTask::new(repo))
+            .collect();
+        drop(db);
+        if tasks.is_empty() {
+            println!("No repo to sync");
+            return Ok(());
+        }
+
+        let word = if tasks.len() == 1 { "repo" } else { "repos" };
+        confirm!("Sync {} {word}", tasks.len());
+
+        let job = Job::create(JobKind::Sync, tasks)?;
+        self.run_job(job)
+    }
+}
+
+impl SyncArgs {
+    /// Sync the repos of the job which are not done yet, the job can be
+    /// resumed by `roxide resume` if interrupted or failed.
+    pub fn run_job(&self, job: Job) -> Result<()> {
+        // Scanning the uncommitted changes and unpushed commits costs two
+        // more git calls per repo, only do it when they are checked.
+        let scan = self.resolve
+            || self
+                .fail_on
+                .iter()
+                .any(|kind| matches!(kind, DirtyKind::Dirty | DirtyKind::Unpushed));
+        let mut items: Vec<(usize, SyncTask)> = Vec::with_capacity(job.tasks.len());
+        let mut priorities = Vec::with_capacity(job.tasks.len());
+        for (idx, task) in job.tasks.iter().enumerate() {
+            if task.done {
+                continue;
+            }
+            let repo = task.to_repo();
+            items.push((
+                idx,
+                SyncTask {
+                    name: repo.full_name(),
+                    dir: repo.get_path(),
+                    default_branch: task.default_branch.clone(),
+                },
+            ));
+            priorities.push(task.priority());
+        }
+
+        // Mark the task done as soon as it completes, so that the progress is
+        // kept if the job is interrupted. Saving the progress is best-effort
+        // here, since the database might be locked by another roxide for a
+        // moment, the progress is saved again after the batch.
+        let job = Mutex::new(job);
+        let results =
+            utils::batch_priority(&items, &priorities, |(idx, task)| -> Result<SyncResult> {
+                let report = Self::sync_repo(task)?;
+                let _ = job.lock().unwrap().done(*idx);
+                let dirty = if scan {
+                    shell::scan_dirty(&task.dir)
+                        .map(|info| (info.changes.len(), info.unpushed))
+                        .unwrap_or_default()
+                } else {
+                    (0, 0)
+                };
+                Ok(SyncResult { report, dirty })
+            });
+        let mut job = job.into_inner().unwrap();
+
+        let mut reports = Vec::with_capacity(items.len());
+        let mut failed = Vec::new();
+        let mut synced = Vec::new();
+        let mut counts = DirtyCounts::default();
+        let mut problems = Vec::new();
+        let mut db = Database::read()?;
+        for ((idx, task), result) in items.iter().zip(results) {
+            let SyncResult { report, dirty } = match result {
+                Ok(result) => result,
+                Err(err) => {
+                    failed.push((task.name.clone(), err));
+                    continue;
+                }
+            };
+            job.done_in(&mut db, *idx);
+            let (changes, unpushed) = dirty;
+            let conflicts = report.conflicts();
+            counts.add(changes, unpushed, conflicts);
+            if changes + unpushed + conflicts > 0 {
+                problems.push((task.name.clone(), task.dir.clone()));
+            }
+            if report.items.is_empty() {
+                continue;
+            }
+            let path = format!("{}", task.dir.display());
+            for item in report.items.iter() {
+                if item.op == "delete" && item.error.is_none() {
+                    event::emit(Event::BranchDeleted {
+                        path: &path,
+                        branch: &item.branch,
+                    });
+                }
+            }
+            synced.push(task.name.clone());
+            reports.push(report);
+        }
+        db.close()?;
+        job.finish()?;
+
+        println!();
+        if reports.is_empty() {
+            println!("Nothing to do");
+        } else {
+            SyncReport::show_grouped(&reports, false)?;
+            // The report lock is not reentrant, so the reports are saved
+            // after the batch, in one write.
+            SyncReport::save_all(reports)?;
+        }
+        if !synced.is_empty() {
+            budget::check_all(&synced);
+        }
+
+        if self.resolve {
+            return Self::resolve(&problems, &failed, &counts, self.cd);
+        }
+
+        if !failed.is_empty() {
+            println!();
+            for (name, err) in failed.iter() {
+                println!("Sync {name} failed: {err:#}");
+            }
+            bail!(
+                "{} repos failed to sync, use `roxide resume` to retry",
+                failed.len()
+            );
+        }
+        if !self.fail_on.is_empty() && !counts.is_empty() {
+            println!();
+            counts.show();
+        }
+        counts.check(&self.fail_on)
+    }
+
+    /// Resolve the problem repos left by this run. The failed repos are only
+    /// warned, so that the path printed in `cd` mode is not discarded by the
+    /// shell wrapper for the error exit.
+    fn resolve(
+        problems: &[(String, PathBuf)],
+        failed: &[(String, anyhow::Error)],
+        counts: &DirtyCounts,
+        cd: bool,
+    ) -> Result<()> {
+        for (name, err) in failed.iter() {
+            utils::show_warn(format!("Sync {name} failed: {err:#}"));
+        }
+        if !failed.is_empty() {
+            utils::show_warn(format!(
+                "{} repos failed to sync, use `roxide resume` to retry",
+                failed.len()
+            ));
+        }
+        if problems.is_empty() {
+            info!("No repo to resolve");
+            return Ok(());
+        }
+        println!();
+        counts.show();
+        dirty::resolve(problems, cd)
+    }
+
+    /// Sync the branches of one repo without touching other branches'
+    /// worktree: push the ahead branches, fast-forward the behind ones,
+    /// delete the gone ones and record the diverged ones as conflicts. The
+    /// failure of a branch is recorded in the report, and does not stop
+    /// syncing other branches.
+    fn sync_repo(task: &SyncTask) -> Result<SyncReport> {
+        let path = format!("{}", task.dir.display());
+        let git = |args: &[&str]| -> Result<()> {
+            let mut full = vec!["-C", path.as_str()];
+            full.extend_from_slice(args);
+            Shell::git(&full).set_mute(true).execute()?.check()
+        };
+
+        git(&["fetch", "--prune", "origin"])?;
+        let branches = GitBranch::list_in(&task.dir)?;
+
+        let mut report = SyncReport::new(task.name.clone());
+        for branch in branches.iter() {
+            let name = branch.name.as_str();
+            match branch.status {
+                BranchStatus::Ahead => {
+                    let result = match branch.upstream.as_deref() {
+                        Some(upstream) => match upstream.strip_prefix("origin/") {
+                            Some(target) => {
+                                let refspec = format!("{name}:{target}");
+                                git(&["push", "origin", refspec.as_str()])
+                            }
+                            None => continue,
+                        },
+                        None => continue,
+                    };
+                    report.add("push", name, branch.ahead, &result);
+                }
+                BranchStatus::Behind => {
+                    let result = if branch.current {
+                        git(&["merge", "--ff-only", "@{u}"])
+                    } else {
+                        match branch.upstream.as_deref() {
+                            Some(upstream) => {
+                                // Fetching from the local repository only
+                                // allows fast-forward.
+                                let refspec = format!("{upstream}:{name}");
+                                git(&["fetch", ".", refspec.as_str()])
+                            }
+                            None => continue,
+                        }
+                    };
+                    report.add("pull", name, branch.behind, &result);
+                }
+                BranchStatus::Gone => {
+                    // The current branch is kept, since we don't checkout
+                    // other branches when syncing many repos.
+                    if branch.current || task.default_branch.as_deref() == Some(name) {
+                        continue;
+                    }
+                    let result = git(&["branch", "-D", name]);
+                    report.add("delete", name, 0, &result);
+                }
+                BranchStatus::Conflict => {
+                    report.add_conflict(name, branch.ahead, branch.behind);
+                }
+                BranchStatus::Sync | BranchStatus::Detached => {}
+            }
+        }
+
+        Ok(report)
+    }
+}
@@ -0,0 +1,80 @@
+use std::env;
+use std::process::{Command, Stdio};
+
+use anyhow::{bail, Context, Result};
+use clap::Args;
+
+use crate::cmd::run::home::HomeArgs;
+use crate::cmd::Run;
+use crate::config;
+use crate::repo::types::Repo;
+use crate::shell::Shell;
+
+/// Create or attach the tmux session of a repo.
+#[derive(Args)]
+pub struct TmuxArgs {
+    #[clap(flatten)]
+    pub home: HomeArgs,
+}
+
+impl Run for TmuxArgs {
+    fn run(&self) -> Result<()> {
+        let (repo, dir) = self.home.enter()?;
+        let name = session_name(&repo);
+        // The `=` prefix makes tmux match the session name exactly.
+        let target = format!("={name}");
+
+        let exists = Shell::with_args("tmux", &["has-session", "-t", &target])
+            .set_mute(true)
+            .execute()?
+            .check()
+            .is_ok();
+        if !exists {
+            let path = format!("{}", dir.display());
+            Shell::with_args("tmux", &["new-session", "-d", "-s", &name, "-c", &path])
+                .with_desc(format!("Create tmux session {name}"))
+                .execute()?
+                .check()?;
+        }
+
+        // Inside tmux, attaching would nest sessions, switch the client
+        // instead.
+        let action = match env::var_os("TMUX") {
+            Some(_) => "switch-client",
+            None => "attach-session",
+        };
+        let mut cmd = Command::new("tmux");
+        cmd.stdout(Stdio::inherit());
+        cmd.stderr(Stdio::inherit());
+        cmd.stdin(Stdio::inherit());
+        cmd.args(&[action, "-t", &target]);
+        let status = cmd
+            .status()
+            .with_context(|| format!("Use tmux to {action} {name}"))?;
+        if !status.success() {
+            bail!("Use tmux to {action} {name} failed: {status}");
+        }
+
+        Ok(())
+    }
+}
+
+/// Build the tmux session name of repo, according to the template in config.
+pub fn session_name(repo: &Repo) -> String {
+    repo.render(&config::base().tmux.session)
+        .replace(".", "_")
+        .replace(":", "_")
+}
+
+/// Rename the current tmux session after repo, do nothing if not running
+/// inside tmux.
+pub fn rename_current(repo: &Repo) -> Result<()> {
+    if let None = env::var_os("TMUX") {
+        return Ok(());
+    }
+    let name = session_name(repo);
+    Shell::with_args("tmux", &["rename-session", &name])
+        .set_mute(true)
+        .execute()?
+        .check()
+}
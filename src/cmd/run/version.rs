@@ -0,0 +1,141 @@
+use std::fs;
+use std::io::ErrorKind;
+use std::path::PathBuf;
+
+use anyhow::{Context, Result};
+use clap::Args;
+use serde::Serialize;
+
+use crate::cmd::Run;
+use crate::config;
+use crate::config::types::Config;
+use crate::repo::database::Database;
+use crate::shell::Shell;
+use crate::utils;
+
+/// Show version and build info.
+#[derive(Args)]
+pub struct VersionArgs {
+    /// Show full info, including build, config, database and tools. This is
+    /// useful when reporting bugs.
+    #[clap(long, short)]
+    pub full: bool,
+}
+
+#[derive(Debug, Serialize)]
+struct VersionInfo {
+    version: String,
+    build: BuildInfo,
+    config: ConfigInfo,
+    database: DatabaseInfo,
+    tools: ToolsInfo,
+}
+
+#[derive(Debug, Serialize)]
+struct BuildInfo {
+    commit: String,
+    time: String,
+    rustc: String,
+    target: String,
+}
+
+#[derive(Debug, Serialize)]
+struct ConfigInfo {
+    dir: String,
+    workspace: String,
+    metadir: String,
+    statedir: String,
+    remotes: Vec<String>,
+}
+
+#[derive(Debug, Serialize)]
+struct DatabaseInfo {
+    path: String,
+    size: String,
+    repos: usize,
+}
+
+#[derive(Debug, Serialize)]
+struct ToolsInfo {
+    git: String,
+    fzf: String,
+}
+
+impl Run for VersionArgs {
+    fn run(&self) -> Result<()> {
+        let version = env!("CARGO_PKG_VERSION");
+        if !self.full {
+            println!("roxide {version}");
+            return Ok(());
+        }
+
+        let build_time: u64 = env!("ROXIDE_BUILD_TIME")
+            .parse()
+            .context("Parse build time")?;
+        let build = BuildInfo {
+            commit: String::from(env!("ROXIDE_BUILD_COMMIT")),
+            time: utils::format_time(build_time)?,
+            rustc: String::from(env!("ROXIDE_BUILD_RUSTC")),
+            target: String::from(env!("ROXIDE_BUILD_TARGET")),
+        };
+
+        let mut remotes: Vec<String> = config::list_remotes()
+            .into_iter()
+            .map(|remote| remote.to_string())
+            .collect();
+        remotes.sort();
+        let config = ConfigInfo {
+            dir: format!("{}", Config::dir()?.display()),
+            workspace: config::base().workspace.clone(),
+            metadir: config::base().metadir.clone(),
+            statedir: config::base().statedir.clone(),
+            remotes,
+        };
+
+        let db = Database::read()?;
+        let path = db.path().clone();
+        let database = DatabaseInfo {
+            path: format!("{}", path.display()),
+            size: Self::file_size(&path)?,
+            repos: db.list_all().len(),
+        };
+        drop(db);
+
+        let tools = ToolsInfo {
+            git: Self::tool_version("git"),
+            fzf: Self::tool_version("fzf"),
+        };
+
+        let info = VersionInfo {
+            version: String::from(version),
+            build,
+            config,
+            database,
+            tools,
+        };
+        let yaml = serde_yaml::to_string(&info).context("Encode info yaml")?;
+        print!("{yaml}");
+        Ok(())
+    }
+}
+
+impl VersionArgs {
+    fn file_size(path: &PathBuf) -> Result<String> {
+        match fs::metadata(path) {
+            Ok(meta) => Ok(utils::human_bytes(meta.len() as f64)),
+            Err(err) if err.kind() == ErrorKind::NotFound => Ok(String::from("<none>")),
+            Err(err) => Err(err).with_context(|| format!("Get metadata for {}", path.display())),
+        }
+    }
+
+    fn tool_version(program: &str) -> String {
+        let result = Shell::with_args(program, &["--version"])
+            .set_mute(true)
+            .execute()
+            .and_then(|mut result| result.checked_read());
+        match result {
+            Ok(version) => version,
+            Err(_) => String::from("<not found>"),
+        }
+    }
+}
@@ -0,0 +1,137 @@
+use std::io::Read;
+use std::path::PathBuf;
+use std::rc::Rc;
+
+use anyhow::{bail, Context, Result};
+use clap::Args;
+use console::style;
+use regex::Regex;
+
+use crate::cmd::run::home;
+use crate::cmd::Run;
+use crate::config::types::glob_to_regex;
+use crate::repo::database::Database;
+use crate::shell::Shell;
+use crate::utils;
+
+/// Find which repos contain the files matched by a glob pattern, such as
+/// `roxide which-repo '*.proto'`. Only the files tracked by git are searched.
+#[derive(Args)]
+pub struct WhichRepoArgs {
+    /// The glob pattern. If it contains `/`, match the whole path in repo,
+    /// such as `api/**/user.proto`, else, match the file name.
+    pub pattern: String,
+
+    /// The remote name or group (`@group`), default will search all repos.
+    pub remote: Option<String>,
+
+    /// The repo query, format is `owner[/[name]]`.
+    pub query: Option<String>,
+
+    /// Select one of the matched repos (if more than one), and jump to it.
+    /// This requires the shell function created by `roxide init`.
+    #[clap(long, short)]
+    pub open: bool,
+}
+
+impl Run for WhichRepoArgs {
+    fn run(&self) -> Result<()> {
+        if self.open && utils::is_non_interactive() {
+            bail!("The `--open` requires interactive mode");
+        }
+        let re = glob_to_regex(&self.pattern)
+            .with_context(|| format!("Parse glob pattern {}", self.pattern))?;
+        let full_path = self.pattern.contains('/');
+
+        let db = Database::read_only()?;
+        let (repos, level) = db.list_by_query(self.remote.as_deref(), self.query.as_deref())?;
+        if repos.is_empty() {
+            println!("No repo to search");
+            return Ok(());
+        }
+
+        let dirs: Vec<PathBuf> = repos.iter().map(|repo| repo.get_path()).collect();
+        let results = utils::batch(&dirs, |dir| Self::find(dir, &re, full_path));
+
+        let mut matched = Vec::new();
+        for (repo, result) in repos.iter().zip(results) {
+            let name = repo.as_string(&level);
+            match result {
+                Ok(files) if files.is_empty() => {}
+                Ok(files) => matched.push((Rc::clone(repo), name, files)),
+                Err(err) => utils::show_warn(format!("Search {name}: {err:#}")),
+            }
+        }
+        if matched.is_empty() {
+            bail!("No repo contains {}", self.pattern);
+        }
+
+        if self.open {
+            let (repos, items): (Vec<_>, Vec<_>) = matched
+                .iter()
+                .map(|(repo, name, files)| {
+                    (Rc::clone(repo), format!("{name} ({} files)", files.len()))
+                })
+                .unzip();
+            return home::select_enter(&repos, &items);
+        }
+
+        let mut count = 0;
+        for (_, name, files) in matched.iter() {
+            println!("{}", style(name).magenta());
+            for file in files {
+                println!("  {file}");
+            }
+            count += files.len();
+        }
+        println!();
+        println!("Summary: {count} files in {} repos", matched.len());
+        Ok(())
+    }
+}
+
+impl WhichRepoArgs {
+    /// Use `git ls-files` instead of walking the directory, it is much faster
+    /// and skips the ignored files (such as build outputs).
+    fn find(dir: &PathBuf, re: &Regex, full_path: bool) -> Result<Vec<String>> {
+        // The repo might not be cloned yet, skip it.
+        if !dir.exists() {
+            return Ok(Vec::new());
+        }
+        // Run by `Shell`, so that the command is killed when exceeding the
+        // `batch_timeout`.
+        let mut result = Shell::git(&["ls-files", "-z"])
+            .with_path(dir)
+            .without_stdin()
+            .set_mute(true)
+            .execute()?;
+        if result.code != Some(0) {
+            bail!("git ls-files exited with code {:?}", result.code);
+        }
+        let mut output = Vec::new();
+        result
+            .stdout
+            .read_to_end(&mut output)
+            .context("Read output of git ls-files")?;
+
+        let stdout = String::from_utf8_lossy(&output);
+        let files = stdout
+            .split('\0')
+            .filter(|path| {
+                if path.is_empty() {
+                    return false;
+                }
+                if full_path {
+                    return re.is_match(path);
+                }
+                let name = match path.rsplit_once('/') {
+                    Some((_, name)) => name,
+                    None => path,
+                };
+                re.is_match(name)
+            })
+            .map(|path| path.to_string())
+            .collect();
+        Ok(files)
+    }
+}
@@ -1,13 +1,31 @@
 use std::collections::HashMap;
+use std::env;
+use std::path::Path;
 
-use crate::config::types::{Base, Command, Owner, WorkflowStep};
+use crate::config::types::{
+    Base, Bootstrap, Command, CommitRule, Owner, Schedule, Tmux, WorkflowStep,
+};
+use crate::utils;
 
 pub fn workspace() -> String {
     String::from("~/src")
 }
 
 pub fn metadir() -> String {
-    String::from("~/.local/share/roxide")
+    xdg_dir("XDG_DATA_HOME", "~/.local/share")
+}
+
+pub fn statedir() -> String {
+    xdg_dir("XDG_STATE_HOME", "~/.local/state")
+}
+
+/// Get the roxide directory under the XDG base directory. According to the
+/// spec, the env should be ignored if it is not an absolute path.
+pub fn xdg_dir(name: &str, fallback: &str) -> String {
+    match env::var(name) {
+        Ok(dir) if Path::new(&dir).is_absolute() => format!("{dir}/roxide"),
+        _ => format!("{fallback}/roxide"),
+    }
 }
 
 pub fn command() -> Command {
@@ -22,18 +40,30 @@ pub fn empty_map() -> HashMap<String, String> {
     HashMap::new()
 }
 
+pub fn empty_vec() -> Vec<String> {
+    Vec::new()
+}
+
+pub fn groups() -> HashMap<String, Vec<String>> {
+    HashMap::new()
+}
+
 pub fn workflows() -> HashMap<String, Vec<WorkflowStep>> {
     HashMap::new()
 }
 
 pub fn release() -> HashMap<String, String> {
-    let mut hmap = HashMap::with_capacity(5);
+    let mut hmap = HashMap::with_capacity(6);
     hmap.insert(String::from("patch"), String::from("v{0}.{1}.{2+}"));
     hmap.insert(String::from("minor"), String::from("v{0}.{1+}.0"));
     hmap.insert(String::from("major"), String::from("v{0+}.0.0"));
 
     hmap.insert(String::from("date-stash"), String::from("{%Y}-{%m}-{%d}"));
     hmap.insert(String::from("date-dot"), String::from("{%Y}.{%m}.{%d}"));
+    hmap.insert(
+        String::from("date-seq"),
+        String::from("{%Y}.{%m}.{%d}-{seq}"),
+    );
 
     hmap
 }
@@ -54,16 +84,119 @@ pub fn api_timeout() -> u64 {
     10
 }
 
+pub fn complete_timeout() -> u64 {
+    1
+}
+
+pub fn max_accessed() -> u64 {
+    10000
+}
+
 pub fn base() -> Base {
     Base {
         workspace: workspace(),
         metadir: metadir(),
+        statedir: statedir(),
         command: command(),
         workflows: workflows(),
         release: release(),
+        max_accessed: max_accessed(),
+        pin_first: enable(),
+        ignore: empty_vec(),
+        ignore_regex: Vec::new(),
+        groups: groups(),
+        groups_regex: HashMap::new(),
+        shortcuts: shortcuts(),
+        metrics: disable(),
+        workspace_budget: None,
+        workspace_budget_bytes: 0,
+        editor: editor(),
+        browser: None,
+        maintain_tasks: maintain_tasks(),
+        batch_timeout: batch_timeout(),
+        bootstrap: bootstrap(),
+        tmux: tmux(),
+        schedule: schedule(),
+    }
+}
+
+pub fn maintain_tasks() -> Vec<String> {
+    vec![String::from("gc --quiet")]
+}
+
+pub fn batch_timeout() -> u64 {
+    600
+}
+
+pub fn bootstrap() -> Bootstrap {
+    Bootstrap {
+        gitignore: empty_map(),
+        license: empty_map(),
+    }
+}
+
+pub fn shortcuts() -> u32 {
+    9
+}
+
+pub fn editor() -> String {
+    String::from("code")
+}
+
+pub fn commit_rule() -> CommitRule {
+    CommitRule {
+        types: commit_types(),
+        scopes: empty_vec(),
+        require_scope: disable(),
+        max_length: commit_max_length(),
+        hook: disable(),
+    }
+}
+
+pub fn commit_types() -> Vec<String> {
+    vec![
+        "feat", "fix", "docs", "style", "refactor", "perf", "test", "build", "ci", "chore",
+        "revert",
+    ]
+    .into_iter()
+    .map(String::from)
+    .collect()
+}
+
+pub fn commit_max_length() -> usize {
+    72
+}
+
+pub fn tmux() -> Tmux {
+    Tmux {
+        session: tmux_session(),
+        rename_on_home: disable(),
     }
 }
 
+pub fn schedule() -> Schedule {
+    Schedule {
+        commands: schedule_commands(),
+        interval: schedule_interval(),
+    }
+}
+
+pub fn schedule_commands() -> Vec<String> {
+    vec![String::from("maintain")]
+}
+
+pub fn schedule_interval() -> u64 {
+    utils::DAY
+}
+
+pub fn tmux_session() -> String {
+    String::from("{owner}/{name}")
+}
+
 pub fn disable() -> bool {
     false
 }
+
+pub fn enable() -> bool {
+    true
+}
@@ -1,4 +1,5 @@
 mod default;
+pub mod schema;
 pub mod types;
 
 use std::path::PathBuf;
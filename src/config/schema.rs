@@ -0,0 +1,23 @@
+use schemars::schema::RootSchema;
+use schemars::schema_for;
+
+use crate::config::types::{Base, Remote};
+
+/// The JSON Schema of base config file (`config.yml`), editors (such as vscode
+/// with yaml-language-server) can use it to validate and complete the config.
+///
+/// The schema is derived from the config types, the field docs are used as the
+/// descriptions, so it never goes out of sync with the fields roxide reads.
+pub fn base() -> RootSchema {
+    with_title(schema_for!(Base), "roxide config")
+}
+
+/// The JSON Schema of remote config file (`{remote}.yml`).
+pub fn remote() -> RootSchema {
+    with_title(schema_for!(Remote), "roxide remote config")
+}
+
+fn with_title(mut schema: RootSchema, title: &str) -> RootSchema {
+    schema.schema.metadata().title = Some(String::from(title));
+    schema
+}
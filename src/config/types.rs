@@ -1,25 +1,34 @@
 use std::collections::HashMap;
 use std::env;
 use std::fs::{self, File};
-use std::io::ErrorKind;
+use std::io::{self, ErrorKind};
 use std::path::{Path, PathBuf};
 
 use anyhow::{bail, Context, Result};
+use regex::Regex;
+use schemars::JsonSchema;
 use serde::Deserialize;
 
-use crate::config::default;
+use crate::config::{self, default};
+use crate::utils;
 
 /// The basic configuration, defining some global behaviors of roxide.
-#[derive(Debug, Deserialize)]
+#[derive(Debug, Deserialize, JsonSchema)]
 pub struct Base {
     /// The working directory, where all repo will be stored.
     #[serde(default = "default::workspace")]
     pub workspace: String,
 
-    /// Store some meta data of repo, including index, cache, etc.
+    /// Store some meta data of repo, including index, cache, etc. Default is
+    /// under `XDG_DATA_HOME`.
     #[serde(default = "default::metadir")]
     pub metadir: String,
 
+    /// Store the state data, such as metrics, jobs and logs. Default is under
+    /// `XDG_STATE_HOME`.
+    #[serde(default = "default::statedir")]
+    pub statedir: String,
+
     /// Command map.
     #[serde(default = "default::command")]
     pub command: Command,
@@ -31,12 +40,147 @@ pub struct Base {
     /// The release rule
     #[serde(default = "default::release")]
     pub release: HashMap<String, String>,
+
+    /// The max total access count of all repos. When the total exceeds it,
+    /// the access counts will be scaled down proportionally when saving the
+    /// database, so that long-abandoned repos age out of the top of fuzzy
+    /// ranking. Set to 0 to disable aging.
+    #[serde(default = "default::max_accessed")]
+    pub max_accessed: u64,
+
+    /// If true, pinned repos are ranked above others in fuzzy matching and
+    /// searching, regardless of their scores.
+    #[serde(default = "default::enable")]
+    pub pin_first: bool,
+
+    /// The glob patterns of repos to ignore, matched against the full name
+    /// `{remote}:{owner}/{name}`, such as `github:vendor/*` or `*:archive/**`.
+    /// `*` matches anything except `/`, `**` matches anything. Ignored repos
+    /// are hidden from fuzzy matching, searching and listing unless `--all` is
+    /// provided, but they can still be accessed by exact name.
+    #[serde(default = "default::empty_vec")]
+    pub ignore: Vec<String>,
+
+    #[serde(skip)]
+    pub ignore_regex: Vec<Regex>,
+
+    /// The named groups of repos, such as `platform`. The value is the glob
+    /// patterns matched against the full name, like `ignore`. A group can be
+    /// used as `@platform` in place of the remote in multi-repo commands, such
+    /// as `exec`, `maintain`, `check`, `dirty` and `get`. It has higher
+    /// priority than the `@lang` filter of the same name.
+    #[serde(default = "default::groups")]
+    pub groups: HashMap<String, Vec<String>>,
+
+    #[serde(skip)]
+    pub groups_regex: HashMap<String, Vec<Regex>>,
+
+    /// The number of top repos to assign numeric shortcuts, so that they can
+    /// be accessed by `roxide home {num}`. The shortcuts are refreshed once a
+    /// day. Set to 0 to disable.
+    #[serde(default = "default::shortcuts")]
+    pub shortcuts: u32,
+
+    /// If true, record the name, duration and result of each command to a
+    /// local file, use `roxide get --metrics` to view them.
+    #[serde(default = "default::disable")]
+    pub metrics: bool,
+
+    /// The disk budget of the workspace, such as `50GB`. roxide warns when
+    /// the total size of repos in workspace exceeds it after cloning or
+    /// syncing, and suggests the large repos not visited for a long time to
+    /// remove. Default has no budget.
+    pub workspace_budget: Option<String>,
+
+    #[serde(skip)]
+    pub workspace_budget_bytes: u64,
+
+    /// The editor command used by `roxide code`, such as `code`, `nvim` or
+    /// `idea`. The repo path will be appended as the last argument.
+    #[serde(default = "default::editor")]
+    pub editor: String,
+
+    /// The command to open urls, such as `firefox` or a script forwarding the
+    /// url to your local machine. The url will be appended as the last
+    /// argument. Default will use the system default browser, or print the
+    /// url as a terminal hyperlink in SSH sessions.
+    pub browser: Option<String>,
+
+    /// The git tasks run by `roxide maintain` for each repo, such as `gc` or
+    /// `maintenance run --task=incremental-repack`.
+    #[serde(default = "default::maintain_tasks")]
+    pub maintain_tasks: Vec<String>,
+
+    /// The timeout seconds of each task in batch operations (such as
+    /// `maintain` and `check`). The git processes of a timed out task will be
+    /// killed and the task is marked as failed, so that a hung repo (such as
+    /// a dead ssh host) won't stall the whole batch. Set to 0 to disable.
+    #[serde(default = "default::batch_timeout")]
+    pub batch_timeout: u64,
+
+    /// The templates used to bootstrap a repo created locally.
+    #[serde(default = "default::bootstrap")]
+    pub bootstrap: Bootstrap,
+
+    /// The tmux integration config.
+    #[serde(default = "default::tmux")]
+    pub tmux: Tmux,
+
+    /// The periodic job config, use `roxide init systemd` (or `launchd` for
+    /// macOS) to install it.
+    #[serde(default = "default::schedule")]
+    pub schedule: Schedule,
+}
+
+/// The roxide commands run periodically by system scheduler.
+#[derive(Debug, Deserialize, JsonSchema)]
+pub struct Schedule {
+    /// The commands to run, without `roxide`, such as `maintain` or
+    /// `dirty --fail-on conflict`. They are run in non-interactive mode.
+    #[serde(default = "default::schedule_commands")]
+    pub commands: Vec<String>,
+
+    /// The interval seconds between runs.
+    #[serde(default = "default::schedule_interval")]
+    pub interval: u64,
+}
+
+/// When creating a repo locally (remote without clone), roxide can generate
+/// `.gitignore` and `LICENSE` files from these templates. The template is
+/// chosen by the owner's `language` and `license`, or selected by user.
+#[derive(Debug, Deserialize, JsonSchema)]
+pub struct Bootstrap {
+    /// The `.gitignore` templates, the key is language, such as `rust`.
+    #[serde(default = "default::empty_map")]
+    pub gitignore: HashMap<String, String>,
+
+    /// The `LICENSE` templates, the key is license name, such as `MIT`. The
+    /// placeholders `{year}` and `{user}` will be replaced with current year
+    /// and remote's `user`.
+    #[serde(default = "default::empty_map")]
+    pub license: HashMap<String, String>,
+}
+
+/// The tmux integration, `roxide tmux` creates or attaches a session for a
+/// repo.
+#[derive(Debug, Deserialize, JsonSchema)]
+pub struct Tmux {
+    /// The session name template, supports placeholders `{remote}`, `{owner}`
+    /// and `{name}`. The characters not allowed by tmux (`.` and `:`) will be
+    /// replaced with `_`.
+    #[serde(default = "default::tmux_session")]
+    pub session: String,
+
+    /// If true, `roxide home` renames the current tmux session after the repo
+    /// when running inside tmux.
+    #[serde(default = "default::disable")]
+    pub rename_on_home: bool,
 }
 
 /// The command mapping, in order to change the current directory, the `roxide`
 /// command cannot be used directly, and some shell functions need to be defined.
 /// This configuration defines shell function names.
-#[derive(Debug, Deserialize)]
+#[derive(Debug, Deserialize, JsonSchema)]
 pub struct Command {
     /// The direct mapping of the `roxide` command, but on the basis of roxide,
     /// it will change the current shell directory.
@@ -57,7 +201,7 @@ pub struct Command {
 
 /// Indicates an execution step in Workflow, which can be writing a file or
 /// executing a shell command.
-#[derive(Debug, Deserialize)]
+#[derive(Debug, Deserialize, JsonSchema)]
 pub struct WorkflowStep {
     /// If the Step is to write to a file, then name is the file name. If Step
     /// is an execution command, name is the name of the step. (required)
@@ -70,7 +214,7 @@ pub struct WorkflowStep {
 }
 
 /// Remote is a Git remote repository. Typical examples are Github and Gitlab.
-#[derive(Debug, Deserialize)]
+#[derive(Debug, Deserialize, JsonSchema)]
 pub struct Remote {
     #[serde(skip)]
     pub name: String,
@@ -99,6 +243,40 @@ pub struct Remote {
     #[serde(default = "default::disable")]
     pub ssh: bool,
 
+    /// If true, and the repo is cloned by https, roxide will pass `token` to git
+    /// through an askpass shim (stored under `{metadir}`), so that private repo
+    /// can be cloned without ssh. The token is passed by environment variable,
+    /// it won't be written to the clone url or any file.
+    #[serde(default = "default::disable")]
+    pub https_auth: bool,
+
+    /// The username sent along with the token in https authentication. This
+    /// is not the commit author `user`. Default is `x-access-token` for Github
+    /// and `oauth2` for others, which are accepted by their token logins.
+    pub https_user: Option<String>,
+
+    /// The ssh user used in clone url, default is "git".
+    pub ssh_user: Option<String>,
+
+    /// The ssh port used in clone url. If your self-built remote serves ssh on
+    /// a non-standard port (such as 2222), set this and roxide will use the
+    /// `ssh://{ssh_user}@{clone_domain}:{ssh_port}/{repo_owner}/{repo_name}.git`
+    /// format to clone repo.
+    pub ssh_port: Option<u16>,
+
+    /// The ssh command used by git, such as `ssh -i ~/.ssh/id_work`. It will be
+    /// exported as `GIT_SSH_COMMAND` when cloning, and written to the repo's
+    /// `core.sshCommand` config, so that later git operations in this repo use
+    /// it too.
+    pub ssh_command: Option<String>,
+
+    /// The host alias defined in your `~/.ssh/config`, such as `gh-work`. If
+    /// set, the ssh clone url will use it instead of `clone_domain`, so that
+    /// the ssh config for that alias (such as a different key) takes effect.
+    /// Urls using this alias, like `git@gh-work:org/repo.git`, will also be
+    /// resolved to this remote.
+    pub ssh_alias: Option<String>,
+
     /// The remote provider, If not empty, roxide will use remote api to enhance
     /// some capabilities, such as searching repos from remote.
     ///
@@ -112,6 +290,11 @@ pub struct Remote {
     /// For Gitlab, see: https://docs.gitlab.com/ee/user/profile/personal_access_tokens.html
     pub token: Option<String>,
 
+    /// The client id of the OAuth app, used by `roxide auth login` to get
+    /// token through the device authorization flow, so that you don't need to
+    /// create token manually. The app should enable device flow.
+    pub oauth_client_id: Option<String>,
+
     /// The alias name of the Owner, which is used to quickly access the owner on
     /// the command line.
     ///
@@ -138,7 +321,19 @@ pub struct Remote {
     #[serde(default = "default::cache_hours")]
     pub cache_hours: u32,
 
-    /// Some personalized configurations for different owners.
+    /// If true, completing `remote owner/` will also list repos from remote api
+    /// (cache will be used), so that you can complete repos that are not
+    /// cloned yet. To prevent the shell from hanging, the api timeout is limited
+    /// to `complete_timeout` seconds in completion.
+    #[serde(default = "default::disable")]
+    pub complete_api: bool,
+
+    /// The api timeout seconds used in completion.
+    #[serde(default = "default::complete_timeout")]
+    pub complete_timeout: u64,
+
+    /// Some personalized configurations for different owners. The key can be
+    /// either the owner or its alias in `owner_alias`.
     #[serde(default = "default::owners")]
     pub owners: HashMap<String, Owner>,
 
@@ -150,13 +345,43 @@ pub struct Remote {
     #[serde(default = "default::api_timeout")]
     pub api_timeout: u64,
 
+    /// The http proxy used to access this remote, such as
+    /// `http://127.0.0.1:7890`. It will be applied to the remote api client, and
+    /// exported as `http_proxy` and `https_proxy` to git when cloning.
+    pub http_proxy: Option<String>,
+
+    /// The socks5 proxy used to access this remote, such as
+    /// `socks5://127.0.0.1:1080`. It will be applied to the remote api client,
+    /// and exported as `ALL_PROXY` to git when cloning. It takes precedence
+    /// over `http_proxy`.
+    pub socks5_proxy: Option<String>,
+
+    /// The hosts that should not use proxy, split by comma, such as
+    /// `localhost,.mycompany.com`.
+    pub no_proxy: Option<String>,
+
     /// API domain, only useful for Gitlab. If your Git remote is self-built, it
     /// should be set to your self-built domain host.
     pub api_domain: Option<String>,
+
+    /// The regex to find the error lines in failed job logs, used by
+    /// `roxide get --job-logs`. Default will match common words such as
+    /// `error`, `failed` and `panic`.
+    pub job_error_pattern: Option<String>,
+
+    /// If not empty, override the global `browser` command to open the urls
+    /// of this remote.
+    pub browser: Option<String>,
+
+    /// The default body template when creating merge request (or PR for
+    /// Github). The template in repository, such as
+    /// `.github/PULL_REQUEST_TEMPLATE.md` and `.gitlab/merge_request_templates`,
+    /// has higher priority.
+    pub merge_template: Option<String>,
 }
 
 /// The remote api provider type.
-#[derive(Debug, Deserialize)]
+#[derive(Debug, Deserialize, JsonSchema)]
 pub enum Provider {
     #[serde(rename = "github")]
     Github,
@@ -165,13 +390,91 @@ pub enum Provider {
 }
 
 /// Owner configuration. Some configurations will override remote's.
-#[derive(Debug, Deserialize)]
+#[derive(Debug, Deserialize, JsonSchema)]
 pub struct Owner {
     /// If not empty, override remote's ssh.
     pub ssh: Option<bool>,
 
+    /// If not empty, override remote's https_auth.
+    pub https_auth: Option<bool>,
+
+    /// If not empty, override remote's token when calling api for the repos
+    /// of this owner, such as using a bot account for the work organization.
+    /// The api cache of this token is separated from remote's.
+    pub token: Option<String>,
+
     /// After cloning or creating a repo, perform some additional workflows.
     pub on_create: Option<Vec<String>>,
+
+    /// The initial branch name when creating a repo locally (remote without
+    /// clone), such as "main". Default will use git's `init.defaultBranch`.
+    pub default_branch_name: Option<String>,
+
+    /// The branch name template used by `roxide branch -c`, such as
+    /// `feat/{user}/{name}`. `{name}` will be replaced with the name given in
+    /// command line, `{user}` will be replaced with remote's `user`. You can
+    /// use `--raw` to skip the template.
+    pub branch_template: Option<String>,
+
+    /// If not empty, override the editor command used by `roxide code`.
+    pub editor: Option<String>,
+
+    /// The language of new repos, used to choose the `.gitignore` template
+    /// in bootstrap config.
+    pub language: Option<String>,
+
+    /// The license of new repos, used to choose the `LICENSE` template in
+    /// bootstrap config.
+    pub license: Option<String>,
+
+    /// The direnv `.envrc` template, it will be rendered into the repo root
+    /// when creating, cloning or attaching a repo. Supports placeholders
+    /// `{remote}`, `{owner}`, `{name}` and `{path}`. Use `roxide env` to view
+    /// the rendered content.
+    pub envrc: Option<String>,
+
+    /// The default reviewers (usernames) when creating merge request, used if
+    /// `--reviewer` is not provided.
+    pub reviewers: Option<Vec<String>>,
+
+    /// The default assignees (usernames) when creating merge request, used if
+    /// `--assignee` is not provided.
+    pub assignees: Option<Vec<String>>,
+
+    /// The default labels when creating merge request, used if `--label` is
+    /// not provided.
+    pub labels: Option<Vec<String>>,
+
+    /// The conventional commit rule used by `roxide create commit`, if not provided,
+    /// the default rule is used.
+    pub commit: Option<CommitRule>,
+}
+
+/// The conventional commit rule, the message header should be like
+/// `type(scope): subject`, see: https://www.conventionalcommits.org
+#[derive(Debug, Clone, Deserialize, JsonSchema)]
+pub struct CommitRule {
+    /// The allowed commit types, such as `feat` and `fix`.
+    #[serde(default = "default::commit_types")]
+    pub types: Vec<String>,
+
+    /// The allowed scopes, empty means any scope is allowed.
+    #[serde(default = "default::empty_vec")]
+    pub scopes: Vec<String>,
+
+    /// If true, the scope is required.
+    #[serde(default = "default::disable")]
+    pub require_scope: bool,
+
+    /// The max length of the message header (the first line).
+    #[serde(default = "default::commit_max_length")]
+    pub max_length: usize,
+
+    /// If true, install a `commit-msg` hook to lint the messages when cloning
+    /// or creating a repo, so that the messages committed by git directly are
+    /// also checked.
+    #[serde(default = "default::disable")]
+    pub hook: bool,
 }
 
 impl Remote {
@@ -179,16 +482,222 @@ impl Remote {
         if let Some(token) = &self.token {
             self.token = Some(expandenv(token).context("Expand token")?);
         }
+        if let Some(ssh_command) = &self.ssh_command {
+            self.ssh_command = Some(expandenv(ssh_command).context("Expand ssh_command")?);
+        }
+        for (name, owner) in self.owners.iter_mut() {
+            if let Some(token) = &owner.token {
+                let token = expandenv(token).with_context(|| format!("Expand token of {name}"))?;
+                owner.token = Some(token);
+            }
+        }
+        // The owners config can be keyed by alias, convert them to the canonical
+        // owner, which is what stored in database.
+        let aliased: Vec<_> = self
+            .owners
+            .keys()
+            .filter(|key| self.owner_alias.contains_key(key.as_str()))
+            .cloned()
+            .collect();
+        for alias in aliased {
+            let owner = self.owners.remove(&alias).unwrap();
+            let raw_owner = self.owner_alias.get(&alias).unwrap().clone();
+            if self.owners.contains_key(&raw_owner) {
+                bail!("The owner {raw_owner} is configured twice, both by itself and its alias {alias}");
+            }
+            self.owners.insert(raw_owner, owner);
+        }
+        Ok(())
+    }
+
+    /// Return true if the clone domain is excluded by `no_proxy`.
+    pub fn is_no_proxy(&self) -> bool {
+        match (&self.no_proxy, &self.clone) {
+            (Some(no_proxy), Some(host)) => match_no_proxy(no_proxy, host),
+            _ => false,
+        }
+    }
+
+    /// Resolve the owner from command line, the trailing `/` is trimmed and the
+    /// alias is converted to the canonical owner.
+    pub fn resolve_owner<'a>(&'a self, owner: &'a str) -> &'a str {
+        let owner = owner.trim_end_matches("/");
+        match self.owner_alias.get(owner) {
+            Some(raw_owner) => raw_owner.as_str(),
+            None => owner,
+        }
+    }
+
+    /// Return error if the remote has no provider, for the commands calling
+    /// remote API.
+    pub fn check_provider(&self) -> Result<()> {
+        if let None = self.provider {
+            bail!("The remote {} has no provider", self.name);
+        }
         Ok(())
     }
+
+    /// Get the browser command to open the urls of this remote, fallback to
+    /// the global `browser` command.
+    pub fn browser(&self) -> Option<&str> {
+        match self.browser.as_deref() {
+            Some(browser) => Some(browser),
+            None => config::base().browser.as_deref(),
+        }
+    }
+
+    /// Get the commit rule of the owner, use the default rule if not
+    /// configured.
+    pub fn commit_rule(&self, owner: &str) -> CommitRule {
+        match self.owners.get(owner) {
+            Some(Owner {
+                commit: Some(rule), ..
+            }) => rule.clone(),
+            _ => CommitRule::default(),
+        }
+    }
+}
+
+impl Default for CommitRule {
+    fn default() -> Self {
+        default::commit_rule()
+    }
 }
 
 impl Base {
     fn validate(&mut self) -> Result<()> {
         self.workspace = expandenv(&self.workspace).context("Expand workspace")?;
         self.metadir = expandenv(&self.metadir).context("Expand metadir")?;
+        self.statedir = expandenv(&self.statedir).context("Expand statedir")?;
+        for pattern in self.ignore.iter() {
+            let regex = glob_to_regex(pattern)
+                .with_context(|| format!("Parse ignore pattern {pattern}"))?;
+            self.ignore_regex.push(regex);
+        }
+        for (name, patterns) in self.groups.iter() {
+            let mut regexes = Vec::with_capacity(patterns.len());
+            for pattern in patterns {
+                let regex = glob_to_regex(pattern)
+                    .with_context(|| format!("Parse pattern {pattern} of group {name}"))?;
+                regexes.push(regex);
+            }
+            self.groups_regex.insert(name.clone(), regexes);
+        }
+        if let Some(budget) = &self.workspace_budget {
+            self.workspace_budget_bytes =
+                utils::parse_bytes(budget).context("Parse workspace budget")?;
+        }
+        Ok(())
+    }
+
+    /// The state files used to be stored under metadir. The jobs are stored
+    /// in database now, so they are moved with the metadir.
+    const STATE_FILES: [&str; 3] = ["metrics.jsonl", "sync_reports.json", "schedule.log"];
+
+    /// The marker file in statedir, written after migrating, so that the
+    /// migration runs only once rather than on every command.
+    const MIGRATED_MARKER: &str = "migrated";
+
+    /// Migrate the data from the old locations, which were not following the
+    /// XDG base directories. The migration runs only once, if it fails, the
+    /// user is warned to move the data manually, and the command continues.
+    /// The failed migration is retried by the next command.
+    fn migrate(&self) -> Result<()> {
+        let marker = PathBuf::from(&self.statedir).join(Self::MIGRATED_MARKER);
+        if exists(&marker)? {
+            return Ok(());
+        }
+        if let Err(err) = self.migrate_paths() {
+            utils::show_warn(format!("{err:#}"));
+            return Ok(());
+        }
+        utils::write_file(&marker, b"")
+    }
+
+    fn migrate_paths(&self) -> Result<()> {
+        // Only migrate the default metadir, the user configured one should
+        // be kept as it is.
+        let metadir = expandenv(&default::metadir()).context("Expand default metadir")?;
+        if self.metadir == metadir {
+            let legacy = expandenv(&String::from("~/.local/share/roxide"))
+                .context("Expand legacy metadir")?;
+            migrate_path(&PathBuf::from(legacy), &PathBuf::from(&self.metadir))?;
+        }
+        if self.statedir != self.metadir {
+            let metadir = PathBuf::from(&self.metadir);
+            let statedir = PathBuf::from(&self.statedir);
+            for name in Self::STATE_FILES {
+                migrate_path(&metadir.join(name), &statedir.join(name))?;
+            }
+        }
         Ok(())
     }
+
+    pub fn is_ignored(&self, full_name: impl AsRef<str>) -> bool {
+        self.ignore_regex
+            .iter()
+            .any(|regex| regex.is_match(full_name.as_ref()))
+    }
+
+    /// Parse the group name from arg `@name`, return None if the arg is not
+    /// a configured group.
+    pub fn parse_group<'a>(&self, arg: &'a str) -> Option<&'a str> {
+        let name = arg.strip_prefix("@")?;
+        if self.groups.contains_key(name) {
+            return Some(name);
+        }
+        None
+    }
+
+    pub fn in_group(&self, group: &str, full_name: impl AsRef<str>) -> bool {
+        match self.groups_regex.get(group) {
+            Some(regexes) => regexes
+                .iter()
+                .any(|regex| regex.is_match(full_name.as_ref())),
+            None => false,
+        }
+    }
+}
+
+/// Convert glob pattern to regex, `*` matches anything except `/`, `**` matches
+/// anything and `?` matches one character except `/`. The `**/` matches zero
+/// or more directories, so `api/**/user.proto` matches `api/user.proto`.
+pub fn glob_to_regex(pattern: &str) -> Result<Regex> {
+    compile_glob(pattern, "[^/]")
+}
+
+/// Convert glob pattern of git refs (branches and tags) to regex. Unlike
+/// [`glob_to_regex`], `*` and `?` also match `/`, the same as `git branch
+/// --list`, so `feature*` matches `feature/x`.
+pub fn ref_glob_to_regex(pattern: &str) -> Result<Regex> {
+    compile_glob(pattern, ".")
+}
+
+fn compile_glob(pattern: &str, any: &str) -> Result<Regex> {
+    let mut expr = String::from("^");
+    let mut chars = pattern.chars().peekable();
+    while let Some(ch) = chars.next() {
+        match ch {
+            '*' => {
+                if let Some('*') = chars.peek() {
+                    chars.next();
+                    if let Some('/') = chars.peek() {
+                        chars.next();
+                        expr.push_str("(?:.*/)?");
+                    } else {
+                        expr.push_str(".*");
+                    }
+                } else {
+                    expr.push_str(any);
+                    expr.push('*');
+                }
+            }
+            '?' => expr.push_str(any),
+            _ => expr.push_str(&regex::escape(&ch.to_string())),
+        }
+    }
+    expr.push('$');
+    Ok(Regex::new(&expr)?)
 }
 
 #[derive(Debug)]
@@ -211,18 +720,30 @@ impl Config {
         // priority is higher.
         match env::var_os("ROXIDE_CONFIG_DIR") {
             Some(dir) => Ok(PathBuf::from(dir)),
-            // Use env "${HOME}" to get home directory, this won't be worked on
-            // Windows, since we have no plan to support Windows, so it is okay.
-            None => match env::var_os("HOME") {
-                // Use ~/.config/roxide as the default config directory
-                Some(home) => Ok(PathBuf::from(home).join(".config").join("roxide")),
-                None => bail!("Could not find HOME env"),
+            None => match env::var("XDG_CONFIG_HOME") {
+                Ok(dir) if Path::new(&dir).is_absolute() => Ok(PathBuf::from(dir).join("roxide")),
+                _ => Self::legacy_dir(),
             },
         }
     }
 
+    /// Use ~/.config/roxide as the default config directory, it was the only
+    /// location before supporting `XDG_CONFIG_HOME`.
+    fn legacy_dir() -> Result<PathBuf> {
+        Ok(utils::home_dir()?.join(".config").join("roxide"))
+    }
+
     pub fn read() -> Result<Config> {
-        let dir = Self::dir()?;
+        let mut dir = Self::dir()?;
+        if env::var_os("ROXIDE_CONFIG_DIR").is_none() {
+            // Like the data migration, the failure should not break the
+            // command, keep reading the legacy dir until it is moved.
+            let legacy = Self::legacy_dir()?;
+            if let Err(err) = migrate_path(&legacy, &dir) {
+                utils::show_warn(format!("Migrate config dir: {err:#}"));
+                dir = legacy;
+            }
+        }
         let mut cfg = match fs::read_dir(&dir) {
             Ok(read_dir) => {
                 let mut remotes: Vec<RemoteFile> = Vec::new();
@@ -299,6 +820,7 @@ impl Config {
             }
         };
         cfg.base.validate().context("Validate base config")?;
+        cfg.base.migrate().context("Migrate data")?;
 
         Ok(cfg)
     }
@@ -336,7 +858,170 @@ impl Config {
     }
 }
 
+/// Move the file or directory from the old location to the new one. Do nothing
+/// if the new one already exists, or the old one does not exist.
+fn migrate_path(from: &PathBuf, to: &PathBuf) -> Result<()> {
+    if from == to || exists(to)? || !exists(from)? {
+        return Ok(());
+    }
+    utils::ensure_dir(to)?;
+    let result = match fs::rename(from, to) {
+        // The rename could not move data across filesystems, copy it and then
+        // remove the old one.
+        Err(err) if is_cross_device(&err) => copy_path(from, to).and_then(|_| remove_path(from)),
+        result => result,
+    };
+    result.with_context(|| {
+        format!(
+            "Move {} to {}, please move it manually",
+            from.display(),
+            to.display()
+        )
+    })?;
+    utils::show_info(format!("Migrate {} to {}", from.display(), to.display()));
+    Ok(())
+}
+
+#[cfg(unix)]
+fn is_cross_device(err: &io::Error) -> bool {
+    err.raw_os_error() == Some(libc::EXDEV)
+}
+
+#[cfg(windows)]
+fn is_cross_device(err: &io::Error) -> bool {
+    // ERROR_NOT_SAME_DEVICE
+    err.raw_os_error() == Some(17)
+}
+
+fn copy_path(from: &PathBuf, to: &PathBuf) -> io::Result<()> {
+    if !fs::metadata(from)?.is_dir() {
+        fs::copy(from, to)?;
+        return Ok(());
+    }
+    fs::create_dir_all(to)?;
+    for entry in fs::read_dir(from)? {
+        let entry = entry?;
+        copy_path(&entry.path(), &to.join(entry.file_name()))?;
+    }
+    Ok(())
+}
+
+fn remove_path(path: &PathBuf) -> io::Result<()> {
+    if fs::metadata(path)?.is_dir() {
+        return fs::remove_dir_all(path);
+    }
+    fs::remove_file(path)
+}
+
+fn exists(path: &PathBuf) -> Result<bool> {
+    match fs::metadata(path) {
+        Ok(_) => Ok(true),
+        Err(err) if err.kind() == ErrorKind::NotFound => Ok(false),
+        Err(err) => Err(err).with_context(|| format!("Read metadata of {}", path.display())),
+    }
+}
+
 fn expandenv(s: &String) -> Result<String> {
     let s = shellexpand::full(s.as_str()).with_context(|| format!("Expand env for \"{s}\""))?;
     Ok(s.to_string())
 }
+
+/// Match the host against the `no_proxy` list, following the common rules:
+/// `*` matches all hosts, `.example.com` and `example.com` both match
+/// `example.com` and its sub-domains.
+fn match_no_proxy(no_proxy: &str, host: &str) -> bool {
+    let host = host.to_lowercase();
+    no_proxy
+        .split(',')
+        .map(|item| item.trim().to_lowercase())
+        .filter(|item| !item.is_empty())
+        .any(|item| {
+            if item == "*" {
+                return true;
+            }
+            let domain = item.trim_start_matches('.');
+            host == domain || host.ends_with(&format!(".{domain}"))
+        })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_match_no_proxy() {
+        assert!(match_no_proxy("*", "github.com"));
+        assert!(match_no_proxy("localhost, github.com", "github.com"));
+        assert!(match_no_proxy(".mycompany.com", "git.mycompany.com"));
+        assert!(match_no_proxy("mycompany.com", "git.mycompany.com"));
+        assert!(match_no_proxy("mycompany.com", "MyCompany.com"));
+        assert!(!match_no_proxy("mycompany.com", "notmycompany.com"));
+        assert!(!match_no_proxy("", "github.com"));
+        assert!(!match_no_proxy("gitlab.com", "github.com"));
+    }
+
+    #[test]
+    fn test_glob_to_regex() {
+        let re = glob_to_regex("github:fioncat/*").unwrap();
+        assert!(re.is_match("github:fioncat/roxide"));
+        assert!(!re.is_match("github:fioncat/roxide/sub"));
+        assert!(!re.is_match("github:kubernetes/kubernetes"));
+
+        let re = glob_to_regex("github:**").unwrap();
+        assert!(re.is_match("github:fioncat/roxide"));
+
+        let re = glob_to_regex("v1.?").unwrap();
+        assert!(re.is_match("v1.2"));
+        assert!(!re.is_match("v1.10"));
+        assert!(!re.is_match("v1x2"));
+
+        let re = glob_to_regex("api/**/user.proto").unwrap();
+        assert!(re.is_match("api/user.proto"));
+        assert!(re.is_match("api/v1/user.proto"));
+        assert!(re.is_match("api/v1/inner/user.proto"));
+        assert!(!re.is_match("api/v1user.proto"));
+        assert!(!re.is_match("apiuser.proto"));
+
+        let re = glob_to_regex("**/*.rs").unwrap();
+        assert!(re.is_match("main.rs"));
+        assert!(re.is_match("src/cmd/main.rs"));
+        assert!(!re.is_match("src/main.go"));
+
+        let re = glob_to_regex("src/**").unwrap();
+        assert!(re.is_match("src/cmd/main.rs"));
+        assert!(!re.is_match("lib/main.rs"));
+    }
+
+    #[test]
+    fn test_ref_glob_to_regex() {
+        let re = ref_glob_to_regex("feature*").unwrap();
+        assert!(re.is_match("feature"));
+        assert!(re.is_match("feature/x"));
+        assert!(re.is_match("feature/x/y"));
+        assert!(!re.is_match("bugfix/feature"));
+
+        let re = ref_glob_to_regex("release/?").unwrap();
+        assert!(re.is_match("release/1"));
+        assert!(!re.is_match("release/10"));
+    }
+
+    #[test]
+    fn test_copy_path() {
+        let root = env::temp_dir().join(format!("roxide-test-migrate-{}", std::process::id()));
+        let _ = fs::remove_dir_all(&root);
+        let from = root.join("from");
+        fs::create_dir_all(from.join("sub")).unwrap();
+        fs::write(from.join("database"), b"data").unwrap();
+        fs::write(from.join("sub").join("file"), b"sub").unwrap();
+
+        let to = root.join("to");
+        copy_path(&from, &to).unwrap();
+        remove_path(&from).unwrap();
+
+        assert!(!exists(&from).unwrap());
+        assert_eq!(fs::read(to.join("database")).unwrap(), b"data");
+        assert_eq!(fs::read(to.join("sub").join("file")).unwrap(), b"sub");
+
+        fs::remove_dir_all(&root).unwrap();
+    }
+}
@@ -9,8 +9,11 @@ mod utils;
 use clap::Parser;
 
 use crate::cmd::{App, Run};
+use crate::repo::event;
 
 fn main() {
+    event::init();
     console::set_colors_enabled(true);
+    utils::handle_signals();
     utils::handle_result(App::parse().run());
 }
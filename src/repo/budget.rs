@@ -0,0 +1,180 @@
+use std::collections::{HashMap, HashSet};
+use std::fs;
+use std::io::ErrorKind;
+use std::path::PathBuf;
+use std::rc::Rc;
+
+use anyhow::{Context, Result};
+use serde::{Deserialize, Serialize};
+
+use crate::config;
+use crate::repo::database::Database;
+use crate::repo::types::Repo;
+use crate::utils::{self, Lock, Table};
+
+/// The cached sizes of the repos in workspace. Walking the whole workspace is
+/// slow, so the cache is only refreshed once a day by the multi-repo commands,
+/// the repos just cloned or synced are refreshed immediately.
+#[derive(Debug, Default, Deserialize, Serialize)]
+struct SizeCache {
+    time: u64,
+    sizes: HashMap<String, u64>,
+}
+
+impl SizeCache {
+    const EXPIRE: u64 = 60 * 60 * 24;
+
+    fn path() -> PathBuf {
+        PathBuf::from(&config::base().statedir).join("repo_sizes.json")
+    }
+
+    fn read() -> Result<SizeCache> {
+        let path = Self::path();
+        match fs::read(&path) {
+            Ok(data) => serde_json::from_slice(&data)
+                .with_context(|| format!("Decode repo sizes {}", path.display())),
+            Err(err) if err.kind() == ErrorKind::NotFound => Ok(SizeCache::default()),
+            Err(err) => Err(err).with_context(|| format!("Read file {}", path.display())),
+        }
+    }
+
+    fn save(&self) -> Result<()> {
+        let data = serde_json::to_vec(self).context("Encode repo sizes")?;
+        utils::write_file(&Self::path(), &data)
+    }
+}
+
+/// The max number of removal candidates to show.
+const MAX_CANDIDATES: usize = 10;
+
+/// Check the workspace size against `workspace_budget` in config, warn if it
+/// is exceeded, and suggest the large repos not visited for a long time to
+/// remove. The `changed` is the full names of the repos just cloned or
+/// synced. This never fails the caller, the error is shown as a warning.
+///
+/// This is used by the single-repo commands, such as `home`, only the changed
+/// repos (and the ones never measured) are walked, the others use the cached
+/// sizes even if expired, see [`check_all`].
+pub fn check(changed: &[String]) {
+    check_with(changed, false);
+}
+
+/// The same as [`check`], but also refresh the expired sizes by walking the
+/// workspace. This is used by the multi-repo commands, such as `sync`, which
+/// take a while anyway.
+pub fn check_all(changed: &[String]) {
+    check_with(changed, true);
+}
+
+fn check_with(changed: &[String], refresh_expired: bool) {
+    if config::base().workspace_budget_bytes == 0 {
+        return;
+    }
+    if let Err(err) = check_budget(changed, refresh_expired) {
+        utils::show_warn(format!("Check workspace budget: {err:#}"));
+    }
+}
+
+fn check_budget(changed: &[String], refresh_expired: bool) -> Result<()> {
+    let budget = config::base().workspace_budget_bytes;
+    let db = Database::read_only()?;
+    // The repos attached from other places (such as by manifest) are counted
+    // as well, they take the disk space too.
+    let repos: Vec<Rc<Repo>> = db.list_all();
+
+    // The lock is not held while walking the repos, it could take a long time
+    // and the lock is not blocking, other roxide would fail to get it.
+    let cache = {
+        let _lock = Lock::acquire("repo_sizes")?;
+        SizeCache::read()?
+    };
+    let now = config::now_secs();
+    let expired = refresh_expired && now.saturating_sub(cache.time) > SizeCache::EXPIRE;
+    let changed: HashSet<&str> = changed.iter().map(|name| name.as_str()).collect();
+    let mut refresh = Vec::new();
+    for repo in repos.iter() {
+        let name = repo.full_name();
+        if expired || changed.contains(name.as_str()) || !cache.sizes.contains_key(&name) {
+            refresh.push((name, repo.get_path()));
+        }
+    }
+    let dirs: Vec<PathBuf> = refresh.iter().map(|(_, dir)| dir.clone()).collect();
+    let refreshed = utils::dir_bytes_batch(dirs)?;
+
+    let sizes = {
+        let _lock = Lock::acquire("repo_sizes")?;
+        // Read again, the cache might be updated by another roxide meanwhile.
+        let mut cache = SizeCache::read()?;
+        let mut sizes = HashMap::with_capacity(repos.len());
+        for repo in repos.iter() {
+            let name = repo.full_name();
+            if let Some(size) = cache.sizes.remove(&name) {
+                sizes.insert(name, size);
+            }
+        }
+        for ((name, _), size) in refresh.into_iter().zip(refreshed) {
+            sizes.insert(name, size);
+        }
+
+        cache.sizes = sizes;
+        if expired {
+            cache.time = now;
+        }
+        cache.save()?;
+        cache.sizes
+    };
+
+    let total: u64 = sizes.values().sum();
+    if total <= budget {
+        return Ok(());
+    }
+    let excess = total - budget;
+    utils::show_warn(format!(
+        "The workspace size {} exceeds the budget {} by {}",
+        utils::human_bytes(total as f64),
+        utils::human_bytes(budget as f64),
+        utils::human_bytes(excess as f64)
+    ));
+
+    // Suggest the least recently visited repos first, skip the small ones,
+    // removing them barely helps.
+    let min_size = budget / 100;
+    let mut candidates: Vec<(&Rc<Repo>, u64)> = repos
+        .iter()
+        .filter(|repo| !repo.pinned && !repo.boosted())
+        .filter(|repo| !changed.contains(repo.full_name().as_str()))
+        .filter_map(|repo| {
+            let size = *sizes.get(&repo.full_name())?;
+            if size < min_size {
+                return None;
+            }
+            Some((repo, size))
+        })
+        .collect();
+    candidates.sort_unstable_by_key(|(repo, _)| repo.last_accessed);
+
+    let mut freed: u64 = 0;
+    let mut table = Table::with_capacity(MAX_CANDIDATES);
+    for (repo, size) in candidates.into_iter().take(MAX_CANDIDATES) {
+        table.add(vec![
+            repo.full_name(),
+            utils::human_bytes(size as f64),
+            utils::format_since(repo.last_accessed),
+        ]);
+        freed += size;
+        if freed >= excess {
+            break;
+        }
+    }
+    if freed == 0 {
+        return Ok(());
+    }
+
+    utils::show_warn(
+        "Consider removing (`roxide remove`) or detaching (`roxide detach`) these repos:",
+    );
+    for line in table.lines() {
+        utils::write_stderr(format!("  {line}"));
+    }
+    Ok(())
+}
@@ -1,4 +1,4 @@
-use std::collections::HashMap;
+use std::collections::{HashMap, HashSet};
 use std::fs;
 use std::io::ErrorKind;
 use std::path::PathBuf;
@@ -8,7 +8,10 @@ use anyhow::{bail, Context, Result};
 use bincode::Options;
 use serde::{Deserialize, Serialize};
 
-use crate::repo::types::Repo;
+use crate::config;
+use crate::repo::job::{Job, JobKind, JobTask};
+use crate::repo::manifest::Entry;
+use crate::repo::types::{Repo, SubProject};
 use crate::utils;
 
 #[derive(Debug, Deserialize, Serialize)]
@@ -16,6 +19,105 @@ pub struct Bytes {
     remotes: Vec<String>,
     owners: Vec<String>,
     repos: Vec<RepoBytes>,
+    subprojects: Vec<SubProjectBytes>,
+    /// The indexes of pinned repos in `repos`.
+    pinned: Vec<u32>,
+    branches: Vec<BranchBytes>,
+    /// The indexes of shortcut repos in `repos`, the shortcut number is the
+    /// position plus 1.
+    shortcuts: Vec<u32>,
+    shortcuts_time: u64,
+    boosts: Vec<BoostBytes>,
+    languages: Vec<LanguageBytes>,
+    jobs: Vec<JobBytes>,
+}
+
+/// The numeric shortcuts of repos, see [`crate::repo::database::Database::get_shortcut`].
+pub struct Shortcuts {
+    /// The full names of repos.
+    pub repos: Vec<String>,
+    /// The last time the shortcuts were refreshed.
+    pub time: u64,
+}
+
+/// The data format of version 7, which has no jobs.
+#[derive(Debug, Deserialize)]
+struct BytesV7 {
+    remotes: Vec<String>,
+    owners: Vec<String>,
+    repos: Vec<RepoBytes>,
+    subprojects: Vec<SubProjectBytes>,
+    pinned: Vec<u32>,
+    branches: Vec<BranchBytes>,
+    shortcuts: Vec<u32>,
+    shortcuts_time: u64,
+    boosts: Vec<BoostBytes>,
+    languages: Vec<LanguageBytes>,
+}
+
+/// The data format of version 6, which has no languages.
+#[derive(Debug, Deserialize)]
+struct BytesV6 {
+    remotes: Vec<String>,
+    owners: Vec<String>,
+    repos: Vec<RepoBytes>,
+    subprojects: Vec<SubProjectBytes>,
+    pinned: Vec<u32>,
+    branches: Vec<BranchBytes>,
+    shortcuts: Vec<u32>,
+    shortcuts_time: u64,
+    boosts: Vec<BoostBytes>,
+}
+
+/// The data format of version 5, which has no boosts.
+#[derive(Debug, Deserialize)]
+struct BytesV5 {
+    remotes: Vec<String>,
+    owners: Vec<String>,
+    repos: Vec<RepoBytes>,
+    subprojects: Vec<SubProjectBytes>,
+    pinned: Vec<u32>,
+    branches: Vec<BranchBytes>,
+    shortcuts: Vec<u32>,
+    shortcuts_time: u64,
+}
+
+/// The data format of version 4, which has no shortcuts.
+#[derive(Debug, Deserialize)]
+struct BytesV4 {
+    remotes: Vec<String>,
+    owners: Vec<String>,
+    repos: Vec<RepoBytes>,
+    subprojects: Vec<SubProjectBytes>,
+    pinned: Vec<u32>,
+    branches: Vec<BranchBytes>,
+}
+
+/// The data format of version 3, which has no default branches.
+#[derive(Debug, Deserialize)]
+struct BytesV3 {
+    remotes: Vec<String>,
+    owners: Vec<String>,
+    repos: Vec<RepoBytes>,
+    subprojects: Vec<SubProjectBytes>,
+    pinned: Vec<u32>,
+}
+
+/// The data format of version 2, which has no pinned repos.
+#[derive(Debug, Deserialize)]
+struct BytesV2 {
+    remotes: Vec<String>,
+    owners: Vec<String>,
+    repos: Vec<RepoBytes>,
+    subprojects: Vec<SubProjectBytes>,
+}
+
+/// The data format of version 1, which has no sub-projects.
+#[derive(Debug, Deserialize)]
+struct BytesV1 {
+    remotes: Vec<String>,
+    owners: Vec<String>,
+    repos: Vec<RepoBytes>,
 }
 
 #[derive(Debug, Deserialize, Serialize)]
@@ -28,19 +130,84 @@ struct RepoBytes {
     accessed: f64,
 }
 
+#[derive(Debug, Deserialize, Serialize)]
+struct SubProjectBytes {
+    /// The index of parent repo in `repos`.
+    repo: u32,
+    path: String,
+    last_accessed: u64,
+    accessed: f64,
+}
+
+#[derive(Debug, Deserialize, Serialize)]
+struct BranchBytes {
+    /// The index of repo in `repos`.
+    repo: u32,
+    default_branch: String,
+}
+
+#[derive(Debug, Deserialize, Serialize)]
+struct BoostBytes {
+    /// The index of repo in `repos`.
+    repo: u32,
+    until: u64,
+}
+
+#[derive(Debug, Deserialize, Serialize)]
+struct LanguageBytes {
+    /// The index of repo in `repos`.
+    repo: u32,
+    languages: Vec<String>,
+}
+
+/// The resumable job, see [`Job`]. The task repos are stored by names rather
+/// than indexes, since they are not in `repos` until the tasks are done.
+#[derive(Debug, Deserialize, Serialize)]
+struct JobBytes {
+    id: u64,
+    created: u64,
+    /// The kind name, see [`JobKind::as_str`].
+    kind: String,
+    /// The git maintenance tasks of the `maintain` job.
+    maintain_tasks: Vec<String>,
+    register: bool,
+    tasks: Vec<JobTaskBytes>,
+}
+
+#[derive(Debug, Deserialize, Serialize)]
+struct JobTaskBytes {
+    remote: String,
+    owner: String,
+    name: String,
+    path: Option<String>,
+    pinned: bool,
+    branch: Option<String>,
+    done: bool,
+    score: f64,
+    default_branch: Option<String>,
+}
+
 impl Bytes {
     // Assume a maximum size for the database. This prevents bincode from
     // throwing strange errors when it encounters invalid data.
     const MAX_SIZE: u64 = 32 << 20;
 
     // Use Version to ensure that decode and encode are consistent.
-    const VERSION: u32 = 1;
+    const VERSION: u32 = 8;
 
     fn empty() -> Bytes {
         Bytes {
             remotes: Vec::new(),
             owners: Vec::new(),
             repos: Vec::new(),
+            subprojects: Vec::new(),
+            pinned: Vec::new(),
+            branches: Vec::new(),
+            shortcuts: Vec::new(),
+            shortcuts_time: 0,
+            boosts: Vec::new(),
+            languages: Vec::new(),
+            jobs: Vec::new(),
         }
     }
 
@@ -66,16 +233,129 @@ impl Bytes {
         let version: u32 = decoder
             .deserialize(version_data)
             .context("Decode version")?;
-        if version != Self::VERSION {
-            bail!("unsupported version {version}");
+        match version {
+            Self::VERSION => Ok(decoder.deserialize(data).context("Decode repo data")?),
+            7 => {
+                let bytes: BytesV7 = decoder.deserialize(data).context("Decode repo data")?;
+                Ok(Bytes {
+                    remotes: bytes.remotes,
+                    owners: bytes.owners,
+                    repos: bytes.repos,
+                    subprojects: bytes.subprojects,
+                    pinned: bytes.pinned,
+                    branches: bytes.branches,
+                    shortcuts: bytes.shortcuts,
+                    shortcuts_time: bytes.shortcuts_time,
+                    boosts: bytes.boosts,
+                    languages: bytes.languages,
+                    jobs: Vec::new(),
+                })
+            }
+            6 => {
+                let bytes: BytesV6 = decoder.deserialize(data).context("Decode repo data")?;
+                Ok(Bytes {
+                    remotes: bytes.remotes,
+                    owners: bytes.owners,
+                    repos: bytes.repos,
+                    subprojects: bytes.subprojects,
+                    pinned: bytes.pinned,
+                    branches: bytes.branches,
+                    shortcuts: bytes.shortcuts,
+                    shortcuts_time: bytes.shortcuts_time,
+                    boosts: bytes.boosts,
+                    languages: Vec::new(),
+                    jobs: Vec::new(),
+                })
+            }
+            5 => {
+                let bytes: BytesV5 = decoder.deserialize(data).context("Decode repo data")?;
+                Ok(Bytes {
+                    remotes: bytes.remotes,
+                    owners: bytes.owners,
+                    repos: bytes.repos,
+                    subprojects: bytes.subprojects,
+                    pinned: bytes.pinned,
+                    branches: bytes.branches,
+                    shortcuts: bytes.shortcuts,
+                    shortcuts_time: bytes.shortcuts_time,
+                    boosts: Vec::new(),
+                    languages: Vec::new(),
+                    jobs: Vec::new(),
+                })
+            }
+            4 => {
+                let bytes: BytesV4 = decoder.deserialize(data).context("Decode repo data")?;
+                Ok(Bytes {
+                    remotes: bytes.remotes,
+                    owners: bytes.owners,
+                    repos: bytes.repos,
+                    subprojects: bytes.subprojects,
+                    pinned: bytes.pinned,
+                    branches: bytes.branches,
+                    shortcuts: Vec::new(),
+                    shortcuts_time: 0,
+                    boosts: Vec::new(),
+                    languages: Vec::new(),
+                    jobs: Vec::new(),
+                })
+            }
+            3 => {
+                let bytes: BytesV3 = decoder.deserialize(data).context("Decode repo data")?;
+                Ok(Bytes {
+                    remotes: bytes.remotes,
+                    owners: bytes.owners,
+                    repos: bytes.repos,
+                    subprojects: bytes.subprojects,
+                    pinned: bytes.pinned,
+                    branches: Vec::new(),
+                    shortcuts: Vec::new(),
+                    shortcuts_time: 0,
+                    boosts: Vec::new(),
+                    languages: Vec::new(),
+                    jobs: Vec::new(),
+                })
+            }
+            2 => {
+                let bytes: BytesV2 = decoder.deserialize(data).context("Decode repo data")?;
+                Ok(Bytes {
+                    remotes: bytes.remotes,
+                    owners: bytes.owners,
+                    repos: bytes.repos,
+                    subprojects: bytes.subprojects,
+                    pinned: Vec::new(),
+                    branches: Vec::new(),
+                    shortcuts: Vec::new(),
+                    shortcuts_time: 0,
+                    boosts: Vec::new(),
+                    languages: Vec::new(),
+                    jobs: Vec::new(),
+                })
+            }
+            1 => {
+                let bytes: BytesV1 = decoder.deserialize(data).context("Decode repo data")?;
+                Ok(Bytes {
+                    remotes: bytes.remotes,
+                    owners: bytes.owners,
+                    repos: bytes.repos,
+                    subprojects: Vec::new(),
+                    pinned: Vec::new(),
+                    branches: Vec::new(),
+                    shortcuts: Vec::new(),
+                    shortcuts_time: 0,
+                    boosts: Vec::new(),
+                    languages: Vec::new(),
+                    jobs: Vec::new(),
+                })
+            }
+            _ => bail!("unsupported version {version}"),
         }
-
-        Ok(decoder.deserialize(data).context("Decode repo data")?)
     }
 
+    /// Save the data atomically, since the database might be read without
+    /// the lock at the same time, such as by completion.
     pub fn save(&self, path: &PathBuf) -> Result<()> {
         let data = self.encode()?;
-        utils::write_file(path, &data)
+        utils::write_file_atomic(path, &data, None)
     }
 
     fn encode(&self) -> Result<Vec<u8>> {
@@ -90,13 +370,34 @@ impl Bytes {
     }
 }
 
-impl From<Bytes> for Vec<Rc<Repo>> {
-    fn from(bytes: Bytes) -> Self {
+impl Bytes {
+    pub fn into_data(self) -> (Vec<Rc<Repo>>, Vec<Rc<SubProject>>, Shortcuts, Vec<Job>) {
         let Bytes {
             remotes,
             owners,
             repos: repo_items,
-        } = bytes;
+            subprojects: subproject_items,
+            pinned,
+            branches,
+            shortcuts: shortcut_items,
+            shortcuts_time,
+            boosts,
+            languages,
+            jobs: job_items,
+        } = self;
+        let pinned: HashSet<u32> = pinned.into_iter().collect();
+        let mut branches: HashMap<u32, String> = branches
+            .into_iter()
+            .map(|branch| (branch.repo, branch.default_branch))
+            .collect();
+        let boosts: HashMap<u32, u64> = boosts
+            .into_iter()
+            .map(|boost| (boost.repo, boost.until))
+            .collect();
+        let mut languages: HashMap<u32, Vec<String>> = languages
+            .into_iter()
+            .map(|language| (language.repo, language.languages))
+            .collect();
 
         let remote_index: HashMap<u32, Rc<String>> = remotes
             .into_iter()
@@ -110,8 +411,9 @@ impl From<Bytes> for Vec<Rc<Repo>> {
             .map(|(idx, owner)| (idx as u32, Rc::new(owner)))
             .collect();
 
+        let mut repo_index: HashMap<u32, Rc<Repo>> = HashMap::with_capacity(repo_items.len());
         let mut repos = Vec::with_capacity(repo_items.len());
-        for repo in repo_items.into_iter() {
+        for (idx, repo) in repo_items.into_iter().enumerate() {
             let remote = match remote_index.get(&repo.remote) {
                 Some(remote) => Rc::clone(remote),
                 None => continue,
@@ -120,23 +422,72 @@ impl From<Bytes> for Vec<Rc<Repo>> {
                 Some(owner) => Rc::clone(owner),
                 None => continue,
             };
-            repos.push(Rc::new(Repo {
+            let repo = Rc::new(Repo {
                 remote,
                 owner,
                 name: Rc::new(repo.name),
                 path: repo.path,
                 last_accessed: repo.last_accessed,
                 accessed: repo.accessed,
+                pinned: pinned.contains(&(idx as u32)),
+                default_branch: branches.remove(&(idx as u32)),
+                boost_until: boosts.get(&(idx as u32)).copied().unwrap_or(0),
+                languages: languages.remove(&(idx as u32)),
+            });
+            repo_index.insert(idx as u32, Rc::clone(&repo));
+            repos.push(repo);
+        }
+        let shortcuts = Shortcuts {
+            repos: shortcut_items
+                .into_iter()
+                .filter_map(|idx| repo_index.get(&idx).map(|repo| repo.full_name()))
+                .collect(),
+            time: shortcuts_time,
+        };
+        let pin_first = config::base().pin_first;
+        let now = config::now_secs();
+        repos.sort_unstable_by(|repo1, repo2| {
+            if pin_first && repo1.pinned != repo2.pinned {
+                return repo2.pinned.cmp(&repo1.pinned);
+            }
+            let (boosted1, boosted2) = (repo1.boosted_at(now), repo2.boosted_at(now));
+            if boosted1 != boosted2 {
+                return boosted2.cmp(&boosted1);
+            }
+            repo2.score().total_cmp(&repo1.score())
+        });
+
+        let mut subprojects = Vec::with_capacity(subproject_items.len());
+        for subproject in subproject_items.into_iter() {
+            let repo = match repo_index.get(&subproject.repo) {
+                Some(repo) => repo,
+                None => continue,
+            };
+            subprojects.push(Rc::new(SubProject {
+                remote: Rc::clone(&repo.remote),
+                owner: Rc::clone(&repo.owner),
+                name: Rc::clone(&repo.name),
+                path: subproject.path,
+                last_accessed: subproject.last_accessed,
+                accessed: subproject.accessed,
             }));
         }
-        repos.sort_unstable_by(|repo1, repo2| repo2.score().total_cmp(&repo1.score()));
+        subprojects.sort_unstable_by(|sub1, sub2| sub2.score().total_cmp(&sub1.score()));
+
+        let jobs = job_items
+            .into_iter()
+            .filter_map(JobBytes::into_job)
+            .collect();
 
-        repos
+        (repos, subprojects, shortcuts, jobs)
     }
-}
 
-impl From<Vec<Rc<Repo>>> for Bytes {
-    fn from(repos: Vec<Rc<Repo>>) -> Self {
+    pub fn from_data(
+        repos: Vec<Rc<Repo>>,
+        subprojects: Vec<Rc<SubProject>>,
+        shortcuts: Shortcuts,
+        jobs: Vec<Job>,
+    ) -> Bytes {
         let mut remotes: Vec<String> = Vec::new();
         let mut remote_index: HashMap<String, u32> = HashMap::new();
 
@@ -144,7 +495,13 @@ impl From<Vec<Rc<Repo>>> for Bytes {
         let mut owner_index: HashMap<String, u32> = HashMap::new();
 
         let mut repo_items: Vec<RepoBytes> = Vec::with_capacity(repos.len());
+        let mut repo_index: HashMap<String, u32> = HashMap::with_capacity(repos.len());
+        let mut pinned: Vec<u32> = Vec::new();
+        let mut branches: Vec<BranchBytes> = Vec::new();
+        let mut boosts: Vec<BoostBytes> = Vec::new();
+        let mut languages: Vec<LanguageBytes> = Vec::new();
 
+        let now = config::now_secs();
         for repo in repos.into_iter() {
             let remote = match remote_index.get(repo.remote.as_str()) {
                 Some(idx) => *idx,
@@ -166,32 +523,206 @@ impl From<Vec<Rc<Repo>>> for Bytes {
                     idx
                 }
             };
-
-            let repo = Rc::try_unwrap(repo).expect("Unwrap repo Rc failed");
-            let Repo {
-                remote: _,
-                owner: _,
-                name,
-                path,
-                last_accessed,
-                accessed,
-            } = repo;
-            let name = Rc::try_unwrap(name).expect("Unwrap repo name failed");
+            repo_index.insert(repo.full_name(), repo_items.len() as u32);
+            if repo.pinned {
+                pinned.push(repo_items.len() as u32);
+            }
+            if let Some(branch) = &repo.default_branch {
+                branches.push(BranchBytes {
+                    repo: repo_items.len() as u32,
+                    default_branch: branch.clone(),
+                });
+            }
+            // The expired boosts are dropped.
+            if repo.boosted_at(now) {
+                boosts.push(BoostBytes {
+                    repo: repo_items.len() as u32,
+                    until: repo.boost_until,
+                });
+            }
+            if let Some(repo_languages) = &repo.languages {
+                languages.push(LanguageBytes {
+                    repo: repo_items.len() as u32,
+                    languages: repo_languages.clone(),
+                });
+            }
 
             repo_items.push(RepoBytes {
                 remote,
                 owner,
-                path,
-                name,
-                last_accessed,
-                accessed,
+                name: format!("{}", repo.name),
+                path: repo.path.clone(),
+                last_accessed: repo.last_accessed,
+                accessed: repo.accessed,
             });
         }
 
+        let mut subproject_items: Vec<SubProjectBytes> = Vec::with_capacity(subprojects.len());
+        for subproject in subprojects.into_iter() {
+            let repo_name = format!(
+                "{}:{}/{}",
+                subproject.remote, subproject.owner, subproject.name
+            );
+            // The parent repo was removed, drop the sub-project too.
+            let repo = match repo_index.get(&repo_name) {
+                Some(idx) => *idx,
+                None => continue,
+            };
+            subproject_items.push(SubProjectBytes {
+                repo,
+                path: subproject.path.clone(),
+                last_accessed: subproject.last_accessed,
+                accessed: subproject.accessed,
+            });
+        }
+
+        // The removed repos are dropped from shortcuts.
+        let shortcut_items = shortcuts
+            .repos
+            .iter()
+            .filter_map(|name| repo_index.get(name).copied())
+            .collect();
+
         Bytes {
             remotes,
             owners,
             repos: repo_items,
+            subprojects: subproject_items,
+            pinned,
+            branches,
+            shortcuts: shortcut_items,
+            shortcuts_time: shortcuts.time,
+            boosts,
+            languages,
+            jobs: jobs.into_iter().map(JobBytes::from_job).collect(),
+        }
+    }
+}
+
+impl JobBytes {
+    fn from_job(job: Job) -> JobBytes {
+        let (maintain_tasks, register) = match &job.kind {
+            JobKind::Maintain { tasks, register } => (tasks.clone(), *register),
+            _ => (Vec::new(), false),
+        };
+        JobBytes {
+            id: job.id,
+            created: job.created,
+            kind: String::from(job.kind.as_str()),
+            maintain_tasks,
+            register,
+            tasks: job
+                .tasks
+                .into_iter()
+                .map(|task| JobTaskBytes {
+                    remote: task.repo.remote,
+                    owner: task.repo.owner,
+                    name: task.repo.name,
+                    path: task.repo.path,
+                    pinned: task.repo.pinned,
+                    branch: task.repo.branch,
+                    done: task.done,
+                    score: task.score,
+                    default_branch: task.default_branch,
+                })
+                .collect(),
+        }
+    }
+
+    /// Convert to the job, return None if the kind is unknown, such as
+    /// written by a newer roxide.
+    fn into_job(self) -> Option<Job> {
+        let kind = match self.kind.as_str() {
+            "attach" => JobKind::Attach,
+            "maintain" => JobKind::Maintain {
+                tasks: self.maintain_tasks,
+                register: self.register,
+            },
+            "sync" => JobKind::Sync,
+            _ => return None,
+        };
+        Some(Job {
+            id: self.id,
+            created: self.created,
+            kind,
+            tasks: self
+                .tasks
+                .into_iter()
+                .map(|task| JobTask {
+                    repo: Entry {
+                        remote: task.remote,
+                        owner: task.owner,
+                        name: task.name,
+                        path: task.path,
+                        pinned: task.pinned,
+                        branch: task.branch,
+                    },
+                    done: task.done,
+                    score: task.score,
+                    default_branch: task.default_branch,
+                })
+                .collect(),
+        })
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_job_bytes() {
+        let task = JobTask {
+            repo: Entry {
+                remote: String::from("github"),
+                owner: String::from("fioncat"),
+                name: String::from("roxide"),
+                path: None,
+                pinned: true,
+                branch: Some(String::from("dev")),
+            },
+            done: true,
+            score: 1.5,
+            default_branch: Some(String::from("main")),
+        };
+        let jobs = vec![
+            Job {
+                id: 1,
+                created: 100,
+                kind: JobKind::Maintain {
+                    tasks: vec![String::from("gc")],
+                    register: true,
+                },
+                tasks: vec![task.clone()],
+            },
+            Job {
+                id: 2,
+                created: 100,
+                kind: JobKind::Sync,
+                tasks: vec![task],
+            },
+        ];
+
+        let jobs: Vec<Job> = jobs
+            .into_iter()
+            .map(JobBytes::from_job)
+            .filter_map(JobBytes::into_job)
+            .collect();
+
+        assert_eq!(jobs.len(), 2);
+        assert_eq!(jobs[0].id, 1);
+        match &jobs[0].kind {
+            JobKind::Maintain { tasks, register } => {
+                assert_eq!(tasks, &vec![String::from("gc")]);
+                assert!(register);
+            }
+            kind => panic!("unexpected kind {}", kind.as_str()),
         }
+        assert_eq!(jobs[1].kind.as_str(), "sync");
+        let task = &jobs[1].tasks[0];
+        assert_eq!(task.full_name(), "github:fioncat/roxide");
+        assert_eq!(task.repo.branch.as_deref(), Some("dev"));
+        assert_eq!(task.default_branch.as_deref(), Some("main"));
+        assert!(task.done && task.repo.pinned);
     }
 }
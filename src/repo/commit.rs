@@ -0,0 +1,118 @@
+use std::fs;
+use std::io::ErrorKind;
+use std::path::PathBuf;
+
+use anyhow::{bail, Context, Result};
+use regex::Regex;
+
+use crate::config::types::CommitRule;
+use crate::{exec, utils};
+
+/// The regex of conventional commit header, such as `feat(api)!: add login`.
+const HEADER_REGEX: &str = r"^([a-z]+)(\(([^()]+)\))?(!)?: (.+)$";
+
+/// The commit-msg hook, it lints the message by roxide. The hook is skipped if
+/// roxide is not installed, so that other tools (such as IDE) won't be blocked.
+const HOOK_SCRIPT: &str = r#"#!/bin/sh
+# Installed by roxide, lint the commit message with the conventional commit rule.
+if command -v roxide > /dev/null 2>&1; then
+	exec roxide create commit --lint "$1"
+fi
+"#;
+
+/// Build the commit message header from the parts.
+pub fn build(kind: &str, scope: &str, breaking: bool, subject: &str) -> String {
+    let mut header = String::from(kind);
+    if !scope.is_empty() {
+        header.push_str(&format!("({scope})"));
+    }
+    if breaking {
+        header.push('!');
+    }
+    header.push_str(": ");
+    header.push_str(subject);
+    header
+}
+
+/// Check the commit message against the rule. The messages generated by git,
+/// such as merge and revert, are skipped.
+pub fn lint(rule: &CommitRule, message: &str) -> Result<()> {
+    let mut lines = message
+        .lines()
+        .filter(|line| !line.starts_with('#'))
+        .skip_while(|line| line.trim().is_empty());
+    let header = match lines.next() {
+        Some(header) => header.trim_end(),
+        None => bail!("The commit message is empty"),
+    };
+    if header.starts_with("Merge ")
+        || header.starts_with("Revert \"")
+        || header.starts_with("fixup! ")
+        || header.starts_with("squash! ")
+    {
+        return Ok(());
+    }
+
+    let header_len = header.chars().count();
+    if header_len > rule.max_length {
+        bail!(
+            "The commit header is too long ({header_len} > {}): {header}",
+            rule.max_length
+        );
+    }
+    if let Some(line) = lines.next() {
+        if !line.trim().is_empty() {
+            bail!("The commit header should be followed by a blank line");
+        }
+    }
+
+    let re = Regex::new(HEADER_REGEX).expect("parse commit header regex");
+    let caps = match re.captures(header) {
+        Some(caps) => caps,
+        None => bail!("The commit header should be like `type(scope): subject`, found: {header}"),
+    };
+    let kind = caps.get(1).unwrap().as_str();
+    if !rule.types.iter().any(|item| item == kind) {
+        bail!(
+            "Invalid commit type {kind}, should be one of: {}",
+            rule.types.join(", ")
+        );
+    }
+    match caps.get(3) {
+        Some(scope) => {
+            let scope = scope.as_str();
+            if !rule.scopes.is_empty() && !rule.scopes.iter().any(|item| item == scope) {
+                bail!(
+                    "Invalid commit scope {scope}, should be one of: {}",
+                    rule.scopes.join(", ")
+                );
+            }
+        }
+        None if rule.require_scope => bail!("The commit scope is required"),
+        None => {}
+    }
+    let subject = caps.get(5).unwrap().as_str();
+    if subject.trim().is_empty() {
+        bail!("The commit subject is empty");
+    }
+    if subject.ends_with('.') {
+        bail!("The commit subject should not end with `.`");
+    }
+    Ok(())
+}
+
+/// Install the commit-msg hook to the repo. If `force` is false, an existing
+/// hook will be kept, since it might be created by user or other tools.
+pub fn install_hook(dir: &PathBuf, force: bool) -> Result<()> {
+    let path = dir.join(".git").join("hooks").join("commit-msg");
+    if !force {
+        match fs::metadata(&path) {
+            Ok(_) => return Ok(()),
+            Err(err) if err.kind() == ErrorKind::NotFound => {}
+            Err(err) => return Err(err).with_context(|| format!("Read file {}", path.display())),
+        }
+    }
+    exec!("Install commit-msg hook");
+    utils::write_file(&path, HOOK_SCRIPT.as_bytes())?;
+    utils::set_mode(&path, 0o755)
+}
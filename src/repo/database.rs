@@ -5,24 +5,72 @@ use std::rc::Rc;
 use anyhow::{bail, Result};
 
 use crate::config;
-use crate::repo::bytes::Bytes;
-use crate::repo::types::Repo;
-use crate::utils::Lock;
+use crate::repo::bytes::{Bytes, Shortcuts};
+use crate::repo::job::Job;
+use crate::repo::types::{NameLevel, Repo, SubProject};
+use crate::utils::{self, Lock};
 
 pub struct Database {
     repos: Vec<Rc<Repo>>,
+    subprojects: Vec<Rc<SubProject>>,
+    shortcuts: Shortcuts,
+    jobs: Vec<Job>,
     path: PathBuf,
-    lock: Lock,
+    /// None means the database is opened in read-only mode.
+    lock: Option<Lock>,
+
+    show_ignored: bool,
 }
 
 impl Database {
+    /// After aging, the total access count will be this ratio of the max.
+    pub const AGE_RATIO: f64 = 0.9;
+
+    /// The shortcuts are refreshed at most once per day, so that the numbers
+    /// stay stable during daily work.
+    const SHORTCUTS_REFRESH_SECS: u64 = 24 * 3600;
+
     pub fn read() -> Result<Database> {
         let lock = Lock::acquire("database")?;
-        let path = PathBuf::from(&config::base().metadir).join("database");
+        Self::read_with_lock(Some(lock))
+    }
+
+    /// Read the database without acquiring the file lock, so that it won't
+    /// fail or block when another roxide is running. This is used by
+    /// completion, which is latency sensitive and never writes. The returned
+    /// database could not be closed.
+    pub fn read_only() -> Result<Database> {
+        Self::read_with_lock(None)
+    }
+
+    fn read_with_lock(lock: Option<Lock>) -> Result<Database> {
+        let path = Self::default_path();
         let bytes = Bytes::read(&path)?;
-        let repos: Vec<Rc<Repo>> = bytes.into();
+        let (repos, subprojects, shortcuts, jobs) = bytes.into_data();
+
+        Ok(Database {
+            repos,
+            subprojects,
+            shortcuts,
+            jobs,
+            path,
+            lock,
+            show_ignored: false,
+        })
+    }
+
+    pub fn default_path() -> PathBuf {
+        PathBuf::from(&config::base().metadir).join("database")
+    }
+
+    /// Show the repos matched by ignore patterns in fuzzy matching and
+    /// browsing (see [`Database::filter_ignored`]), they are hidden by default.
+    pub fn show_ignored(&mut self) {
+        self.show_ignored = true;
+    }
 
-        Ok(Database { repos, path, lock })
+    pub fn path(&self) -> &PathBuf {
+        &self.path
     }
 
     pub fn get<S>(&self, remote: S, owner: S, name: S) -> Option<Rc<Repo>>
@@ -61,7 +109,10 @@ impl Database {
             if !remote.as_ref().is_empty() && remote.as_ref().ne(repo.remote.as_str()) {
                 return None;
             }
-            if repo.name.as_str().contains(query.as_ref()) {
+            if self.is_hidden(repo) {
+                return None;
+            }
+            if repo.fuzzy_match(query.as_ref()) {
                 return Some(Rc::clone(repo));
             }
             None
@@ -86,7 +137,7 @@ impl Database {
         let mut max_time = 0;
         let mut pos = None;
         for (idx, repo) in self.repos.iter().enumerate() {
-            if repo.get_path().eq(dir) {
+            if repo.get_path().eq(dir) || self.is_hidden(repo) {
                 continue;
             }
             if !remote.as_ref().is_empty() && repo.remote.as_str().ne(remote.as_ref()) {
@@ -123,6 +174,26 @@ impl Database {
         })
     }
 
+    /// Find the repo containing the directory, the directory can be the repo
+    /// root or any of its sub-directories.
+    pub fn get_by_dir(&self, dir: &PathBuf) -> Option<Rc<Repo>> {
+        let mut found: Option<(&Rc<Repo>, PathBuf)> = None;
+        for repo in self.repos.iter() {
+            let path = repo.get_path();
+            if !dir.starts_with(&path) {
+                continue;
+            }
+            // The repos might be nested, use the deepest one.
+            if let Some((_, found_path)) = &found {
+                if found_path.starts_with(&path) {
+                    continue;
+                }
+            }
+            found = Some((repo, path));
+        }
+        found.map(|(repo, _)| Rc::clone(repo))
+    }
+
     pub fn must_current(&self) -> Result<Rc<Repo>> {
         match self.current() {
             Some(repo) => Ok(repo),
@@ -138,6 +209,17 @@ impl Database {
         list
     }
 
+    /// Drop the repos matched by ignore patterns. The listing functions return
+    /// all repos, so that the batch commands (such as `check` and `remove`)
+    /// can operate on the ignored repos too. This should be used by selectors,
+    /// listing and stats, where the ignored repos are noise.
+    pub fn filter_ignored(&self, repos: Vec<Rc<Repo>>) -> Vec<Rc<Repo>> {
+        repos
+            .into_iter()
+            .filter(|repo| !self.is_hidden(repo))
+            .collect()
+    }
+
     pub fn list_by_remote<S>(&self, remote: S) -> Vec<Rc<Repo>>
     where
         S: AsRef<str>,
@@ -151,6 +233,50 @@ impl Database {
         list
     }
 
+    /// List the repos in the group, the ignored repos are included, since they
+    /// are added to the group explicitly.
+    pub fn list_by_group(&self, group: &str) -> Vec<Rc<Repo>> {
+        let base = config::base();
+        self.repos
+            .iter()
+            .filter(|repo| base.in_group(group, repo.full_name()))
+            .map(|repo| Rc::clone(repo))
+            .collect()
+    }
+
+    /// List the repos by the args of multi-repo commands, the `remote` can be
+    /// a group (`@group`). The query can be owner or a single repo. Return the
+    /// repos and the name level to show them.
+    pub fn list_by_query(
+        &self,
+        remote: Option<&str>,
+        query: Option<&str>,
+    ) -> Result<(Vec<Rc<Repo>>, NameLevel)> {
+        let remote = match remote {
+            Some(remote) => remote,
+            None => return Ok((self.list_all(), NameLevel::Full)),
+        };
+        if let Some(group) = config::base().parse_group(remote) {
+            if query.is_some() {
+                bail!("The query cannot be used with group {remote}");
+            }
+            return Ok((self.list_by_group(group), NameLevel::Full));
+        }
+        let query = match query {
+            Some(query) => query,
+            None => return Ok((self.list_by_remote(remote), NameLevel::Owner)),
+        };
+        let remote = config::must_get_remote(remote)?;
+        let (owner, name) = utils::parse_query(&remote, query);
+        if owner.is_empty() || name.is_empty() {
+            let owner = remote.resolve_owner(query);
+            let repos = self.list_by_owner(remote.name.as_str(), owner);
+            return Ok((repos, NameLevel::Name));
+        }
+        let repo = self.must_get(remote.name.as_str(), &owner, &name)?;
+        Ok((vec![repo], NameLevel::Name))
+    }
+
     pub fn list_by_owner<S>(&self, remote: S, owner: S) -> Vec<Rc<Repo>>
     where
         S: AsRef<str>,
@@ -171,7 +297,7 @@ impl Database {
         let mut owners = Vec::new();
         let mut owner_set = HashSet::new();
         for repo in self.repos.iter() {
-            if repo.remote.as_str().ne(remote.as_ref()) {
+            if repo.remote.as_str().ne(remote.as_ref()) || self.is_hidden(repo) {
                 continue;
             }
             if let None = owner_set.get(repo.owner.as_str()) {
@@ -182,6 +308,110 @@ impl Database {
         owners
     }
 
+    /// Get the repo by numeric shortcut, the shortcut starts from 1.
+    pub fn get_shortcut(&self, num: usize) -> Option<Rc<Repo>> {
+        if num == 0 {
+            return None;
+        }
+        let name = self.shortcuts.repos.get(num - 1)?;
+        self.repos
+            .iter()
+            .find(|repo| repo.full_name().eq(name))
+            .map(Rc::clone)
+    }
+
+    /// List the repos with their shortcuts.
+    pub fn list_shortcuts(&self) -> Vec<(usize, Rc<Repo>)> {
+        (1..=self.shortcuts.repos.len())
+            .filter_map(|num| Some((num, self.get_shortcut(num)?)))
+            .collect()
+    }
+
+    /// Assign shortcuts to the top repos by score, the ties are broken by
+    /// name so that the result is deterministic.
+    pub fn refresh_shortcuts(&mut self) {
+        let count = config::base().shortcuts as usize;
+        let mut repos: Vec<&Rc<Repo>> = self
+            .repos
+            .iter()
+            .filter(|repo| !self.is_hidden(repo))
+            .collect();
+        repos.sort_by(|repo1, repo2| {
+            repo2
+                .score()
+                .total_cmp(&repo1.score())
+                .then_with(|| repo1.full_name().cmp(&repo2.full_name()))
+        });
+        self.shortcuts = Shortcuts {
+            repos: repos
+                .into_iter()
+                .take(count)
+                .map(|repo| repo.full_name())
+                .collect(),
+            time: config::now_secs(),
+        };
+    }
+
+    pub fn list_subprojects(&self, repo: &Repo) -> Vec<Rc<SubProject>> {
+        let mut list = Vec::new();
+        for subproject in self.subprojects.iter() {
+            if subproject.belongs_to(repo) {
+                list.push(Rc::clone(subproject));
+            }
+        }
+        list
+    }
+
+    /// Find the sub-project whose repo is matched by `query` (the same as
+    /// [`Database::get_fuzzy`]) and whose path starts with the `path`
+    /// components. The sub-projects are sorted by score, so the first matched
+    /// one is the best.
+    pub fn get_subproject_fuzzy<S>(&self, query: S, path: S) -> Option<(Rc<Repo>, Rc<SubProject>)>
+    where
+        S: AsRef<str>,
+    {
+        let path = path.as_ref();
+        let dir_prefix = format!("{path}/");
+        self.subprojects.iter().find_map(|subproject| {
+            // Match the whole path components, so that `services/api` won't
+            // match `services/api-gateway`.
+            if subproject.path != path && !subproject.path.starts_with(&dir_prefix) {
+                return None;
+            }
+            let repo = self.get(
+                subproject.remote.as_str(),
+                subproject.owner.as_str(),
+                subproject.name.as_str(),
+            )?;
+            if !repo.fuzzy_match(query.as_ref()) || self.is_hidden(&repo) {
+                return None;
+            }
+            Some((repo, Rc::clone(subproject)))
+        })
+    }
+
+    pub fn update_subproject(&mut self, subproject: Rc<SubProject>) {
+        match self.subproject_position(&subproject) {
+            Some(idx) => self.subprojects[idx] = subproject.update(),
+            None => self.subprojects.push(subproject.update()),
+        }
+    }
+
+    pub fn remove_subproject(&mut self, subproject: Rc<SubProject>) {
+        if let Some(idx) = self.subproject_position(&subproject) {
+            self.subprojects.remove(idx);
+        }
+    }
+
+    /// Move all sub-projects of `from` repo to `to` repo.
+    pub fn move_subprojects(&mut self, from: &Repo, to: &Repo) {
+        for subproject in self.subprojects.iter_mut() {
+            if subproject.belongs_to(from) {
+                *subproject = subproject.moved(to);
+            }
+        }
+    }
+
     pub fn update(&mut self, repo: Rc<Repo>) {
         if let Some(idx) = self.position(&repo) {
             self.repos[idx] = repo.update();
@@ -190,21 +420,122 @@ impl Database {
         }
     }
 
+    pub fn pin(&mut self, repo: &Repo, pinned: bool) {
+        for to_pin in self.repos.iter_mut() {
+            if to_pin.as_ref().eq(repo) {
+                *to_pin = to_pin.pin(pinned);
+                break;
+            }
+        }
+    }
+
+    /// Boost the repo until the given time, zero to remove the boost.
+    pub fn boost(&mut self, repo: &Repo, until: u64) {
+        for to_boost in self.repos.iter_mut() {
+            if to_boost.as_ref().eq(repo) {
+                *to_boost = to_boost.boost(until);
+                break;
+            }
+        }
+    }
+
+    pub fn set_default_branch(&mut self, repo: &Repo, branch: String) {
+        for to_update in self.repos.iter_mut() {
+            if to_update.as_ref().eq(repo) {
+                *to_update = to_update.with_default_branch(branch);
+                break;
+            }
+        }
+    }
+
+    pub fn set_languages(&mut self, repo: &Repo, languages: Vec<String>) {
+        for to_update in self.repos.iter_mut() {
+            if to_update.as_ref().eq(repo) {
+                *to_update = to_update.with_languages(languages);
+                break;
+            }
+        }
+    }
+
     pub fn remove(&mut self, repo: Rc<Repo>) {
         if let Some(idx) = self.position(&repo) {
             self.repos.remove(idx);
         }
+        self.subprojects
+            .retain(|subproject| !subproject.belongs_to(&repo));
+    }
+
+    /// Scale down the access count of all repos and sub-projects by `factor`,
+    /// this is zoxide-style aging to keep the fuzzy ranking fresh.
+    pub fn age(&mut self, factor: f64) {
+        for repo in self.repos.iter_mut() {
+            *repo = repo.aged(factor);
+        }
+        for subproject in self.subprojects.iter_mut() {
+            *subproject = subproject.aged(factor);
+        }
+    }
+
+    /// The unfinished jobs, in the order of creation.
+    pub fn list_jobs(&self) -> &[Job] {
+        &self.jobs
+    }
+
+    /// The id for the new job. The ids are increasing, so two jobs created in
+    /// the same second won't collide.
+    pub fn next_job_id(&self) -> u64 {
+        self.jobs.iter().map(|job| job.id).max().unwrap_or(0) + 1
+    }
+
+    /// Insert the job, or replace the one with the same id.
+    pub fn update_job(&mut self, job: &Job) {
+        match self.jobs.iter_mut().find(|item| item.id == job.id) {
+            Some(item) => *item = job.clone(),
+            None => self.jobs.push(job.clone()),
+        }
     }
 
-    pub fn close(self) -> Result<()> {
-        let Database { repos, path, lock } = self;
-        let bytes: Bytes = repos.into();
+    pub fn remove_job(&mut self, id: u64) {
+        self.jobs.retain(|job| job.id != id);
+    }
+
+    pub fn close(mut self) -> Result<()> {
+        if let None = self.lock {
+            bail!("Could not save the database opened in read-only mode");
+        }
+        let max_accessed = config::base().max_accessed as f64;
+        if max_accessed > 0.0 {
+            let total: f64 = self.repos.iter().map(|repo| repo.accessed).sum();
+            if total > max_accessed {
+                self.age(Self::AGE_RATIO * max_accessed / total);
+            }
+        }
+
+        let now = config::now_secs();
+        if now.saturating_sub(self.shortcuts.time) >= Self::SHORTCUTS_REFRESH_SECS {
+            self.refresh_shortcuts();
+        }
+
+        let Database {
+            repos,
+            subprojects,
+            shortcuts,
+            jobs,
+            path,
+            lock,
+            show_ignored: _,
+        } = self;
+        let bytes = Bytes::from_data(repos, subprojects, shortcuts, jobs);
         bytes.save(&path)?;
         // Drop lock to release file lock after write done.
         drop(lock);
         Ok(())
     }
 
+    fn is_hidden(&self, repo: &Repo) -> bool {
+        !self.show_ignored && config::base().is_ignored(repo.full_name())
+    }
+
     fn position(&self, repo: &Rc<Repo>) -> Option<usize> {
         let mut pos = None;
         for (idx, to_update) in self.repos.iter().enumerate() {
@@ -215,4 +546,13 @@ impl Database {
         }
         pos
     }
+
+    fn subproject_position(&self, subproject: &Rc<SubProject>) -> Option<usize> {
+        self.subprojects.iter().position(|to_update| {
+            to_update.remote.eq(&subproject.remote)
+                && to_update.owner.eq(&subproject.owner)
+                && to_update.name.eq(&subproject.name)
+                && to_update.path.eq(&subproject.path)
+        })
+    }
 }
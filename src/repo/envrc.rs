@@ -0,0 +1,44 @@
+use std::fs;
+use std::io::ErrorKind;
+
+use anyhow::{Context, Result};
+
+use crate::config;
+use crate::repo::types::Repo;
+use crate::{exec, utils};
+
+/// Render the owner's `envrc` template for the repo, return None if the owner
+/// does not have one. The placeholders `{remote}`, `{owner}`, `{name}` and
+/// `{path}` are replaced with the repo's values.
+pub fn render(repo: &Repo) -> Result<Option<String>> {
+    let remote = config::must_get_remote(repo.remote.as_str())?;
+    let template = match remote.owners.get(repo.owner.as_str()) {
+        Some(owner) => match &owner.envrc {
+            Some(template) => template,
+            None => return Ok(None),
+        },
+        None => return Ok(None),
+    };
+    let path = format!("{}", repo.get_path().display());
+    let content = repo.render(template).replace("{path}", &path);
+    Ok(Some(content))
+}
+
+/// Write the rendered envrc to `.envrc` in the repo root. If `force` is false,
+/// an existing file will be kept, since it might be edited by user.
+pub fn write(repo: &Repo, force: bool) -> Result<()> {
+    let content = match render(repo)? {
+        Some(content) => content,
+        None => return Ok(()),
+    };
+    let path = repo.get_path().join(".envrc");
+    if !force {
+        match fs::metadata(&path) {
+            Ok(_) => return Ok(()),
+            Err(err) if err.kind() == ErrorKind::NotFound => {}
+            Err(err) => return Err(err).with_context(|| format!("Read file {}", path.display())),
+        }
+    }
+    exec!("Create file .envrc");
+    utils::write_file(&path, content.as_bytes())
+}
@@ -0,0 +1,110 @@
+use std::env;
+#[cfg(unix)]
+use std::fs::File;
+#[cfg(unix)]
+use std::io::Write;
+#[cfg(unix)]
+use std::mem::ManuallyDrop;
+#[cfg(unix)]
+use std::os::unix::io::FromRawFd;
+use std::sync::OnceLock;
+
+use serde::Serialize;
+
+use crate::config;
+
+/// The events emitted to the file descriptor specified by env
+/// `ROXIDE_EVENTS_FD`, one JSON object per line. Wrapper tools and status
+/// bars can use them to react to roxide actions without parsing the human
+/// readable output.
+#[derive(Debug, Serialize)]
+#[serde(tag = "event", rename_all = "snake_case")]
+pub enum Event<'a> {
+    /// A new repo directory was created or cloned.
+    RepoCreated { repo: &'a str, path: &'a str },
+    /// A repo was removed from disk and database.
+    RepoRemoved { repo: &'a str, path: &'a str },
+    /// An existing directory was attached to a repo.
+    RepoAttached { repo: &'a str, path: &'a str },
+    /// A repo was detached from its directory, the directory is kept.
+    RepoDetached { repo: &'a str, path: &'a str },
+    /// A repo was moved to another remote, owner or name.
+    RepoMoved {
+        from: &'a str,
+        to: &'a str,
+        path: &'a str,
+    },
+    /// A branch sync run finished, `failed` is the number of failed
+    /// operations.
+    SyncDone {
+        repo: &'a str,
+        total: usize,
+        failed: usize,
+    },
+    /// A local branch was deleted.
+    BranchDeleted { path: &'a str, branch: &'a str },
+}
+
+#[derive(Debug, Serialize)]
+struct EventRecord<'a> {
+    time: u64,
+    #[serde(flatten)]
+    event: Event<'a>,
+}
+
+/// The fd to emit events, None means the events are disabled.
+static EVENTS_FD: OnceLock<Option<i32>> = OnceLock::new();
+
+/// Read the fd from env `ROXIDE_EVENTS_FD` and check that it is opened by the
+/// parent process. This must be called at startup, before roxide opens any
+/// file, otherwise a closed fd might be reused by roxide itself (such as for
+/// the database), and the events would corrupt that file.
+pub fn init() {
+    let fd = env::var("ROXIDE_EVENTS_FD")
+        .ok()
+        .and_then(|fd| fd.parse().ok())
+        .filter(|fd| is_open(*fd));
+    let _ = EVENTS_FD.set(fd);
+}
+
+/// Emit the event if env `ROXIDE_EVENTS_FD` is set. Events are best-effort,
+/// any error (such as a closed pipe) is ignored so that it won't break the
+/// command itself.
+pub fn emit(event: Event) {
+    let fd = match EVENTS_FD.get() {
+        Some(Some(fd)) => *fd,
+        _ => return,
+    };
+    let record = EventRecord {
+        time: config::now_secs(),
+        event,
+    };
+    let mut data = match serde_json::to_vec(&record) {
+        Ok(data) => data,
+        Err(_) => return,
+    };
+    data.push(b'\n');
+    write_fd(fd, &data);
+}
+
+#[cfg(unix)]
+fn is_open(fd: i32) -> bool {
+    fd >= 0 && unsafe { libc::fcntl(fd, libc::F_GETFD) } != -1
+}
+
+#[cfg(unix)]
+fn write_fd(fd: i32, data: &[u8]) {
+    // The fd is owned by the parent process, we should not close it.
+    let mut file = ManuallyDrop::new(unsafe { File::from_raw_fd(fd) });
+    let _ = file.write_all(data);
+}
+
+/// Inheriting file descriptors is not supported on Windows, the events are
+/// disabled.
+#[cfg(not(unix))]
+fn is_open(_fd: i32) -> bool {
+    false
+}
+
+#[cfg(not(unix))]
+fn write_fd(_fd: i32, _data: &[u8]) {}
@@ -0,0 +1,154 @@
+use std::rc::Rc;
+
+use anyhow::Result;
+
+use crate::config;
+use crate::repo::database::Database;
+use crate::repo::manifest::Entry;
+use crate::repo::types::Repo;
+
+/// A resumable batch operation. The tasks and their completion are saved to
+/// the database, so that an interrupted run (such as the laptop sleeping or
+/// the terminal being closed) can be continued by `roxide resume`.
+#[derive(Debug, Clone)]
+pub struct Job {
+    pub id: u64,
+    /// The time when the job was created.
+    pub created: u64,
+    pub kind: JobKind,
+    pub tasks: Vec<JobTask>,
+}
+
+#[derive(Debug, Clone)]
+pub enum JobKind {
+    /// Attach or clone the repos, created by `roxide attach --from-file`.
+    Attach,
+    /// Run git maintenance tasks for the repos, created by `roxide maintain`.
+    Maintain { tasks: Vec<String>, register: bool },
+    /// Sync the branches of the repos, created by `roxide sync`.
+    Sync,
+}
+
+#[derive(Debug, Clone)]
+pub struct JobTask {
+    /// The repo to operate, its path is absolute if not in workspace.
+    pub repo: Entry,
+    pub done: bool,
+
+    /// The score of the repo when creating the job, the repos with higher
+    /// scores are done first.
+    pub score: f64,
+
+    /// The default branch of the repo. For attach, it is fetched from remote
+    /// API when creating the job, to cache in database.
+    pub default_branch: Option<String>,
+}
+
+impl JobKind {
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            JobKind::Attach => "attach",
+            JobKind::Maintain { .. } => "maintain",
+            JobKind::Sync => "sync",
+        }
+    }
+}
+
+impl JobTask {
+    pub fn new(repo: &Repo) -> JobTask {
+        JobTask {
+            repo: Entry {
+                remote: format!("{}", repo.remote),
+                owner: format!("{}", repo.owner),
+                name: format!("{}", repo.name),
+                path: repo.path.clone(),
+                pinned: repo.pinned,
+                branch: None,
+            },
+            done: false,
+            score: repo.score(),
+            default_branch: repo.default_branch.clone(),
+        }
+    }
+
+    pub fn full_name(&self) -> String {
+        format!(
+            "{}:{}/{}",
+            self.repo.remote, self.repo.owner, self.repo.name
+        )
+    }
+
+    /// The priority of the task, pinned repos come first, and then the repos
+    /// with higher scores.
+    pub fn priority(&self) -> (bool, f64) {
+        (self.repo.pinned, self.score)
+    }
+
+    pub fn to_repo(&self) -> Rc<Repo> {
+        Repo::new(
+            &self.repo.remote,
+            &self.repo.owner,
+            &self.repo.name,
+            self.repo.path.clone(),
+        )
+    }
+}
+
+impl Job {
+    /// Create the job and save it, so that it can be resumed from the
+    /// beginning if interrupted before the first task is done.
+    pub fn create(kind: JobKind, tasks: Vec<JobTask>) -> Result<Job> {
+        let mut db = Database::read()?;
+        let job = Job {
+            id: db.next_job_id(),
+            created: config::now_secs(),
+            kind,
+            tasks,
+        };
+        db.update_job(&job);
+        db.close()?;
+        Ok(job)
+    }
+
+    /// Read all the unfinished jobs, the newest one comes first.
+    pub fn list() -> Result<Vec<Job>> {
+        let db = Database::read_only()?;
+        let mut jobs = db.list_jobs().to_vec();
+        jobs.reverse();
+        Ok(jobs)
+    }
+
+    pub fn done_count(&self) -> usize {
+        self.tasks.iter().filter(|task| task.done).count()
+    }
+
+    /// Mark the task done and save the progress.
+    pub fn done(&mut self, idx: usize) -> Result<()> {
+        let mut db = Database::read()?;
+        self.done_in(&mut db, idx);
+        db.close()
+    }
+
+    /// The same as [`Job::done`], but save the progress to the database
+    /// opened by the caller, so that it is saved together with the repos.
+    pub fn done_in(&mut self, db: &mut Database, idx: usize) {
+        self.tasks[idx].done = true;
+        db.update_job(self);
+    }
+
+    /// Remove the job if all its tasks are done, otherwise keep it so that the
+    /// failed tasks can be retried by resuming. Return true if removed.
+    pub fn finish(self) -> Result<bool> {
+        if self.done_count() < self.tasks.len() {
+            return Ok(false);
+        }
+        self.remove()?;
+        Ok(true)
+    }
+
+    pub fn remove(self) -> Result<()> {
+        let mut db = Database::read()?;
+        db.remove_job(self.id);
+        db.close()
+    }
+}
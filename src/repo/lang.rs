@@ -0,0 +1,197 @@
+use std::collections::HashMap;
+use std::fs;
+use std::io::ErrorKind;
+use std::path::PathBuf;
+use std::rc::Rc;
+
+use anyhow::{Context, Result};
+use serde::Serialize;
+
+use crate::config;
+use crate::repo::database::Database;
+use crate::repo::types::Repo;
+use crate::utils;
+
+#[derive(Debug, Serialize)]
+pub struct LanguageStat {
+    pub name: String,
+    pub percent: f64,
+}
+
+// The directories that are generated or vendored, they should not be counted.
+const IGNORE_DIRS: [&str; 8] = [
+    ".git",
+    "node_modules",
+    "vendor",
+    "target",
+    "dist",
+    "build",
+    ".venv",
+    "__pycache__",
+];
+
+// Only keep the top languages, this is enough for a monorepo.
+const TOP_LANGUAGES: usize = 3;
+
+fn get_language(ext: &str) -> Option<&'static str> {
+    let lang = match ext {
+        "go" => "Go",
+        "rs" => "Rust",
+        "py" | "pyi" => "Python",
+        "c" | "h" => "C",
+        "cc" | "cpp" | "cxx" | "hpp" | "hh" | "hxx" => "C++",
+        "java" => "Java",
+        "kt" | "kts" => "Kotlin",
+        "scala" => "Scala",
+        "js" | "mjs" | "cjs" | "jsx" => "JavaScript",
+        "ts" | "tsx" => "TypeScript",
+        "vue" => "Vue",
+        "rb" => "Ruby",
+        "php" => "PHP",
+        "swift" => "Swift",
+        "m" | "mm" => "Objective-C",
+        "cs" => "C#",
+        "lua" => "Lua",
+        "sh" | "bash" | "zsh" => "Shell",
+        "pl" | "pm" => "Perl",
+        "hs" => "Haskell",
+        "ex" | "exs" => "Elixir",
+        "erl" => "Erlang",
+        "clj" => "Clojure",
+        "dart" => "Dart",
+        "zig" => "Zig",
+        "nix" => "Nix",
+        "html" | "htm" => "HTML",
+        "css" | "scss" | "sass" | "less" => "CSS",
+        "proto" => "Protocol Buffer",
+        "sql" => "SQL",
+        "vim" => "Vim Script",
+        _ => return None,
+    };
+    Some(lang)
+}
+
+/// Detect the languages of a directory, weighted by the file bytes of each
+/// language. Return the top languages with their percentages, sorted by
+/// percentage desc.
+pub fn detect(dir: &PathBuf) -> Result<Vec<LanguageStat>> {
+    let mut stack = vec![dir.clone()];
+    let mut bytes: HashMap<&'static str, u64> = HashMap::new();
+    let mut total: u64 = 0;
+    while let Some(current_dir) = stack.pop() {
+        let read_dir = match fs::read_dir(&current_dir) {
+            Ok(read_dir) => read_dir,
+            Err(err) if err.kind() == ErrorKind::NotFound => continue,
+            Err(err) => {
+                return Err(err)
+                    .with_context(|| format!("Read directory {}", current_dir.display()))
+            }
+        };
+        for item in read_dir {
+            let item =
+                item.with_context(|| format!("Read directory item for {}", current_dir.display()))?;
+            let path = item.path();
+            let meta = item
+                .metadata()
+                .with_context(|| format!("Get metadata for {}", path.display()))?;
+            if meta.is_dir() {
+                let name = item.file_name();
+                let name = name.to_str().unwrap_or("");
+                if !IGNORE_DIRS.contains(&name) {
+                    stack.push(path);
+                }
+                continue;
+            }
+            if !meta.is_file() {
+                continue;
+            }
+            let lang = match path.extension() {
+                Some(ext) => match ext.to_str() {
+                    Some(ext) => get_language(ext.to_lowercase().as_str()),
+                    None => None,
+                },
+                None => None,
+            };
+            if let Some(lang) = lang {
+                let size = meta.len();
+                *bytes.entry(lang).or_insert(0) += size;
+                total += size;
+            }
+        }
+    }
+    if total == 0 {
+        return Ok(vec![]);
+    }
+
+    let mut stats: Vec<_> = bytes
+        .into_iter()
+        .map(|(name, size)| LanguageStat {
+            name: name.to_string(),
+            percent: (size as f64) * 100.0 / (total as f64),
+        })
+        .collect();
+    stats.sort_unstable_by(|a, b| b.percent.total_cmp(&a.percent));
+    stats.truncate(TOP_LANGUAGES);
+    Ok(stats)
+}
+
+/// Split the `@lang` filter from the query args, such as `roxide home @rust
+/// keyword`. Return the rest query args and the language. The configured
+/// groups (`@group`) are kept in the query args.
+pub fn split_query(args: &[String]) -> (Vec<String>, Option<String>) {
+    let mut query = Vec::with_capacity(args.len());
+    let mut language = None;
+    for arg in args {
+        if config::base().parse_group(arg).is_some() {
+            query.push(arg.clone());
+            continue;
+        }
+        match arg.strip_prefix("@") {
+            Some(lang) if !lang.is_empty() => language = Some(lang.to_string()),
+            _ => query.push(arg.clone()),
+        }
+    }
+    (query, language)
+}
+
+/// Detect the top language names of the repo, return None if the repo is not
+/// cloned yet, so that it can be detected after cloning.
+pub fn detect_names(dir: &PathBuf) -> Result<Option<Vec<String>>> {
+    if !dir.exists() {
+        return Ok(None);
+    }
+    let names = detect(dir)?.into_iter().map(|stat| stat.name).collect();
+    Ok(Some(names))
+}
+
+/// Filter the repos whose top languages contain the given language, the
+/// language name is case-insensitive. The languages cached in database are
+/// used, the repos not detected yet are detected concurrently and cached into
+/// the database, the caller should close the database to save them.
+pub fn filter(db: &mut Database, repos: Vec<Rc<Repo>>, language: &str) -> Result<Vec<Rc<Repo>>> {
+    let missing: Vec<PathBuf> = repos
+        .iter()
+        .filter(|repo| repo.languages.is_none())
+        .map(|repo| repo.get_path())
+        .collect();
+    let mut detected = utils::batch(&missing, detect_names).into_iter();
+
+    let language = language.to_lowercase();
+    let mut result = Vec::with_capacity(repos.len());
+    for repo in repos {
+        let languages = match &repo.languages {
+            Some(languages) => languages.clone(),
+            None => match detected.next().unwrap()? {
+                Some(languages) => {
+                    db.set_languages(&repo, languages.clone());
+                    languages
+                }
+                None => continue,
+            },
+        };
+        if languages.iter().any(|name| name.to_lowercase() == language) {
+            result.push(repo);
+        }
+    }
+    Ok(result)
+}
@@ -0,0 +1,199 @@
+use std::fs;
+use std::io::ErrorKind;
+use std::path::PathBuf;
+
+use anyhow::{Context, Result};
+use serde::Serialize;
+
+/// The license found in the root of a repo.
+#[derive(Debug, Serialize)]
+pub struct LicenseInfo {
+    /// The license file name, None means the license is missing.
+    pub file: Option<String>,
+    /// The SPDX identifier, None means the license file could not be
+    /// recognized.
+    pub name: Option<&'static str>,
+}
+
+// The prefixes of license file names, case insensitive.
+const FILE_PREFIXES: [&str; 3] = ["license", "licence", "copying"];
+
+// Only the head of the license file is inspected, where the title and the
+// first paragraphs are. The full texts mention other licenses later, such as
+// GPL-3.0 mentioning AGPL (section 13) and LGPL (the last paragraph).
+const HEAD_LEN: usize = 2000;
+
+// The license is recognized by these phrases, all of them should appear in the
+// head of file. The GPL family, Apache and MPL are matched by their titles,
+// such as "GNU GENERAL PUBLIC LICENSE Version 3". The more specific ones must
+// come first, such as BSD-3-Clause before BSD-2-Clause.
+const RULES: [(&str, &[&str]); 13] = [
+    ("AGPL-3.0", &["gnu affero general public license version 3"]),
+    ("LGPL-3.0", &["gnu lesser general public license version 3"]),
+    (
+        "LGPL-2.1",
+        &["gnu lesser general public license version 2.1"],
+    ),
+    ("GPL-3.0", &["gnu general public license version 3"]),
+    ("GPL-2.0", &["gnu general public license version 2"]),
+    ("Apache-2.0", &["apache license version 2.0"]),
+    ("MPL-2.0", &["mozilla public license version 2.0"]),
+    ("MIT", &["permission is hereby granted, free of charge"]),
+    (
+        "BSD-3-Clause",
+        &[
+            "redistribution and use in source and binary forms",
+            "neither the name",
+        ],
+    ),
+    (
+        "BSD-2-Clause",
+        &["redistribution and use in source and binary forms"],
+    ),
+    (
+        "ISC",
+        &["permission to use, copy, modify, and/or distribute this software"],
+    ),
+    ("Unlicense", &["this is free and unencumbered software"]),
+    ("CC0-1.0", &["cc0 1.0 universal"]),
+];
+
+/// Find the license file in the root of repo and recognize it. Return None
+/// if the repo is not cloned.
+pub fn detect(dir: &PathBuf) -> Result<Option<LicenseInfo>> {
+    let entries = match fs::read_dir(dir) {
+        Ok(entries) => entries,
+        Err(err) if err.kind() == ErrorKind::NotFound => return Ok(None),
+        Err(err) => return Err(err).with_context(|| format!("Read dir {}", dir.display())),
+    };
+
+    let mut files = Vec::new();
+    for entry in entries {
+        let entry = entry.with_context(|| format!("Read dir entry in {}", dir.display()))?;
+        if !entry.file_type().context("Get file type")?.is_file() {
+            continue;
+        }
+        let name = entry.file_name().to_string_lossy().into_owned();
+        let lower = name.to_lowercase();
+        if FILE_PREFIXES.iter().any(|prefix| lower.starts_with(prefix)) {
+            files.push(name);
+        }
+    }
+    if files.is_empty() {
+        return Ok(Some(LicenseInfo {
+            file: None,
+            name: None,
+        }));
+    }
+    // Prefer the shortest name, such as `LICENSE` over `LICENSE-THIRD-PARTY`.
+    files.sort_unstable_by(|a, b| a.len().cmp(&b.len()).then(a.cmp(b)));
+
+    for file in files.iter() {
+        let path = dir.join(file);
+        let data =
+            fs::read(&path).with_context(|| format!("Read license file {}", path.display()))?;
+        if let Some(name) = recognize(&String::from_utf8_lossy(&data)) {
+            return Ok(Some(LicenseInfo {
+                file: Some(file.clone()),
+                name: Some(name),
+            }));
+        }
+    }
+    Ok(Some(LicenseInfo {
+        file: Some(files.remove(0)),
+        name: None,
+    }))
+}
+
+/// Recognize the license by the head of its text, return None if unknown.
+fn recognize(content: &str) -> Option<&'static str> {
+    // Collapse the whitespaces, the phrases might be wrapped, and the titles
+    // are usually indented.
+    let head: String = content
+        .split_whitespace()
+        .collect::<Vec<_>>()
+        .join(" ")
+        .to_lowercase()
+        .chars()
+        .take(HEAD_LEN)
+        .collect();
+    for (name, phrases) in RULES {
+        if phrases.iter().all(|phrase| head.contains(phrase)) {
+            return Some(name);
+        }
+    }
+    None
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_recognize_full_text() {
+        let cases = [
+            ("GPL-3.0", include_str!("testdata/license/GPL-3.0")),
+            ("GPL-2.0", include_str!("testdata/license/GPL-2.0")),
+            ("LGPL-3.0", include_str!("testdata/license/LGPL-3.0")),
+            ("LGPL-2.1", include_str!("testdata/license/LGPL-2.1")),
+            ("Apache-2.0", include_str!("testdata/license/Apache-2.0")),
+            ("MPL-2.0", include_str!("testdata/license/MPL-2.0")),
+            ("CC0-1.0", include_str!("testdata/license/CC0-1.0")),
+        ];
+        for (expect, content) in cases {
+            assert_eq!(recognize(content), Some(expect));
+        }
+    }
+
+    #[test]
+    fn test_recognize_short_text() {
+        let agpl = "                    GNU AFFERO GENERAL PUBLIC LICENSE
+                       Version 3, 19 November 2007
+
+ Copyright (C) 2007 Free Software Foundation, Inc. <https://fsf.org/>
+ Everyone is permitted to copy and distribute verbatim copies
+ of this license document, but changing it is not allowed.
+
+                            Preamble
+
+  The GNU Affero General Public License is a free, copyleft license for
+software and other kinds of works, specifically designed to ensure
+cooperation with the community in the case of network server software.";
+        let mit = "MIT License
+
+Copyright (c) 2023 fioncat
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the \"Software\"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:";
+        let bsd3 = "BSD 3-Clause License
+
+Copyright (c) 2023, fioncat
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+1. Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+
+2. Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+3. Neither the name of the copyright holder nor the names of its
+   contributors may be used to endorse or promote products derived from
+   this software without specific prior written permission.";
+        assert_eq!(recognize(agpl), Some("AGPL-3.0"));
+        assert_eq!(recognize(mit), Some("MIT"));
+        assert_eq!(recognize(bsd3), Some("BSD-3-Clause"));
+
+        assert_eq!(recognize(""), None);
+        assert_eq!(recognize("All rights reserved."), None);
+        // The other licenses mentioned after the head are ignored.
+        let tail = format!("{}{}", "word ".repeat(HEAD_LEN / 5), agpl);
+        assert_eq!(recognize(&format!("Custom license.\n{tail}")), None);
+    }
+}
@@ -0,0 +1,68 @@
+use std::fs;
+
+use anyhow::{bail, Context, Result};
+use serde::{Deserialize, Serialize};
+
+/// An entry of the repo manifest, which is exported by `roxide get --manifest`
+/// and imported by `roxide attach --from-file`, so that the workspace can be
+/// recreated on a new machine.
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct Entry {
+    pub remote: String,
+    pub owner: String,
+    pub name: String,
+
+    /// The path of the repo, None means the repo is in workspace.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub path: Option<String>,
+
+    #[serde(default, skip_serializing_if = "is_false")]
+    pub pinned: bool,
+
+    /// The branch to checkout after cloning.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub branch: Option<String>,
+}
+
+fn is_false(value: &bool) -> bool {
+    !*value
+}
+
+/// Read the manifest file. The file can be json or yaml (a list of entries),
+/// or plain text with lines `remote owner/name [path]`.
+pub fn read(file: &str) -> Result<Vec<Entry>> {
+    let data = fs::read_to_string(file).with_context(|| format!("Read manifest file {file}"))?;
+    if file.ends_with(".json") {
+        return serde_json::from_str(&data).context("Parse manifest json");
+    }
+    if file.ends_with(".yaml") || file.ends_with(".yml") {
+        return serde_yaml::from_str(&data).context("Parse manifest yaml");
+    }
+
+    let mut entries = Vec::new();
+    for (idx, line) in data.lines().enumerate() {
+        let line = line.trim();
+        if line.is_empty() || line.starts_with("#") {
+            continue;
+        }
+        let fields: Vec<&str> = line.split_whitespace().collect();
+        let (remote, query, path) = match fields.as_slice() {
+            [remote, query] => (*remote, *query, None),
+            [remote, query, path] => (*remote, *query, Some(path.to_string())),
+            _ => bail!("Invalid manifest line {}: {line}", idx + 1),
+        };
+        let (owner, name) = match query.rsplit_once("/") {
+            Some((owner, name)) if !owner.is_empty() && !name.is_empty() => (owner, name),
+            _ => bail!("Invalid repo {query} in manifest line {}", idx + 1),
+        };
+        entries.push(Entry {
+            remote: remote.to_string(),
+            owner: owner.to_string(),
+            name: name.to_string(),
+            path,
+            pinned: false,
+            branch: None,
+        });
+    }
+    Ok(entries)
+}
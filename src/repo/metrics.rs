@@ -0,0 +1,143 @@
+use std::collections::HashMap;
+use std::fs::{self, OpenOptions};
+use std::io::{ErrorKind, Write};
+use std::path::PathBuf;
+use std::time::Duration;
+
+use anyhow::{Context, Result};
+use serde::{Deserialize, Serialize};
+
+use crate::config;
+use crate::utils::{self, Lock};
+
+/// The record of a command run, only saved when `metrics` is enabled in
+/// config. The records are stored locally and never sent anywhere.
+#[derive(Debug, Deserialize, Serialize)]
+pub struct Metric {
+    pub time: u64,
+    pub command: String,
+    /// The duration in milliseconds.
+    pub duration: u64,
+    pub ok: bool,
+}
+
+/// The aggregated metrics of a command.
+pub struct MetricSummary {
+    pub command: String,
+    pub count: usize,
+    pub failed: usize,
+    pub avg: u64,
+    pub p95: u64,
+    pub max: u64,
+}
+
+impl Metric {
+    /// Only keep the latest records to prevent the file growing forever.
+    const MAX_METRICS: usize = 2000;
+
+    /// The records are appended as json lines, rewriting the whole file on
+    /// every command is too slow. Once the file exceeds this size, it is
+    /// compacted to the latest [`Metric::MAX_METRICS`] records.
+    const MAX_FILE_BYTES: u64 = 1024 * 1024;
+
+    /// Append a record to the metrics file.
+    pub fn save(command: &str, duration: Duration, ok: bool) -> Result<()> {
+        let metric = Metric {
+            time: config::now_secs(),
+            command: command.to_string(),
+            duration: duration.as_millis() as u64,
+            ok,
+        };
+        let mut line = serde_json::to_vec(&metric).context("Encode metric")?;
+        line.push(b'\n');
+
+        let _lock = Lock::acquire("metrics")?;
+        let path = Self::path();
+        utils::ensure_dir(&path)?;
+        let mut file = OpenOptions::new()
+            .create(true)
+            .append(true)
+            .open(&path)
+            .with_context(|| format!("Open file {}", path.display()))?;
+        file.write_all(&line)
+            .with_context(|| format!("Write file {}", path.display()))?;
+
+        let size = file
+            .metadata()
+            .with_context(|| format!("Get metadata of {}", path.display()))?
+            .len();
+        if size > Self::MAX_FILE_BYTES {
+            Self::compact(&path)?;
+        }
+        Ok(())
+    }
+
+    fn compact(path: &PathBuf) -> Result<()> {
+        let mut metrics = Self::read_path(path)?;
+        if metrics.len() > Self::MAX_METRICS {
+            metrics.drain(..metrics.len() - Self::MAX_METRICS);
+        }
+        let mut data = Vec::new();
+        for metric in metrics.iter() {
+            serde_json::to_writer(&mut data, metric).context("Encode metric")?;
+            data.push(b'\n');
+        }
+        utils::write_file(path, &data)
+    }
+
+    /// Aggregate the records by command, the slowest command comes first.
+    pub fn summary() -> Result<Vec<MetricSummary>> {
+        let mut metrics = Self::read_path(&Self::path())?;
+        if metrics.len() > Self::MAX_METRICS {
+            metrics.drain(..metrics.len() - Self::MAX_METRICS);
+        }
+        let mut groups: HashMap<String, Vec<Metric>> = HashMap::new();
+        for metric in metrics {
+            groups
+                .entry(metric.command.clone())
+                .or_default()
+                .push(metric);
+        }
+
+        let mut summaries: Vec<MetricSummary> = groups
+            .into_iter()
+            .map(|(command, metrics)| {
+                let mut durations: Vec<u64> =
+                    metrics.iter().map(|metric| metric.duration).collect();
+                durations.sort_unstable();
+                let count = durations.len();
+                let total: u64 = durations.iter().sum();
+                // Nearest-rank percentile.
+                let p95_idx = ((count as f64) * 0.95).ceil() as usize;
+                MetricSummary {
+                    command,
+                    count,
+                    failed: metrics.iter().filter(|metric| !metric.ok).count(),
+                    avg: total / count as u64,
+                    p95: durations[p95_idx.max(1) - 1],
+                    max: durations[count - 1],
+                }
+            })
+            .collect();
+        summaries.sort_unstable_by(|a, b| b.p95.cmp(&a.p95));
+        Ok(summaries)
+    }
+
+    fn path() -> PathBuf {
+        PathBuf::from(&config::base().statedir).join("metrics.jsonl")
+    }
+
+    /// Read the records, the broken lines (such as the one partially written
+    /// when the process is killed) are skipped.
+    fn read_path(path: &PathBuf) -> Result<Vec<Metric>> {
+        let data = match fs::read_to_string(path) {
+            Ok(data) => data,
+            Err(err) if err.kind() == ErrorKind::NotFound => return Ok(Vec::new()),
+            Err(err) => return Err(err).with_context(|| format!("Read file {}", path.display())),
+        };
+        Ok(data
+            .lines()
+            .filter_map(|line| serde_json::from_str(line).ok())
+            .collect())
+    }
+}
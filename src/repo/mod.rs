@@ -1,3 +1,13 @@
+pub mod budget;
 pub mod bytes;
+pub mod commit;
 pub mod database;
+pub mod envrc;
+pub mod event;
+pub mod job;
+pub mod lang;
+pub mod license;
+pub mod manifest;
+pub mod metrics;
+pub mod report;
 pub mod types;
@@ -0,0 +1,314 @@
+use std::fs;
+use std::io::ErrorKind;
+use std::path::PathBuf;
+
+use anyhow::{Context, Result};
+use console::style;
+use serde::{Deserialize, Serialize};
+
+use crate::config;
+use crate::repo::event::{self, Event};
+use crate::utils::{self, Lock, Table};
+
+/// The report of a branch sync run, records what happened to each branch so
+/// that the conflicts can be reviewed later.
+#[derive(Debug, Deserialize, Serialize)]
+pub struct SyncReport {
+    pub time: u64,
+    pub repo: String,
+    pub items: Vec<SyncReportItem>,
+}
+
+#[derive(Debug, Deserialize, Serialize)]
+pub struct SyncReportItem {
+    /// The operation, one of `push`, `pull`, `delete` and `conflict`. The
+    /// `conflict` records the branch diverged from upstream, which is skipped.
+    pub op: String,
+    pub branch: String,
+    /// The number of commits pushed or pulled.
+    #[serde(default)]
+    pub commits: usize,
+    /// The error message if the operation failed, such as a merge conflict.
+    pub error: Option<String>,
+}
+
+impl SyncReportItem {
+    /// The branch with the number of commits pushed or pulled, such as
+    /// `main(3)`.
+    pub fn branch_desc(&self) -> String {
+        if self.commits == 0 {
+            return self.branch.clone();
+        }
+        format!("{}({})", self.branch, self.commits)
+    }
+}
+
+impl SyncReport {
+    /// Only keep the latest reports to prevent the file growing forever.
+    const MAX_REPORTS: usize = 100;
+
+    pub fn new(repo: String) -> SyncReport {
+        SyncReport {
+            time: config::now_secs(),
+            repo,
+            items: Vec::new(),
+        }
+    }
+
+    pub fn add(&mut self, op: &str, branch: &str, commits: usize, result: &Result<()>) {
+        self.items.push(SyncReportItem {
+            op: op.to_string(),
+            branch: branch.to_string(),
+            commits,
+            error: result
+                .as_ref()
+                .err()
+                .map(|err| format!("{err:#}").replace("\n", " ")),
+        });
+    }
+
+    /// Record the branch diverged from upstream, it could not be synced
+    /// automatically and should be resolved by the user.
+    pub fn add_conflict(&mut self, branch: &str, ahead: usize, behind: usize) {
+        self.items.push(SyncReportItem {
+            op: String::from("conflict"),
+            branch: branch.to_string(),
+            commits: 0,
+            error: Some(format!("diverged from upstream, ↑{ahead} ↓{behind}")),
+        });
+    }
+
+    /// The number of branches diverged from upstream.
+    pub fn conflicts(&self) -> usize {
+        self.items
+            .iter()
+            .filter(|item| item.op == "conflict")
+            .count()
+    }
+
+    /// Append this report to the report file, and emit the `sync_done`
+    /// event.
+    pub fn save(self) -> Result<()> {
+        Self::save_all(vec![self])
+    }
+
+    /// The same as [`SyncReport::save`], but append the reports of many
+    /// repos in one write.
+    pub fn save_all(new_reports: Vec<SyncReport>) -> Result<()> {
+        for report in new_reports.iter() {
+            event::emit(Event::SyncDone {
+                repo: &report.repo,
+                total: report.items.len(),
+                failed: report
+                    .items
+                    .iter()
+                    .filter(|item| item.error.is_some() && item.op != "conflict")
+                    .count(),
+            });
+        }
+
+        let _lock = Lock::acquire("sync_report")?;
+        let path = Self::path();
+        let mut reports = Self::read_path(&path)?;
+        reports.extend(new_reports);
+        if reports.len() > Self::MAX_REPORTS {
+            reports.drain(..reports.len() - Self::MAX_REPORTS);
+        }
+        let data = serde_json::to_vec(&reports).context("Encode sync reports")?;
+        utils::write_file(&path, &data)
+    }
+
+    /// Split the report repo into the group (`remote:owner`) and the name.
+    /// The report repo is the full name `remote:owner/name`, or the directory
+    /// path if the repo is not in database, which is put in `<others>`.
+    pub fn split_group(&self) -> (String, String) {
+        match self.repo.split_once(":") {
+            Some((remote, rest)) => match rest.rsplit_once("/") {
+                Some((owner, name)) => (format!("{remote}:{owner}"), name.to_string()),
+                None => (remote.to_string(), rest.to_string()),
+            },
+            None => (String::from("<others>"), self.repo.clone()),
+        }
+    }
+
+    /// Show the reports grouped by owner, each group with its counts, and
+    /// end with the summary matrix of all owners. This makes the result of a
+    /// big sync run digestible. The time column is only useful for the
+    /// persisted reports, the live sync run has the same time.
+    pub fn show_grouped(reports: &[SyncReport], show_time: bool) -> Result<()> {
+        let mut groups: Vec<(String, Vec<(String, &SyncReport)>)> = Vec::new();
+        for report in reports {
+            let (group, name) = report.split_group();
+            match groups.iter_mut().find(|(key, _)| key == &group) {
+                Some((_, items)) => items.push((name, report)),
+                None => groups.push((group, vec![(name, report)])),
+            }
+        }
+        groups.sort_by(|(a, _), (b, _)| a.cmp(b));
+
+        let mut summaries = Vec::with_capacity(groups.len());
+        for (group, items) in groups {
+            let mut summary = ReportSummary::default();
+            let mut repos: Vec<&str> = Vec::new();
+            let mut table = Table::with_capacity(1 + items.len());
+            let mut titles = vec![
+                String::from("REPO"),
+                String::from("OP"),
+                String::from("BRANCH"),
+                String::from("RESULT"),
+            ];
+            if show_time {
+                titles.insert(0, String::from("TIME"));
+            }
+            table.add(titles);
+            for (name, report) in items.iter() {
+                if !repos.contains(&name.as_str()) {
+                    repos.push(name);
+                }
+                let time = utils::format_time(report.time)?;
+                for item in report.items.iter() {
+                    summary.add(item);
+                    let result = match &item.error {
+                        Some(err) if item.op == "conflict" => err.clone(),
+                        Some(err) => format!("failed: {err}"),
+                        None => String::from("ok"),
+                    };
+                    let mut row = vec![name.clone(), item.op.clone(), item.branch_desc(), result];
+                    if show_time {
+                        row.insert(0, time.clone());
+                    }
+                    table.add(row);
+                }
+            }
+            summary.repos = repos.len();
+
+            let word = if summary.repos == 1 { "repo" } else { "repos" };
+            println!(
+                "{} ({} {word}, {} conflicts, {} failed)",
+                style(&group).yellow(),
+                summary.repos,
+                summary.conflict,
+                summary.failed
+            );
+            table.show();
+            println!();
+            summaries.push((group, summary));
+        }
+
+        ReportSummary::show_matrix(summaries);
+        Ok(())
+    }
+
+    /// Read the latest `limit` reports, the newest one comes first.
+    pub fn list(limit: usize) -> Result<Vec<SyncReport>> {
+        let mut reports = Self::read_path(&Self::path())?;
+        reports.reverse();
+        reports.truncate(limit);
+        Ok(reports)
+    }
+
+    fn path() -> PathBuf {
+        PathBuf::from(&config::base().statedir).join("sync_reports.json")
+    }
+
+    fn read_path(path: &PathBuf) -> Result<Vec<SyncReport>> {
+        match fs::read(path) {
+            Ok(data) => serde_json::from_slice(&data)
+                .with_context(|| format!("Decode sync reports {}", path.display())),
+            Err(err) if err.kind() == ErrorKind::NotFound => Ok(Vec::new()),
+            Err(err) => Err(err).with_context(|| format!("Read file {}", path.display())),
+        }
+    }
+}
+
+/// The operation counts of sync reports in one owner.
+#[derive(Default)]
+pub struct ReportSummary {
+    pub repos: usize,
+    pub push: usize,
+    pub pull: usize,
+    pub delete: usize,
+    pub conflict: usize,
+    pub failed: usize,
+}
+
+impl ReportSummary {
+    pub fn add(&mut self, item: &SyncReportItem) {
+        match item.op.as_str() {
+            "push" => self.push += 1,
+            "pull" => self.pull += 1,
+            "delete" => self.delete += 1,
+            // The diverged branch is reported with its error, but it is not
+            // counted as a failure.
+            "conflict" => {
+                self.conflict += 1;
+                return;
+            }
+            _ => {}
+        }
+        if item.error.is_some() {
+            self.failed += 1;
+        }
+    }
+
+    /// Show the summaries as a matrix, one row per owner.
+    pub fn show_matrix(summaries: Vec<(String, ReportSummary)>) {
+        let mut table = Table::with_capacity(1 + summaries.len());
+        table.add(vec![
+            String::from("OWNER"),
+            String::from("REPOS"),
+            String::from("PUSH"),
+            String::from("PULL"),
+            String::from("DELETE"),
+            String::from("CONFLICT"),
+            String::from("FAILED"),
+        ]);
+        for (group, summary) in summaries {
+            table.add(vec![
+                group,
+                format!("{}", summary.repos),
+                format!("{}", summary.push),
+                format!("{}", summary.pull),
+                format!("{}", summary.delete),
+                format!("{}", summary.conflict),
+                format!("{}", summary.failed),
+            ]);
+        }
+        table.show();
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_split_group() {
+        let cases = [
+            ("github:fioncat/roxide", "github:fioncat", "roxide"),
+            ("gitlab:group/sub/repo", "gitlab:group/sub", "repo"),
+            ("/tmp/repo", "<others>", "/tmp/repo"),
+        ];
+        for (repo, group, name) in cases {
+            let report = SyncReport::new(repo.to_string());
+            assert_eq!(report.split_group(), (group.to_string(), name.to_string()));
+        }
+    }
+
+    #[test]
+    fn test_summary_conflict() {
+        let mut report = SyncReport::new(String::from("github:fioncat/roxide"));
+        report.add("push", "main", 1, &Ok(()));
+        report.add("pull", "dev", 2, &Err(anyhow::anyhow!("failed")));
+        report.add_conflict("feature", 1, 2);
+
+        let mut summary = ReportSummary::default();
+        for item in report.items.iter() {
+            summary.add(item);
+        }
+        assert_eq!(summary.push, 1);
+        assert_eq!(summary.pull, 1);
+        assert_eq!(summary.conflict, 1);
+        assert_eq!(summary.failed, 1);
+    }
+}
@@ -6,7 +6,7 @@ use crate::config;
 use crate::config::types::Remote;
 use crate::utils;
 
-#[derive(Debug)]
+#[derive(Debug, Clone)]
 pub struct Repo {
     pub remote: Rc<String>,
     pub owner: Rc<String>,
@@ -16,6 +16,37 @@ pub struct Repo {
 
     pub last_accessed: u64,
     pub accessed: f64,
+
+    /// Pinned repo could not be removed, and is ranked above others in
+    /// fuzzy matching and searching.
+    pub pinned: bool,
+
+    /// The cached default branch, to avoid calling git or remote API every
+    /// time. It is refreshed when syncing branches.
+    pub default_branch: Option<String>,
+
+    /// Until this time, the repo is ranked above the unboosted ones. Zero
+    /// means no boost. The boost expires by itself, see [`Repo::boosted`].
+    pub boost_until: u64,
+
+    /// The cached top languages, to avoid walking the repo files every time
+    /// filtering by language. None means the languages are not detected yet.
+    pub languages: Option<Vec<String>>,
+}
+
+/// A sub-project is a directory inside a repo, such as a service in a
+/// monorepo. It has its own access info so that it can be ranked separately.
+#[derive(Debug)]
+pub struct SubProject {
+    pub remote: Rc<String>,
+    pub owner: Rc<String>,
+    pub name: Rc<String>,
+
+    /// The relative path inside the repo.
+    pub path: String,
+
+    pub last_accessed: u64,
+    pub accessed: f64,
 }
 
 pub enum NameLevel {
@@ -55,6 +86,10 @@ impl Repo {
             path,
             last_accessed: config::now_secs(),
             accessed: 0.0,
+            pinned: false,
+            default_branch: None,
+            boost_until: 0,
+            languages: None,
         })
     }
 
@@ -65,27 +100,84 @@ impl Repo {
         Self::new(remote.as_ref(), &upstream.owner, &upstream.name, None)
     }
 
+    /// Move the repo to another remote or owner, the access info is kept.
+    pub fn moved<S>(&self, remote: S, owner: S, name: S, path: Option<String>) -> Rc<Repo>
+    where
+        S: AsRef<str>,
+    {
+        Rc::new(Repo {
+            remote: Rc::new(remote.as_ref().to_string()),
+            owner: Rc::new(owner.as_ref().to_string()),
+            name: Rc::new(name.as_ref().to_string()),
+            path,
+            ..self.clone()
+        })
+    }
+
     pub fn update(&self) -> Rc<Repo> {
         Rc::new(Repo {
-            remote: Rc::clone(&self.remote),
-            owner: Rc::clone(&self.owner),
-            name: Rc::clone(&self.name),
-            path: self.path.clone(),
             last_accessed: config::now_secs(),
             accessed: self.accessed + 1.0,
+            ..self.clone()
+        })
+    }
+
+    pub fn pin(&self, pinned: bool) -> Rc<Repo> {
+        Rc::new(Repo {
+            pinned,
+            ..self.clone()
+        })
+    }
+
+    pub fn boost(&self, until: u64) -> Rc<Repo> {
+        Rc::new(Repo {
+            boost_until: until,
+            ..self.clone()
+        })
+    }
+
+    pub fn with_default_branch(&self, branch: String) -> Rc<Repo> {
+        Rc::new(Repo {
+            default_branch: Some(branch),
+            ..self.clone()
+        })
+    }
+
+    pub fn with_languages(&self, languages: Vec<String>) -> Rc<Repo> {
+        Rc::new(Repo {
+            languages: Some(languages),
+            ..self.clone()
+        })
+    }
+
+    pub fn aged(&self, factor: f64) -> Rc<Repo> {
+        Rc::new(Repo {
+            accessed: self.accessed * factor,
+            ..self.clone()
         })
     }
 
     pub fn score(&self) -> f64 {
-        let duration = config::now_secs().saturating_sub(self.last_accessed);
-        if duration < utils::HOUR {
-            self.accessed * 4.0
-        } else if duration < utils::DAY {
-            self.accessed * 2.0
-        } else if duration < utils::WEEK {
-            self.accessed * 0.5
-        } else {
-            self.accessed * 0.25
+        score(self.last_accessed, self.accessed)
+    }
+
+    /// Return true if the repo has a boost that has not expired yet.
+    pub fn boosted(&self) -> bool {
+        self.boosted_at(config::now_secs())
+    }
+
+    /// Same as [`Repo::boosted`], but with the given current time, so that
+    /// the callers comparing many repos only need to get the time once.
+    pub fn boosted_at(&self, now: u64) -> bool {
+        self.boost_until > now
+    }
+
+    /// Return true if the repo matches the fuzzy query, the query is a keyword
+    /// of the name, or `owner/keyword` to limit the owner.
+    pub fn fuzzy_match(&self, query: &str) -> bool {
+        match query.split_once("/") {
+            Some((owner, name)) => self.owner.as_str() == owner && self.name.contains(name),
+            None => self.name.contains(query),
         }
     }
 
@@ -116,23 +208,168 @@ impl Repo {
         format!("{}:{}/{}", self.remote, self.owner, self.name)
     }
 
-    pub fn clone_url(&self, remote: &Remote) -> String {
-        let mut ssh = remote.ssh;
+    /// Replace the placeholders `{remote}`, `{owner}` and `{name}` in the
+    /// template with the repo's values. The callers replace their own extra
+    /// placeholders afterwards.
+    pub fn render(&self, template: &str) -> String {
+        template
+            .replace("{remote}", self.remote.as_str())
+            .replace("{owner}", self.owner.as_str())
+            .replace("{name}", self.name.as_str())
+    }
+
+    pub fn use_ssh(&self, remote: &Remote) -> bool {
         if let Some(owner_cfg) = remote.owners.get(self.owner.as_str()) {
             if let Some(use_ssh) = owner_cfg.ssh {
-                ssh = use_ssh;
+                return use_ssh;
             }
         }
+        remote.ssh
+    }
 
-        let domain = match &remote.clone {
-            Some(domain) => domain.as_str(),
-            None => "github.com",
-        };
+    pub fn use_https_auth(&self, remote: &Remote) -> bool {
+        if self.use_ssh(remote) {
+            return false;
+        }
+        if let Some(owner_cfg) = remote.owners.get(self.owner.as_str()) {
+            if let Some(https_auth) = owner_cfg.https_auth {
+                return https_auth;
+            }
+        }
+        remote.https_auth
+    }
 
-        if ssh {
-            format!("git@{}:{}.git", domain, self.long_name())
+    pub fn clone_url(&self, remote: &Remote) -> String {
+        if self.use_ssh(remote) {
+            self.ssh_url(remote)
         } else {
-            format!("https://{}/{}.git", domain, self.long_name())
+            self.https_url(remote)
         }
     }
+
+    pub fn ssh_url(&self, remote: &Remote) -> String {
+        let domain = match &remote.ssh_alias {
+            Some(alias) => alias.as_str(),
+            None => Self::domain(remote),
+        };
+        let user = match &remote.ssh_user {
+            Some(user) => user.as_str(),
+            None => "git",
+        };
+        match remote.ssh_port {
+            Some(port) => format!("ssh://{user}@{domain}:{port}/{}.git", self.long_name()),
+            None => format!("{user}@{domain}:{}.git", self.long_name()),
+        }
+    }
+
+    pub fn https_url(&self, remote: &Remote) -> String {
+        format!("https://{}/{}.git", Self::domain(remote), self.long_name())
+    }
+
+    /// The web url is built from the clone domain, without calling remote API.
+    pub fn web_url(&self, remote: &Remote) -> String {
+        format!("https://{}/{}", Self::domain(remote), self.long_name())
+    }
+
+    fn domain(remote: &Remote) -> &str {
+        match &remote.clone {
+            Some(domain) => domain.as_str(),
+            None => "github.com",
+        }
+    }
+}
+
+impl SubProject {
+    pub fn new<S>(repo: &Repo, path: S) -> Rc<SubProject>
+    where
+        S: AsRef<str>,
+    {
+        Rc::new(SubProject {
+            remote: Rc::clone(&repo.remote),
+            owner: Rc::clone(&repo.owner),
+            name: Rc::clone(&repo.name),
+            path: path.as_ref().to_string(),
+            last_accessed: config::now_secs(),
+            accessed: 0.0,
+        })
+    }
+
+    pub fn update(&self) -> Rc<SubProject> {
+        Rc::new(SubProject {
+            remote: Rc::clone(&self.remote),
+            owner: Rc::clone(&self.owner),
+            name: Rc::clone(&self.name),
+            path: self.path.clone(),
+            last_accessed: config::now_secs(),
+            accessed: self.accessed + 1.0,
+        })
+    }
+
+    /// Move the sub-project to another parent repo, the access info is kept.
+    pub fn moved(&self, repo: &Repo) -> Rc<SubProject> {
+        Rc::new(SubProject {
+            remote: Rc::clone(&repo.remote),
+            owner: Rc::clone(&repo.owner),
+            name: Rc::clone(&repo.name),
+            path: self.path.clone(),
+            last_accessed: self.last_accessed,
+            accessed: self.accessed,
+        })
+    }
+
+    pub fn aged(&self, factor: f64) -> Rc<SubProject> {
+        Rc::new(SubProject {
+            remote: Rc::clone(&self.remote),
+            owner: Rc::clone(&self.owner),
+            name: Rc::clone(&self.name),
+            path: self.path.clone(),
+            last_accessed: self.last_accessed,
+            accessed: self.accessed * factor,
+        })
+    }
+
+    pub fn belongs_to(&self, repo: &Repo) -> bool {
+        self.remote.eq(&repo.remote) && self.owner.eq(&repo.owner) && self.name.eq(&repo.name)
+    }
+
+    pub fn score(&self) -> f64 {
+        score(self.last_accessed, self.accessed)
+    }
+
+    pub fn get_path(&self, repo: &Repo) -> PathBuf {
+        repo.get_path().join(&self.path)
+    }
+
+    pub fn full_name(&self) -> String {
+        format!("{}:{}/{}/{}", self.remote, self.owner, self.name, self.path)
+    }
+}
+
+fn score(last_accessed: u64, accessed: f64) -> f64 {
+    let duration = config::now_secs().saturating_sub(last_accessed);
+    if duration < utils::HOUR {
+        accessed * 4.0
+    } else if duration < utils::DAY {
+        accessed * 2.0
+    } else if duration < utils::WEEK {
+        accessed * 0.5
+    } else {
+        accessed * 0.25
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_render() {
+        let repo = Repo::new("github", "fioncat", "roxide", None);
+        assert_eq!(
+            repo.render("{remote}:{owner}/{name}"),
+            "github:fioncat/roxide"
+        );
+        assert_eq!(repo.render("{name}-{path}"), "roxide-{path}");
+        assert_eq!(repo.render("plain"), "plain");
+    }
 }
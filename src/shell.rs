@@ -1,17 +1,26 @@
-use std::io::{ErrorKind, Read, Write};
-use std::mem;
+use std::fs;
+use std::io::{self, ErrorKind, Read, Write};
+#[cfg(unix)]
+use std::os::unix::process::CommandExt;
 use std::path::PathBuf;
-use std::process::{ChildStdout, Command, Stdio};
+use std::process::{Command, Stdio};
 use std::rc::Rc;
+use std::sync::mpsc;
+use std::thread;
+use std::time::Instant;
 
 use anyhow::{bail, Context, Result};
 use chrono::offset::Local;
 use console::{style, StyledObject};
 use regex::{Captures, Regex};
+use serde::Serialize;
 
+use crate::api::auth;
 use crate::api::types::Provider;
-use crate::config::types::{Remote, WorkflowStep};
+use crate::config;
+use crate::config::types::{self, Remote, WorkflowStep};
 use crate::errors::SilentExit;
+use crate::repo::database::Database;
 use crate::repo::types::Repo;
 use crate::utils;
 use crate::{confirm, exec, info};
@@ -19,7 +28,6 @@ use crate::{confirm, exec, info};
 pub struct Shell {
     cmd: Command,
     desc: Option<String>,
-    input: Option<String>,
 
     mute: bool,
 }
@@ -27,7 +35,9 @@ pub struct Shell {
 pub struct ShellResult {
     pub code: Option<i32>,
 
-    pub stdout: ChildStdout,
+    /// The stdout of the command, it is buffered if the command ran with a
+    /// timeout.
+    pub stdout: Box<dyn Read>,
 }
 
 impl ShellResult {
@@ -67,10 +77,6 @@ impl ShellResult {
 }
 
 impl Shell {
-    pub fn new(program: &str) -> Shell {
-        Self::with_args(program, &[])
-    }
-
     pub fn with_args(program: &str, args: &[&str]) -> Shell {
         let mut cmd = Command::new(program);
         if !args.is_empty() {
@@ -86,7 +92,6 @@ impl Shell {
         Shell {
             cmd,
             desc: None,
-            input: None,
             mute: false,
         }
     }
@@ -96,10 +101,10 @@ impl Shell {
     }
 
     pub fn sh(script: &str) -> Shell {
-        // FIXME: We add `> /dev/stderr` at the end of the script to ensure that
-        // the script does not output any content to stdout. This method is not
-        // applicable to Windows and a more universal method is needed.
-        let script = format!("{script} > /dev/stderr");
+        // Redirect the stdout to stderr to ensure that the script does not
+        // output any content to stdout. Unlike `/dev/stderr`, this also works
+        // for the `sh` shipped with Git for Windows.
+        let script = format!("{{ {script}\n}} 1>&2");
         Self::with_args("sh", &["-c", script.as_str()])
     }
 
@@ -108,14 +113,15 @@ impl Shell {
         self
     }
 
-    pub fn with_input(&mut self, input: String) -> &mut Self {
-        self.input = Some(input);
-        self.cmd.stdin(Stdio::piped());
+    pub fn with_path(&mut self, path: &PathBuf) -> &mut Self {
+        self.cmd.current_dir(path);
         self
     }
 
-    pub fn with_path(&mut self, path: &PathBuf) -> &mut Self {
-        self.cmd.current_dir(path);
+    /// Do not inherit stdin, for the commands which read stdin when it is not
+    /// a terminal, such as ripgrep without path.
+    pub fn without_stdin(&mut self) -> &mut Self {
+        self.cmd.stdin(Stdio::null());
         self
     }
 
@@ -128,6 +134,56 @@ impl Shell {
         self
     }
 
+    /// Export the remote's git related environments to the command, such as
+    /// `GIT_SSH_COMMAND` and proxies.
+    pub fn with_remote(&mut self, remote: &Remote) -> &mut Self {
+        if let Some(ssh_command) = &remote.ssh_command {
+            self.cmd.env("GIT_SSH_COMMAND", ssh_command);
+        }
+        if let Some(http_proxy) = &remote.http_proxy {
+            self.cmd.env("http_proxy", http_proxy);
+            self.cmd.env("https_proxy", http_proxy);
+        }
+        if let Some(socks5_proxy) = &remote.socks5_proxy {
+            self.cmd.env("ALL_PROXY", socks5_proxy);
+        }
+        if let Some(no_proxy) = &remote.no_proxy {
+            self.cmd.env("no_proxy", no_proxy);
+        }
+        self
+    }
+
+    /// Let git use the remote's token to authenticate https requests. The token
+    /// is passed to the askpass shim by environment, see [`ensure_askpass`].
+    pub fn with_https_auth(&mut self, remote: &Remote) -> Result<&mut Self> {
+        let token = match &remote.token {
+            Some(token) => token.clone(),
+            None => match auth::load(&remote.name)? {
+                Some(token) => token,
+                None => bail!(
+                    "Remote {} enables https_auth, but its token is not provided",
+                    remote.name
+                ),
+            },
+        };
+        let askpass = ensure_askpass()?;
+        // The `user` of remote is the commit author name, which is not a
+        // valid login for token authentication.
+        let user = match &remote.https_user {
+            Some(user) => user.as_str(),
+            None => match remote.provider {
+                Some(types::Provider::Github) => "x-access-token",
+                _ => "oauth2",
+            },
+        };
+
+        self.cmd.env("GIT_ASKPASS", &askpass);
+        self.cmd.env("GIT_TERMINAL_PROMPT", "0");
+        self.cmd.env("ROXIDE_GIT_USER", user);
+        self.cmd.env("ROXIDE_GIT_TOKEN", token);
+        Ok(self)
+    }
+
     pub fn set_mute(&mut self, mute: bool) -> &mut Self {
         self.mute = mute;
         self
@@ -136,6 +192,14 @@ impl Shell {
     pub fn execute(&mut self) -> Result<ShellResult> {
         self.show_desc();
 
+        let deadline = utils::task_deadline();
+        #[cfg(unix)]
+        if deadline.is_some() {
+            // Run the command in its own process group, so that its children
+            // (such as ssh spawned by git) can be killed together on timeout.
+            self.cmd.process_group(0);
+        }
+
         let mut child = match self.cmd.spawn() {
             Ok(child) => child,
             Err(e) if e.kind() == ErrorKind::NotFound => {
@@ -154,21 +218,56 @@ impl Shell {
             }
         };
 
-        if let Some(input) = &self.input {
-            let handle = child.stdin.as_mut().unwrap();
-            if let Err(err) = write!(handle, "{}", input) {
-                return Err(err).context("Write content to command");
+        let stdout = child.stdout.take().unwrap();
+        let deadline = match deadline {
+            Some(deadline) => deadline,
+            None => {
+                let status = child.wait().context("Wait command done")?;
+                return Ok(ShellResult {
+                    code: status.code(),
+                    stdout: Box::new(stdout),
+                });
             }
+        };
 
-            mem::drop(child.stdin.take());
-        }
+        // Running in a batch task with timeout, kill the command if it exceeds
+        // the deadline, so that the task won't hang forever. The stdout is
+        // drained concurrently, otherwise the command would block on writing
+        // when the pipe is full.
+        let reader = thread::spawn(move || -> io::Result<Vec<u8>> {
+            let mut stdout = stdout;
+            let mut output = Vec::new();
+            stdout.read_to_end(&mut output)?;
+            Ok(output)
+        });
+        let pid = child.id();
+        let (sender, receiver) = mpsc::channel();
+        thread::spawn(move || {
+            let _ = sender.send(child.wait());
+        });
 
-        let stdout = child.stdout.take().unwrap();
-        let status = child.wait().context("Wait command done")?;
+        let timeout = deadline.saturating_duration_since(Instant::now());
+        let status = match receiver.recv_timeout(timeout) {
+            Ok(status) => status.context("Wait command done")?,
+            Err(_) => {
+                kill_group(pid);
+                // Wait for the waiter to reap the killed command.
+                let _ = receiver.recv();
+                let _ = reader.join();
+                bail!(
+                    "Command `{}` timed out",
+                    self.cmd.get_program().to_str().unwrap()
+                );
+            }
+        };
+        let output = match reader.join() {
+            Ok(output) => output.context("Read stdout from command")?,
+            Err(_) => bail!("Read stdout from command panicked"),
+        };
 
         Ok(ShellResult {
             code: status.code(),
-            stdout,
+            stdout: Box::new(io::Cursor::new(output)),
         })
     }
 
@@ -192,27 +291,96 @@ impl Shell {
     }
 }
 
+/// Kill the process group of the command spawned with timeout, see
+/// [`Shell::execute`].
+#[cfg(unix)]
+fn kill_group(pid: u32) {
+    unsafe {
+        libc::kill(-(pid as libc::pid_t), libc::SIGKILL);
+    }
+}
+
+/// Kill the process tree of the command spawned with timeout, Windows has no
+/// process groups like unix.
+#[cfg(windows)]
+fn kill_group(pid: u32) {
+    let pid = format!("{pid}");
+    let _ = Command::new("taskkill")
+        .args(["/F", "/T", "/PID", pid.as_str()])
+        .stdout(Stdio::null())
+        .stderr(Stdio::null())
+        .status();
+}
+
 pub fn search<S>(keys: &Vec<S>) -> Result<usize>
 where
     S: AsRef<str>,
 {
-    let mut input = String::with_capacity(keys.len());
-    for key in keys {
-        input.push_str(key.as_ref());
-        input.push_str("\n");
+    search_iter(keys.iter())
+}
+
+/// Search items with fzf and return the index of the selected one. The items
+/// are streamed to fzf lazily, so fzf shows up immediately, and the startup
+/// latency does not scale with the number of items. Each line is prefixed
+/// with its index (hidden by fzf), so the items need not to be kept.
+pub fn search_iter<I, S>(items: I) -> Result<usize>
+where
+    I: Iterator<Item = S>,
+    S: AsRef<str>,
+{
+    let idxs = fzf(items, false)?;
+    Ok(idxs[0])
+}
+
+/// Search items with fzf and return the indexes of the selected ones, use
+/// `TAB` to select multiple items.
+pub fn search_multi<S>(keys: &Vec<S>) -> Result<Vec<usize>>
+where
+    S: AsRef<str>,
+{
+    fzf(keys.iter(), true)
+}
+
+fn fzf<I, S>(items: I, multi: bool) -> Result<Vec<usize>>
+where
+    I: Iterator<Item = S>,
+    S: AsRef<str>,
+{
+    if utils::is_non_interactive() {
+        bail!("Could not search in non-interactive mode, please provide the exact query");
     }
 
-    let mut fzf = Shell::new("fzf");
-    fzf.set_mute(true).with_input(input);
+    let mut args = vec!["--delimiter", "\t", "--with-nth", "2.."];
+    if multi {
+        args.push("--multi");
+    }
+    let mut fzf = Command::new("fzf")
+        .args(args)
+        .stdin(Stdio::piped())
+        .stdout(Stdio::piped())
+        .stderr(Stdio::inherit())
+        .spawn()
+        .context("Launch fzf, please make sure it is installed")?;
+
+    let mut stdin = fzf.stdin.take().unwrap();
+    let mut count = 0;
+    for (idx, item) in items.enumerate() {
+        count += 1;
+        let line = format!("{idx}\t{}\n", item.as_ref());
+        match stdin.write_all(line.as_bytes()) {
+            Ok(_) => {}
+            // The user has already selected an item, fzf exited.
+            Err(err) if err.kind() == ErrorKind::BrokenPipe => break,
+            Err(err) => return Err(err).context("Write items to fzf"),
+        }
+    }
+    drop(stdin);
 
-    let mut result = fzf.execute()?;
-    match result.code {
+    let output = fzf.wait_with_output().context("Wait fzf done")?;
+    match output.status.code() {
         Some(0) => {
-            let output = result.read()?;
-            match keys.iter().position(|s| s.as_ref() == output) {
-                Some(idx) => Ok(idx),
-                None => bail!("could not find key {}", output),
-            }
+            let output = String::from_utf8(output.stdout).context("Decode fzf output")?;
+            parse_fzf_output(&output, count)
         }
         Some(1) => bail!("fzf no match found"),
         Some(2) => bail!("fzf returned an error"),
@@ -222,8 +390,140 @@ where
     }
 }
 
+/// Parse the selected items printed by fzf, each line is `{idx}\t{item}`,
+/// return the indexes of them. The `count` is the number of items passed to
+/// fzf, the indexes out of range are rejected.
+fn parse_fzf_output(output: &str, count: usize) -> Result<Vec<usize>> {
+    let mut idxs = Vec::new();
+    for line in output.lines() {
+        if line.trim().is_empty() {
+            continue;
+        }
+        let idx = line.split('\t').next().unwrap_or_default();
+        match idx.trim().parse::<usize>() {
+            Ok(idx) if idx < count => idxs.push(idx),
+            _ => bail!("could not parse fzf output {}", line.trim()),
+        }
+    }
+    if idxs.is_empty() {
+        bail!("could not parse fzf output {}", output.trim());
+    }
+    Ok(idxs)
+}
+
+const ASKPASS_SCRIPT: &str = r#"#!/bin/sh
+case "$1" in
+Username*) echo "${ROXIDE_GIT_USER}" ;;
+*) echo "${ROXIDE_GIT_TOKEN}" ;;
+esac
+"#;
+
+/// Write the askpass shim to `{metadir}/askpass.sh` and return its path. The
+/// shim only reads credentials from environment, so it is safe to keep it on
+/// disk. It is only written when the content differs, and replaced by
+/// renaming, since the git processes of other batch workers might be running
+/// it.
+pub fn ensure_askpass() -> Result<PathBuf> {
+    let path = PathBuf::from(&config::base().metadir).join("askpass.sh");
+    if let Ok(content) = fs::read(&path) {
+        if content == ASKPASS_SCRIPT.as_bytes() {
+            return Ok(path);
+        }
+    }
+    utils::write_file_atomic(&path, ASKPASS_SCRIPT.as_bytes(), Some(0o700))?;
+    Ok(path)
+}
+
+/// The uncommitted and unpushed work of a repo.
+pub struct DirtyInfo {
+    /// The uncommitted changes, in `git status --porcelain` format.
+    pub changes: Vec<String>,
+    /// The number of commits on local branches that are not on any remote
+    /// branch.
+    pub unpushed: usize,
+}
+
+impl DirtyInfo {
+    pub fn is_clean(&self) -> bool {
+        self.changes.is_empty() && self.unpushed == 0
+    }
+}
+
+/// Scan the uncommitted changes and unpushed commits of the repo in `dir`.
+/// Return None if the repo could not be scanned, such as not cloned yet.
+pub fn scan_dirty(dir: &PathBuf) -> Option<DirtyInfo> {
+    if !dir.exists() {
+        return None;
+    }
+    let path = format!("{}", dir.display());
+    let changes = Shell::git(&["-C", path.as_str(), "status", "--porcelain"])
+        .set_mute(true)
+        .execute()
+        .ok()?
+        .checked_lines()
+        .ok()?;
+    let unpushed = Shell::git(&[
+        "-C",
+        path.as_str(),
+        "rev-list",
+        "--count",
+        "--branches",
+        "--not",
+        "--remotes",
+    ])
+    .set_mute(true)
+    .execute()
+    .ok()?
+    .checked_read()
+    .ok()?
+    .parse()
+    .ok()?;
+    Some(DirtyInfo { changes, unpushed })
+}
+
+/// List the files with unresolved conflicts, such as after a failed rebase or
+/// merge.
+pub fn conflict_files() -> Result<Vec<String>> {
+    Shell::git(&["diff", "--name-only", "--diff-filter=U"])
+        .set_mute(true)
+        .execute()?
+        .checked_lines()
+}
+
+/// Find the branches both ahead and behind their upstreams in the repo,
+/// return the name, upstream, ahead and behind counts.
+pub fn scan_diverged(dir: &PathBuf) -> Result<Vec<(String, String, usize, usize)>> {
+    let path = format!("{}", dir.display());
+    let lines = Shell::git(&[
+        "-C",
+        path.as_str(),
+        "for-each-ref",
+        "--format=%(refname:short)\t%(upstream:short)\t%(upstream:trackshort)",
+        "refs/heads",
+    ])
+    .set_mute(true)
+    .execute()?
+    .checked_lines()?;
+
+    let mut branches = Vec::new();
+    for line in lines {
+        let mut fields = line.split('\t');
+        let branch = fields.next().unwrap_or_default();
+        let upstream = fields.next().unwrap_or_default();
+        let track = fields.next().unwrap_or_default();
+        if upstream.is_empty() {
+            continue;
+        }
+        if let BranchStatus::Conflict = parse_trackshort(track) {
+            let (ahead, behind) = count_commits(Some(path.as_str()), branch, upstream)?;
+            branches.push((branch.to_string(), upstream.to_string(), ahead, behind));
+        }
+    }
+    Ok(branches)
+}
+
 pub fn ensure_no_uncommitted() -> Result<()> {
-    let mut git = Shell::git(&["status", "-s"]);
+    let mut git = Shell::git(&["status", "--porcelain"]);
     let lines = git.execute()?.checked_lines()?;
     if !lines.is_empty() {
         let (word, call) = if lines.len() == 1 {
@@ -254,12 +554,23 @@ pub enum BranchStatus {
 impl BranchStatus {
     pub fn display(&self) -> StyledObject<&'static str> {
         match self {
-            Self::Sync => style("sync").green(),
-            Self::Gone => style("gone").red(),
-            Self::Ahead => style("ahead").yellow(),
-            Self::Behind => style("behind").yellow(),
-            Self::Conflict => style("conflict").yellow().bold(),
-            Self::Detached => style("detached").red(),
+            Self::Sync => style(self.as_str()).green(),
+            Self::Gone => style(self.as_str()).red(),
+            Self::Ahead => style(self.as_str()).yellow(),
+            Self::Behind => style(self.as_str()).yellow(),
+            Self::Conflict => style(self.as_str()).yellow().bold(),
+            Self::Detached => style(self.as_str()).red(),
+        }
+    }
+
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            Self::Sync => "sync",
+            Self::Gone => "gone",
+            Self::Ahead => "ahead",
+            Self::Behind => "behind",
+            Self::Conflict => "conflict",
+            Self::Detached => "detached",
         }
     }
 }
@@ -269,22 +580,68 @@ pub struct GitBranch {
     pub name: String,
     pub status: BranchStatus,
 
+    pub commit: String,
+    /// The upstream branch, such as `origin/main`.
+    pub upstream: Option<String>,
+
+    /// The number of commits ahead and behind the upstream.
+    pub ahead: usize,
+    pub behind: usize,
+
     pub current: bool,
 }
 
 impl GitBranch {
-    const BRANCH_REGEX: &str = r"^(\*)*[ ]*([^ ]*)[ ]*([^ ]*)[ ]*(\[[^\]]*\])*[ ]*(.*)$";
     const HEAD_BRANCH_PREFIX: &str = "HEAD branch:";
 
+    /// The format of `git for-each-ref`, the fields are separated by tab:
+    /// name, current mark (`*`), short commit id, upstream and short track
+    /// (see [`parse_trackshort`]). Unlike `%(upstream:track)` and `git branch
+    /// -vv`, the short track is not translated by git, so it can be parsed in
+    /// any locale. The commit counts are got by `git rev-list`. The trailing
+    /// empty fields might be trimmed.
+    const BRANCH_FORMAT: &str = "--format=%(refname:short)\t%(HEAD)\t%(objectname:short)\t%(upstream:short)\t%(upstream:trackshort)";
+
     pub fn list() -> Result<Vec<GitBranch>> {
-        let re = Regex::new(Self::BRANCH_REGEX).expect("parse git branch regex");
-        let lines = Shell::git(&["branch", "-vv"])
+        let lines = Shell::git(&["for-each-ref", Self::BRANCH_FORMAT, "refs/heads"])
             .with_desc("List git branch info")
             .execute()?
             .checked_lines()?;
+        Self::parse_lines(lines, None)
+    }
+
+    /// The same as [`GitBranch::list`], but list the branches of the repo in
+    /// `dir` silently, so it can be used in batch.
+    pub fn list_in(dir: &PathBuf) -> Result<Vec<GitBranch>> {
+        let path = format!("{}", dir.display());
+        let lines = Shell::git(&[
+            "-C",
+            path.as_str(),
+            "for-each-ref",
+            Self::BRANCH_FORMAT,
+            "refs/heads",
+        ])
+        .set_mute(true)
+        .execute()?
+        .checked_lines()?;
+        Self::parse_lines(lines, Some(path.as_str()))
+    }
+
+    /// Parse the branches, and count the commits ahead and behind upstream of
+    /// the branches not in sync. The `path` is the repo to run git in, None
+    /// means the current directory.
+    fn parse_lines(lines: Vec<String>, path: Option<&str>) -> Result<Vec<GitBranch>> {
         let mut branches: Vec<GitBranch> = Vec::with_capacity(lines.len());
         for line in lines {
-            let branch = Self::parse(&re, line)?;
+            let mut branch = Self::parse(line)?;
+            if let BranchStatus::Ahead | BranchStatus::Behind | BranchStatus::Conflict =
+                branch.status
+            {
+                let upstream = branch.upstream.as_deref().unwrap_or_default();
+                let (ahead, behind) = count_commits(path, &branch.name, upstream)?;
+                branch.ahead = ahead;
+                branch.behind = behind;
+            }
             branches.push(branch);
         }
 
@@ -295,6 +652,43 @@ impl GitBranch {
         Self::default_by_remote("origin")
     }
 
+    /// Get the default branch of origin, use the one cached in database if
+    /// possible. If not cached, the result will be saved to database, the
+    /// caller should close the database.
+    pub fn default_cached(db: &mut Database) -> Result<String> {
+        let repo = match db.current() {
+            Some(repo) => repo,
+            None => return Self::default(),
+        };
+        if let Some(branch) = &repo.default_branch {
+            return Ok(branch.clone());
+        }
+        let branch = Self::default()?;
+        db.set_default_branch(&repo, branch.clone());
+        Ok(branch)
+    }
+
+    /// Same as `default_cached`, but open the database by itself. The database
+    /// is opened in read-only mode, and only locked and saved when the default
+    /// branch is not cached. Do not call this when the database is already
+    /// opened, the lock is not reentrant.
+    pub fn default_fast() -> Result<String> {
+        let db = Database::read_only()?;
+        let repo = match db.current() {
+            Some(repo) => repo,
+            None => return Self::default(),
+        };
+        if let Some(branch) = &repo.default_branch {
+            return Ok(branch.clone());
+        }
+
+        let branch = Self::default()?;
+        let mut db = Database::read()?;
+        db.set_default_branch(&repo, branch.clone());
+        db.close()?;
+        Ok(branch)
+    }
+
     pub fn default_by_remote(remote: &str) -> Result<String> {
         info!("Get default branch for {}", remote);
         let head_ref = format!("refs/remotes/{}/HEAD", remote);
@@ -334,63 +728,157 @@ impl GitBranch {
             .checked_read()
     }
 
-    fn parse(re: &Regex, line: impl AsRef<str>) -> Result<GitBranch> {
-        let parse_err = format!(
-            "invalid branch description {}, please check your git command",
-            style(line.as_ref()).yellow()
+    /// Get the current branch, fail with guidance if HEAD is detached (such as
+    /// after checking out a tag by `roxide tag`).
+    pub fn must_current() -> Result<String> {
+        let branch = Self::current()?;
+        if branch.is_empty() {
+            bail!(
+                "HEAD is detached at {}, please switch to a branch, or create one by `roxide branch -c <name>`",
+                Self::detached_head()?
+            );
+        }
+        Ok(branch)
+    }
+
+    /// Same as [`GitBranch::must_current`], but offer to create a branch from
+    /// the detached HEAD, so that the commits won't be lost.
+    pub fn current_or_create() -> Result<String> {
+        let branch = Self::current()?;
+        if !branch.is_empty() {
+            return Ok(branch);
+        }
+        if utils::is_non_interactive() {
+            return Self::must_current();
+        }
+        let head = Self::detached_head()?;
+        confirm!(
+            "HEAD is detached at {}, do you want to create a branch from it",
+            style(&head).yellow()
         );
-        let mut iter = re.captures_iter(line.as_ref());
-        let caps = match iter.next() {
-            Some(caps) => caps,
-            None => bail!(parse_err),
-        };
-        // We have 6 captures:
-        //   0 -> line itself
-        //   1 -> current branch
-        //   2 -> branch name
-        //   3 -> commit id
-        //   4 -> remote description
-        //   5 -> commit message
-        if caps.len() != 6 {
-            bail!(parse_err)
-        }
-        let mut current = false;
-        if let Some(_) = caps.get(1) {
-            current = true;
-        }
-
-        let name = match caps.get(2) {
-            Some(name) => name.as_str().trim(),
-            None => bail!("{}: missing name", parse_err),
-        };
+        let name = utils::input("Please input branch name", true, None)?;
+        Shell::git(&["switch", "-c", name.as_str()])
+            .execute()?
+            .check()?;
+        Ok(name)
+    }
 
-        let status = match caps.get(4) {
-            Some(remote_desc) => {
-                let remote_desc = remote_desc.as_str();
-                let behind = remote_desc.contains("behind");
-                let ahead = remote_desc.contains("ahead");
-
-                if remote_desc.contains("gone") {
-                    BranchStatus::Gone
-                } else if ahead && behind {
-                    BranchStatus::Conflict
-                } else if ahead {
-                    BranchStatus::Ahead
-                } else if behind {
-                    BranchStatus::Behind
-                } else {
-                    BranchStatus::Sync
-                }
+    /// Describe the detached HEAD, use the tag name if HEAD is at a tag, else
+    /// the short commit id.
+    fn detached_head() -> Result<String> {
+        let tag = Shell::git(&["describe", "--tags", "--exact-match", "HEAD"])
+            .set_mute(true)
+            .execute()?
+            .checked_read();
+        if let Ok(tag) = tag {
+            if !tag.is_empty() {
+                return Ok(format!("tag {tag}"));
             }
+        }
+        Shell::git(&["rev-parse", "--short", "HEAD"])
+            .set_mute(true)
+            .execute()?
+            .checked_read()
+    }
+
+    fn parse(line: impl AsRef<str>) -> Result<GitBranch> {
+        let line = line.as_ref();
+        let mut fields = line.split('\t').map(|field| field.trim());
+        let name = fields.next().unwrap_or_default();
+        if name.is_empty() {
+            bail!(
+                "invalid branch description {}: missing name, please check your git command",
+                style(line).yellow()
+            );
+        }
+        let current = fields.next() == Some("*");
+        let commit = fields.next().unwrap_or_default().to_string();
+        let upstream = match fields.next().unwrap_or_default() {
+            "" => None,
+            upstream => Some(upstream.to_string()),
+        };
+        let status = match upstream {
+            Some(_) => parse_trackshort(fields.next().unwrap_or_default()),
             None => BranchStatus::Detached,
         };
 
         Ok(GitBranch {
             name: name.to_string(),
             status,
+            commit,
+            upstream,
+            ahead: 0,
+            behind: 0,
             current,
         })
     }
+
+    /// The status with commit counts, such as `ahead ↑3` or
+    /// `conflict ↑1 ↓2`.
+    pub fn status_desc(&self) -> String {
+        let counts = self.counts_desc();
+        if counts.is_empty() {
+            return self.status.as_str().to_string();
+        }
+        format!("{} {counts}", self.status.as_str())
+    }
+
+    /// The commit counts ahead and behind upstream, such as `↑1 ↓2`, empty if
+    /// in sync.
+    pub fn counts_desc(&self) -> String {
+        let mut items = Vec::with_capacity(2);
+        if self.ahead > 0 {
+            items.push(format!("↑{}", self.ahead));
+        }
+        if self.behind > 0 {
+            items.push(format!("↓{}", self.behind));
+        }
+        items.join(" ")
+    }
+}
+
+/// Parse the `%(upstream:trackshort)` of `git for-each-ref` for the branch
+/// with upstream: `>` ahead, `<` behind, `<>` diverged and `=` in sync. It is
+/// empty if the upstream is gone.
+pub fn parse_trackshort(track: &str) -> BranchStatus {
+    match track.trim() {
+        "" => BranchStatus::Gone,
+        "<>" => BranchStatus::Conflict,
+        ">" => BranchStatus::Ahead,
+        "<" => BranchStatus::Behind,
+        _ => BranchStatus::Sync,
+    }
+}
+
+/// Count the commits of the branch ahead and behind its upstream. The `path`
+/// is the repo to run git in, None means the current directory.
+fn count_commits(path: Option<&str>, branch: &str, upstream: &str) -> Result<(usize, usize)> {
+    let range = format!("refs/heads/{branch}...{upstream}");
+    let mut args = Vec::with_capacity(6);
+    if let Some(path) = path {
+        args.extend(["-C", path]);
+    }
+    args.extend(["rev-list", "--count", "--left-right", range.as_str()]);
+    let output = Shell::git(&args).set_mute(true).execute()?.checked_read()?;
+    parse_counts(&output)
+}
+
+/// Parse the output of `git rev-list --count --left-right`, such as `1\t2`,
+/// return the commit counts ahead and behind.
+pub fn parse_counts(output: &str) -> Result<(usize, usize)> {
+    let (ahead, behind) = match output.trim().split_once('\t') {
+        Some(counts) => counts,
+        None => bail!("invalid rev-list counts {}", style(output).yellow()),
+    };
+    let ahead = ahead
+        .trim()
+        .parse()
+        .with_context(|| format!("invalid ahead count {}", style(ahead).yellow()))?;
+    let behind = behind
+        .trim()
+        .parse()
+        .with_context(|| format!("invalid behind count {}", style(behind).yellow()))?;
+    Ok((ahead, behind))
 }
 
 pub struct GitRemote(String);
@@ -459,8 +947,9 @@ impl GitRemote {
         Ok(target)
     }
 
-    pub fn commits_between(&self, branch: Option<&str>) -> Result<Vec<String>> {
-        let target = self.target(branch)?;
+    /// List the commits of current branch ahead of the target, which is
+    /// returned by [`GitRemote::target`].
+    pub fn commits_between(target: &str) -> Result<Vec<String>> {
         let compare = format!("HEAD...{}", target);
         let lines = Shell::git(&[
             "log",
@@ -494,19 +983,71 @@ impl GitRemote {
             .collect();
         Ok(commits)
     }
+
+    /// The same as [`GitRemote::commits_between`], but return the full
+    /// messages (subject and body) of the commits, the newest one comes
+    /// first.
+    pub fn messages_between(target: &str) -> Result<Vec<String>> {
+        let compare = format!("HEAD...{}", target);
+        let output = Shell::git(&[
+            "log",
+            "--left-right",
+            "--cherry-pick",
+            "-z",
+            "--format=%m%B",
+            compare.as_str(),
+        ])
+        .with_desc(format!("Get commit messages between {target}"))
+        .execute()?
+        .checked_read()?;
+        Ok(parse_messages(&output))
+    }
+}
+
+/// Parse the output of `git log --left-right -z --format=%m%B`, the commits
+/// are separated by NUL, and each starts with the side mark. Only the commits
+/// of the left side (current branch) are returned.
+pub fn parse_messages(output: &str) -> Vec<String> {
+    output
+        .split('\0')
+        .filter_map(|commit| commit.trim_start_matches('\n').strip_prefix('<'))
+        .map(|message| message.trim().to_string())
+        .collect()
 }
 
 pub struct GitTag(String);
 
+/// The tag with its commit info.
+#[derive(Debug, Serialize)]
+pub struct GitTagInfo {
+    pub name: String,
+    pub commit: String,
+    /// The unix timestamp when the tag (or the commit for lightweight tag)
+    /// was created.
+    pub time: u64,
+    pub subject: String,
+}
+
 impl std::fmt::Display for GitTag {
     fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
         write!(f, "{}", self.0)
     }
 }
 
+struct SemVer {
+    prefix: String,
+    major: u64,
+    minor: u64,
+    patch: u64,
+    pre: Option<String>,
+    build: Option<String>,
+}
+
 impl GitTag {
     const NUM_REGEX: &str = r"\d+";
-    const PLACEHOLDER_REGEX: &str = r"\{(\d+|%[yYmMdD])(\+)*}";
+    const PLACEHOLDER_REGEX: &str = r"\{(\d+|major|minor|patch|pre|build|v|seq|%[yYmMdD])(\+)*}";
+    const SEMVER_REGEX: &str =
+        r"^(v)?(\d+)\.(\d+)\.(\d+)(?:-([0-9A-Za-z.-]+))?(?:\+([0-9A-Za-z.-]+))?$";
 
     pub fn as_str(&self) -> &str {
         self.0.as_str()
@@ -524,6 +1065,45 @@ impl GitTag {
         Ok(tags)
     }
 
+    /// List tags with their commit info, the newest tag comes first.
+    pub fn list_info() -> Result<Vec<GitTagInfo>> {
+        // The `*objectname` is the commit that an annotated tag points to, it
+        // is empty for lightweight tag.
+        let lines = Shell::git(&[
+            "for-each-ref",
+            "refs/tags",
+            "--sort=-creatordate",
+            "--format=%(refname:short)%09%(objectname:short)%09%(*objectname:short)%09%(creatordate:unix)%09%(subject)",
+        ])
+        .with_desc("Get git tags info")
+        .execute()?
+        .checked_lines()?;
+        let mut tags = Vec::with_capacity(lines.len());
+        for line in lines {
+            // The output is trimmed, so the empty subject of the last tag
+            // might be lost.
+            let fields: Vec<&str> = line.splitn(5, "\t").collect();
+            if fields.len() < 4 {
+                bail!(
+                    "invalid tag description {}, please check your git command",
+                    style(&line).yellow()
+                );
+            }
+            let commit = if fields[2].is_empty() {
+                fields[1]
+            } else {
+                fields[2]
+            };
+            tags.push(GitTagInfo {
+                name: fields[0].to_string(),
+                commit: commit.to_string(),
+                time: fields[3].parse().unwrap_or(0),
+                subject: fields.get(4).unwrap_or(&"").to_string(),
+            });
+        }
+        Ok(tags)
+    }
+
     pub fn latest() -> Result<GitTag> {
         Shell::git(&["fetch", "origin", "--prune-tags"])
             .with_desc("Fetch tags")
@@ -539,12 +1119,25 @@ impl GitTag {
         Ok(GitTag(output))
     }
 
-    pub fn apply_rule(&self, rule: impl AsRef<str>) -> Result<GitTag> {
+    /// Build the rule to bump the semantic version, the `v` prefix of the tag
+    /// will be kept.
+    pub fn bump_rule(level: &str) -> Result<&'static str> {
+        match level {
+            "patch" => Ok("{v}{major}.{minor}.{patch+}"),
+            "minor" => Ok("{v}{major}.{minor+}.0"),
+            "major" => Ok("{v}{major+}.0.0"),
+            _ => bail!("Invalid bump level {level}, should be one of patch, minor or major"),
+        }
+    }
+
+    /// Apply the rule to the tag to generate a new tag. The `tags` is all the
+    /// existing tags, used by `{seq}` placeholder to find the next sequence.
+    pub fn apply_rule(&self, rule: impl AsRef<str>, tags: &[GitTag]) -> Result<GitTag> {
         let num_re = Regex::new(Self::NUM_REGEX).context("unable to parse num regex")?;
         let ph_re = Regex::new(Self::PLACEHOLDER_REGEX)
             .context("unable to parse rule placeholder regex")?;
 
-        let nums: Vec<i32> = num_re
+        let nums: Vec<u64> = num_re
             .captures_iter(self.0.as_str())
             .map(|caps| {
                 caps.get(0)
@@ -555,31 +1148,100 @@ impl GitTag {
                     .expect("unable to parse num caps")
             })
             .collect();
+        let semver = self.parse_semver()?;
 
-        let mut with_date = false;
+        let now = Local::now();
+        let mut with_seq = false;
+        let mut require_semver = false;
         let result = ph_re.replace_all(rule.as_ref(), |caps: &Captures| {
             let rep = caps.get(1).unwrap().as_str();
-            let idx: usize = match rep.parse() {
-                Ok(idx) => idx,
-                Err(_) => {
-                    with_date = true;
-                    return rep.to_string();
+            let plus = caps.get(2).is_some();
+            let inc = |num: u64| if plus { num + 1 } else { num };
+            if rep.starts_with("%") {
+                return now.format(rep).to_string();
+            }
+            if rep == "seq" {
+                // The sequence is generated after all other placeholders are
+                // expanded, keep it here.
+                with_seq = true;
+                return String::from("{seq}");
+            }
+            if let Ok(idx) = rep.parse::<usize>() {
+                let num = if idx >= nums.len() { 0 } else { nums[idx] };
+                return format!("{}", inc(num));
+            }
+
+            let semver = match &semver {
+                Some(semver) => semver,
+                None => {
+                    require_semver = true;
+                    return String::new();
                 }
             };
-            let num = if idx >= nums.len() { 0 } else { nums[idx] };
-            let plus = caps.get(2);
-            match plus {
-                Some(_) => format!("{}", num + 1),
-                None => format!("{}", num),
+            match rep {
+                "major" => format!("{}", inc(semver.major)),
+                "minor" => format!("{}", inc(semver.minor)),
+                "patch" => format!("{}", inc(semver.patch)),
+                "v" => semver.prefix.clone(),
+                // The prerelease and build metadata are preserved with their
+                // separators, if the tag does not have them, expand to empty.
+                "pre" => match &semver.pre {
+                    Some(pre) => format!("-{pre}"),
+                    None => String::new(),
+                },
+                "build" => match &semver.build {
+                    Some(build) => format!("+{build}"),
+                    None => String::new(),
+                },
+                _ => unreachable!(),
             }
         });
-        let mut result = result.to_string();
-        if with_date {
-            let date = Local::now();
-            result = date.format(&result).to_string();
+        if require_semver {
+            bail!(
+                "The tag {} is not a semantic version, could not apply rule {}",
+                style(&self.0).yellow(),
+                style(rule.as_ref()).yellow()
+            );
+        }
+        let result = result.to_string();
+        if !with_seq {
+            return Ok(GitTag(result));
         }
 
-        Ok(GitTag(result))
+        // Find the smallest sequence that is not used by existing tags, such as
+        // "2023.06.18-1", "2023.06.18-2", etc. This is useful for CalVer.
+        let mut seq: u64 = 1;
+        loop {
+            let tag = result.replace("{seq}", &format!("{seq}"));
+            if !tags.iter().any(|existing| existing.as_str() == tag) {
+                return Ok(GitTag(tag));
+            }
+            seq += 1;
+        }
+    }
+
+    fn parse_semver(&self) -> Result<Option<SemVer>> {
+        let re = Regex::new(Self::SEMVER_REGEX).context("unable to parse semver regex")?;
+        let caps = match re.captures(self.0.as_str()) {
+            Some(caps) => caps,
+            None => return Ok(None),
+        };
+        let get_num = |idx: usize| -> Result<u64> {
+            let num = caps.get(idx).unwrap().as_str();
+            num.parse()
+                .with_context(|| format!("Parse semver number {num}"))
+        };
+        Ok(Some(SemVer {
+            prefix: match caps.get(1) {
+                Some(prefix) => prefix.as_str().to_string(),
+                None => String::new(),
+            },
+            major: get_num(2)?,
+            minor: get_num(3)?,
+            patch: get_num(4)?,
+            pre: caps.get(5).map(|pre| pre.as_str().to_string()),
+            build: caps.get(6).map(|build| build.as_str().to_string()),
+        }))
     }
 }
 
@@ -615,3 +1277,149 @@ pub fn execute_workflow(steps: &Vec<WorkflowStep>, repo: &Rc<Repo>) -> Result<()
     }
     Ok(())
 }
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse_trackshort() {
+        let cases = [
+            ("", "gone"),
+            ("=", "sync"),
+            (">", "ahead"),
+            ("<", "behind"),
+            ("<>", "conflict"),
+            (" <> ", "conflict"),
+        ];
+        for (track, expect) in cases {
+            assert_eq!(parse_trackshort(track).as_str(), expect, "track {track:?}");
+        }
+    }
+
+    #[test]
+    fn test_parse_fzf_output() {
+        assert_eq!(parse_fzf_output("2\tfioncat/roxide\n", 3).unwrap(), vec![2]);
+        assert_eq!(
+            parse_fzf_output("0\ta  1KB\n2\tc\t2KB\n", 3).unwrap(),
+            vec![0, 2]
+        );
+        assert_eq!(parse_fzf_output("1\tb\n\n", 3).unwrap(), vec![1]);
+
+        assert!(parse_fzf_output("", 3).is_err());
+        assert!(parse_fzf_output("\n", 3).is_err());
+        assert!(parse_fzf_output("3\td\n", 3).is_err());
+        assert!(parse_fzf_output("x\ta\n", 3).is_err());
+        assert!(parse_fzf_output("0\ta\ny\tb\n", 3).is_err());
+    }
+
+    #[test]
+    fn test_parse_counts() {
+        assert_eq!(parse_counts("0\t0").unwrap(), (0, 0));
+        assert_eq!(parse_counts("3\t0\n").unwrap(), (3, 0));
+        assert_eq!(parse_counts("1\t12").unwrap(), (1, 12));
+
+        assert!(parse_counts("").is_err());
+        assert!(parse_counts("3").is_err());
+        assert!(parse_counts("a\t1").is_err());
+        assert!(parse_counts("1\t-1").is_err());
+    }
+
+    #[test]
+    fn test_parse_branch() {
+        let branch = GitBranch::parse("main\t*\tabc1234\torigin/main\t<").unwrap();
+        assert_eq!(branch.name, "main");
+        assert!(branch.current);
+        assert_eq!(branch.commit, "abc1234");
+        assert_eq!(branch.upstream.as_deref(), Some("origin/main"));
+        assert_eq!(branch.status.as_str(), "behind");
+
+        // The trailing empty fields might be trimmed.
+        let branch = GitBranch::parse("feature/x\t \tabc1234\torigin/feature/x").unwrap();
+        assert!(!branch.current);
+        assert_eq!(branch.status.as_str(), "gone");
+
+        let branch = GitBranch::parse("dev\t \tabc1234").unwrap();
+        assert_eq!(branch.upstream, None);
+        assert_eq!(branch.status.as_str(), "detached");
+
+        let branch = GitBranch::parse("dev\t \tabc1234\torigin/dev\t=").unwrap();
+        assert_eq!(branch.status.as_str(), "sync");
+
+        assert!(GitBranch::parse("").is_err());
+    }
+
+    #[test]
+    fn test_list_in() {
+        let root = std::env::temp_dir().join(format!("roxide-test-branch-{}", std::process::id()));
+        let _ = std::fs::remove_dir_all(&root);
+        std::fs::create_dir_all(&root).unwrap();
+        let script = r#"
+            set -e
+            export GIT_AUTHOR_NAME=test GIT_AUTHOR_EMAIL=test@test
+            export GIT_COMMITTER_NAME=test GIT_COMMITTER_EMAIL=test@test
+            git init -q --bare remote.git
+            git clone -q remote.git repo 2>/dev/null
+            cd repo
+            git checkout -q -b main
+            git commit -q --allow-empty -m 1
+            git push -q -u origin main
+            git checkout -q -b ahead
+            git push -q -u origin ahead
+            git commit -q --allow-empty -m 2
+            git commit -q --allow-empty -m 3
+            git checkout -q -b gone
+            git push -q -u origin gone
+            git push -q origin :gone
+            git fetch -q --prune
+            git checkout -q -b diverged origin/main
+            git commit -q --allow-empty -m 4
+            git checkout -q main
+            git commit -q --allow-empty -m 5
+            git push -q
+            git reset -q --hard HEAD~1
+            git checkout -q diverged
+        "#;
+        let status = Command::new("sh")
+            .args(["-c", script])
+            .current_dir(&root)
+            .status()
+            .unwrap();
+        assert!(status.success());
+
+        let branches = GitBranch::list_in(&root.join("repo")).unwrap();
+        let result: Vec<String> = branches
+            .iter()
+            .map(|branch| format!("{} {}", branch.name, branch.status_desc()))
+            .collect();
+        assert_eq!(
+            result,
+            vec![
+                "ahead ahead ↑2",
+                "diverged conflict ↑1 ↓1",
+                "gone gone",
+                "main behind ↓1",
+            ]
+        );
+        assert!(branches[1].current);
+
+        let diverged = scan_diverged(&root.join("repo")).unwrap();
+        assert_eq!(
+            diverged,
+            vec![(String::from("diverged"), String::from("origin/main"), 1, 1)]
+        );
+
+        std::fs::remove_dir_all(&root).unwrap();
+    }
+
+    #[test]
+    fn test_parse_messages() {
+        let output =
+            "<feat: add login\n\nThe body\nof login.\n\0>fix: from target\n\0\n<fix: typo\n";
+        assert_eq!(
+            parse_messages(output),
+            vec!["feat: add login\n\nThe body\nof login.", "fix: typo"]
+        );
+        assert!(parse_messages("").is_empty());
+    }
+}
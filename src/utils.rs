@@ -1,16 +1,22 @@
+use std::cell::Cell;
 use std::collections::HashMap;
 use std::env;
 use std::fs::{self, OpenOptions};
-use std::io::{ErrorKind, Write};
+use std::io::{self, ErrorKind, Write};
 use std::path::PathBuf;
-use std::process;
-use std::time::{Duration, SystemTime};
+use std::process::{self, Command, Stdio};
+use std::sync::atomic::{AtomicBool, AtomicUsize, Ordering};
+use std::sync::Mutex;
+use std::thread;
+use std::time::{Duration, Instant, SystemTime};
 
 use anyhow::{anyhow, bail, Context, Error, Result};
+use base64::Engine;
 use chrono::{Local, LocalResult, TimeZone};
 use console::{self, style};
 use dialoguer::theme::ColorfulTheme;
 use dialoguer::{Editor, Input};
+#[cfg(unix)]
 use file_lock::{FileLock, FileOptions};
 use pad::PadStr;
 
@@ -67,6 +73,20 @@ macro_rules! info {
     };
 }
 
+static NON_INTERACTIVE: AtomicBool = AtomicBool::new(false);
+
+/// Enable the non-interactive mode, all prompts will be skipped, and the
+/// selectors will fail instead of prompting. This is useful when running roxide
+/// in scripts.
+pub fn set_non_interactive() {
+    NON_INTERACTIVE.store(true, Ordering::Relaxed);
+    console::set_colors_enabled(false);
+}
+
+pub fn is_non_interactive() -> bool {
+    NON_INTERACTIVE.load(Ordering::Relaxed)
+}
+
 pub fn show_exec(msg: impl AsRef<str>) {
     let msg = format!("{} {}", style("==>").cyan(), msg.as_ref());
     write_stderr(msg);
@@ -77,6 +97,11 @@ pub fn show_info(msg: impl AsRef<str>) {
     write_stderr(msg);
 }
 
+pub fn show_warn(msg: impl AsRef<str>) {
+    let msg = format!("{} {}", style("==>").yellow(), msg.as_ref());
+    write_stderr(msg);
+}
+
 pub fn write_stderr(msg: String) {
     _ = writeln!(std::io::stderr(), "{}", msg);
 }
@@ -109,6 +134,34 @@ pub fn write_file(path: &PathBuf, data: &[u8]) -> Result<()> {
     Ok(())
 }
 
+/// Write the file through a temp file in the same directory and rename it
+/// over `path`, so that concurrent readers never see a partially written
+/// file, and the processes running the old file are not affected. The `mode`
+/// is set before renaming.
+pub fn write_file_atomic(path: &PathBuf, data: &[u8], mode: Option<u32>) -> Result<()> {
+    static TEMP_SEQ: AtomicUsize = AtomicUsize::new(0);
+    let name = match path.file_name() {
+        Some(name) => name.to_string_lossy(),
+        None => bail!("invalid file path {}", path.display()),
+    };
+    let seq = TEMP_SEQ.fetch_add(1, Ordering::Relaxed);
+    let temp = path.with_file_name(format!(".{name}.{}.{seq}.tmp", process::id()));
+
+    write_file(&temp, data)?;
+    let result = match mode {
+        Some(mode) => set_mode(&temp, mode),
+        None => Ok(()),
+    }
+    .and_then(|_| {
+        fs::rename(&temp, path)
+            .with_context(|| format!("Rename file {} to {}", temp.display(), path.display()))
+    });
+    if result.is_err() {
+        let _ = fs::remove_file(&temp);
+    }
+    result
+}
+
 pub fn current_time() -> Result<Duration> {
     SystemTime::now()
         .duration_since(SystemTime::UNIX_EPOCH)
@@ -119,6 +172,68 @@ pub fn current_dir() -> Result<PathBuf> {
     env::current_dir().context("Get current directory")
 }
 
+/// Get the home directory from env `HOME`, on Windows, `USERPROFILE` is used
+/// if `HOME` is not set.
+pub fn home_dir() -> Result<PathBuf> {
+    if let Some(home) = env::var_os("HOME") {
+        return Ok(PathBuf::from(home));
+    }
+    #[cfg(windows)]
+    if let Some(home) = env::var_os("USERPROFILE") {
+        return Ok(PathBuf::from(home));
+    }
+    bail!("Could not find HOME env")
+}
+
+/// Set the permission mode of the file. Windows has no such mode, the file
+/// is left unchanged.
+#[cfg(unix)]
+pub fn set_mode(path: &PathBuf, mode: u32) -> Result<()> {
+    use std::os::unix::fs::PermissionsExt;
+
+    let perm = fs::Permissions::from_mode(mode);
+    fs::set_permissions(path, perm)
+        .with_context(|| format!("Set permissions for {}", path.display()))
+}
+
+#[cfg(not(unix))]
+pub fn set_mode(_path: &PathBuf, _mode: u32) -> Result<()> {
+    Ok(())
+}
+
+/// Install the handler for SIGINT and SIGTERM, to restore the terminal state
+/// (such as the cursor hidden by prompts) and show a consistent message before
+/// exiting.
+#[cfg(unix)]
+pub fn handle_signals() {
+    extern "C" fn handler(_: libc::c_int) {
+        // Only async-signal-safe functions can be called here, so we write the
+        // raw escape sequence to show the cursor instead of using console.
+        let msg = b"\x1b[?25h\n\x1b[31maborted\x1b[0m\n";
+        unsafe {
+            libc::write(libc::STDERR_FILENO, msg.as_ptr() as *const _, msg.len());
+            libc::_exit(130);
+        }
+    }
+    unsafe {
+        libc::signal(libc::SIGINT, handler as libc::sighandler_t);
+        libc::signal(libc::SIGTERM, handler as libc::sighandler_t);
+    }
+}
+
+/// The console handles Ctrl-C on Windows, nothing to install.
+#[cfg(not(unix))]
+pub fn handle_signals() {}
+
+/// Restore the terminal state and return an error to exit silently. This is
+/// used when the user interrupts a prompt (prompts read Ctrl-C as a key, so the
+/// signal handler won't be triggered).
+pub fn abort() -> Error {
+    _ = console::Term::stderr().show_cursor();
+    write_stderr(format!("\n{}", style("aborted").red()));
+    anyhow!(SilentExit { code: 130 })
+}
+
 pub fn error_exit(err: Error) {
     _ = writeln!(std::io::stderr(), "{}: {err:#}", style("error").red());
     process::exit(2);
@@ -160,27 +275,206 @@ fn parse_query_raw(query: impl AsRef<str>) -> (String, String) {
     (group_buffer.join("/"), base.to_string())
 }
 
+/// The path segments of web pages in a repo, such as `owner/name/tree/main`.
+/// The Gitlab pages are after `/-/`, such as `group/name/-/tree/main`.
+const URL_PAGE_SEGMENTS: [&str; 13] = [
+    "tree", "blob", "raw", "commit", "commits", "compare", "pull", "pulls", "issues", "actions",
+    "releases", "tags", "wiki",
+];
+
+/// Parse a repo url, such as `https://github.com/owner/name`,
+/// `git@github.com:owner/name.git` or `ssh://git@host:2222/owner/name.git`.
+/// The web page urls in the repo are supported too, such as
+/// `https://github.com/owner/name/tree/main`. The host is matched with the
+/// `clone` domain or `ssh_alias` of remotes. Return `None` if the arg is not
+/// an url or no remote matches.
+pub fn parse_url(url: impl AsRef<str>) -> Result<Option<(Remote, String, String)>> {
+    let (host, owner, name) = match parse_url_raw(url.as_ref()) {
+        Some(parsed) => parsed,
+        None => return Ok(None),
+    };
+    for remote_name in config::list_remotes() {
+        let remote = config::must_get_remote(remote_name)?;
+        let domain = remote.clone.as_ref().map(|domain| domain.as_str());
+        let alias = remote.ssh_alias.as_ref().map(|alias| alias.as_str());
+        if domain == Some(host.as_str()) || alias == Some(host.as_str()) {
+            return Ok(Some((remote, owner, name)));
+        }
+    }
+    Ok(None)
+}
+
+/// Parse the url to host, owner and name, see [`parse_url`].
+fn parse_url_raw(url: &str) -> Option<(String, String, String)> {
+    let (host, path) = match url.split_once("://") {
+        Some((_, rest)) => rest.split_once("/")?,
+        None => match url.split_once(":") {
+            Some((host, path)) if host.contains("@") => (host, path),
+            _ => return None,
+        },
+    };
+    // Remove the user and port in host.
+    let host = match host.split_once("@") {
+        Some((_, host)) => host,
+        None => host,
+    };
+    let host = match host.split_once(":") {
+        Some((host, _)) => host,
+        None => host,
+    };
+
+    // Remove the query and fragment of web urls.
+    let path = match path.find(|c| c == '?' || c == '#') {
+        Some(idx) => &path[..idx],
+        None => path,
+    };
+    let path = match path.split_once("/-/") {
+        Some((path, _)) => path,
+        None => path,
+    };
+    let mut segments: Vec<&str> = path.split("/").filter(|seg| !seg.is_empty()).collect();
+    // The owner and name are required before the page segment.
+    if let Some(idx) = segments
+        .iter()
+        .skip(2)
+        .position(|seg| URL_PAGE_SEGMENTS.contains(seg))
+    {
+        segments.truncate(idx + 2);
+    }
+    let path = segments.join("/");
+    let path = path.strip_suffix(".git").unwrap_or(&path);
+    let (owner, name) = parse_query_raw(path);
+    if owner.is_empty() || name.is_empty() {
+        return None;
+    }
+    Some((host.to_string(), owner, name))
+}
+
 pub fn revert_map(map: &HashMap<String, String>) -> HashMap<String, String> {
     map.iter()
         .map(|(key, value)| (value.clone(), key.clone()))
         .collect()
 }
 
-pub fn open_url(url: impl AsRef<str>) -> Result<()> {
-    open::that(url.as_ref()).with_context(|| {
-        format!(
-            "unable to open url {} in default browser",
-            style(url.as_ref()).yellow()
-        )
-    })
+/// Open the url by the `browser` command (see `Remote::browser`). If not
+/// configured, use the default browser, but in SSH sessions, the browser is on
+/// the other side, print the url as a terminal hyperlink (OSC8) instead.
+pub fn open_url(browser: Option<&str>, url: impl AsRef<str>) -> Result<()> {
+    let url = url.as_ref();
+    if let Some(browser) = browser {
+        return open_with_command(browser, url);
+    }
+    if is_ssh_session() {
+        print_hyperlink(url);
+        return Ok(());
+    }
+    open::that(url).with_context(|| format!("Open url {url} in default browser"))
+}
+
+/// The browser command might contain args, such as `firefox --new-tab`, the
+/// url will be appended as the last argument.
+fn open_with_command(browser: &str, url: &str) -> Result<()> {
+    let mut fields = browser.split_whitespace();
+    let program = match fields.next() {
+        Some(program) => program,
+        None => bail!("The browser command is empty, please check your config"),
+    };
+    let status = Command::new(program)
+        .args(fields)
+        .arg(url)
+        .stdin(Stdio::inherit())
+        .stdout(Stdio::inherit())
+        .stderr(Stdio::inherit())
+        .status()
+        .with_context(|| format!("Use browser {browser} to open url {url}"))?;
+    if !status.success() {
+        bail!("Browser {browser} exited with {status}");
+    }
+    Ok(())
+}
+
+/// Print the url as OSC8 hyperlink to stderr, so that it can be clicked in
+/// the terminal. The terminals not supporting OSC8 show the plain url.
+fn print_hyperlink(url: &str) {
+    let link = format!("\x1b]8;;{url}\x1b\\{url}\x1b]8;;\x1b\\");
+    show_info(format!("Open {link}"));
+}
+
+fn is_ssh_session() -> bool {
+    env::var_os("SSH_TTY").is_some() || env::var_os("SSH_CONNECTION").is_some()
+}
+
+/// Copy text to system clipboard. In SSH sessions, or if no clipboard command
+/// is available, use the OSC52 escape sequence to let the terminal do this.
+pub fn copy_to_clipboard(text: impl AsRef<str>) -> Result<()> {
+    let text = text.as_ref();
+    if !is_ssh_session() {
+        for (cmd, args) in CLIPBOARD_COMMANDS {
+            if copy_with_command(cmd, args, text) {
+                show_info(format!("Copied {} to clipboard", style(text).yellow()));
+                return Ok(());
+            }
+        }
+    }
+
+    let data = base64::engine::general_purpose::STANDARD.encode(text);
+    let seq = match env::var_os("TMUX") {
+        // Tmux requires the sequence to be wrapped in DCS passthrough.
+        Some(_) => format!("\x1bPtmux;\x1b\x1b]52;c;{data}\x07\x1b\\"),
+        None => format!("\x1b]52;c;{data}\x07"),
+    };
+    let result = match OpenOptions::new().write(true).open("/dev/tty") {
+        Ok(mut tty) => tty.write_all(seq.as_bytes()),
+        Err(_) => io::stderr().write_all(seq.as_bytes()),
+    };
+    result.context("Write OSC52 sequence to terminal")?;
+    show_info(format!(
+        "Copied {} to clipboard (OSC52)",
+        style(text).yellow()
+    ));
+    Ok(())
+}
+
+const CLIPBOARD_COMMANDS: [(&str, &[&str]); 4] = [
+    ("pbcopy", &[]),
+    ("wl-copy", &[]),
+    ("xclip", &["-selection", "clipboard"]),
+    ("xsel", &["--clipboard", "--input"]),
+];
+
+fn copy_with_command(cmd: &str, args: &[&str], text: &str) -> bool {
+    let child = Command::new(cmd)
+        .args(args)
+        .stdin(Stdio::piped())
+        .stdout(Stdio::null())
+        .stderr(Stdio::null())
+        .spawn();
+    let mut child = match child {
+        Ok(child) => child,
+        Err(_) => return false,
+    };
+    if let Some(mut stdin) = child.stdin.take() {
+        if let Err(_) = stdin.write_all(text.as_bytes()) {
+            return false;
+        }
+    }
+    match child.wait() {
+        Ok(status) => status.success(),
+        Err(_) => false,
+    }
 }
 
 pub fn confirm(msg: impl AsRef<str>) -> Result<bool> {
+    if is_non_interactive() {
+        show_info(format!("{}: yes", msg.as_ref()));
+        return Ok(true);
+    }
     let result = dialoguer::Confirm::with_theme(&dialoguer::theme::ColorfulTheme::default())
         .with_prompt(msg.as_ref())
         .interact_on(&console::Term::stderr());
     match result {
         Ok(ok) => Ok(ok),
+        Err(err) if err.kind() == ErrorKind::Interrupted => Err(abort()),
         Err(err) => Err(err).context("Terminal confirm"),
     }
 }
@@ -194,13 +488,27 @@ pub fn must_confirm(msg: impl AsRef<str>) -> Result<()> {
 }
 
 pub fn input(msg: impl AsRef<str>, require: bool, default: Option<&str>) -> Result<String> {
+    if is_non_interactive() {
+        return match default {
+            Some(default) => Ok(default.to_string()),
+            None if require => bail!(
+                "{} is required, could not prompt in non-interactive mode",
+                msg.as_ref()
+            ),
+            None => Ok(String::new()),
+        };
+    }
     let theme = ColorfulTheme::default();
     let mut input: Input<String> = Input::with_theme(&theme);
     input.with_prompt(msg.as_ref());
     if let Some(default) = default {
         input.with_initial_text(default.to_string());
     }
-    let text = input.interact_text().context("Terminal input")?;
+    let text = match input.interact_text() {
+        Ok(text) => text,
+        Err(err) if err.kind() == ErrorKind::Interrupted => return Err(abort()),
+        Err(err) => return Err(err).context("Terminal input"),
+    };
     if require && text.is_empty() {
         bail!("Input cannot be empty");
     }
@@ -211,8 +519,14 @@ pub fn edit<S>(default: S, ext: S, require: bool) -> Result<String>
 where
     S: AsRef<str>,
 {
+    if is_non_interactive() {
+        if require {
+            bail!("Could not open editor in non-interactive mode");
+        }
+        return Ok(default.as_ref().to_string());
+    }
     let text = Editor::new()
-        .executable(ext.as_ref())
+        .extension(ext.as_ref())
         .edit(default.as_ref())
         .context("Terminal edit")?;
     match text {
@@ -252,6 +566,13 @@ impl Table {
     }
 
     pub fn show(self) {
+        for line in self.lines() {
+            println!("{line}");
+        }
+    }
+
+    /// Render the rows to aligned lines, which can be used as search items.
+    pub fn lines(self) -> Vec<String> {
         let mut pads = Vec::with_capacity(self.ncol);
         for i in 0..self.ncol {
             let mut max_len: usize = 0;
@@ -264,14 +585,17 @@ impl Table {
             pads.push(max_len + 2);
         }
 
-        for row in self.rows.into_iter() {
-            for (i, cell) in row.into_iter().enumerate() {
-                let pad = pads[i];
-                let cell = cell.pad_to_width_with_alignment(pad, pad::Alignment::Left);
-                print!("{}", cell);
-            }
-            println!()
-        }
+        self.rows
+            .into_iter()
+            .map(|row| {
+                let mut line = String::new();
+                for (i, cell) in row.into_iter().enumerate() {
+                    let pad = pads[i];
+                    line.push_str(&cell.pad_to_width_with_alignment(pad, pad::Alignment::Left));
+                }
+                line
+            })
+            .collect()
     }
 }
 
@@ -310,6 +634,51 @@ pub fn format_since(time: u64) -> String {
     }
 }
 
+/// Convert the text to a slug which is safe in ref names and paths, such as
+/// `John Doe` to `john-doe`.
+pub fn slugify(s: impl AsRef<str>) -> String {
+    let mut slug = String::with_capacity(s.as_ref().len());
+    for c in s.as_ref().trim().chars() {
+        if c.is_ascii_alphanumeric() || c == '_' || c == '.' {
+            slug.push(c.to_ascii_lowercase());
+            continue;
+        }
+        if !slug.is_empty() && !slug.ends_with('-') {
+            slug.push('-');
+        }
+    }
+    slug.trim_end_matches('-').trim_matches('.').to_string()
+}
+
+/// Parse the duration such as `30m`, `12h`, `7d` and `2w` into seconds. The
+/// number without unit is treated as seconds.
+pub fn parse_duration(s: impl AsRef<str>) -> Result<u64> {
+    let s = s.as_ref().trim();
+    let (value, unit) = match s.find(|c: char| !c.is_ascii_digit()) {
+        Some(idx) => s.split_at(idx),
+        None => (s, "s"),
+    };
+    let unit = match unit {
+        "s" => SECOND,
+        "m" => MINUTE,
+        "h" => HOUR,
+        "d" => DAY,
+        "w" => WEEK,
+        _ => bail!("invalid duration unit {unit:?}, should be one of s, m, h, d, w"),
+    };
+    let value: u64 = match value.parse() {
+        Ok(value) => value,
+        Err(_) => bail!("invalid duration {s:?}, should be like `7d`"),
+    };
+    if value == 0 {
+        bail!("the duration could not be zero");
+    }
+    match value.checked_mul(unit) {
+        Some(duration) => Ok(duration),
+        None => bail!("the duration {s:?} is too large"),
+    }
+}
+
 pub fn format_time(time: u64) -> Result<String> {
     match Local.timestamp_opt(time as i64, 0) {
         LocalResult::None => bail!("Invalid timestamp {time}"),
@@ -335,13 +704,159 @@ pub fn human_bytes<T: Into<f64>>(bytes: T) -> String {
     [&result, BYTES_SUFFIX[base.floor() as usize]].join("")
 }
 
+/// Parse the size such as `500MB`, `50GB` and `1.5TB` into bytes, the unit
+/// is case insensitive and uses the same base as [`human_bytes`]. The number
+/// without unit is treated as bytes.
+pub fn parse_bytes(s: impl AsRef<str>) -> Result<u64> {
+    let s = s.as_ref().trim();
+    let (value, unit) = match s.find(|c: char| !c.is_ascii_digit() && c != '.') {
+        Some(idx) => s.split_at(idx),
+        None => (s, "B"),
+    };
+    let unit = unit.trim().to_uppercase();
+    let exp = match BYTES_SUFFIX.iter().position(|suffix| *suffix == unit) {
+        Some(exp) => exp,
+        None => bail!(
+            "invalid size unit {unit:?}, should be one of {}",
+            BYTES_SUFFIX[..5].join(", ")
+        ),
+    };
+    let value: f64 = match value.parse() {
+        Ok(value) => value,
+        Err(_) => bail!("invalid size {s:?}, should be like `50GB`"),
+    };
+    if value <= 0.0 {
+        bail!("the size could not be zero");
+    }
+    Ok((value * BYTES_UNIT.powi(exp as i32)) as u64)
+}
+
 pub fn dir_size(dir: PathBuf) -> Result<String> {
+    let size = dir_bytes(dir)?;
+    Ok(human_bytes(size as f64))
+}
+
+/// Compute the sizes of multiple directories concurrently, the result has the
+/// same order as `dirs`. Walking directories is IO bound, so this is much
+/// faster than calling `dir_bytes` serially on a large workspace.
+pub fn dir_bytes_batch(dirs: Vec<PathBuf>) -> Result<Vec<u64>> {
+    batch(&dirs, |dir| dir_bytes(dir.clone()))
+        .into_iter()
+        .collect()
+}
+
+thread_local! {
+    /// The deadline of the batch task running in current thread, see
+    /// [`batch_timeout`].
+    static TASK_DEADLINE: Cell<Option<Instant>> = Cell::new(None);
+}
+
+/// Return the deadline of the batch task running in current thread, the
+/// commands spawned after it should be killed.
+pub fn task_deadline() -> Option<Instant> {
+    TASK_DEADLINE.with(|deadline| deadline.get())
+}
+
+/// Call `f` for each item concurrently, the number of workers is limited to
+/// the number of CPUs. The results have the same order as `items`. Each task
+/// is limited by the `batch_timeout` config, see [`batch_timeout`].
+pub fn batch<T, R, F>(items: &[T], f: F) -> Vec<R>
+where
+    T: Sync,
+    R: Send,
+    F: Fn(&T) -> R + Sync,
+{
+    batch_timeout(items, config_batch_timeout(), f)
+}
+
+/// The same as [`batch`], but with the specified per-task timeout. When a task
+/// exceeds the timeout, the command it is running (such as git) will be killed
+/// and return a timeout error, see [`crate::shell::Shell::execute`]. None
+/// means no timeout.
+pub fn batch_timeout<T, R, F>(items: &[T], timeout: Option<Duration>, f: F) -> Vec<R>
+where
+    T: Sync,
+    R: Send,
+    F: Fn(&T) -> R + Sync,
+{
+    let order: Vec<usize> = (0..items.len()).collect();
+    batch_run(items, order, timeout, f)
+}
+
+/// The same as [`batch`], but the items with higher priorities are started
+/// first, the items with equal priorities keep their order. So the important
+/// items (such as pinned repos) are done early, and the user can start working
+/// while the long tail finishes. The results still have the same order as
+/// `items`.
+pub fn batch_priority<T, P, R, F>(items: &[T], priorities: &[P], f: F) -> Vec<R>
+where
+    T: Sync,
+    P: PartialOrd,
+    R: Send,
+    F: Fn(&T) -> R + Sync,
+{
+    let mut order: Vec<usize> = (0..items.len()).collect();
+    // The sort is stable, so the FIFO order is kept for equal priorities.
+    order.sort_by(|a, b| {
+        priorities[*b]
+            .partial_cmp(&priorities[*a])
+            .unwrap_or(std::cmp::Ordering::Equal)
+    });
+    batch_run(items, order, config_batch_timeout(), f)
+}
+
+fn config_batch_timeout() -> Option<Duration> {
+    match config::base().batch_timeout {
+        0 => None,
+        secs => Some(Duration::from_secs(secs)),
+    }
+}
+
+fn batch_run<T, R, F>(items: &[T], order: Vec<usize>, timeout: Option<Duration>, f: F) -> Vec<R>
+where
+    T: Sync,
+    R: Send,
+    F: Fn(&T) -> R + Sync,
+{
+    let workers = thread::available_parallelism()
+        .map(|count| count.get())
+        .unwrap_or(1)
+        .min(items.len())
+        .max(1);
+    let next = AtomicUsize::new(0);
+    let results: Mutex<Vec<Option<R>>> = Mutex::new((0..items.len()).map(|_| None).collect());
+
+    thread::scope(|scope| {
+        for _ in 0..workers {
+            scope.spawn(|| loop {
+                let pos = next.fetch_add(1, Ordering::Relaxed);
+                if pos >= order.len() {
+                    return;
+                }
+                let idx = order[pos];
+                let deadline = timeout.map(|timeout| Instant::now() + timeout);
+                TASK_DEADLINE.with(|task_deadline| task_deadline.set(deadline));
+                let result = f(&items[idx]);
+                results.lock().unwrap()[idx] = Some(result);
+            });
+        }
+    });
+
+    results
+        .into_inner()
+        .unwrap()
+        .into_iter()
+        .map(|result| result.unwrap())
+        .collect()
+}
+
+pub fn dir_bytes(dir: PathBuf) -> Result<u64> {
     let mut stack = vec![dir];
     let mut total_size: u64 = 0;
     loop {
         let maybe_dir = stack.pop();
         if let None = maybe_dir {
-            return Ok(human_bytes(total_size as f64));
+            return Ok(total_size);
         }
         let current_dir = maybe_dir.unwrap();
 
@@ -373,22 +888,61 @@ pub fn dir_size(dir: PathBuf) -> Result<String> {
     }
 }
 
+/// Remove the empty parent directories of the path, stop when meeting a
+/// non-empty directory. This is used to clean the workspace after the repo
+/// directory is removed or moved.
+pub fn remove_empty_parents(path: &PathBuf) -> Result<()> {
+    let dir = path.parent();
+    if let None = dir {
+        return Ok(());
+    }
+    let mut dir = dir.unwrap();
+    loop {
+        match fs::read_dir(dir) {
+            Ok(dir_read) => {
+                let count = dir_read.count();
+                if count > 0 {
+                    return Ok(());
+                }
+                show_info(format!("Remove dir {}", dir.display()));
+                fs::remove_dir(dir).context("Remove directory")?;
+                match dir.parent() {
+                    Some(parent) => dir = parent,
+                    None => return Ok(()),
+                }
+            }
+            Err(err) if err.kind() == ErrorKind::NotFound => return Ok(()),
+            Err(err) => return Err(err).with_context(|| format!("Read dir {}", dir.display())),
+        }
+    }
+}
+
 pub struct Lock {
     path: PathBuf,
-    _file_lock: FileLock,
+    _file: LockFile,
 }
 
+#[cfg(unix)]
+type LockFile = FileLock;
+
+#[cfg(windows)]
+type LockFile = fs::File;
+
 impl Lock {
+    /// The error code when the lock is held by another process, it is EAGAIN
+    /// on unix and ERROR_SHARING_VIOLATION on Windows.
+    #[cfg(unix)]
     const RESOURCE_TEMPORARILY_UNAVAILABLE_CODE: i32 = 11;
+    #[cfg(windows)]
+    const RESOURCE_TEMPORARILY_UNAVAILABLE_CODE: i32 = 32;
 
     pub fn acquire(name: impl AsRef<str>) -> Result<Lock> {
         let dir = PathBuf::from(config::base().metadir.as_str());
         ensure_dir(&dir)?;
         let path = dir.join(format!("lock_{}", name.as_ref()));
 
-        let lock_opts = FileOptions::new().write(true).create(true).truncate(true);
-        let mut file_lock = match FileLock::lock(&path, false, lock_opts) {
-            Ok(lock) => lock,
+        let mut file = match Self::lock_file(&path) {
+            Ok(file) => file,
             Err(err) => match err.raw_os_error() {
                 Some(code) if code == Self::RESOURCE_TEMPORARILY_UNAVAILABLE_CODE => {
                     bail!("Acquire file lock error, {} is occupied by another roxide, please wait for it to complete", name.as_ref());
@@ -402,19 +956,47 @@ impl Lock {
         let pid = process::id();
         let pid = format!("{pid}");
 
-        file_lock
-            .file
+        let writer = Self::writer(&mut file);
+        writer
             .write_all(pid.as_bytes())
             .with_context(|| format!("Write pid to lock file {}", path.display()))?;
-        file_lock
-            .file
+        writer
             .flush()
             .with_context(|| format!("Flush pid to lock file {}", path.display()))?;
 
-        Ok(Lock {
-            path,
-            _file_lock: file_lock,
-        })
+        Ok(Lock { path, _file: file })
+    }
+
+    #[cfg(unix)]
+    fn lock_file(path: &PathBuf) -> io::Result<LockFile> {
+        let lock_opts = FileOptions::new().write(true).create(true).truncate(true);
+        FileLock::lock(path, false, lock_opts)
+    }
+
+    #[cfg(windows)]
+    fn lock_file(path: &PathBuf) -> io::Result<LockFile> {
+        use std::os::windows::fs::OpenOptionsExt;
+
+        // Other processes are not allowed to open the file until it is closed
+        // (including the process exits), which works like flock. The delete
+        // sharing is required to remove the file when dropping.
+        const FILE_SHARE_DELETE: u32 = 0x4;
+        OpenOptions::new()
+            .write(true)
+            .create(true)
+            .truncate(true)
+            .share_mode(FILE_SHARE_DELETE)
+            .open(path)
+    }
+
+    #[cfg(unix)]
+    fn writer(file: &mut LockFile) -> &mut fs::File {
+        &mut file.file
+    }
+
+    #[cfg(windows)]
+    fn writer(file: &mut LockFile) -> &mut fs::File {
+        file
     }
 }
 
@@ -429,3 +1011,142 @@ impl Drop for Lock {
         }
     }
 }
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_slugify() {
+        assert_eq!(slugify("John Doe"), "john-doe");
+        assert_eq!(slugify("  fioncat "), "fioncat");
+        assert_eq!(slugify("Zhang, San (CN)"), "zhang-san-cn");
+        assert_eq!(slugify("dev.ops_team"), "dev.ops_team");
+        assert_eq!(slugify("..hidden.."), "hidden");
+        assert_eq!(slugify("李四"), "");
+    }
+
+    #[test]
+    fn test_parse_duration() {
+        assert_eq!(parse_duration("30").unwrap(), 30);
+        assert_eq!(parse_duration("30s").unwrap(), 30);
+        assert_eq!(parse_duration("5m").unwrap(), 5 * MINUTE);
+        assert_eq!(parse_duration(" 2h ").unwrap(), 2 * HOUR);
+        assert_eq!(parse_duration("7d").unwrap(), 7 * DAY);
+        assert_eq!(parse_duration("8w").unwrap(), 8 * WEEK);
+
+        assert!(parse_duration("").is_err());
+        assert!(parse_duration("0d").is_err());
+        assert!(parse_duration("d").is_err());
+        assert!(parse_duration("7y").is_err());
+        assert!(parse_duration("-7d").is_err());
+        assert!(parse_duration("18446744073709551615w").is_err());
+    }
+
+    #[test]
+    fn test_parse_bytes() {
+        assert_eq!(parse_bytes("512").unwrap(), 512);
+        assert_eq!(parse_bytes("512B").unwrap(), 512);
+        assert_eq!(parse_bytes("1KB").unwrap(), 1000);
+        assert_eq!(parse_bytes("500mb").unwrap(), 500_000_000);
+        assert_eq!(parse_bytes(" 50 GB ").unwrap(), 50_000_000_000);
+        assert_eq!(parse_bytes("1.5TB").unwrap(), 1_500_000_000_000);
+
+        assert!(parse_bytes("").is_err());
+        assert!(parse_bytes("0GB").is_err());
+        assert!(parse_bytes("GB").is_err());
+        assert!(parse_bytes("50XB").is_err());
+        assert!(parse_bytes("-50GB").is_err());
+    }
+
+    #[test]
+    #[cfg(unix)]
+    fn test_batch_timeout() {
+        use crate::shell::Shell;
+
+        // The output larger than the pipe buffer should not block the command.
+        let results = batch_timeout(&[200000], Some(Duration::from_secs(10)), |size| {
+            let script = format!("head -c {size} /dev/zero");
+            Shell::with_args("sh", &["-c", script.as_str()])
+                .set_mute(true)
+                .execute()?
+                .checked_read()
+        });
+        assert_eq!(results[0].as_ref().unwrap().len(), 200000);
+
+        // The command and its children should be killed on timeout.
+        let start = Instant::now();
+        let results = batch_timeout(
+            &["sleep 5 & wait"],
+            Some(Duration::from_millis(200)),
+            |script| {
+                Shell::with_args("sh", &["-c", script])
+                    .set_mute(true)
+                    .execute()?
+                    .checked_read()
+            },
+        );
+        assert!(results[0].is_err());
+        assert!(start.elapsed() < Duration::from_secs(3));
+    }
+
+    #[test]
+    fn test_parse_url_raw() {
+        let cases = [
+            (
+                "https://github.com/owner/name",
+                Some(("github.com", "owner", "name")),
+            ),
+            (
+                "https://github.com/owner/name/",
+                Some(("github.com", "owner", "name")),
+            ),
+            (
+                "https://github.com/owner/name.git",
+                Some(("github.com", "owner", "name")),
+            ),
+            (
+                "https://github.com/owner/name/tree/main",
+                Some(("github.com", "owner", "name")),
+            ),
+            (
+                "https://github.com/owner/name/blob/main/src/main.rs#L10",
+                Some(("github.com", "owner", "name")),
+            ),
+            (
+                "https://github.com/owner/name/pull/12?diff=split",
+                Some(("github.com", "owner", "name")),
+            ),
+            (
+                "https://gitlab.com/group/sub/name/-/tree/main",
+                Some(("gitlab.com", "group/sub", "name")),
+            ),
+            (
+                "https://gitlab.com/group/sub/name",
+                Some(("gitlab.com", "group/sub", "name")),
+            ),
+            // The repo named like a page segment is kept.
+            (
+                "https://github.com/owner/tree",
+                Some(("github.com", "owner", "tree")),
+            ),
+            (
+                "git@github.com:owner/name.git",
+                Some(("github.com", "owner", "name")),
+            ),
+            (
+                "ssh://git@host.com:2222/owner/name.git",
+                Some(("host.com", "owner", "name")),
+            ),
+            ("https://github.com/owner", None),
+            ("https://github.com", None),
+            ("owner/name", None),
+            ("github:owner/name", None),
+        ];
+        for (url, expect) in cases {
+            let expect = expect
+                .map(|(host, owner, name)| (host.to_string(), owner.to_string(), name.to_string()));
+            assert_eq!(parse_url_raw(url), expect, "{url}");
+        }
+    }
+}